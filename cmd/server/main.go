@@ -1,15 +1,40 @@
 package main
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 	serverapp "gophkeeper/internal/app/server"
 	"gophkeeper/internal/config"
+	"gophkeeper/internal/models"
 )
 func main() {
 	cfg := config.LoadServerConfigWithFlags()
+	if flag.Arg(0) == "unlock" {
+		if flag.NArg() != 3 {
+			fmt.Fprintln(os.Stderr, "usage: gophkeeper-server unlock user|ip <username|address>")
+			os.Exit(2)
+		}
+		if err := serverapp.Unlock(cfg, flag.Arg(1), flag.Arg(2)); err != nil {
+			log.Fatalf("Unlock failed: %v", err)
+		}
+		fmt.Printf("Unlocked %s %s\n", flag.Arg(1), flag.Arg(2))
+		return
+	}
+	if flag.Arg(0) == "audit" {
+		if flag.NArg() < 2 || flag.NArg() > 3 || flag.Arg(1) != "verify" {
+			fmt.Fprintln(os.Stderr, "usage: gophkeeper-server audit verify [heads-file]")
+			os.Exit(2)
+		}
+		verifyAudit(cfg, flag.Arg(2))
+		return
+	}
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 	if err := serverapp.Run(ctx, cfg); err != nil {
@@ -17,3 +42,41 @@ func main() {
 	}
 	time.Sleep(100 * time.Millisecond)
 }
+// verifyAudit checks the audit log. With headsFile the chain heads of the
+// previous run are read from it, checked to still be there, and replaced
+// with the current ones once every chain verifies.
+func verifyAudit(cfg config.ServerConfig, headsFile string) {
+	var anchors []models.AuditHead
+	if headsFile != "" {
+		data, err := os.ReadFile(headsFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("Failed to read audit heads: %v", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &anchors); err != nil {
+				log.Fatalf("Failed to parse audit heads: %v", err)
+			}
+		}
+	}
+	result, err := serverapp.VerifyAudit(cfg, anchors)
+	if errors.Is(err, models.ErrAuditChainBroken) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		fmt.Fprintf(os.Stderr, "Events verified before the break: %d\n", result.Events)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("Audit verification failed: %v", err)
+	}
+	fmt.Printf("Audit chains intact: %d events in %d chains\n", result.Events, len(result.Heads))
+	if headsFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(result.Heads, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode audit heads: %v", err)
+	}
+	if err := os.WriteFile(headsFile, data, 0600); err != nil {
+		log.Fatalf("Failed to write audit heads: %v", err)
+	}
+	fmt.Printf("Heads written to %s\n", headsFile)
+}
@@ -8,6 +8,9 @@ require (
 	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.17
 	github.com/pressly/goose/v3 v3.25.0
+	golang.org/x/crypto v0.41.0
+	golang.org/x/term v0.34.0
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -15,4 +18,5 @@ require (
 	github.com/sethvargo/go-retry v0.3.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/sync v0.16.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
 )
@@ -24,7 +24,7 @@ func New(cfg config.ClientConfig) (*App, error) {
 	logger.Debug("Server URL: %s", cfg.ServerURL)
 	logger.Debug("Config directory: %s", cfg.ConfigDir)
 
-	cli, err := client.NewClient(cfg.ServerURL, cfg.ConfigDir, cfg.EncryptionKey)
+	cli, err := client.NewClient(cfg)
 	if err != nil {
 		logger.Error("Failed to create client: %v", err)
 		return nil, fmt.Errorf("create client: %w", err)
@@ -1,12 +1,16 @@
 package serverapp
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"gophkeeper/internal/config"
+	"gophkeeper/internal/crypto"
 	"gophkeeper/internal/database"
 	dbm "gophkeeper/internal/database/migrations"
 	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
 	"gophkeeper/internal/server"
 	"net/http"
 	"time"
@@ -17,6 +21,12 @@ import (
 type App struct {
 	httpServer *http.Server
 	db         *database.DB
+	rotator    *server.KeyRotator
+	signing    *server.SigningKeyService
+	oidc       *server.OIDCService
+	idempotent *server.IdempotencyService
+	rotateCtx  context.Context
+	stop       context.CancelFunc
 }
 
 func New(cfg config.ServerConfig) (*App, error) {
@@ -26,6 +36,54 @@ func New(cfg config.ServerConfig) (*App, error) {
 
 	logger.Info("Initializing server application")
 
+	if err := checkAuditKey(cfg); err != nil {
+		logger.Error("Refusing to start: %v", err)
+		return nil, err
+	}
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Initializing HTTP server on port %s", cfg.Port)
+	keyProvider, err := newKeyProvider(cfg)
+	if err != nil {
+		logger.Error("Failed to load master keys: %v", err)
+		_ = db.Close()
+		return nil, fmt.Errorf("load master keys: %w", err)
+	}
+	handler := server.NewServer(db, cfg, keyProvider)
+	if err := handler.SigningKeys().Refresh(); err != nil {
+		logger.Error("Failed to load signing keys: %v", err)
+		_ = db.Close()
+		return nil, fmt.Errorf("load signing keys: %w", err)
+	}
+	if err := handler.Receipts().Load(); err != nil {
+		logger.Error("Failed to load receipt key: %v", err)
+		_ = db.Close()
+		return nil, fmt.Errorf("load receipt key: %w", err)
+	}
+	if cfg.ZeroKnowledge {
+		logger.Info("Zero-knowledge mode enabled: item blobs are stored as sent by clients")
+		unwrapped, err := handler.DataService().UnwrapLegacyData()
+		if err != nil {
+			logger.Error("Failed to unwrap legacy data: %v", err)
+			_ = db.Close()
+			return nil, fmt.Errorf("unwrap legacy data: %w", err)
+		}
+		if unwrapped > 0 {
+			logger.Info("Removed server encryption layer from %d legacy rows", unwrapped)
+		}
+	}
+	rotator := server.NewKeyRotator(handler.KeyService(), handler.DataService(), cfg.KeyRotationInterval, cfg.KeyRotationBatch)
+	httpSrv := &http.Server{Addr: ":" + cfg.Port, Handler: handler}
+	rotateCtx, stop := context.WithCancel(context.Background())
+	return &App{httpServer: httpSrv, db: db, rotator: rotator, signing: handler.SigningKeys(), oidc: handler.OIDC(), idempotent: handler.Idempotency(), rotateCtx: rotateCtx, stop: stop}, nil
+}
+
+// openDB connects to the database and applies pending migrations.
+func openDB(cfg config.ServerConfig) (*database.DB, error) {
 	connStr := fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName,
@@ -51,18 +109,97 @@ func New(cfg config.ServerConfig) (*App, error) {
 		return nil, fmt.Errorf("run migrations: %w", err)
 	}
 	logger.Info("Database migrations completed successfully")
+	return db, nil
+}
 
-	logger.Info("Initializing HTTP server on port %s", cfg.Port)
-	handler := server.NewServer(db, cfg.JWTSecret, cfg.EncryptionKey)
-	httpSrv := &http.Server{Addr: ":" + cfg.Port, Handler: handler}
-	return &App{httpServer: httpSrv, db: db}, nil
+// Unlock clears login throttling for a username (kind "user") or a client
+// IP (kind "ip"). Counters shared through Postgres are cleared directly;
+// in-memory ones live in the running server and go through its admin API.
+func Unlock(cfg config.ServerConfig, kind, value string) error {
+	req := &models.UnlockRequest{}
+	switch kind {
+	case "user":
+		req.Username = value
+	case "ip":
+		req.IP = value
+	default:
+		return fmt.Errorf("unknown unlock target %q, use user or ip", kind)
+	}
+	if cfg.LoginThrottleStore == "postgres" {
+		db, err := openDB(cfg)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		throttle := server.NewThrottler(db, server.ThrottleOptions{})
+		if req.Username != "" {
+			return throttle.UnlockUser(req.Username)
+		}
+		return throttle.UnlockIP(req.IP)
+	}
+	if cfg.AdminToken == "" {
+		return fmt.Errorf("ADMIN_TOKEN must be set to unlock a server using the in-memory throttle store")
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest("POST", "http://localhost:"+cfg.Port+"/api/v1/admin/unlock", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+cfg.AdminToken)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to reach the server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unlock failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkAuditKey refuses an audit key anybody could know: missing, the
+// example value, short, or shared with JWT_SECRET.
+func checkAuditKey(cfg config.ServerConfig) error {
+	switch {
+	case cfg.AuditKey == "":
+		return fmt.Errorf("AUDIT_KEY must be set")
+	case cfg.AuditKey == config.ExampleAuditKey:
+		return fmt.Errorf("AUDIT_KEY must not be the example value")
+	case cfg.AuditKey == cfg.JWTSecret:
+		return fmt.Errorf("AUDIT_KEY must differ from JWT_SECRET")
+	case len(cfg.AuditKey) < server.MinAuditKeyLength:
+		return fmt.Errorf("AUDIT_KEY must be at least %d characters", server.MinAuditKeyLength)
+	}
+	return nil
+}
+
+// newKeyProvider prefers the keyfile; otherwise a single master key is
+// derived from ENCRYPTION_KEY. Zero-knowledge servers need one as well, for
+// the JWT signing keys.
+func newKeyProvider(cfg config.ServerConfig) (crypto.KeyProvider, error) {
+	if cfg.MasterKeyFile != "" {
+		return crypto.LoadKeyFile(cfg.MasterKeyFile)
+	}
+	if cfg.EncryptionKey != "" {
+		return crypto.NewStaticKeyProvider(cfg.EncryptionKey), nil
+	}
+	return nil, fmt.Errorf("either MASTER_KEY_FILE or ENCRYPTION_KEY must be set")
 }
 func (a *App) Start() error {
+	go a.rotator.Run(a.rotateCtx)
+	go a.signing.Run(a.rotateCtx)
+	go a.oidc.Run(a.rotateCtx)
+	go a.idempotent.Run(a.rotateCtx)
 	logger.Info("Starting server on %s", a.httpServer.Addr)
 	return a.httpServer.ListenAndServe()
 }
 func (a *App) Shutdown(ctx context.Context) error {
 	logger.Info("Shutting down server")
+	a.stop()
 	httpErr := a.httpServer.Shutdown(ctx)
 	dbErr := a.db.Close()
 	logger.Close()
@@ -92,3 +229,15 @@ func Run(ctx context.Context, cfg config.ServerConfig) error {
 		return err
 	}
 }
+
+// VerifyAudit checks every chain of the audit log against the heads of an
+// earlier check, if any. On a broken chain it returns what was verified
+// together with ErrAuditChainBroken.
+func VerifyAudit(cfg config.ServerConfig, anchors []models.AuditHead) (*models.AuditVerification, error) {
+	db, err := openDB(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return server.NewAuditService(db, server.AuditOptions{Key: cfg.AuditKey, PreviousKeys: cfg.AuditPreviousKeys, LegacySecret: cfg.JWTSecret}).Verify(anchors)
+}
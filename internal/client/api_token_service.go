@@ -0,0 +1,48 @@
+package client
+import (
+	"fmt"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+// APITokenServiceImpl manages the account's API tokens. It needs a user
+// session; API tokens cannot mint or revoke other tokens.
+type APITokenServiceImpl struct {
+	httpClient  HTTPClient
+	authService AuthService
+}
+func NewAPITokenService(httpClient HTTPClient, authService AuthService) *APITokenServiceImpl {
+	return &APITokenServiceImpl{
+		httpClient:  httpClient,
+		authService: authService,
+	}
+}
+func (t *APITokenServiceImpl) Create(req *models.APITokenRequest) (*models.APITokenResponse, error) {
+	if !t.authService.IsAuthenticated() {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	if req.Name == "" {
+		return nil, fmt.Errorf("token name is required")
+	}
+	response, err := t.httpClient.CreateAPIToken(req, t.authService.GetToken())
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Created api token %s (%s)", response.APIToken.ID, response.APIToken.Name)
+	return response, nil
+}
+func (t *APITokenServiceImpl) List() ([]models.APIToken, error) {
+	if !t.authService.IsAuthenticated() {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	return t.httpClient.ListAPITokens(t.authService.GetToken())
+}
+func (t *APITokenServiceImpl) Revoke(id string) error {
+	if !t.authService.IsAuthenticated() {
+		return fmt.Errorf("not authenticated")
+	}
+	if err := t.httpClient.RevokeAPIToken(id, t.authService.GetToken()); err != nil {
+		return fmt.Errorf("failed to revoke api token: %w", err)
+	}
+	logger.Info("Revoked api token %s", id)
+	return nil
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"gophkeeper/internal/crypto"
 	"gophkeeper/internal/logger"
 	"gophkeeper/internal/models"
 	"strings"
@@ -12,7 +13,11 @@ type AuthServiceImpl struct {
 	httpClient   HTTPClient
 	tokenManager TokenManager
 	token        string
+	refreshToken string
 	userID       string
+	username     string
+	apiToken     bool
+	sso          SSOOptions
 }
 
 func NewAuthService(httpClient HTTPClient, tokenManager TokenManager) *AuthServiceImpl {
@@ -22,36 +27,88 @@ func NewAuthService(httpClient HTTPClient, tokenManager TokenManager) *AuthServi
 	}
 	if token, err := tokenManager.LoadToken(); err == nil && token != "" {
 		service.token = token
-		if userID, err := service.extractUserIDFromToken(token); err == nil {
-			service.userID = userID
+		if claims, err := service.extractClaims(token); err == nil {
+			service.userID = claims.UserID
+			service.username = claims.Username
 		}
 	}
+	if refreshToken, err := tokenManager.LoadRefreshToken(); err == nil {
+		service.refreshToken = refreshToken
+	}
 	return service
 }
-func (a *AuthServiceImpl) Register(username, email, password string) (*models.AuthResponse, error) {
+func (a *AuthServiceImpl) Register(username, email, password string, kdf *models.KDFParams) (*models.AuthResponse, error) {
 	logger.Info("Registering user: %s", username)
+	params := crypto.DefaultPasswordHashParams
+	salt, verifier, err := crypto.NewSRPVerifier(username, password, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute srp verifier: %w", err)
+	}
 	req := &models.UserRegistrationRequest{
 		Username: username,
 		Email:    email,
-		Password: password,
+		KDF:      kdf,
+		SRP: &models.SRPVerifier{
+			Salt:        salt,
+			Verifier:    verifier,
+			Time:        params.Time,
+			Memory:      params.Memory,
+			Parallelism: params.Parallelism,
+		},
 	}
 	response, err := a.httpClient.Register(req)
 	if err != nil {
 		logger.Error("Registration failed for user %s: %v", username, err)
 		return nil, err
 	}
-	a.token = response.Token
-	a.userID = response.User.ID
-	logger.Debug("User registered successfully, user_id: %s", a.userID)
-	if err := a.tokenManager.SaveToken(a.token); err != nil {
-		logger.Error("Failed to save token: %v", err)
-		return nil, fmt.Errorf("failed to save token: %w", err)
+	logger.Debug("User registered successfully, user_id: %s", response.User.ID)
+	if err := a.storeTokens(response); err != nil {
+		return nil, err
 	}
 	logger.Info("User %s registered and authenticated successfully", username)
 	return response, nil
 }
+// Login runs the SRP handshake, so the password never leaves the client.
+// Accounts created before SRP log in once with the password, which lets the
+// server store a verifier for them.
 func (a *AuthServiceImpl) Login(username, password string) (*models.AuthResponse, error) {
 	logger.Info("Logging in user: %s", username)
+	srp, err := crypto.NewSRPClient(username)
+	if err != nil {
+		return nil, err
+	}
+	init, err := a.httpClient.SRPInit(&models.SRPInitRequest{
+		Username:     username,
+		ClientPublic: srp.PublicKey(),
+	})
+	if err != nil {
+		logger.Error("Login failed for user %s: %v", username, err)
+		return nil, err
+	}
+	if init.Legacy {
+		logger.Info("Account %s has no srp verifier yet, using password login", username)
+		return a.loginWithPassword(username, password)
+	}
+	params := crypto.PasswordHashParams{Time: init.Time, Memory: init.Memory, Parallelism: init.Parallelism}
+	proof, err := srp.ComputeProof(password, init.Salt, init.ServerPublic, params)
+	if err != nil {
+		return nil, fmt.Errorf("login failed: %w", err)
+	}
+	response, err := a.httpClient.SRPVerify(&models.SRPVerifyRequest{
+		HandshakeID: init.HandshakeID,
+		ClientProof: proof,
+	})
+	if err != nil {
+		logger.Error("Login failed for user %s: %v", username, err)
+		return nil, err
+	}
+	if !srp.VerifyServerProof(response.ServerProof) {
+		logger.Error("Server failed to prove knowledge of the srp verifier for user %s", username)
+		return nil, fmt.Errorf("login failed: server could not be authenticated")
+	}
+	return a.finishLogin(username, response)
+}
+func (a *AuthServiceImpl) loginWithPassword(username, password string) (*models.AuthResponse, error) {
 	req := &models.UserLoginRequest{
 		Username: username,
 		Password: password,
@@ -61,16 +118,164 @@ func (a *AuthServiceImpl) Login(username, password string) (*models.AuthResponse
 		logger.Error("Login failed for user %s: %v", username, err)
 		return nil, err
 	}
-	a.token = response.Token
-	a.userID = response.User.ID
-	logger.Debug("User logged in successfully, user_id: %s", a.userID)
-	if err := a.tokenManager.SaveToken(a.token); err != nil {
-		logger.Error("Failed to save token: %v", err)
-		return nil, fmt.Errorf("failed to save token: %w", err)
+	return a.finishLogin(username, response)
+}
+func (a *AuthServiceImpl) finishLogin(username string, response *models.AuthResponse) (*models.AuthResponse, error) {
+	if response.MFARequired {
+		logger.Info("User %s needs a second factor to log in", username)
+		return response, nil
+	}
+	logger.Debug("User logged in successfully, user_id: %s", response.User.ID)
+	if err := a.storeTokens(response); err != nil {
+		return nil, err
 	}
 	logger.Info("User %s logged in successfully", username)
 	return response, nil
 }
+// LoginMFA completes a login challenged for a second factor. code is either
+// a TOTP code or a recovery code.
+func (a *AuthServiceImpl) LoginMFA(mfaToken, code string) (*models.AuthResponse, error) {
+	factor := secondFactor(code)
+	response, err := a.httpClient.LoginMFA(&models.MFALoginRequest{
+		MFAToken:     mfaToken,
+		Code:         factor.Code,
+		RecoveryCode: factor.RecoveryCode,
+	})
+	if err != nil {
+		logger.Error("Two-factor login failed: %v", err)
+		return nil, err
+	}
+	if err := a.storeTokens(response); err != nil {
+		return nil, err
+	}
+	logger.Info("User %s logged in successfully", response.User.Username)
+	return response, nil
+}
+func (a *AuthServiceImpl) EnrollTOTP() (*models.TOTPEnrollResponse, error) {
+	if a.token == "" {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	return a.httpClient.EnrollTOTP(a.token)
+}
+func (a *AuthServiceImpl) ConfirmTOTP(code string) ([]string, error) {
+	if a.token == "" {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	response, err := a.httpClient.ConfirmTOTP(strings.TrimSpace(code), a.token)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Two-factor authentication enabled")
+	return response.Codes, nil
+}
+func (a *AuthServiceImpl) DisableTOTP(code string) error {
+	if a.token == "" {
+		return fmt.Errorf("not authenticated")
+	}
+	factor := secondFactor(code)
+	if err := a.httpClient.DisableTOTP(&factor, a.token); err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+	logger.Info("Two-factor authentication disabled")
+	return nil
+}
+func (a *AuthServiceImpl) RegenerateRecoveryCodes(code string) ([]string, error) {
+	if a.token == "" {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	factor := secondFactor(code)
+	response, err := a.httpClient.RegenerateRecoveryCodes(&factor, a.token)
+	if err != nil {
+		return nil, err
+	}
+	return response.Codes, nil
+}
+func (a *AuthServiceImpl) SetKDFParams(params *models.KDFParams) error {
+	if a.token == "" {
+		return fmt.Errorf("not authenticated")
+	}
+	if err := a.httpClient.SetKDFParams(params, a.token); err != nil {
+		logger.Error("Failed to upload kdf parameters: %v", err)
+		return fmt.Errorf("failed to upload kdf parameters: %w", err)
+	}
+	return nil
+}
+// ChangePassword proves the current password and registers a verifier for
+// the new one. Other sessions of the account are logged out by the server.
+func (a *AuthServiceImpl) ChangePassword(oldPassword, newPassword string) error {
+	proof, err := a.provePassword(oldPassword)
+	if err != nil {
+		return err
+	}
+	params := crypto.DefaultPasswordHashParams
+	salt, verifier, err := crypto.NewSRPVerifier(a.username, newPassword, params)
+	if err != nil {
+		return fmt.Errorf("failed to compute srp verifier: %w", err)
+	}
+	req := &models.ChangePasswordRequest{
+		Proof: *proof,
+		SRP: &models.SRPVerifier{
+			Salt:        salt,
+			Verifier:    verifier,
+			Time:        params.Time,
+			Memory:      params.Memory,
+			Parallelism: params.Parallelism,
+		},
+	}
+	if err := a.httpClient.ChangePassword(req, a.token); err != nil {
+		return err
+	}
+	logger.Info("Password changed for user %s", a.username)
+	return nil
+}
+func (a *AuthServiceImpl) ChangeEmail(email string) error {
+	_, err := a.httpClient.ChangeEmail(&models.ChangeEmailRequest{Email: email}, a.token)
+	return err
+}
+// DeleteAccount erases the account on the server and forgets the local
+// session. The caller keeps the returned receipt.
+func (a *AuthServiceImpl) DeleteAccount(password string) (*models.AccountDeletionResponse, error) {
+	proof, err := a.provePassword(password)
+	if err != nil {
+		return nil, err
+	}
+	response, err := a.httpClient.DeleteAccount(&models.DeleteAccountRequest{Proof: *proof}, a.token)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.ClearSession(); err != nil {
+		logger.Error("Failed to clear token: %v", err)
+	}
+	logger.Info("Account %s deleted", response.Receipt.Username)
+	return response, nil
+}
+// provePassword answers a fresh SRP handshake with password, or passes the
+// password itself for accounts that have no verifier yet.
+func (a *AuthServiceImpl) provePassword(password string) (*models.PasswordProof, error) {
+	if a.username == "" {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	srp, err := crypto.NewSRPClient(a.username)
+	if err != nil {
+		return nil, err
+	}
+	init, err := a.httpClient.SRPInit(&models.SRPInitRequest{
+		Username:     a.username,
+		ClientPublic: srp.PublicKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if init.Legacy {
+		return &models.PasswordProof{Password: password}, nil
+	}
+	params := crypto.PasswordHashParams{Time: init.Time, Memory: init.Memory, Parallelism: init.Parallelism}
+	proof, err := srp.ComputeProof(password, init.Salt, init.ServerPublic, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prove password: %w", err)
+	}
+	return &models.PasswordProof{HandshakeID: init.HandshakeID, ClientProof: proof}, nil
+}
 func (a *AuthServiceImpl) IsAuthenticated() bool {
 	return a.token != ""
 }
@@ -80,22 +285,103 @@ func (a *AuthServiceImpl) GetToken() string {
 func (a *AuthServiceImpl) GetUserID() string {
 	return a.userID
 }
-func (a *AuthServiceImpl) Logout() error {
-	logger.Info("Logging out user")
+// TokenClaims decodes the current access token. The signature is checked by
+// the server, not here.
+func (a *AuthServiceImpl) TokenClaims() (*crypto.JWTClaims, error) {
+	if a.token == "" {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	return a.extractClaims(a.token)
+}
+// ClearSession forgets the tokens without contacting the server, for
+// sessions the server has already ended.
+func (a *AuthServiceImpl) ClearSession() error {
 	a.token = ""
+	a.refreshToken = ""
 	a.userID = ""
-	if err := a.tokenManager.ClearToken(); err != nil {
+	a.username = ""
+	return a.tokenManager.ClearToken()
+}
+// UseAPIToken authenticates with an API token instead of a user session.
+// The token is kept in memory only and never replaces a saved session.
+func (a *AuthServiceImpl) UseAPIToken(token, userID, username string) {
+	a.token = token
+	a.refreshToken = ""
+	a.userID = userID
+	a.username = username
+	a.apiToken = true
+}
+// RefreshToken exchanges the stored refresh token for a new token pair and
+// returns the new access token.
+func (a *AuthServiceImpl) RefreshToken() (string, error) {
+	if a.apiToken {
+		return "", fmt.Errorf("api token was rejected, check that it is valid and not revoked")
+	}
+	if a.refreshToken == "" {
+		return "", fmt.Errorf("no refresh token, please log in again")
+	}
+	response, err := a.httpClient.RefreshToken(a.refreshToken)
+	if err != nil {
+		logger.Error("Token refresh failed: %v", err)
+		return "", err
+	}
+	if err := a.storeTokens(response); err != nil {
+		return "", err
+	}
+	logger.Debug("Access token refreshed")
+	return a.token, nil
+}
+func (a *AuthServiceImpl) Logout() error {
+	if a.apiToken {
+		a.token, a.userID, a.username, a.apiToken = "", "", "", false
+		return nil
+	}
+	logger.Info("Logging out user")
+	if a.token != "" || a.refreshToken != "" {
+		if err := a.httpClient.Logout(a.token, a.refreshToken); err != nil {
+			logger.Warn("Failed to revoke session on server: %v", err)
+		}
+	}
+	if err := a.ClearSession(); err != nil {
 		logger.Error("Failed to clear token: %v", err)
 		return err
 	}
 	logger.Info("User logged out successfully")
 	return nil
 }
+func (a *AuthServiceImpl) storeTokens(response *models.AuthResponse) error {
+	a.token = response.Token
+	a.userID = response.User.ID
+	a.username = response.User.Username
+	if response.RefreshToken != "" {
+		a.refreshToken = response.RefreshToken
+	}
+	if err := a.tokenManager.SaveToken(a.token); err != nil {
+		logger.Error("Failed to save token: %v", err)
+		return fmt.Errorf("failed to save token: %w", err)
+	}
+	if err := a.tokenManager.SaveRefreshToken(a.refreshToken); err != nil {
+		logger.Error("Failed to save refresh token: %v", err)
+		return fmt.Errorf("failed to save refresh token: %w", err)
+	}
+	return nil
+}
 
-func (a *AuthServiceImpl) extractUserIDFromToken(token string) (string, error) {
+// secondFactor tells TOTP codes, which are all digits, from recovery codes.
+func secondFactor(input string) models.TOTPCodeRequest {
+	input = strings.TrimSpace(input)
+	for _, r := range input {
+		if r < '0' || r > '9' {
+			return models.TOTPCodeRequest{RecoveryCode: input}
+		}
+	}
+	return models.TOTPCodeRequest{Code: input}
+}
+
+func (a *AuthServiceImpl) extractClaims(token string) (*crypto.JWTClaims, error) {
 	parts := strings.Split(token, ".")
 	if len(parts) != 3 {
-		return "", fmt.Errorf("invalid token format")
+		return nil, fmt.Errorf("invalid token format")
 	}
 
 	payload := parts[1]
@@ -105,18 +391,16 @@ func (a *AuthServiceImpl) extractUserIDFromToken(token string) (string, error) {
 
 	decoded, err := a.base64DecodeString(payload)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode payload: %w", err)
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
 	}
 
-	var claims struct {
-		UserID string `json:"user_id"`
-	}
+	var claims crypto.JWTClaims
 
 	if err := json.Unmarshal(decoded, &claims); err != nil {
-		return "", fmt.Errorf("failed to unmarshal claims: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal claims: %w", err)
 	}
 
-	return claims.UserID, nil
+	return &claims, nil
 }
 
 func (a *AuthServiceImpl) base64DecodeString(s string) ([]byte, error) {
@@ -1,7 +1,11 @@
 package cli
 import (
+	"flag"
 	"fmt"
+	"io"
 	"regexp"
+	"strings"
+	"time"
 	"gophkeeper/internal/models"
 )
 type ClientInterface interface {
@@ -11,9 +15,31 @@ type ClientInterface interface {
 	GetData(id string) error
 	DeleteData(id string) error
 	SyncData() error
+	WatchSync(interval time.Duration) error
+	ListConflicts() error
+	ResolveConflict(id, keep string) error
 	ShowHistory(id string) error
+	DiscardLocalChanges() error
 	ListData() error
 	GetDataList() ([]models.StoredData, error)
+	EnableTwoFactor() error
+	DisableTwoFactor() error
+	RegenerateRecoveryCodes() error
+	ChangeMasterPassword() error
+	RecoverySplit(shares, threshold int, format string) error
+	RecoveryCombine() error
+	ChangeAccountPassword() error
+	ChangeEmail(email string) error
+	DeleteAccount() error
+	ListDevices() error
+	RevokeDevice(id string) error
+	CreateAPIToken(req *models.APITokenRequest) error
+	ListAPITokens() error
+	RevokeAPIToken(id string) error
+	LoginSSO(issuer string) error
+	LinkSSO(issuer string) error
+	ListSSO() error
+	UnlinkSSO(issuer string) error
 }
 type Command interface {
 	Execute(client ClientInterface) error
@@ -132,9 +158,39 @@ func (c *DeleteCommand) Execute(client ClientInterface) error {
 	}
 	return client.DeleteData(c.ID)
 }
-type SyncCommand struct{}
+// DefaultSyncInterval is how often sync --watch syncs unless told otherwise.
+const DefaultSyncInterval = 30 * time.Second
+type SyncCommand struct {
+	Watch    bool
+	Interval time.Duration
+}
 func (c *SyncCommand) Execute(client ClientInterface) error {
-	return client.SyncData()
+	if !c.Watch {
+		return client.SyncData()
+	}
+	if c.Interval < time.Second {
+		return fmt.Errorf("sync interval must be at least 1s")
+	}
+	return client.WatchSync(c.Interval)
+}
+type ConflictsCommand struct{}
+func (c *ConflictsCommand) Execute(client ClientInterface) error {
+	return client.ListConflicts()
+}
+type ResolveCommand struct {
+	ID   string
+	Keep string
+}
+func (c *ResolveCommand) Execute(client ClientInterface) error {
+	if c.ID == "" {
+		return fmt.Errorf("data ID is required")
+	}
+	switch c.Keep {
+	case "local", "server", "merge":
+		return client.ResolveConflict(c.ID, c.Keep)
+	default:
+		return fmt.Errorf("invalid --keep value: %s. Valid values: local, server, merge", c.Keep)
+	}
 }
 type HistoryCommand struct{ ID string }
 func (c *HistoryCommand) Execute(client ClientInterface) error {
@@ -146,10 +202,153 @@ func (c *HistoryCommand) Execute(client ClientInterface) error {
 	}
 	return client.ShowHistory(c.ID)
 }
+type ResetLocalCommand struct{}
+func (c *ResetLocalCommand) Execute(client ClientInterface) error {
+	return client.DiscardLocalChanges()
+}
 type ListCommand struct{}
 func (c *ListCommand) Execute(client ClientInterface) error {
 	return client.ListData()
 }
+type TwoFactorCommand struct{ Action string }
+func (c *TwoFactorCommand) Execute(client ClientInterface) error {
+	switch c.Action {
+	case "enable":
+		return client.EnableTwoFactor()
+	case "disable":
+		return client.DisableTwoFactor()
+	case "recovery-codes":
+		return client.RegenerateRecoveryCodes()
+	default:
+		return fmt.Errorf("invalid 2fa action: %s. Valid actions: enable, disable, recovery-codes", c.Action)
+	}
+}
+type PasswdCommand struct{}
+func (c *PasswdCommand) Execute(client ClientInterface) error {
+	return client.ChangeMasterPassword()
+}
+type AccountCommand struct {
+	Action string
+	Email  string
+}
+func (c *AccountCommand) Execute(client ClientInterface) error {
+	switch c.Action {
+	case "password":
+		return client.ChangeAccountPassword()
+	case "email":
+		emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+		if !emailRegex.MatchString(c.Email) {
+			return fmt.Errorf("invalid email format")
+		}
+		return client.ChangeEmail(c.Email)
+	case "delete":
+		return client.DeleteAccount()
+	default:
+		return fmt.Errorf("invalid account action: %s. Valid actions: password, email, delete", c.Action)
+	}
+}
+type DevicesCommand struct {
+	Action string
+	ID     string
+}
+func (c *DevicesCommand) Execute(client ClientInterface) error {
+	switch c.Action {
+	case "list":
+		return client.ListDevices()
+	case "revoke":
+		if c.ID == "" {
+			return fmt.Errorf("device ID cannot be empty")
+		}
+		return client.RevokeDevice(c.ID)
+	default:
+		return fmt.Errorf("invalid devices action: %s. Valid actions: list, revoke", c.Action)
+	}
+}
+// SSOCommand runs single sign-on actions. Issuer may be empty for login and
+// link when the server accepts a single identity provider.
+type SSOCommand struct {
+	Action string
+	Issuer string
+}
+func (c *SSOCommand) Execute(client ClientInterface) error {
+	switch c.Action {
+	case "login":
+		return client.LoginSSO(c.Issuer)
+	case "link":
+		return client.LinkSSO(c.Issuer)
+	case "list":
+		return client.ListSSO()
+	case "unlink":
+		if c.Issuer == "" {
+			return fmt.Errorf("issuer cannot be empty")
+		}
+		return client.UnlinkSSO(c.Issuer)
+	default:
+		return fmt.Errorf("invalid sso action: %s. Valid actions: login, link, list, unlink", c.Action)
+	}
+}
+type TokenCommand struct {
+	Action     string
+	Name       string
+	ID         string
+	ReadOnly   bool
+	ItemIDs    []string
+	AllowedIPs []string
+	ExpiresIn  time.Duration
+}
+func (c *TokenCommand) Execute(client ClientInterface) error {
+	switch c.Action {
+	case "create":
+		if c.Name == "" {
+			return fmt.Errorf("token name cannot be empty")
+		}
+		if c.ExpiresIn < 0 {
+			return fmt.Errorf("token expiry cannot be negative")
+		}
+		req := &models.APITokenRequest{
+			Name:       c.Name,
+			ReadOnly:   c.ReadOnly,
+			ItemIDs:    c.ItemIDs,
+			AllowedIPs: c.AllowedIPs,
+		}
+		if c.ExpiresIn > 0 {
+			expiresAt := time.Now().Add(c.ExpiresIn)
+			req.ExpiresAt = &expiresAt
+		}
+		return client.CreateAPIToken(req)
+	case "list":
+		return client.ListAPITokens()
+	case "revoke":
+		if c.ID == "" {
+			return fmt.Errorf("token ID cannot be empty")
+		}
+		return client.RevokeAPIToken(c.ID)
+	default:
+		return fmt.Errorf("invalid token action: %s. Valid actions: create, list, revoke", c.Action)
+	}
+}
+type RecoveryCommand struct {
+	Action    string
+	Shares    int
+	Threshold int
+	Format    string
+}
+func (c *RecoveryCommand) Execute(client ClientInterface) error {
+	switch c.Action {
+	case "split":
+		if c.Threshold < 2 || c.Threshold > c.Shares || c.Shares > 255 {
+			return fmt.Errorf("need 2 <= threshold <= shares <= 255")
+		}
+		if c.Format != "mnemonic" && c.Format != "base32" {
+			return fmt.Errorf("invalid share format: %s. Valid formats: mnemonic, base32", c.Format)
+		}
+		return client.RecoverySplit(c.Shares, c.Threshold, c.Format)
+	case "combine":
+		return client.RecoveryCombine()
+	default:
+		return fmt.Errorf("invalid recovery action: %s. Valid actions: split, combine", c.Action)
+	}
+}
 type HelpCommand struct{}
 func (c *HelpCommand) Execute(client ClientInterface) error {
 	ShowHelp()
@@ -193,20 +392,94 @@ func ParseCommand(args []string) (Command, error) {
 		}
 		return &DeleteCommand{ID: commandArgs[0]}, nil
 	case "sync":
+		return parseSyncCommand(commandArgs)
+	case "conflicts":
 		if len(commandArgs) != 0 {
-			return nil, fmt.Errorf("sync command takes no arguments")
+			return nil, fmt.Errorf("conflicts command takes no arguments")
 		}
-		return &SyncCommand{}, nil
+		return &ConflictsCommand{}, nil
+	case "resolve":
+		return parseResolveCommand(commandArgs)
 	case "history":
 		if len(commandArgs) != 1 {
 			return nil, fmt.Errorf("history command requires exactly 1 argument: id")
 		}
 		return &HistoryCommand{ID: commandArgs[0]}, nil
+	case "reset-local":
+		if len(commandArgs) != 0 {
+			return nil, fmt.Errorf("reset-local command takes no arguments")
+		}
+		return &ResetLocalCommand{}, nil
 	case "list":
 		if len(commandArgs) != 0 {
 			return nil, fmt.Errorf("list command takes no arguments")
 		}
 		return &ListCommand{}, nil
+	case "2fa":
+		if len(commandArgs) != 1 {
+			return nil, fmt.Errorf("2fa command requires exactly 1 argument: enable, disable or recovery-codes")
+		}
+		return &TwoFactorCommand{Action: commandArgs[0]}, nil
+	case "passwd":
+		if len(commandArgs) != 0 {
+			return nil, fmt.Errorf("passwd command takes no arguments")
+		}
+		return &PasswdCommand{}, nil
+	case "account":
+		if len(commandArgs) == 0 {
+			return nil, fmt.Errorf("account command requires an action: password, email or delete")
+		}
+		if commandArgs[0] == "email" {
+			if len(commandArgs) != 2 {
+				return nil, fmt.Errorf("account email requires exactly 1 argument: new email")
+			}
+			return &AccountCommand{Action: "email", Email: commandArgs[1]}, nil
+		}
+		if len(commandArgs) != 1 {
+			return nil, fmt.Errorf("account %s takes no arguments", commandArgs[0])
+		}
+		return &AccountCommand{Action: commandArgs[0]}, nil
+	case "devices":
+		if len(commandArgs) == 0 {
+			return nil, fmt.Errorf("devices command requires an action: list or revoke")
+		}
+		if commandArgs[0] == "revoke" {
+			if len(commandArgs) != 2 {
+				return nil, fmt.Errorf("devices revoke requires exactly 1 argument: device ID")
+			}
+			return &DevicesCommand{Action: "revoke", ID: commandArgs[1]}, nil
+		}
+		if len(commandArgs) != 1 {
+			return nil, fmt.Errorf("devices %s takes no arguments", commandArgs[0])
+		}
+		return &DevicesCommand{Action: commandArgs[0]}, nil
+	case "sso":
+		if len(commandArgs) == 0 {
+			return nil, fmt.Errorf("sso command requires an action: login, link, list or unlink")
+		}
+		switch commandArgs[0] {
+		case "login", "link":
+			if len(commandArgs) > 2 {
+				return nil, fmt.Errorf("sso %s takes at most 1 argument: issuer", commandArgs[0])
+			}
+		case "unlink":
+			if len(commandArgs) != 2 {
+				return nil, fmt.Errorf("sso unlink requires exactly 1 argument: issuer")
+			}
+		default:
+			if len(commandArgs) != 1 {
+				return nil, fmt.Errorf("sso %s takes no arguments", commandArgs[0])
+			}
+		}
+		cmd := &SSOCommand{Action: commandArgs[0]}
+		if len(commandArgs) == 2 {
+			cmd.Issuer = commandArgs[1]
+		}
+		return cmd, nil
+	case "token":
+		return parseTokenCommand(commandArgs)
+	case "recovery":
+		return parseRecoveryCommand(commandArgs)
 	case "help":
 		if len(commandArgs) != 0 {
 			return nil, fmt.Errorf("help command takes no arguments")
@@ -221,6 +494,106 @@ func ParseCommand(args []string) (Command, error) {
 		return nil, fmt.Errorf("unknown command: %s", command)
 	}
 }
+func parseRecoveryCommand(args []string) (Command, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("recovery command requires an action: split or combine")
+	}
+	cmd := &RecoveryCommand{Action: args[0]}
+	flags := flag.NewFlagSet("recovery "+cmd.Action, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	if cmd.Action == "split" {
+		flags.IntVar(&cmd.Shares, "shares", 5, "number of shares")
+		flags.IntVar(&cmd.Threshold, "threshold", 3, "shares needed to recover")
+		flags.StringVar(&cmd.Format, "format", "mnemonic", "share format")
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return nil, fmt.Errorf("recovery %s: %w", cmd.Action, err)
+	}
+	if flags.NArg() != 0 {
+		return nil, fmt.Errorf("recovery %s takes no positional arguments", cmd.Action)
+	}
+	return cmd, nil
+}
+func parseTokenCommand(args []string) (Command, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("token command requires an action: create, list or revoke")
+	}
+	switch args[0] {
+	case "create":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return nil, fmt.Errorf("token create requires a name")
+		}
+		cmd := &TokenCommand{Action: "create", Name: args[1]}
+		var items, ips string
+		flags := flag.NewFlagSet("token create", flag.ContinueOnError)
+		flags.SetOutput(io.Discard)
+		flags.BoolVar(&cmd.ReadOnly, "read-only", true, "deny writes; --read-only=false allows them")
+		flags.StringVar(&items, "items", "", "comma-separated item IDs")
+		flags.StringVar(&ips, "ip", "", "comma-separated addresses or CIDR ranges")
+		flags.DurationVar(&cmd.ExpiresIn, "expires", 0, "lifetime of the token")
+		if err := flags.Parse(args[2:]); err != nil {
+			return nil, fmt.Errorf("token create: %w", err)
+		}
+		if flags.NArg() != 0 {
+			return nil, fmt.Errorf("token create takes exactly 1 positional argument: name")
+		}
+		cmd.ItemIDs = splitList(items)
+		cmd.AllowedIPs = splitList(ips)
+		return cmd, nil
+	case "revoke":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("token revoke requires exactly 1 argument: token ID")
+		}
+		return &TokenCommand{Action: "revoke", ID: args[1]}, nil
+	default:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("token %s takes no arguments", args[0])
+		}
+		return &TokenCommand{Action: args[0]}, nil
+	}
+}
+func parseSyncCommand(args []string) (Command, error) {
+	cmd := &SyncCommand{}
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.BoolVar(&cmd.Watch, "watch", false, "keep syncing in the background")
+	flags.DurationVar(&cmd.Interval, "interval", DefaultSyncInterval, "time between syncs with --watch")
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("sync: %w", err)
+	}
+	if flags.NArg() != 0 {
+		return nil, fmt.Errorf("sync command takes no arguments")
+	}
+	return cmd, nil
+}
+func parseResolveCommand(args []string) (Command, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return nil, fmt.Errorf("resolve command requires a data ID")
+	}
+	cmd := &ResolveCommand{ID: args[0]}
+	flags := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&cmd.Keep, "keep", "", "local, server or merge")
+	if err := flags.Parse(args[1:]); err != nil {
+		return nil, fmt.Errorf("resolve: %w", err)
+	}
+	if flags.NArg() != 0 {
+		return nil, fmt.Errorf("resolve takes exactly 1 positional argument: id")
+	}
+	if cmd.Keep == "" {
+		return nil, fmt.Errorf("resolve requires --keep local, server or merge")
+	}
+	return cmd, nil
+}
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
 func ShowHelp() {
 	fmt.Println("GophKeeper - Secure Password Manager")
 	fmt.Println("")
@@ -231,6 +604,13 @@ func ShowHelp() {
 	fmt.Println("    - password: minimum 6 characters")
 	fmt.Println("")
 	fmt.Println("  login <username> <password>             Login to your account")
+	fmt.Println("    - asks for an authentication or recovery code when 2FA is enabled")
+	fmt.Println("")
+	fmt.Println("  sso login [issuer]                      Login through the company identity provider")
+	fmt.Println("    - the identity must be linked first; the master password is still needed")
+	fmt.Println("  sso link [issuer]                       Link an identity provider account to this account")
+	fmt.Println("  sso list                                List linked identities")
+	fmt.Println("  sso unlink <issuer>                     Unlink the identity at a provider")
 	fmt.Println("")
 	fmt.Println("  add <type> <title> [data...]            Add new data")
 	fmt.Println("    - type: login_password, text, binary, bank_card")
@@ -240,8 +620,33 @@ func ShowHelp() {
 	fmt.Println("  list                                    List all data")
 	fmt.Println("  get <id>                                Get specific data")
 	fmt.Println("  delete <id>                             Delete data")
-	fmt.Println("  sync                                    Synchronize with server")
+	fmt.Println("  sync [--watch] [--interval 30s]         Synchronize with server")
+	fmt.Println("    - sends changes queued while offline first, in order")
+	fmt.Println("    - --watch keeps syncing every interval until interrupted")
+	fmt.Println("  conflicts                               List items changed both here and on the server, field by field")
+	fmt.Println("  resolve <id> --keep local|server|merge  Resolve a conflict and push the result")
+	fmt.Println("    - merge takes fields changed on one side only and asks about the rest (login, card and text items)")
 	fmt.Println("  history <id>                            Show data history")
+	fmt.Println("  reset-local                             Erase the local copy of the vault, unsent changes included")
+	fmt.Println("    - needed after the master password was changed on another device while changes were pending")
+	fmt.Println("  2fa enable                              Enable two-factor authentication")
+	fmt.Println("  2fa disable                             Disable two-factor authentication")
+	fmt.Println("  2fa recovery-codes                      Replace your recovery codes")
+	fmt.Println("  passwd                                  Change the master password and re-encrypt all data")
+	fmt.Println("    - run it again to finish a change that was interrupted")
+	fmt.Println("  account password                        Change the account (login) password, logs out other devices")
+	fmt.Println("  account email <email>                   Change the account email")
+	fmt.Println("  account delete                          Delete the account and all its data, prints a signed receipt")
+	fmt.Println("  devices list                            List devices registered with the account")
+	fmt.Println("  devices revoke <id>                     Log a device out for good and wipe it on its next contact")
+	fmt.Println("  token create <name> [--read-only=false] [--items id,...] [--ip cidr,...] [--expires 720h]")
+	fmt.Println("                                          Create an API token for CI, printed only once")
+	fmt.Println("    - tokens are read-only unless --read-only=false is given")
+	fmt.Println("  token list                              List API tokens with their scopes and last use")
+	fmt.Println("  token revoke <id>                       Revoke an API token")
+	fmt.Println("  recovery split [--shares N] [--threshold K] [--format mnemonic|base32]")
+	fmt.Println("                                          Split a recovery key into printable shares (default 5 of 3)")
+	fmt.Println("  recovery combine                        Rebuild the key from shares and set a new master password")
 	fmt.Println("  help                                    Show this help")
 	fmt.Println("  version                                 Show version information")
 	fmt.Println("")
@@ -1,6 +1,7 @@
 package cli
 import (
 	"testing"
+	"time"
 	"gophkeeper/internal/client/cli"
 )
 func TestParseCommand(t *testing.T) {
@@ -15,5 +16,133 @@ func TestParseCommand(t *testing.T) {
 		t.Errorf("expected error for short username")
 	}
 }
-
-
+func TestParseCommand_Recovery(t *testing.T) {
+	cmd, err := cli.ParseCommand([]string{"recovery", "split", "--shares", "7", "--threshold", "4", "--format", "base32"})
+	if err != nil {
+		t.Fatalf("expected recovery split to parse, got %v", err)
+	}
+	split := cmd.(*cli.RecoveryCommand)
+	if split.Shares != 7 || split.Threshold != 4 || split.Format != "base32" {
+		t.Errorf("unexpected flags: %+v", split)
+	}
+	cmd, _ = cli.ParseCommand([]string{"recovery", "split"})
+	if defaults := cmd.(*cli.RecoveryCommand); defaults.Shares != 5 || defaults.Threshold != 3 || defaults.Format != "mnemonic" {
+		t.Errorf("unexpected defaults: %+v", defaults)
+	}
+	if _, err := cli.ParseCommand([]string{"recovery", "combine", "--shares", "3"}); err == nil {
+		t.Error("expected combine to reject split flags")
+	}
+	if _, err := cli.ParseCommand([]string{"recovery"}); err == nil {
+		t.Error("expected error for missing recovery action")
+	}
+}
+func TestParseCommand_Devices(t *testing.T) {
+	cmd, err := cli.ParseCommand([]string{"devices", "revoke", "device-1"})
+	if err != nil {
+		t.Fatalf("expected devices revoke to parse, got %v", err)
+	}
+	if revoke := cmd.(*cli.DevicesCommand); revoke.Action != "revoke" || revoke.ID != "device-1" {
+		t.Errorf("unexpected command: %+v", revoke)
+	}
+	if _, err := cli.ParseCommand([]string{"devices", "revoke"}); err == nil {
+		t.Error("expected error for missing device ID")
+	}
+	if _, err := cli.ParseCommand([]string{"devices", "list", "extra"}); err == nil {
+		t.Error("expected devices list to take no arguments")
+	}
+}
+func TestParseCommand_Token(t *testing.T) {
+	cmd, err := cli.ParseCommand([]string{"token", "create", "ci", "--read-only", "--items", "a, b", "--ip", "10.0.0.0/8", "--expires", "720h"})
+	if err != nil {
+		t.Fatalf("expected token create to parse, got %v", err)
+	}
+	create := cmd.(*cli.TokenCommand)
+	if create.Name != "ci" || !create.ReadOnly || len(create.ItemIDs) != 2 || create.ItemIDs[1] != "b" ||
+		len(create.AllowedIPs) != 1 || create.ExpiresIn != 720*time.Hour {
+		t.Errorf("unexpected command: %+v", create)
+	}
+	cmd, err = cli.ParseCommand([]string{"token", "create", "ci"})
+	if err != nil || !cmd.(*cli.TokenCommand).ReadOnly {
+		t.Errorf("expected tokens to be read-only by default, got %+v, %v", cmd, err)
+	}
+	cmd, err = cli.ParseCommand([]string{"token", "create", "ci", "--read-only=false"})
+	if err != nil || cmd.(*cli.TokenCommand).ReadOnly {
+		t.Errorf("expected --read-only=false to allow writes, got %+v, %v", cmd, err)
+	}
+	if _, err := cli.ParseCommand([]string{"token", "create", "--read-only"}); err == nil {
+		t.Error("expected error for missing token name")
+	}
+	if _, err := cli.ParseCommand([]string{"token", "create", "ci", "--expires", "soon"}); err == nil {
+		t.Error("expected error for invalid expiry")
+	}
+	if _, err := cli.ParseCommand([]string{"token", "revoke"}); err == nil {
+		t.Error("expected error for missing token ID")
+	}
+	if _, err := cli.ParseCommand([]string{"token", "list", "extra"}); err == nil {
+		t.Error("expected token list to take no arguments")
+	}
+}
+func TestParseCommand_Resolve(t *testing.T) {
+	cmd, err := cli.ParseCommand([]string{"resolve", "item-1", "--keep", "server"})
+	if err != nil {
+		t.Fatalf("expected resolve to parse, got %v", err)
+	}
+	if resolve := cmd.(*cli.ResolveCommand); resolve.ID != "item-1" || resolve.Keep != "server" {
+		t.Errorf("unexpected command: %+v", resolve)
+	}
+	if _, err := cli.ParseCommand([]string{"resolve", "item-1"}); err == nil {
+		t.Error("expected error for missing --keep")
+	}
+	if _, err := cli.ParseCommand([]string{"resolve", "--keep", "local"}); err == nil {
+		t.Error("expected error for missing ID")
+	}
+	if _, err := cli.ParseCommand([]string{"conflicts", "extra"}); err == nil {
+		t.Error("expected conflicts to take no arguments")
+	}
+}
+func TestParseCommand_SyncWatch(t *testing.T) {
+	cmd, err := cli.ParseCommand([]string{"sync", "--watch", "--interval", "5s"})
+	if err != nil {
+		t.Fatalf("expected sync --watch to parse, got %v", err)
+	}
+	mockClient := &MockClient{}
+	if err := cmd.Execute(mockClient); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if mockClient.WatchInterval != 5*time.Second {
+		t.Errorf("expected a 5s interval, got %s", mockClient.WatchInterval)
+	}
+	cmd, err = cli.ParseCommand([]string{"sync"})
+	if err != nil || cmd.(*cli.SyncCommand).Watch {
+		t.Errorf("expected a single sync, got %+v (%v)", cmd, err)
+	}
+	cmd, _ = cli.ParseCommand([]string{"sync", "--watch", "--interval", "10ms"})
+	if err := cmd.Execute(mockClient); err == nil {
+		t.Error("expected error for an interval under a second")
+	}
+	if _, err := cli.ParseCommand([]string{"sync", "extra"}); err == nil {
+		t.Error("expected sync to take no positional arguments")
+	}
+}
+func TestParseCommand_SSO(t *testing.T) {
+	cmd, err := cli.ParseCommand([]string{"sso", "login"})
+	if err != nil {
+		t.Fatalf("expected sso login to parse, got %v", err)
+	}
+	if login := cmd.(*cli.SSOCommand); login.Action != "login" || login.Issuer != "" {
+		t.Errorf("unexpected command: %+v", login)
+	}
+	cmd, err = cli.ParseCommand([]string{"sso", "link", "https://sso.example.com"})
+	if err != nil || cmd.(*cli.SSOCommand).Issuer != "https://sso.example.com" {
+		t.Errorf("expected sso link to take an issuer, got %+v (%v)", cmd, err)
+	}
+	if _, err := cli.ParseCommand([]string{"sso", "unlink"}); err == nil {
+		t.Error("expected error for missing issuer")
+	}
+	if _, err := cli.ParseCommand([]string{"sso", "list", "extra"}); err == nil {
+		t.Error("expected sso list to take no arguments")
+	}
+	if _, err := cli.ParseCommand([]string{"sso"}); err == nil {
+		t.Error("expected error for missing sso action")
+	}
+}
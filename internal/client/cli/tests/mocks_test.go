@@ -4,6 +4,7 @@ import (
 	"gophkeeper/internal/client/cli"
 	"gophkeeper/internal/models"
 	"testing"
+	"time"
 )
 
 type MockClient struct {
@@ -16,6 +17,18 @@ type MockClient struct {
 	ShowHistoryFunc func(id string) error
 	ListDataFunc    func() error
 	GetDataListFunc func() ([]models.StoredData, error)
+	TwoFactorAction string
+	ResetCalled     bool
+	PasswdCalled    bool
+	RecoveryAction  string
+	RecoveryArgs    []interface{}
+	AccountAction   string
+	DevicesAction   string
+	TokenAction     string
+	TokenRequest    *models.APITokenRequest
+	SSOAction       string
+	Resolved        string
+	WatchInterval   time.Duration
 }
 
 func (m *MockClient) Register(username, email, password string) error {
@@ -54,6 +67,10 @@ func (m *MockClient) SyncData() error {
 	}
 	return nil
 }
+func (m *MockClient) WatchSync(interval time.Duration) error {
+	m.WatchInterval = interval
+	return nil
+}
 func (m *MockClient) ShowHistory(id string) error {
 	if m.ShowHistoryFunc != nil {
 		return m.ShowHistoryFunc(id)
@@ -72,6 +89,91 @@ func (m *MockClient) GetDataList() ([]models.StoredData, error) {
 	}
 	return []models.StoredData{}, nil
 }
+func (m *MockClient) EnableTwoFactor() error {
+	m.TwoFactorAction = "enable"
+	return nil
+}
+func (m *MockClient) DisableTwoFactor() error {
+	m.TwoFactorAction = "disable"
+	return nil
+}
+func (m *MockClient) RegenerateRecoveryCodes() error {
+	m.TwoFactorAction = "recovery-codes"
+	return nil
+}
+func (m *MockClient) DiscardLocalChanges() error {
+	m.ResetCalled = true
+	return nil
+}
+func (m *MockClient) ChangeMasterPassword() error {
+	m.PasswdCalled = true
+	return nil
+}
+func (m *MockClient) RecoverySplit(shares, threshold int, format string) error {
+	m.RecoveryAction = "split"
+	m.RecoveryArgs = []interface{}{shares, threshold, format}
+	return nil
+}
+func (m *MockClient) RecoveryCombine() error {
+	m.RecoveryAction = "combine"
+	return nil
+}
+func (m *MockClient) ChangeAccountPassword() error {
+	m.AccountAction = "password"
+	return nil
+}
+func (m *MockClient) ChangeEmail(email string) error {
+	m.AccountAction = "email:" + email
+	return nil
+}
+func (m *MockClient) DeleteAccount() error {
+	m.AccountAction = "delete"
+	return nil
+}
+func (m *MockClient) ListDevices() error {
+	m.DevicesAction = "list"
+	return nil
+}
+func (m *MockClient) RevokeDevice(id string) error {
+	m.DevicesAction = "revoke:" + id
+	return nil
+}
+func (m *MockClient) CreateAPIToken(req *models.APITokenRequest) error {
+	m.TokenAction = "create"
+	m.TokenRequest = req
+	return nil
+}
+func (m *MockClient) ListAPITokens() error {
+	m.TokenAction = "list"
+	return nil
+}
+func (m *MockClient) RevokeAPIToken(id string) error {
+	m.TokenAction = "revoke:" + id
+	return nil
+}
+func (m *MockClient) LoginSSO(issuer string) error {
+	m.SSOAction = "login:" + issuer
+	return nil
+}
+func (m *MockClient) LinkSSO(issuer string) error {
+	m.SSOAction = "link:" + issuer
+	return nil
+}
+func (m *MockClient) ListSSO() error {
+	m.SSOAction = "list"
+	return nil
+}
+func (m *MockClient) UnlinkSSO(issuer string) error {
+	m.SSOAction = "unlink:" + issuer
+	return nil
+}
+func (m *MockClient) ListConflicts() error {
+	return nil
+}
+func (m *MockClient) ResolveConflict(id, keep string) error {
+	m.Resolved = id + ":" + keep
+	return nil
+}
 func TestExecuteMethods(t *testing.T) {
 	t.Run("RegisterCommand_Execute", func(t *testing.T) {
 		cmd := &cli.RegisterCommand{Username: "testuser", Email: "test@example.com", Password: "password123"}
@@ -97,4 +199,126 @@ func TestExecuteMethods(t *testing.T) {
 			t.Fatalf("expected no error, got %v", err)
 		}
 	})
+	t.Run("TwoFactorCommand_Execute", func(t *testing.T) {
+		for _, action := range []string{"enable", "disable", "recovery-codes"} {
+			mockClient := &MockClient{}
+			if err := (&cli.TwoFactorCommand{Action: action}).Execute(mockClient); err != nil {
+				t.Fatalf("expected no error for %s, got %v", action, err)
+			}
+			if mockClient.TwoFactorAction != action {
+				t.Errorf("expected %s to be dispatched, got %q", action, mockClient.TwoFactorAction)
+			}
+		}
+		if err := (&cli.TwoFactorCommand{Action: "bogus"}).Execute(&MockClient{}); err == nil {
+			t.Error("expected error for unknown 2fa action")
+		}
+	})
+	t.Run("PasswdCommand_Execute", func(t *testing.T) {
+		mockClient := &MockClient{}
+		if err := (&cli.PasswdCommand{}).Execute(mockClient); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !mockClient.PasswdCalled {
+			t.Error("expected passwd to change the master password")
+		}
+	})
+	t.Run("ResetLocalCommand_Execute", func(t *testing.T) {
+		mockClient := &MockClient{}
+		if err := (&cli.ResetLocalCommand{}).Execute(mockClient); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !mockClient.ResetCalled {
+			t.Error("expected reset-local to discard the local copy")
+		}
+	})
+	t.Run("RecoveryCommand_Execute", func(t *testing.T) {
+		mockClient := &MockClient{}
+		if err := (&cli.RecoveryCommand{Action: "split", Shares: 5, Threshold: 3, Format: "base32"}).Execute(mockClient); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if mockClient.RecoveryAction != "split" || mockClient.RecoveryArgs[1] != 3 {
+			t.Errorf("expected split to be dispatched, got %q %v", mockClient.RecoveryAction, mockClient.RecoveryArgs)
+		}
+		if err := (&cli.RecoveryCommand{Action: "split", Shares: 2, Threshold: 3, Format: "mnemonic"}).Execute(&MockClient{}); err == nil {
+			t.Error("expected error for threshold above shares")
+		}
+		if err := (&cli.RecoveryCommand{Action: "combine"}).Execute(mockClient); err != nil || mockClient.RecoveryAction != "combine" {
+			t.Errorf("expected combine to be dispatched, got %v", err)
+		}
+	})
+	t.Run("AccountCommand_Execute", func(t *testing.T) {
+		for action, want := range map[string]string{"password": "password", "delete": "delete"} {
+			mockClient := &MockClient{}
+			if err := (&cli.AccountCommand{Action: action}).Execute(mockClient); err != nil || mockClient.AccountAction != want {
+				t.Errorf("expected %s to be dispatched, got %q (%v)", action, mockClient.AccountAction, err)
+			}
+		}
+		mockClient := &MockClient{}
+		if err := (&cli.AccountCommand{Action: "email", Email: "new@example.com"}).Execute(mockClient); err != nil || mockClient.AccountAction != "email:new@example.com" {
+			t.Errorf("expected email change to be dispatched, got %q (%v)", mockClient.AccountAction, err)
+		}
+		if err := (&cli.AccountCommand{Action: "email", Email: "not-an-email"}).Execute(&MockClient{}); err == nil {
+			t.Error("expected error for invalid email")
+		}
+	})
+	t.Run("DevicesCommand_Execute", func(t *testing.T) {
+		mockClient := &MockClient{}
+		if err := (&cli.DevicesCommand{Action: "list"}).Execute(mockClient); err != nil || mockClient.DevicesAction != "list" {
+			t.Errorf("expected list to be dispatched, got %q (%v)", mockClient.DevicesAction, err)
+		}
+		if err := (&cli.DevicesCommand{Action: "revoke", ID: "device-1"}).Execute(mockClient); err != nil || mockClient.DevicesAction != "revoke:device-1" {
+			t.Errorf("expected revoke to be dispatched, got %q (%v)", mockClient.DevicesAction, err)
+		}
+		if err := (&cli.DevicesCommand{Action: "revoke"}).Execute(&MockClient{}); err == nil {
+			t.Error("expected error for empty device ID")
+		}
+	})
+	t.Run("TokenCommand_Execute", func(t *testing.T) {
+		mockClient := &MockClient{}
+		cmd := &cli.TokenCommand{Action: "create", Name: "ci", ReadOnly: true, ItemIDs: []string{"item-1"}, ExpiresIn: time.Hour}
+		if err := cmd.Execute(mockClient); err != nil || mockClient.TokenAction != "create" {
+			t.Fatalf("expected create to be dispatched, got %q (%v)", mockClient.TokenAction, err)
+		}
+		req := mockClient.TokenRequest
+		if req.Name != "ci" || !req.ReadOnly || len(req.ItemIDs) != 1 || req.ExpiresAt == nil || !req.ExpiresAt.After(time.Now()) {
+			t.Errorf("unexpected token request: %+v", req)
+		}
+		if err := (&cli.TokenCommand{Action: "create", Name: "ci"}).Execute(mockClient); err != nil || mockClient.TokenRequest.ExpiresAt != nil {
+			t.Errorf("expected a token without expiry, got %+v (%v)", mockClient.TokenRequest, err)
+		}
+		if err := (&cli.TokenCommand{Action: "revoke", ID: "token-1"}).Execute(mockClient); err != nil || mockClient.TokenAction != "revoke:token-1" {
+			t.Errorf("expected revoke to be dispatched, got %q (%v)", mockClient.TokenAction, err)
+		}
+		if err := (&cli.TokenCommand{Action: "create"}).Execute(&MockClient{}); err == nil {
+			t.Error("expected error for empty token name")
+		}
+	})
+	t.Run("SSOCommand_Execute", func(t *testing.T) {
+		mockClient := &MockClient{}
+		for _, tc := range []struct {
+			cmd  cli.SSOCommand
+			want string
+		}{
+			{cli.SSOCommand{Action: "login"}, "login:"},
+			{cli.SSOCommand{Action: "link", Issuer: "https://sso.example.com"}, "link:https://sso.example.com"},
+			{cli.SSOCommand{Action: "list"}, "list"},
+			{cli.SSOCommand{Action: "unlink", Issuer: "https://sso.example.com"}, "unlink:https://sso.example.com"},
+		} {
+			if err := tc.cmd.Execute(mockClient); err != nil || mockClient.SSOAction != tc.want {
+				t.Errorf("expected %q to be dispatched, got %q (%v)", tc.want, mockClient.SSOAction, err)
+			}
+		}
+		if err := (&cli.SSOCommand{Action: "unlink"}).Execute(&MockClient{}); err == nil {
+			t.Error("expected error for empty issuer")
+		}
+	})
+	t.Run("ResolveCommand_Execute", func(t *testing.T) {
+		mockClient := &MockClient{}
+		if err := (&cli.ResolveCommand{ID: "item-1", Keep: "merge"}).Execute(mockClient); err != nil || mockClient.Resolved != "item-1:merge" {
+			t.Errorf("expected merge to be dispatched, got %q (%v)", mockClient.Resolved, err)
+		}
+		if err := (&cli.ResolveCommand{ID: "item-1", Keep: "both"}).Execute(&MockClient{}); err == nil {
+			t.Error("expected error for invalid choice")
+		}
+	})
 }
@@ -1,12 +1,21 @@
 package client
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"gophkeeper/internal/config"
 	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/logger"
 	"gophkeeper/internal/models"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -15,39 +24,230 @@ type Client struct {
 	authService AuthService
 	dataService DataService
 	syncService SyncService
+	conflicts   ConflictService
+	rekey       RekeyService
+	recovery    RecoveryService
+	devices     DeviceService
+	apiTokens   APITokenService
+	storage     Storage
+	keys        KeyStore
+	identities  DeviceStore
+	vault       Vault
+	prompter    Prompter
 }
 
-func NewClient(serverURL, configDir, encryptionKey string) (*Client, error) {
-	if err := os.MkdirAll(configDir, 0755); err != nil {
+func NewClient(cfg config.ClientConfig) (*Client, error) {
+	if err := os.MkdirAll(cfg.ConfigDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create config directory: %w", err)
 	}
-	dbPath := filepath.Join(configDir, "data.db")
+	dbPath := filepath.Join(cfg.ConfigDir, "data.db")
 	storage, err := NewClientStorage(dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize storage: %w", err)
 	}
-	httpClient := NewHTTPClient(serverURL)
-	tokenManager := NewTokenManager(configDir)
-	encryptor := crypto.NewEncryptor(encryptionKey)
+	httpClient := NewHTTPClient(cfg.ServerURL)
+	tokenManager := NewTokenManager(cfg.ConfigDir)
 	authService := NewAuthService(httpClient, tokenManager)
-	dataService := NewDataService(storage, httpClient, encryptor, authService)
-	syncService := NewSyncService(storage, httpClient, encryptor, authService)
-	return &Client{
+	httpClient.SetTokenRefresher(authService.RefreshToken)
+	prompter := NewTerminalPrompter()
+	vault := NewVault(storage, authService, prompter, VaultOptions{
+		MasterPassword: cfg.MasterPassword,
+		LegacyKey:      cfg.LegacyEncryptionKey,
+		KDFTime:        cfg.KDFTime,
+		KDFMemory:      cfg.KDFMemory,
+		KDFParallelism: cfg.KDFParallelism,
+	})
+	storage.SetEncryptor(vault)
+	httpClient.SetVaultKeyCheck(vault.KeyCheck)
+	dataService := NewDataService(storage, httpClient, vault, authService)
+	syncService := NewSyncService(storage, httpClient, vault, authService)
+	rekey := NewRekeyService(storage, storage, httpClient, vault, syncService, authService)
+	recovery := NewRecoveryService(storage, httpClient, vault, rekey, authService)
+	deviceName := cfg.DeviceName
+	if deviceName == "" {
+		deviceName = "gophkeeper"
+	}
+	devices := NewDeviceService(storage, httpClient, authService, deviceName, runtime.GOOS+"/"+runtime.GOARCH)
+	c := &Client{
 		authService: authService,
 		dataService: dataService,
 		syncService: syncService,
-	}, nil
+		conflicts:   NewConflictService(storage, httpClient, vault, authService),
+		rekey:       rekey,
+		recovery:    recovery,
+		devices:     devices,
+		apiTokens:   NewAPITokenService(httpClient, authService),
+		storage:     storage,
+		keys:        storage,
+		identities:  storage,
+		vault:       vault,
+		prompter:    prompter,
+	}
+	httpClient.SetDeviceRevokedHandler(c.wipeRevokedDevice)
+	if cfg.APIToken != "" {
+		if err := c.useAPIToken(httpClient, authService, cfg.APIToken); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+// useAPIToken switches the client to an API token, for CI jobs. The vault is
+// still opened with the master password.
+func (c *Client) useAPIToken(httpClient HTTPClient, authService *AuthServiceImpl, token string) error {
+	info, err := httpClient.GetAPITokenInfo(token)
+	if err != nil {
+		return err
+	}
+	authService.UseAPIToken(token, info.UserID, info.Username)
+	if info.KDF == nil {
+		return nil
+	}
+	if err := c.dropStaleVault(info.UserID, info.KDF); err != nil {
+		// The old key stays, so unsent changes can still be read; the
+		// server refuses writes sealed with it.
+		logger.Warn("%v", err)
+		return nil
+	}
+	return c.keys.SaveKDFParams(info.UserID, info.KDF)
 }
 func (c *Client) Register(username, email, password string) error {
-	_, err := c.authService.Register(username, email, password)
-	return err
+	params, err := c.vault.Create()
+	if err != nil {
+		return err
+	}
+	response, err := c.authService.Register(username, email, password, params)
+	if err != nil {
+		return err
+	}
+	if err := c.devices.Register(); err != nil {
+		return err
+	}
+	return c.vault.Persist(response.User.ID, params)
 }
 func (c *Client) Login(username, password string) error {
-	_, err := c.authService.Login(username, password)
-	return err
+	response, err := c.authService.Login(username, password)
+	if err != nil {
+		return err
+	}
+	return c.completeLogin(response)
+}
+// LoginSSO logs in through the identity provider. The vault is still opened
+// with the master password.
+func (c *Client) LoginSSO(issuer string) error {
+	response, err := c.authService.LoginSSO(issuer)
+	if err != nil {
+		return err
+	}
+	return c.completeLogin(response)
+}
+// completeLogin asks for the second factor when needed, unlocks the vault
+// and registers the device.
+func (c *Client) completeLogin(response *models.AuthResponse) error {
+	if response.MFARequired {
+		code, err := c.prompter.ReadLine("Authentication code (or recovery code): ")
+		if err != nil {
+			return err
+		}
+		response, err = c.authService.LoginMFA(response.MFAToken, code)
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.openVault(response); err != nil {
+		return err
+	}
+	// The device key is sealed under the vault key, so the device is
+	// registered once the vault is open.
+	return c.devices.Register()
+}
+func (c *Client) openVault(response *models.AuthResponse) error {
+	if response.KDF != nil {
+		if err := c.dropStaleVault(response.User.ID, response.KDF); err != nil {
+			return err
+		}
+		err := c.vault.Unlock(response.User.ID, response.KDF)
+		if errors.Is(err, ErrWrongMasterPassword) {
+			return fmt.Errorf("%w (if it is lost, run 'recovery combine' with your recovery kit)", err)
+		}
+		return err
+	}
+	logger.Info("Account has no vault key parameters yet, creating them")
+	params, err := c.vault.Create()
+	if err != nil {
+		return err
+	}
+	if err := c.authService.SetKDFParams(params); err != nil {
+		return err
+	}
+	return c.vault.Persist(response.User.ID, params)
+}
+// EnableTwoFactor enrolls an authenticator app and prints the recovery codes
+// once the first code from the app has been confirmed.
+func (c *Client) EnableTwoFactor() error {
+	enrollment, err := c.authService.EnrollTOTP()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Scan this QR code with your authenticator app:")
+	fmt.Println()
+	if err := RenderQR(os.Stdout, enrollment.URI); err != nil {
+		return err
+	}
+	fmt.Printf("\nOr enter the secret manually: %s\n", enrollment.Secret)
+	fmt.Printf("URI: %s\n\n", enrollment.URI)
+	code, err := c.prompter.ReadLine("Code from the app: ")
+	if err != nil {
+		return err
+	}
+	codes, err := c.authService.ConfirmTOTP(code)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Two-factor authentication enabled.")
+	printRecoveryCodes(codes)
+	return nil
+}
+func (c *Client) DisableTwoFactor() error {
+	code, err := c.prompter.ReadLine("Authentication code (or recovery code): ")
+	if err != nil {
+		return err
+	}
+	if err := c.authService.DisableTOTP(code); err != nil {
+		return err
+	}
+	fmt.Println("Two-factor authentication disabled.")
+	return nil
+}
+func (c *Client) RegenerateRecoveryCodes() error {
+	code, err := c.prompter.ReadLine("Authentication code (or recovery code): ")
+	if err != nil {
+		return err
+	}
+	codes, err := c.authService.RegenerateRecoveryCodes(code)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Previous recovery codes are no longer valid.")
+	printRecoveryCodes(codes)
+	return nil
+}
+func printRecoveryCodes(codes []string) {
+	fmt.Println("Recovery codes (each works once; store them somewhere safe):")
+	for _, code := range codes {
+		fmt.Printf("  %s\n", code)
+	}
 }
 func (c *Client) AddData(dataType, title string, data []string) error {
-	return c.dataService.AddData(dataType, title, data)
+	return reportQueued(c.dataService.AddData(dataType, title, data))
+}
+// reportQueued tells the user that a local write is saved but waits for the
+// next sync, which is not a failure of the command.
+func reportQueued(err error) error {
+	if errors.Is(err, ErrQueued) {
+		fmt.Println("Saved locally; the change will be sent to the server on the next sync.")
+		return nil
+	}
+	return err
 }
 func (c *Client) ListData() error {
 	dataList, err := c.dataService.GetDataList()
@@ -85,10 +285,453 @@ func (c *Client) GetData(id string) error {
 	return nil
 }
 func (c *Client) DeleteData(id string) error {
-	return c.dataService.DeleteData(id)
+	return reportQueued(c.dataService.DeleteData(id))
 }
 func (c *Client) SyncData() error {
-	return c.syncService.SyncData()
+	if err := c.syncService.SyncData(); err != nil {
+		return err
+	}
+	conflicts, err := c.conflicts.List()
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		fmt.Printf("%d item(s) were changed both here and on the server. Run 'conflicts' to review them.\n", len(conflicts))
+	}
+	rejected, err := c.storage.ListDeadLetters(c.authService.GetUserID())
+	if err != nil {
+		return err
+	}
+	if len(rejected) > 0 {
+		fmt.Printf("%d change(s) were rejected by the server. Run 'conflicts' to review them.\n", len(rejected))
+	}
+	return nil
+}
+// WatchSync syncs every interval until interrupted. A failed sync, e.g.
+// while offline, is reported and retried on the next tick; queued changes
+// stay queued until then.
+func (c *Client) WatchSync(interval time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	fmt.Printf("Syncing every %s, press Ctrl+C to stop.\n", interval)
+	for {
+		if err := c.SyncData(); err != nil {
+			if errors.Is(err, models.ErrDeviceRevoked) {
+				return err
+			}
+			logger.Warn("Background sync failed: %v", err)
+			fmt.Printf("%s sync failed: %v\n", time.Now().Format("15:04:05"), err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+// ListConflicts prints every open conflict with the two copies side by side,
+// then the queued changes the server rejected. Fields that differ are marked
+// with '*'.
+func (c *Client) ListConflicts() error {
+	conflicts, err := c.conflicts.List()
+	if err != nil {
+		return err
+	}
+	rejected, err := c.storage.ListDeadLetters(c.authService.GetUserID())
+	if err != nil {
+		return err
+	}
+	if len(conflicts) == 0 && len(rejected) == 0 {
+		fmt.Println("No conflicts.")
+		return nil
+	}
+	if len(rejected) > 0 {
+		fmt.Println("Changes rejected by the server (sent again with the next sync):")
+		for _, entry := range rejected {
+			fmt.Printf("  %s of item %s at %s: %s\n", entry.Op, entry.ItemID, entry.DeadAt.Format("2006-01-02 15:04:05"), entry.LastError)
+		}
+		fmt.Println()
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	for _, conflict := range conflicts {
+		fmt.Printf("Item %s: %s (server version %d, detected %s)\n", conflict.ID, conflict.Reason,
+			conflict.Server.Version, conflict.DetectedAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("  %-14s %-40s %-40s\n", "Field", "Local", "Server")
+		fmt.Println("  " + strings.Repeat("-", 96))
+		for _, field := range conflict.Fields() {
+			marker := " "
+			if field.Differs() {
+				marker = "*"
+			}
+			fmt.Printf("%s %-14s %-40s %-40s\n", marker, field.Name, truncate(field.Local, 40), truncate(field.Server, 40))
+		}
+		fmt.Println()
+	}
+	fmt.Println("Resolve with: resolve <id> --keep local|server|merge")
+	return nil
+}
+// ResolveConflict settles a conflict. A merge asks for every field the two
+// copies disagree on.
+func (c *Client) ResolveConflict(id, keep string) error {
+	result, err := c.conflicts.Resolve(id, keep, c.chooseField)
+	if err != nil && !errors.Is(err, ErrQueued) {
+		return err
+	}
+	fmt.Printf("Conflict on item %s resolved with the %s copy, now at version %d.\n", id, keep, result.Version)
+	return reportQueued(err)
+}
+func (c *Client) chooseField(field ConflictField) (string, error) {
+	fmt.Printf("%s:\n  local:  %s\n  server: %s\n", field.Name, field.Local, field.Server)
+	for {
+		answer, err := c.prompter.ReadLine("Keep [l]ocal, [s]erver or [e]dit: ")
+		if err != nil {
+			return "", err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "l", "local":
+			return field.Local, nil
+		case "s", "server":
+			return field.Server, nil
+		case "e", "edit":
+			return c.prompter.ReadLine(fmt.Sprintf("New %s: ", field.Name))
+		}
+		fmt.Println("Answer l, s or e.")
+	}
+}
+func truncate(value string, width int) string {
+	value = strings.ReplaceAll(value, "\n", " ")
+	if len([]rune(value)) <= width {
+		return value
+	}
+	return string([]rune(value)[:width-3]) + "..."
+}
+func (c *Client) ChangeMasterPassword() error {
+	if err := c.rekey.ChangeMasterPassword(); err != nil {
+		return err
+	}
+	fmt.Println("Master password changed. Log in again on your other devices.")
+	return nil
+}
+func (c *Client) ChangeAccountPassword() error {
+	current, err := c.prompter.ReadPassword("Current password: ")
+	if err != nil {
+		return err
+	}
+	next, err := c.prompter.ReadPassword("New password: ")
+	if err != nil {
+		return err
+	}
+	if len(next) < 6 {
+		return fmt.Errorf("password must be at least 6 characters long")
+	}
+	confirm, err := c.prompter.ReadPassword("Repeat new password: ")
+	if err != nil {
+		return err
+	}
+	if confirm != next {
+		return fmt.Errorf("passwords do not match")
+	}
+	if err := c.authService.ChangePassword(current, next); err != nil {
+		return err
+	}
+	fmt.Println("Password changed. Other devices have been logged out.")
+	return nil
+}
+func (c *Client) ChangeEmail(email string) error {
+	if err := c.authService.ChangeEmail(email); err != nil {
+		return err
+	}
+	fmt.Printf("Email changed to %s.\n", email)
+	return nil
+}
+// DeleteAccount erases the account on the server and the local copy of the
+// vault, then prints the signed deletion receipt.
+func (c *Client) DeleteAccount() error {
+	if !c.authService.IsAuthenticated() {
+		return fmt.Errorf("not authenticated")
+	}
+	confirm, err := c.prompter.ReadLine("This erases all your data on the server. Type 'delete' to confirm: ")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(confirm) != "delete" {
+		return fmt.Errorf("account deletion cancelled")
+	}
+	password, err := c.prompter.ReadPassword("Password: ")
+	if err != nil {
+		return err
+	}
+	userID := c.authService.GetUserID()
+	response, err := c.authService.DeleteAccount(password)
+	if err != nil {
+		return err
+	}
+	if err := c.storage.ResetData(userID); err != nil {
+		logger.Warn("Failed to clear local data: %v", err)
+	}
+	c.vault.Lock()
+	receipt := response.Receipt
+	fmt.Printf("Account %s deleted at %s.\n", receipt.Username, time.Unix(receipt.DeletedAt, 0).Format("2006-01-02 15:04:05"))
+	fmt.Printf("Erased %d items and %d history entries.\n", receipt.DeletedItems, receipt.DeletedHistory)
+	fmt.Println("Deletion receipt (signed by the server, keep it as proof):")
+	fmt.Printf("  %s\n", response.Signature)
+	return nil
+}
+// RecoverySplit creates a recovery kit and prints its shares.
+func (c *Client) RecoverySplit(shares, threshold int, format string) error {
+	kit, err := c.recovery.Split(shares, threshold)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Recovery kit: any %d of these %d shares reset the master password.\n", threshold, shares)
+	fmt.Println("Store them in separate places; earlier kits no longer work.")
+	for i, share := range kit {
+		text := share.Mnemonic()
+		if format == "base32" {
+			text = share.Base32()
+		}
+		fmt.Printf("\nShare %d:\n  %s\n", i+1, text)
+	}
+	return nil
+}
+
+// RecoveryCombine reads shares until the threshold is reached, then resets
+// the master password with the rebuilt key.
+func (c *Client) RecoveryCombine() error {
+	var shares []string
+	threshold := 0
+	for threshold == 0 || len(shares) < threshold {
+		text, err := c.prompter.ReadLine(fmt.Sprintf("Share %d: ", len(shares)+1))
+		if err != nil {
+			return err
+		}
+		share, err := crypto.ParseRecoveryShare(text)
+		if err != nil {
+			return fmt.Errorf("share %d: %w", len(shares)+1, err)
+		}
+		threshold = int(share.Threshold)
+		shares = append(shares, text)
+	}
+	if err := c.recovery.Combine(shares); err != nil {
+		return err
+	}
+	fmt.Println("Master password reset. Log in again on your other devices.")
+	return nil
+}
+
+// ListDevices prints the devices registered with the account.
+func (c *Client) ListDevices() error {
+	devices, err := c.devices.List()
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		fmt.Println("No devices found.")
+		return nil
+	}
+	fmt.Printf("%-36s %-24s %-16s %-20s %-8s\n", "ID", "Name", "Platform", "Last seen", "Status")
+	fmt.Println(strings.Repeat("-", 108))
+	for _, device := range devices {
+		lastSeen := "-"
+		if device.LastSeenAt != nil {
+			lastSeen = device.LastSeenAt.Format("2006-01-02 15:04:05")
+		}
+		status := "active"
+		if device.RevokedAt != nil {
+			status = "revoked"
+		}
+		name := device.Name
+		if device.Current {
+			name += " (this)"
+		}
+		fmt.Printf("%-36s %-24s %-16s %-20s %-8s\n", device.ID, name, device.Platform, lastSeen, status)
+	}
+	return nil
+}
+// RevokeDevice ends every session of the device. Revoking this device wipes
+// it right away; others are wiped on their next contact with the server.
+func (c *Client) RevokeDevice(id string) error {
+	claims, err := c.authService.TokenClaims()
+	if err != nil {
+		return err
+	}
+	if err := c.devices.Revoke(id); err != nil {
+		return err
+	}
+	fmt.Printf("Device %s revoked.\n", id)
+	if claims.DeviceID == id {
+		c.wipeRevokedDevice()
+	}
+	return nil
+}
+func (c *Client) LinkSSO(issuer string) error {
+	password, err := c.prompter.ReadPassword("Password: ")
+	if err != nil {
+		return err
+	}
+	identity, err := c.authService.LinkSSO(issuer, password)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Linked %s (%s). You can now log in with 'sso login'.\n", identity.Issuer, ssoIdentityName(identity))
+	return nil
+}
+func (c *Client) ListSSO() error {
+	identities, err := c.authService.ListSSO()
+	if err != nil {
+		return err
+	}
+	if len(identities) == 0 {
+		fmt.Println("No linked identities.")
+		return nil
+	}
+	fmt.Printf("%-40s %-32s %-20s\n", "Issuer", "Identity", "Last login")
+	fmt.Println(strings.Repeat("-", 94))
+	for _, identity := range identities {
+		lastLogin := "-"
+		if identity.LastLoginAt != nil {
+			lastLogin = identity.LastLoginAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%-40s %-32s %-20s\n", identity.Issuer, ssoIdentityName(&identity), lastLogin)
+	}
+	return nil
+}
+func (c *Client) UnlinkSSO(issuer string) error {
+	password, err := c.prompter.ReadPassword("Password: ")
+	if err != nil {
+		return err
+	}
+	if err := c.authService.UnlinkSSO(issuer, password); err != nil {
+		return err
+	}
+	fmt.Printf("Unlinked %s.\n", issuer)
+	return nil
+}
+func ssoIdentityName(identity *models.OIDCIdentity) string {
+	if identity.Email != "" {
+		return identity.Email
+	}
+	return identity.Subject
+}
+// CreateAPIToken issues an API token and prints its secret, which cannot be
+// shown again.
+func (c *Client) CreateAPIToken(req *models.APITokenRequest) error {
+	response, err := c.apiTokens.Create(req)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("API token %s (%s) created. Store it now, it will not be shown again:\n\n", response.APIToken.ID, response.APIToken.Name)
+	fmt.Printf("  %s\n\n", response.Token)
+	fmt.Println("Use it with GOPHKEEPER_API_TOKEN, or as 'Authorization: Bearer <token>'.")
+	return nil
+}
+// ListAPITokens prints the account's API tokens and their scopes.
+func (c *Client) ListAPITokens() error {
+	tokens, err := c.apiTokens.List()
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		fmt.Println("No API tokens found.")
+		return nil
+	}
+	fmt.Printf("%-36s %-20s %-6s %-12s %-20s %-20s %-8s\n", "ID", "Name", "Access", "Items", "Expires", "Last used", "Status")
+	fmt.Println(strings.Repeat("-", 128))
+	for _, token := range tokens {
+		access := "rw"
+		if token.ReadOnly {
+			access = "ro"
+		}
+		items := "all"
+		if len(token.ItemIDs) > 0 {
+			items = fmt.Sprintf("%d", len(token.ItemIDs))
+		}
+		expires := "never"
+		if token.ExpiresAt != nil {
+			expires = token.ExpiresAt.Format("2006-01-02 15:04:05")
+		}
+		lastUsed := "-"
+		if token.LastUsedAt != nil {
+			lastUsed = token.LastUsedAt.Format("2006-01-02 15:04:05")
+		}
+		status := "active"
+		switch {
+		case token.RevokedAt != nil:
+			status = "revoked"
+		case token.ExpiresAt != nil && token.ExpiresAt.Before(time.Now()):
+			status = "expired"
+		}
+		fmt.Printf("%-36s %-20s %-6s %-12s %-20s %-20s %-8s\n", token.ID, token.Name, access, items, expires, lastUsed, status)
+	}
+	return nil
+}
+func (c *Client) RevokeAPIToken(id string) error {
+	if err := c.apiTokens.Revoke(id); err != nil {
+		return err
+	}
+	fmt.Printf("API token %s revoked.\n", id)
+	return nil
+}
+// wipeRevokedDevice runs when the server refuses this device: the local copy
+// of the vault and the device key are erased and the session is forgotten.
+func (c *Client) wipeRevokedDevice() {
+	logger.Warn("Device was revoked, erasing local data")
+	if userID := c.authService.GetUserID(); userID != "" {
+		if err := c.storage.ResetData(userID); err != nil {
+			logger.Error("Failed to clear local data: %v", err)
+		}
+		if err := c.identities.DeleteDeviceIdentity(userID); err != nil {
+			logger.Error("Failed to delete device identity: %v", err)
+		}
+	}
+	c.vault.Lock()
+	if err := c.authService.ClearSession(); err != nil {
+		logger.Error("Failed to clear token: %v", err)
+	}
+	fmt.Println("This device was revoked. Local data has been erased; log in again to register it anew.")
+}
+// dropStaleVault clears the local copy when the master password was changed
+// on another device: it is sealed under a key this device can no longer
+// derive, and the next sync downloads everything again. Changes that never
+// reached the server would be lost with it, so the copy is kept and an error
+// returned while there are any; DiscardLocalChanges drops them on request.
+func (c *Client) dropStaleVault(userID string, params *models.KDFParams) error {
+	local, err := c.keys.GetKDFParams(userID)
+	if err != nil || bytes.Equal(local.Salt, params.Salt) {
+		return nil
+	}
+	unsent, err := c.storage.CountUnsent(userID)
+	if err != nil {
+		return err
+	}
+	if unsent > 0 {
+		return fmt.Errorf("master password was changed on another device, but %d local changes were never sent; "+
+			"they can still be read here with the old master password, then run 'reset-local' and log in again", unsent)
+	}
+	logger.Warn("Master password was changed on another device, discarding the local copy of the vault")
+	if err := c.storage.ResetData(userID); err != nil {
+		return err
+	}
+	// The device key was sealed under the old key too; this installation
+	// registers as a new device.
+	return c.identities.DeleteDeviceIdentity(userID)
+}
+// DiscardLocalChanges erases the local copy of the vault, unsent changes
+// included, so the next login downloads it again.
+func (c *Client) DiscardLocalChanges() error {
+	if !c.authService.IsAuthenticated() {
+		return fmt.Errorf("not authenticated")
+	}
+	if err := c.storage.ResetData(c.authService.GetUserID()); err != nil {
+		return err
+	}
+	c.vault.Lock()
+	fmt.Println("Local copy of the vault erased; log in again to download it.")
+	return nil
 }
 func (c *Client) ShowHistory(id string) error {
 	return c.dataService.ShowHistory(id)
@@ -0,0 +1,318 @@
+package client
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+)
+// Ways to resolve a conflict.
+const (
+	KeepLocal  = "local"
+	KeepServer = "server"
+	KeepMerge  = "merge"
+)
+// ItemConflict is a local change the server refused at sync because the
+// item was changed elsewhere first. Server is the copy the server kept;
+// Local is read from the vault when conflicts are listed.
+type ItemConflict struct {
+	ID         string
+	Reason     string
+	Local      *models.StoredData
+	Server     models.StoredData
+	DetectedAt time.Time
+}
+// ConflictField is one field of a conflicting item in both copies.
+type ConflictField struct {
+	Name   string
+	Local  string
+	Server string
+}
+func (f ConflictField) Differs() bool {
+	return f.Local != f.Server
+}
+// FieldChooser returns the merged value of a field the copies disagree on.
+type FieldChooser func(field ConflictField) (string, error)
+// Fields lists the fields of both copies side by side, in display order.
+func (c *ItemConflict) Fields() []ConflictField {
+	local := models.StoredData{}
+	if c.Local != nil {
+		local = *c.Local
+	}
+	fields := []ConflictField{
+		{Name: "type", Local: string(local.Type), Server: string(c.Server.Type)},
+		{Name: "status", Local: itemStatus(&local), Server: itemStatus(&c.Server)},
+	}
+	index := make(map[string]int)
+	for _, ref := range displayFields(&local) {
+		index[ref.name] = len(fields)
+		fields = append(fields, ConflictField{Name: ref.name, Local: *ref.value})
+	}
+	for _, ref := range displayFields(&c.Server) {
+		i, ok := index[ref.name]
+		if !ok {
+			i = len(fields)
+			fields = append(fields, ConflictField{Name: ref.name})
+		}
+		fields[i].Server = *ref.value
+	}
+	return fields
+}
+type ConflictServiceImpl struct {
+	storage     Storage
+	httpClient  HTTPClient
+	encryptor   Encryptor
+	authService AuthService
+}
+func NewConflictService(storage Storage, httpClient HTTPClient, encryptor Encryptor, authService AuthService) *ConflictServiceImpl {
+	return &ConflictServiceImpl{
+		storage:     storage,
+		httpClient:  httpClient,
+		encryptor:   encryptor,
+		authService: authService,
+	}
+}
+// List returns the open conflicts with the local copy of each item.
+func (c *ConflictServiceImpl) List() ([]ItemConflict, error) {
+	if !c.authService.IsAuthenticated() {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	conflicts, err := c.storage.ListConflicts(c.authService.GetUserID())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conflicts: %w", err)
+	}
+	for i := range conflicts {
+		if local, err := c.storage.GetData(conflicts[i].ID); err == nil {
+			conflicts[i].Local = local
+		}
+	}
+	return conflicts, nil
+}
+// Resolve settles a conflict and returns the item as it now stands. Keeping
+// the server copy only updates the vault; keeping the local copy or a merge
+// is pushed as the next version of the server copy and fails with
+// ErrVersionConflict if the server moved on again in the meantime. A result
+// that could not be sent yet is returned along with ErrQueued. A merge
+// takes fields changed on one side only from that side and asks choose
+// about the rest.
+func (c *ConflictServiceImpl) Resolve(id string, keep string, choose FieldChooser) (*models.StoredData, error) {
+	if !c.authService.IsAuthenticated() {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	conflict, err := c.storage.GetConflict(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conflict: %w", err)
+	}
+	if conflict == nil {
+		return nil, fmt.Errorf("no conflict recorded for item %s", id)
+	}
+	// The vault reports a missing item as an error; only keeping or
+	// merging the local copy needs it.
+	local, _ := c.storage.GetData(id)
+	var result models.StoredData
+	switch keep {
+	case KeepServer:
+		result = conflict.Server
+		if err := c.storage.SaveData(&result); err != nil {
+			return nil, fmt.Errorf("failed to save server copy: %w", err)
+		}
+		if err := c.storage.SaveSyncBase(&result, false); err != nil {
+			return nil, err
+		}
+		if err := c.storage.DeleteConflict(id); err != nil {
+			return nil, err
+		}
+		return &result, nil
+	case KeepLocal:
+		if local == nil {
+			return nil, fmt.Errorf("item %s has no local copy, keep the server copy instead", id)
+		}
+		result = *local
+	case KeepMerge:
+		if local == nil {
+			return nil, fmt.Errorf("item %s has no local copy, keep the server copy instead", id)
+		}
+		base, err := c.storage.GetSyncBase(id)
+		if err != nil {
+			return nil, err
+		}
+		merged, err := mergeItems(base, local, &conflict.Server, choose)
+		if err != nil {
+			return nil, err
+		}
+		result = *merged
+	default:
+		return nil, fmt.Errorf("invalid choice: %s. Valid choices: local, server, merge", keep)
+	}
+	err = pushResolved(c.storage, c.httpClient, c.encryptor, c.authService, &result, conflict.Server.Version)
+	if errors.Is(err, models.ErrVersionConflict) {
+		return nil, fmt.Errorf("item %s was changed on the server again, run sync and resolve it once more: %w", id, err)
+	}
+	queued := errors.Is(err, ErrQueued)
+	if err != nil && !queued {
+		return nil, err
+	}
+	if err := c.storage.DeleteConflict(id); err != nil {
+		return nil, err
+	}
+	return &result, err
+}
+// pushResolved saves result as the version after baseVersion of the server
+// copy and queues it as an update on top of that version. The server taking
+// it makes it the new sync base; ErrVersionConflict means the server copy
+// moved on, and ErrQueued that it could not be sent yet.
+func pushResolved(storage Storage, httpClient HTTPClient, encryptor Encryptor, authService AuthService, result *models.StoredData, baseVersion int) error {
+	result.UserID = authService.GetUserID()
+	result.Version = baseVersion + 1
+	result.BaseVersion = 0
+	entry := newOutboxEntry(OutboxUpdate, result.ID)
+	entry.BaseVersion = baseVersion
+	err := enqueue(storage, httpClient, encryptor, authService, entry, result)
+	if err != nil && !errors.Is(err, models.ErrVersionConflict) && !errors.Is(err, ErrQueued) {
+		return fmt.Errorf("failed to push resolved item: %w", err)
+	}
+	return err
+}
+// mergeItems merges local and server field by field, starting from the
+// server copy. With base, the copy both started from, a field changed on
+// one side only is taken from that side; choose decides the fields changed
+// on both sides, or on either side when there is no base. Without choose
+// such a field fails the merge.
+func mergeItems(base, local, server *models.StoredData, choose FieldChooser) (*models.StoredData, error) {
+	if local.Type != server.Type {
+		return nil, fmt.Errorf("copies have different types (%s and %s), keep one of them instead", local.Type, server.Type)
+	}
+	if local.IsDeleted || server.IsDeleted {
+		return nil, fmt.Errorf("one copy is deleted, keep one of them instead")
+	}
+	localCopy := *local
+	localRefs, _, err := editableFields(&localCopy)
+	if err != nil {
+		return nil, err
+	}
+	merged := *server
+	mergedRefs, encode, err := editableFields(&merged)
+	if err != nil {
+		return nil, err
+	}
+	var baseRefs []fieldRef
+	if base != nil && base.Type == server.Type && !base.IsDeleted {
+		baseCopy := *base
+		// A base that cannot be decoded only means more questions.
+		baseRefs, _, _ = editableFields(&baseCopy)
+	}
+	for i, ref := range mergedRefs {
+		field := ConflictField{Name: ref.name, Local: *localRefs[i].value, Server: *ref.value}
+		if !field.Differs() {
+			continue
+		}
+		if baseRefs != nil {
+			switch *baseRefs[i].value {
+			case field.Local:
+				continue
+			case field.Server:
+				*ref.value = field.Local
+				continue
+			}
+		}
+		if choose == nil {
+			return nil, fmt.Errorf("field %s was changed on both sides", field.Name)
+		}
+		value, err := choose(field)
+		if err != nil {
+			return nil, err
+		}
+		*ref.value = value
+	}
+	if err := encode(); err != nil {
+		return nil, err
+	}
+	return &merged, nil
+}
+// fieldRef points at one field of a decoded item.
+type fieldRef struct {
+	name  string
+	value *string
+}
+// editableFields decodes the fields of login, card and text items that can
+// be merged one by one. encode writes changes made through the refs back
+// into data.Data.
+func editableFields(data *models.StoredData) ([]fieldRef, func() error, error) {
+	refs := []fieldRef{{"title", &data.Title}}
+	var encode func() error
+	switch data.Type {
+	case models.DataTypeLoginPassword:
+		login := &models.LoginPasswordData{}
+		if err := json.Unmarshal(data.Data, login); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode login data: %w", err)
+		}
+		refs = append(refs,
+			fieldRef{"login", &login.Login},
+			fieldRef{"password", &login.Password},
+			fieldRef{"website", &login.Website},
+			fieldRef{"notes", &login.Notes},
+		)
+		encode = func() error { return encodeFields(data, login) }
+	case models.DataTypeBankCard:
+		card := &models.BankCardData{}
+		if err := json.Unmarshal(data.Data, card); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode card data: %w", err)
+		}
+		refs = append(refs,
+			fieldRef{"card_number", &card.CardNumber},
+			fieldRef{"expiry_date", &card.ExpiryDate},
+			fieldRef{"cvv", &card.CVV},
+			fieldRef{"cardholder", &card.Cardholder},
+			fieldRef{"bank", &card.Bank},
+			fieldRef{"notes", &card.Notes},
+		)
+		encode = func() error { return encodeFields(data, card) }
+	case models.DataTypeText:
+		text := string(data.Data)
+		refs = append(refs, fieldRef{"text", &text})
+		encode = func() error {
+			data.Data = []byte(text)
+			return nil
+		}
+	default:
+		return nil, nil, fmt.Errorf("merging is supported for %s, %s and %s items only",
+			models.DataTypeLoginPassword, models.DataTypeBankCard, models.DataTypeText)
+	}
+	refs = append(refs, fieldRef{"metadata", &data.Metadata})
+	return refs, encode, nil
+}
+func encodeFields(data *models.StoredData, fields interface{}) error {
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("failed to encode merged data: %w", err)
+	}
+	data.Data = encoded
+	return nil
+}
+// displayFields is editableFields for any item; binary content is only
+// shown by size.
+func displayFields(data *models.StoredData) []fieldRef {
+	item := *data
+	if refs, _, err := editableFields(&item); err == nil {
+		return refs
+	}
+	content := fmt.Sprintf("%d bytes", len(item.Data))
+	return []fieldRef{{"title", &item.Title}, {"data", &content}, {"metadata", &item.Metadata}}
+}
+func itemStatus(data *models.StoredData) string {
+	switch {
+	case data.ID == "":
+		return "missing"
+	case data.IsDeleted:
+		return "deleted"
+	default:
+		return "active"
+	}
+}
+// sameContent reports whether two copies of an item hold the same data, so
+// a conflict between them is not worth asking about.
+func sameContent(a, b *models.StoredData) bool {
+	return a.Type == b.Type && a.Title == b.Title && a.Metadata == b.Metadata &&
+		a.IsDeleted == b.IsDeleted && bytes.Equal(a.Data, b.Data)
+}
@@ -1,6 +1,7 @@
 package client
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"gophkeeper/internal/models"
@@ -33,17 +34,7 @@ func (d *DataServiceImpl) AddData(dataType, title string, data []string) error {
 	if err := d.processDataByType(storedData, dataType, data); err != nil {
 		return err
 	}
-	if err := d.storage.SaveData(storedData); err != nil {
-		return fmt.Errorf("failed to save data locally: %w", err)
-	}
-	encryptedData := *storedData
-	if err := d.encryptData(&encryptedData); err != nil {
-		return fmt.Errorf("failed to encrypt data: %w", err)
-	}
-	if err := d.httpClient.AddData(&encryptedData, d.authService.GetToken()); err != nil {
-		return fmt.Errorf("failed to add data to server: %w", err)
-	}
-	return nil
+	return d.enqueue(newOutboxEntry(OutboxAdd, storedData.ID), storedData)
 }
 func (d *DataServiceImpl) GetData(id string) (*models.StoredData, error) {
 	if !d.authService.IsAuthenticated() {
@@ -69,13 +60,17 @@ func (d *DataServiceImpl) DeleteData(id string) error {
 	if !d.authService.IsAuthenticated() {
 		return fmt.Errorf("not authenticated")
 	}
-	if err := d.storage.DeleteData(id); err != nil {
-		return fmt.Errorf("failed to delete data locally: %w", err)
-	}
-	if err := d.httpClient.DeleteData(id, d.authService.GetToken()); err != nil {
-		return fmt.Errorf("failed to delete data from server: %w", err)
-	}
-	return nil
+	return d.enqueue(newOutboxEntry(OutboxDelete, id), nil)
+}
+// enqueue saves and queues a local write. The write is done once it is
+// queued: a write the server cannot take yet, or refused, is sent by the
+// next sync and reported as ErrQueued.
+func (d *DataServiceImpl) enqueue(entry *OutboxEntry, data *models.StoredData) error {
+	err := enqueue(d.storage, d.httpClient, d.encryptor, d.authService, entry, data)
+	if err != nil && !errors.Is(err, ErrQueued) && (refused(err) || serverFailed(err)) {
+		return fmt.Errorf("%w: %v", ErrQueued, err)
+	}
+	return err
 }
 func (d *DataServiceImpl) ShowHistory(id string) error {
 	if !d.authService.IsAuthenticated() {
@@ -148,19 +143,3 @@ func (d *DataServiceImpl) processDataByType(storedData *models.StoredData, dataT
 	}
 	return nil
 }
-func (d *DataServiceImpl) encryptData(data *models.StoredData) error {
-	encrypted, err := d.encryptor.Encrypt(data.Data)
-	if err != nil {
-		return fmt.Errorf("failed to encrypt data: %w", err)
-	}
-	data.Data = encrypted
-	return nil
-}
-func (d *DataServiceImpl) decryptData(data *models.StoredData) error {
-	decrypted, err := d.encryptor.Decrypt(data.Data)
-	if err != nil {
-		return fmt.Errorf("failed to decrypt data: %w", err)
-	}
-	data.Data = decrypted
-	return nil
-}
@@ -0,0 +1,98 @@
+package client
+import (
+	"crypto/ed25519"
+	"fmt"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+// DeviceIdentity is this installation's registration with an account: the
+// server-assigned device ID and the key that proves it.
+type DeviceIdentity struct {
+	DeviceID   string
+	PrivateKey ed25519.PrivateKey
+}
+// DeviceServiceImpl registers the client as a device of the account and
+// manages the account's other devices.
+type DeviceServiceImpl struct {
+	store       DeviceStore
+	httpClient  HTTPClient
+	authService AuthService
+	name        string
+	platform    string
+}
+func NewDeviceService(store DeviceStore, httpClient HTTPClient, authService AuthService, name, platform string) *DeviceServiceImpl {
+	return &DeviceServiceImpl{
+		store:       store,
+		httpClient:  httpClient,
+		authService: authService,
+		name:        name,
+		platform:    platform,
+	}
+}
+// Register binds the current session to this device, generating a device key
+// on first use, and refreshes the access token so it carries the device ID.
+func (d *DeviceServiceImpl) Register() error {
+	claims, err := d.authService.TokenClaims()
+	if err != nil {
+		return err
+	}
+	if claims.SessionID == "" {
+		return fmt.Errorf("token is not bound to a session")
+	}
+	identity, err := d.store.GetDeviceIdentity(claims.UserID)
+	if err != nil {
+		return err
+	}
+	if identity == nil {
+		_, key, err := crypto.NewDeviceKey()
+		if err != nil {
+			return err
+		}
+		identity = &DeviceIdentity{PrivateKey: key}
+	}
+	req := &models.DeviceRegistrationRequest{
+		DeviceID:  identity.DeviceID,
+		Name:      d.name,
+		Platform:  d.platform,
+		PublicKey: identity.PrivateKey.Public().(ed25519.PublicKey),
+		Signature: crypto.SignDeviceProof(identity.PrivateKey, identity.DeviceID, claims.SessionID),
+	}
+	device, err := d.httpClient.RegisterDevice(req, d.authService.GetToken())
+	if err != nil {
+		return err
+	}
+	if device.ID != identity.DeviceID {
+		identity.DeviceID = device.ID
+		if err := d.store.SaveDeviceIdentity(claims.UserID, identity); err != nil {
+			return err
+		}
+		logger.Info("Registered as device %s (%s)", device.ID, device.Name)
+	}
+	if claims.DeviceID != device.ID {
+		if _, err := d.authService.RefreshToken(); err != nil {
+			return fmt.Errorf("failed to refresh token for device: %w", err)
+		}
+	}
+	return nil
+}
+// deviceKeyAAD binds the sealed device key to the account it belongs to.
+func deviceKeyAAD(userID string) []byte {
+	return []byte("gophkeeper device key:" + userID)
+}
+func (d *DeviceServiceImpl) List() ([]models.Device, error) {
+	if !d.authService.IsAuthenticated() {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	return d.httpClient.ListDevices(d.authService.GetToken())
+}
+func (d *DeviceServiceImpl) Revoke(id string) error {
+	if !d.authService.IsAuthenticated() {
+		return fmt.Errorf("not authenticated")
+	}
+	if err := d.httpClient.RevokeDevice(id, d.authService.GetToken()); err != nil {
+		return fmt.Errorf("failed to revoke device: %w", err)
+	}
+	logger.Info("Revoked device %s", id)
+	return nil
+}
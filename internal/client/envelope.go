@@ -0,0 +1,115 @@
+package client
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/models"
+)
+
+// Envelope plaintexts are padded to a power of two, at least
+// envelopeMinSize bytes, and to a multiple of envelopeMaxBucket above it,
+// so the blob size only hints at how large an item is.
+const (
+	envelopeMinSize   = 256
+	envelopeMaxBucket = 64 * 1024
+)
+
+// itemEnvelope is everything about an item the server must not see. It is
+// sealed as a whole into StoredData.Data.
+type itemEnvelope struct {
+	Type     models.DataType `json:"type"`
+	Title    string          `json:"title"`
+	Metadata string          `json:"metadata,omitempty"`
+	Data     []byte          `json:"data"`
+}
+
+// sealItem returns the copy of data sent to the server: type, title and
+// metadata move into the encrypted envelope and only the ID, version,
+// timestamps and a padded blob remain readable.
+func sealItem(encryptor Encryptor, userID string, data *models.StoredData) (*models.StoredData, error) {
+	plaintext, err := json.Marshal(itemEnvelope{
+		Type:     data.Type,
+		Title:    data.Title,
+		Metadata: data.Metadata,
+		Data:     data.Data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode item envelope: %w", err)
+	}
+	sealed := *data
+	sealed.Type = models.DataTypeSealed
+	sealed.Title = ""
+	sealed.Metadata = ""
+	sealed.Data, err = encryptor.EncryptWithAAD(padEnvelope(plaintext), itemAAD(userID, &sealed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt data: %w", err)
+	}
+	return &sealed, nil
+}
+
+// openItem decrypts a server copy in place. Items uploaded before envelopes
+// existed keep their type, title and metadata in the clear and only the
+// payload is decrypted. Unless legacyOK, a blob without a binding is
+// refused with crypto.ErrBindingMismatch.
+func openItem(encryptor Encryptor, userID string, data *models.StoredData, legacyOK bool) error {
+	decrypt := encryptor.DecryptBound
+	if legacyOK {
+		decrypt = encryptor.DecryptWithAAD
+	}
+	plaintext, err := decrypt(data.Data, itemAAD(userID, data))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt data: %w", err)
+	}
+	if data.Type != models.DataTypeSealed {
+		data.Data = plaintext
+		return nil
+	}
+	envelope, err := unpadEnvelope(plaintext)
+	if err != nil {
+		return err
+	}
+	var item itemEnvelope
+	if err := json.Unmarshal(envelope, &item); err != nil {
+		return fmt.Errorf("failed to decode item envelope: %w", err)
+	}
+	data.Type = item.Type
+	data.Title = item.Title
+	data.Metadata = item.Metadata
+	data.Data = item.Data
+	return nil
+}
+
+// itemAAD binds an item blob to the account that sealed it, so the server
+// cannot swap blobs between items or serve an older version of one.
+func itemAAD(userID string, data *models.StoredData) []byte {
+	return crypto.ItemAAD(userID, data.ID, string(data.Type), data.Version)
+}
+
+// padEnvelope prefixes the length and pads with zeros up to the bucket size.
+func padEnvelope(plaintext []byte) []byte {
+	size := envelopeMinSize
+	needed := len(plaintext) + 4
+	for size < needed && size < envelopeMaxBucket {
+		size *= 2
+	}
+	if size < needed {
+		size = (needed + envelopeMaxBucket - 1) / envelopeMaxBucket * envelopeMaxBucket
+	}
+	padded := make([]byte, size)
+	binary.BigEndian.PutUint32(padded, uint32(len(plaintext)))
+	copy(padded[4:], plaintext)
+	return padded
+}
+func unpadEnvelope(padded []byte) ([]byte, error) {
+	if len(padded) < 4 {
+		return nil, fmt.Errorf("item envelope too short")
+	}
+	n := binary.BigEndian.Uint32(padded)
+	if uint64(n) > uint64(len(padded)-4) {
+		return nil, fmt.Errorf("invalid item envelope length")
+	}
+	return padded[4 : 4+n], nil
+}
@@ -1,16 +1,35 @@
 package client
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 	"gophkeeper/internal/models"
 )
+// RequestError is returned for responses with an error status, so callers
+// can tell a missing resource from a failed request.
+type RequestError struct {
+	StatusCode int
+	Message    string
+}
+func (e *RequestError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("request failed: %s", e.Message)
+	}
+	return fmt.Sprintf("request failed with status %d", e.StatusCode)
+}
 type HTTPClientImpl struct {
 	serverURL  string
 	httpClient *http.Client
+	refresher  func() (string, error)
+	onRevoked  func()
+	keyCheck   func() []byte
 }
 func NewHTTPClient(serverURL string) *HTTPClientImpl {
 	return &HTTPClientImpl{
@@ -18,6 +37,22 @@ func NewHTTPClient(serverURL string) *HTTPClientImpl {
 		httpClient: &http.Client{Timeout: 30 * time.Second},
 	}
 }
+// SetTokenRefresher installs the callback used to obtain a new access token
+// when an authenticated request is rejected with 401.
+func (h *HTTPClientImpl) SetTokenRefresher(refresher func() (string, error)) {
+	h.refresher = refresher
+}
+// SetDeviceRevokedHandler installs the callback run when the server refuses
+// this device because it was revoked from another one.
+func (h *HTTPClientImpl) SetDeviceRevokedHandler(handler func()) {
+	h.onRevoked = handler
+}
+// SetVaultKeyCheck installs the source of the vault key check sent with
+// writes of sealed items, so the server refuses them once the key was
+// replaced on another device.
+func (h *HTTPClientImpl) SetVaultKeyCheck(keyCheck func() []byte) {
+	h.keyCheck = keyCheck
+}
 func (h *HTTPClientImpl) Register(req *models.UserRegistrationRequest) (*models.AuthResponse, error) {
 	var response models.AuthResponse
 	if err := h.makeRequest("POST", "/api/v1/register", req, &response, ""); err != nil {
@@ -32,67 +67,279 @@ func (h *HTTPClientImpl) Login(req *models.UserLoginRequest) (*models.AuthRespon
 	}
 	return &response, nil
 }
-func (h *HTTPClientImpl) AddData(data *models.StoredData, token string) error {
-	return h.makeRequest("POST", "/api/v1/data", data, data, token)
+func (h *HTTPClientImpl) SRPInit(req *models.SRPInitRequest) (*models.SRPInitResponse, error) {
+	var response models.SRPInitResponse
+	if err := h.makeRequest("POST", "/api/v1/srp/init", req, &response, ""); err != nil {
+		return nil, fmt.Errorf("login failed: %w", err)
+	}
+	return &response, nil
 }
-func (h *HTTPClientImpl) DeleteData(id, token string) error {
-	url := fmt.Sprintf("%s/api/v1/data?id=%s", h.serverURL, id)
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+func (h *HTTPClientImpl) SRPVerify(req *models.SRPVerifyRequest) (*models.AuthResponse, error) {
+	var response models.AuthResponse
+	if err := h.makeRequest("POST", "/api/v1/srp/verify", req, &response, ""); err != nil {
+		return nil, fmt.Errorf("login failed: %w", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := h.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to make request: %w", err)
+	return &response, nil
+}
+func (h *HTTPClientImpl) LoginMFA(req *models.MFALoginRequest) (*models.AuthResponse, error) {
+	var response models.AuthResponse
+	if err := h.makeRequest("POST", "/api/v1/login/2fa", req, &response, ""); err != nil {
+		return nil, fmt.Errorf("two-factor login failed: %w", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("request failed: %s", string(body))
+	return &response, nil
+}
+// AddData creates an item on the server. A non-empty idempotencyKey lets
+// the server recognise a retry of the same write.
+func (h *HTTPClientImpl) AddData(data *models.StoredData, idempotencyKey, token string) error {
+	return h.makeSealedRequest("POST", "/api/v1/data", data, data, token, idempotencyKey)
+}
+// UpdateData replaces an item on the server only if it is still at
+// baseVersion there, and returns ErrVersionConflict otherwise.
+func (h *HTTPClientImpl) UpdateData(data *models.StoredData, baseVersion int, idempotencyKey, token string) error {
+	path := "/api/v1/data?base_version=" + strconv.Itoa(baseVersion)
+	if err := h.makeSealedRequest("PUT", path, data, nil, token, idempotencyKey); err != nil {
+		var reqErr *RequestError
+		if errors.As(err, &reqErr) && reqErr.StatusCode == http.StatusConflict {
+			return models.ErrVersionConflict
+		}
+		return fmt.Errorf("failed to update data: %w", err)
 	}
 	return nil
 }
-func (h *HTTPClientImpl) SyncData(req *models.DataSyncRequest, token string) (*models.DataSyncResponse, error) {
+func (h *HTTPClientImpl) DeleteData(id, idempotencyKey, token string) error {
+	return h.makeKeyedRequest("DELETE", "/api/v1/data?id="+url.QueryEscape(id), nil, nil, token, idempotencyKey)
+}
+func (h *HTTPClientImpl) SyncData(req *models.DataSyncRequest, idempotencyKey, token string) (*models.DataSyncResponse, error) {
 	var response models.DataSyncResponse
-	if err := h.makeRequest("POST", "/api/v1/sync", req, &response, token); err != nil {
+	if err := h.makeSealedRequest("POST", "/api/v1/sync", req, &response, token, idempotencyKey); err != nil {
 		return nil, fmt.Errorf("sync failed: %w", err)
 	}
 	return &response, nil
 }
+func (h *HTTPClientImpl) SetKDFParams(params *models.KDFParams, token string) error {
+	return h.makeRequest("PUT", "/api/v1/kdf", params, nil, token)
+}
+func (h *HTTPClientImpl) GetHistory(token string) ([]models.DataHistory, error) {
+	var history []models.DataHistory
+	if err := h.makeRequest("GET", "/api/v1/history", nil, &history, token); err != nil {
+		return nil, fmt.Errorf("failed to get history: %w", err)
+	}
+	return history, nil
+}
+func (h *HTTPClientImpl) Rekey(req *models.RekeyRequest, token string) error {
+	return h.makeRequest("POST", "/api/v1/rekey", req, nil, token)
+}
+func (h *HTTPClientImpl) ChangePassword(req *models.ChangePasswordRequest, token string) error {
+	return h.makeRequest("POST", "/api/v1/account/password", req, nil, token)
+}
+func (h *HTTPClientImpl) ChangeEmail(req *models.ChangeEmailRequest, token string) (*models.User, error) {
+	var user models.User
+	if err := h.makeRequest("PUT", "/api/v1/account/email", req, &user, token); err != nil {
+		return nil, fmt.Errorf("failed to change email: %w", err)
+	}
+	return &user, nil
+}
+func (h *HTTPClientImpl) DeleteAccount(req *models.DeleteAccountRequest, token string) (*models.AccountDeletionResponse, error) {
+	var response models.AccountDeletionResponse
+	if err := h.makeRequest("DELETE", "/api/v1/account", req, &response, token); err != nil {
+		return nil, fmt.Errorf("failed to delete account: %w", err)
+	}
+	return &response, nil
+}
+func (h *HTTPClientImpl) SaveRecoverySlot(slot *models.RecoverySlot, token string) error {
+	return h.makeRequest("PUT", "/api/v1/recovery", slot, nil, token)
+}
+func (h *HTTPClientImpl) GetRecoverySlot(token string) (*models.RecoverySlot, error) {
+	var slot models.RecoverySlot
+	if err := h.makeRequest("GET", "/api/v1/recovery", nil, &slot, token); err != nil {
+		var reqErr *RequestError
+		if errors.As(err, &reqErr) && reqErr.StatusCode == http.StatusNotFound {
+			return nil, models.ErrRecoveryNotConfigured
+		}
+		return nil, fmt.Errorf("failed to get recovery slot: %w", err)
+	}
+	return &slot, nil
+}
+func (h *HTTPClientImpl) RegisterDevice(req *models.DeviceRegistrationRequest, token string) (*models.Device, error) {
+	var device models.Device
+	if err := h.makeRequest("POST", "/api/v1/devices", req, &device, token); err != nil {
+		return nil, fmt.Errorf("failed to register device: %w", err)
+	}
+	return &device, nil
+}
+func (h *HTTPClientImpl) ListDevices(token string) ([]models.Device, error) {
+	var devices []models.Device
+	if err := h.makeRequest("GET", "/api/v1/devices", nil, &devices, token); err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	return devices, nil
+}
+func (h *HTTPClientImpl) RevokeDevice(id, token string) error {
+	return h.makeRequest("DELETE", "/api/v1/devices?id="+url.QueryEscape(id), nil, nil, token)
+}
+func (h *HTTPClientImpl) CreateAPIToken(req *models.APITokenRequest, token string) (*models.APITokenResponse, error) {
+	var response models.APITokenResponse
+	if err := h.makeRequest("POST", "/api/v1/tokens", req, &response, token); err != nil {
+		return nil, fmt.Errorf("failed to create api token: %w", err)
+	}
+	return &response, nil
+}
+func (h *HTTPClientImpl) ListAPITokens(token string) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	if err := h.makeRequest("GET", "/api/v1/tokens", nil, &tokens, token); err != nil {
+		return nil, fmt.Errorf("failed to list api tokens: %w", err)
+	}
+	return tokens, nil
+}
+func (h *HTTPClientImpl) RevokeAPIToken(id, token string) error {
+	return h.makeRequest("DELETE", "/api/v1/tokens?id="+url.QueryEscape(id), nil, nil, token)
+}
+func (h *HTTPClientImpl) GetAPITokenInfo(token string) (*models.APITokenInfo, error) {
+	var info models.APITokenInfo
+	if err := h.makeRequest("GET", "/api/v1/tokens/self", nil, &info, token); err != nil {
+		return nil, fmt.Errorf("failed to check api token: %w", err)
+	}
+	return &info, nil
+}
+func (h *HTTPClientImpl) OIDCProviders() ([]models.OIDCProvider, error) {
+	var providers []models.OIDCProvider
+	if err := h.makeRequest("GET", "/api/v1/oidc/providers", nil, &providers, ""); err != nil {
+		return nil, fmt.Errorf("failed to get identity providers: %w", err)
+	}
+	return providers, nil
+}
+func (h *HTTPClientImpl) OIDCNonce() (*models.OIDCNonceResponse, error) {
+	var response models.OIDCNonceResponse
+	if err := h.makeRequest("POST", "/api/v1/oidc/nonce", nil, &response, ""); err != nil {
+		return nil, fmt.Errorf("failed to get sso nonce: %w", err)
+	}
+	return &response, nil
+}
+func (h *HTTPClientImpl) OIDCLogin(req *models.OIDCTokenRequest) (*models.AuthResponse, error) {
+	var response models.AuthResponse
+	if err := h.makeRequest("POST", "/api/v1/oidc/login", req, &response, ""); err != nil {
+		return nil, fmt.Errorf("sso login failed: %w", err)
+	}
+	return &response, nil
+}
+func (h *HTTPClientImpl) LinkOIDCIdentity(req *models.OIDCLinkRequest, token string) (*models.OIDCIdentity, error) {
+	var identity models.OIDCIdentity
+	if err := h.makeRequest("POST", "/api/v1/oidc/identities", req, &identity, token); err != nil {
+		return nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+	return &identity, nil
+}
+func (h *HTTPClientImpl) ListOIDCIdentities(token string) ([]models.OIDCIdentity, error) {
+	var identities []models.OIDCIdentity
+	if err := h.makeRequest("GET", "/api/v1/oidc/identities", nil, &identities, token); err != nil {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	return identities, nil
+}
+func (h *HTTPClientImpl) UnlinkOIDCIdentity(req *models.OIDCUnlinkRequest, token string) error {
+	return h.makeRequest("DELETE", "/api/v1/oidc/identities", req, nil, token)
+}
+func (h *HTTPClientImpl) RefreshToken(refreshToken string) (*models.AuthResponse, error) {
+	var response models.AuthResponse
+	req := &models.RefreshTokenRequest{RefreshToken: refreshToken}
+	if err := h.makeRequest("POST", "/api/v1/token/refresh", req, &response, ""); err != nil {
+		return nil, fmt.Errorf("token refresh failed: %w", err)
+	}
+	return &response, nil
+}
+func (h *HTTPClientImpl) Logout(token, refreshToken string) error {
+	return h.makeRequest("POST", "/api/v1/logout", &models.LogoutRequest{RefreshToken: refreshToken}, nil, token)
+}
+func (h *HTTPClientImpl) EnrollTOTP(token string) (*models.TOTPEnrollResponse, error) {
+	var response models.TOTPEnrollResponse
+	if err := h.makeRequest("POST", "/api/v1/2fa/enroll", nil, &response, token); err != nil {
+		return nil, fmt.Errorf("two-factor enrollment failed: %w", err)
+	}
+	return &response, nil
+}
+func (h *HTTPClientImpl) ConfirmTOTP(code, token string) (*models.RecoveryCodesResponse, error) {
+	var response models.RecoveryCodesResponse
+	if err := h.makeRequest("POST", "/api/v1/2fa/confirm", &models.TOTPCodeRequest{Code: code}, &response, token); err != nil {
+		return nil, fmt.Errorf("two-factor confirmation failed: %w", err)
+	}
+	return &response, nil
+}
+func (h *HTTPClientImpl) DisableTOTP(req *models.TOTPCodeRequest, token string) error {
+	return h.makeRequest("POST", "/api/v1/2fa/disable", req, nil, token)
+}
+func (h *HTTPClientImpl) RegenerateRecoveryCodes(req *models.TOTPCodeRequest, token string) (*models.RecoveryCodesResponse, error) {
+	var response models.RecoveryCodesResponse
+	if err := h.makeRequest("POST", "/api/v1/2fa/recovery-codes", req, &response, token); err != nil {
+		return nil, fmt.Errorf("failed to regenerate recovery codes: %w", err)
+	}
+	return &response, nil
+}
 func (h *HTTPClientImpl) makeRequest(method, path string, body interface{}, result interface{}, token string) error {
-	var reqBody io.Reader
+	return h.makeKeyedRequest(method, path, body, result, token, "")
+}
+// makeKeyedRequest is makeRequest with an Idempotency-Key header, sent
+// unless idempotencyKey is empty.
+func (h *HTTPClientImpl) makeKeyedRequest(method, path string, body interface{}, result interface{}, token, idempotencyKey string) error {
+	headers := make(map[string]string)
+	if idempotencyKey != "" {
+		headers["Idempotency-Key"] = idempotencyKey
+	}
+	return h.send(method, path, body, result, token, headers)
+}
+// makeSealedRequest is makeKeyedRequest for writes of sealed items; it also
+// names the vault key they were sealed with.
+func (h *HTTPClientImpl) makeSealedRequest(method, path string, body interface{}, result interface{}, token, idempotencyKey string) error {
+	headers := make(map[string]string)
+	if idempotencyKey != "" {
+		headers["Idempotency-Key"] = idempotencyKey
+	}
+	if h.keyCheck != nil {
+		if keyCheck := h.keyCheck(); len(keyCheck) > 0 {
+			headers["X-Vault-Key-Check"] = base64.StdEncoding.EncodeToString(keyCheck)
+		}
+	}
+	return h.send(method, path, body, result, token, headers)
+}
+func (h *HTTPClientImpl) send(method, path string, body interface{}, result interface{}, token string, headers map[string]string) error {
+	var jsonData []byte
 	if body != nil {
-		jsonData, err := json.Marshal(body)
+		var err error
+		jsonData, err = json.Marshal(body)
 		if err != nil {
 			return fmt.Errorf("failed to marshal request body: %w", err)
 		}
-		reqBody = bytes.NewBuffer(jsonData)
 	}
-	req, err := http.NewRequest(method, h.serverURL+path, reqBody)
+	resp, respBody, err := h.do(method, path, jsonData, token, headers)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	if token != "" {
-		req.Header.Set("Authorization", "Bearer "+token)
+		return err
 	}
-	resp, err := h.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to make request: %w", err)
-	}
-	defer resp.Body.Close()
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+	if resp.StatusCode == http.StatusUnauthorized && token != "" && h.refresher != nil {
+		newToken, refreshErr := h.refresher()
+		if errors.Is(refreshErr, models.ErrDeviceRevoked) {
+			return refreshErr
+		}
+		if refreshErr == nil {
+			resp, respBody, err = h.do(method, path, jsonData, newToken, headers)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	if resp.StatusCode >= 400 {
 		var errorResp models.ErrorResponse
 		if err := json.Unmarshal(respBody, &errorResp); err == nil {
-			return fmt.Errorf("request failed: %s", errorResp.Error)
+			if resp.StatusCode == http.StatusForbidden && errorResp.Error == models.ErrDeviceRevoked.Error() {
+				return h.deviceRevoked()
+			}
+			if resp.StatusCode == http.StatusConflict && errorResp.Error == models.ErrStaleVaultKey.Error() {
+				return models.ErrStaleVaultKey
+			}
+			if resp.StatusCode == http.StatusConflict && errorResp.Error == models.ErrIdempotencyNotKept.Error() {
+				return models.ErrIdempotencyNotKept
+			}
+			return &RequestError{StatusCode: resp.StatusCode, Message: errorResp.Error}
 		}
-		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(respBody))
+		return &RequestError{StatusCode: resp.StatusCode, Message: string(respBody)}
 	}
 	if result != nil {
 		var wrap models.APIResponse
@@ -120,3 +367,36 @@ func (h *HTTPClientImpl) makeRequest(method, path string, body interface{}, resu
 	}
 	return nil
 }
+func (h *HTTPClientImpl) deviceRevoked() error {
+	if h.onRevoked != nil {
+		h.onRevoked()
+	}
+	return models.ErrDeviceRevoked
+}
+func (h *HTTPClientImpl) do(method, path string, body []byte, token string, headers map[string]string) (*http.Response, []byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, h.serverURL+path, reqBody)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return resp, respBody, nil
+}
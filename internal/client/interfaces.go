@@ -1,6 +1,7 @@
 package client
 import (
 	"time"
+	"gophkeeper/internal/crypto"
 	"gophkeeper/internal/models"
 )
 type Storage interface {
@@ -8,34 +9,130 @@ type Storage interface {
 	GetData(id string) (*models.StoredData, error)
 	GetAllData(userID string) ([]models.StoredData, error)
 	GetDataSince(userID string, since time.Time) ([]models.StoredData, error)
+	GetDirtyData(userID string) ([]models.StoredData, error)
+	CountUnsent(userID string) (int, error)
 	DeleteData(id string) error
 	GetDataHistory(id string) ([]models.DataHistory, error)
-	GetLastSyncTime(userID string) (time.Time, error)
-	UpdateLastSyncTime(userID string, t time.Time) error
+	GetSyncState(userID string) (*SyncState, error)
+	SaveSyncState(userID string, state *SyncState) error
+	SaveConflict(userID string, conflict *ItemConflict) error
+	GetConflict(id string) (*ItemConflict, error)
+	ListConflicts(userID string) ([]ItemConflict, error)
+	DeleteConflict(id string) error
+	SaveSyncBase(data *models.StoredData, bound bool) error
+	GetSyncBase(id string) (*models.StoredData, error)
+	SyncBaseBound(id string) (bool, error)
+	SaveDataQueued(data *models.StoredData, entry *OutboxEntry) error
+	DeleteDataQueued(userID, id string, entry *OutboxEntry) error
+	ListOutbox(userID string) ([]OutboxEntry, error)
+	ListDeadLetters(userID string) ([]OutboxEntry, error)
+	RecordOutboxFailure(seq int64, lastError string) error
+	DeadLetterOutboxEntry(seq int64, lastError string) error
+	DeleteOutboxEntry(seq int64) error
+	SaveRekeyState(userID string, params *models.KDFParams) error
+	GetRekeyState(userID string) (*models.KDFParams, error)
+	Rekey(userID string, to Encryptor, params *models.KDFParams) error
+	ResetData(userID string) error
 	Close() error
 }
+type KeyStore interface {
+	SaveKDFParams(userID string, params *models.KDFParams) error
+	GetKDFParams(userID string) (*models.KDFParams, error)
+}
+type DeviceStore interface {
+	SaveDeviceIdentity(userID string, identity *DeviceIdentity) error
+	GetDeviceIdentity(userID string) (*DeviceIdentity, error)
+	DeleteDeviceIdentity(userID string) error
+}
 type HTTPClient interface {
 	Register(req *models.UserRegistrationRequest) (*models.AuthResponse, error)
 	Login(req *models.UserLoginRequest) (*models.AuthResponse, error)
-	AddData(data *models.StoredData, token string) error
-	DeleteData(id, token string) error
-	SyncData(req *models.DataSyncRequest, token string) (*models.DataSyncResponse, error)
+	SRPInit(req *models.SRPInitRequest) (*models.SRPInitResponse, error)
+	SRPVerify(req *models.SRPVerifyRequest) (*models.AuthResponse, error)
+	AddData(data *models.StoredData, idempotencyKey, token string) error
+	UpdateData(data *models.StoredData, baseVersion int, idempotencyKey, token string) error
+	DeleteData(id, idempotencyKey, token string) error
+	SyncData(req *models.DataSyncRequest, idempotencyKey, token string) (*models.DataSyncResponse, error)
+	SetKDFParams(params *models.KDFParams, token string) error
+	GetHistory(token string) ([]models.DataHistory, error)
+	Rekey(req *models.RekeyRequest, token string) error
+	ChangePassword(req *models.ChangePasswordRequest, token string) error
+	ChangeEmail(req *models.ChangeEmailRequest, token string) (*models.User, error)
+	DeleteAccount(req *models.DeleteAccountRequest, token string) (*models.AccountDeletionResponse, error)
+	SaveRecoverySlot(slot *models.RecoverySlot, token string) error
+	GetRecoverySlot(token string) (*models.RecoverySlot, error)
+	RegisterDevice(req *models.DeviceRegistrationRequest, token string) (*models.Device, error)
+	ListDevices(token string) ([]models.Device, error)
+	RevokeDevice(id, token string) error
+	CreateAPIToken(req *models.APITokenRequest, token string) (*models.APITokenResponse, error)
+	ListAPITokens(token string) ([]models.APIToken, error)
+	RevokeAPIToken(id, token string) error
+	GetAPITokenInfo(token string) (*models.APITokenInfo, error)
+	OIDCProviders() ([]models.OIDCProvider, error)
+	OIDCNonce() (*models.OIDCNonceResponse, error)
+	OIDCLogin(req *models.OIDCTokenRequest) (*models.AuthResponse, error)
+	LinkOIDCIdentity(req *models.OIDCLinkRequest, token string) (*models.OIDCIdentity, error)
+	ListOIDCIdentities(token string) ([]models.OIDCIdentity, error)
+	UnlinkOIDCIdentity(req *models.OIDCUnlinkRequest, token string) error
+	RefreshToken(refreshToken string) (*models.AuthResponse, error)
+	Logout(token, refreshToken string) error
+	LoginMFA(req *models.MFALoginRequest) (*models.AuthResponse, error)
+	EnrollTOTP(token string) (*models.TOTPEnrollResponse, error)
+	ConfirmTOTP(code, token string) (*models.RecoveryCodesResponse, error)
+	DisableTOTP(req *models.TOTPCodeRequest, token string) error
+	RegenerateRecoveryCodes(req *models.TOTPCodeRequest, token string) (*models.RecoveryCodesResponse, error)
 }
 type Encryptor interface {
 	Encrypt(data []byte) ([]byte, error)
 	Decrypt(data []byte) ([]byte, error)
+	EncryptWithAAD(data, aad []byte) ([]byte, error)
+	DecryptWithAAD(data, aad []byte) ([]byte, error)
+	DecryptBound(data, aad []byte) ([]byte, error)
+}
+type Vault interface {
+	Encryptor
+	Create() (*models.KDFParams, error)
+	Unlock(userID string, params *models.KDFParams) error
+	Persist(userID string, params *models.KDFParams) error
+	NewKey() (*models.KDFParams, *crypto.Encryptor, error)
+	ResumeKey(params *models.KDFParams) (*crypto.Encryptor, error)
+	UseKey(encryptor *crypto.Encryptor)
+	WrapKey(kek *crypto.Encryptor, aad []byte) ([]byte, error)
+	Lock()
+}
+type Prompter interface {
+	ReadPassword(prompt string) (string, error)
+	ReadLine(prompt string) (string, error)
 }
 type TokenManager interface {
 	SaveToken(token string) error
 	LoadToken() (string, error)
+	SaveRefreshToken(token string) error
+	LoadRefreshToken() (string, error)
 	ClearToken() error
 }
 type AuthService interface {
-	Register(username, email, password string) (*models.AuthResponse, error)
+	Register(username, email, password string, kdf *models.KDFParams) (*models.AuthResponse, error)
 	Login(username, password string) (*models.AuthResponse, error)
+	LoginMFA(mfaToken, code string) (*models.AuthResponse, error)
+	LoginSSO(issuer string) (*models.AuthResponse, error)
+	LinkSSO(issuer, password string) (*models.OIDCIdentity, error)
+	ListSSO() ([]models.OIDCIdentity, error)
+	UnlinkSSO(issuer, password string) error
+	EnrollTOTP() (*models.TOTPEnrollResponse, error)
+	ConfirmTOTP(code string) ([]string, error)
+	DisableTOTP(code string) error
+	RegenerateRecoveryCodes(code string) ([]string, error)
+	SetKDFParams(params *models.KDFParams) error
+	ChangePassword(oldPassword, newPassword string) error
+	ChangeEmail(email string) error
+	DeleteAccount(password string) (*models.AccountDeletionResponse, error)
+	RefreshToken() (string, error)
 	IsAuthenticated() bool
 	GetToken() string
 	GetUserID() string
+	TokenClaims() (*crypto.JWTClaims, error)
+	ClearSession() error
 	Logout() error
 }
 type DataService interface {
@@ -48,3 +145,25 @@ type DataService interface {
 type SyncService interface {
 	SyncData() error
 }
+type ConflictService interface {
+	List() ([]ItemConflict, error)
+	Resolve(id string, keep string, choose FieldChooser) (*models.StoredData, error)
+}
+type RekeyService interface {
+	ChangeMasterPassword() error
+	ResetMasterPassword(recovered *crypto.Encryptor, previousKeyCheck []byte) error
+}
+type RecoveryService interface {
+	Split(shares, threshold int) ([]crypto.RecoveryShare, error)
+	Combine(shares []string) error
+}
+type DeviceService interface {
+	Register() error
+	List() ([]models.Device, error)
+	Revoke(id string) error
+}
+type APITokenService interface {
+	Create(req *models.APITokenRequest) (*models.APITokenResponse, error)
+	List() ([]models.APIToken, error)
+	Revoke(id string) error
+}
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS vault_keys (
+    user_id TEXT PRIMARY KEY,
+    algorithm TEXT NOT NULL,
+    salt BLOB NOT NULL,
+    time_cost INTEGER NOT NULL,
+    memory_cost INTEGER NOT NULL,
+    parallelism INTEGER NOT NULL,
+    key_length INTEGER NOT NULL,
+    key_check BLOB,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS vault_keys;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE stored_data ADD COLUMN encrypted INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE data_history ADD COLUMN encrypted INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE data_history DROP COLUMN encrypted;
+ALTER TABLE stored_data DROP COLUMN encrypted;
@@ -0,0 +1,9 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS rekey_state (
+    user_id TEXT PRIMARY KEY,
+    kdf TEXT NOT NULL,
+    started_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS rekey_state;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS device_identity (
+    user_id TEXT PRIMARY KEY,
+    device_id TEXT NOT NULL,
+    private_key BLOB NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS device_identity;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE sync_metadata ADD COLUMN cursor TEXT NOT NULL DEFAULT '';
+-- Local changes keep being found from where the last sync left them; the
+-- empty cursor makes the next sync fetch every item once.
+INSERT OR IGNORE INTO sync_metadata (user_id, last_sync_at)
+SELECT user_id, MAX(last_sync_at) FROM stored_data GROUP BY user_id;
+
+-- +goose Down
+ALTER TABLE sync_metadata DROP COLUMN cursor;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- The server copy of items whose local edit lost at sync, kept until the
+-- user resolves the conflict. The local copy stays in stored_data.
+CREATE TABLE IF NOT EXISTS conflicts (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    type TEXT NOT NULL,
+    title TEXT NOT NULL,
+    data BLOB NOT NULL,
+    metadata TEXT,
+    version INTEGER NOT NULL,
+    updated_at DATETIME NOT NULL,
+    is_deleted BOOLEAN DEFAULT FALSE,
+    encrypted BOOLEAN DEFAULT FALSE,
+    detected_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_conflicts_user_id ON conflicts(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS conflicts;
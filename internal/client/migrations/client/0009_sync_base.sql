@@ -0,0 +1,18 @@
+-- +goose Up
+-- The copy of each item both sides last agreed on, used as the common base
+-- when local and server changes are merged.
+CREATE TABLE IF NOT EXISTS sync_base (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    title TEXT NOT NULL,
+    data BLOB NOT NULL,
+    metadata TEXT,
+    version INTEGER NOT NULL,
+    is_deleted BOOLEAN DEFAULT FALSE,
+    encrypted BOOLEAN DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS idx_sync_base_user_id ON sync_base(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS sync_base;
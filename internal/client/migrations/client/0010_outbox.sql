@@ -0,0 +1,17 @@
+-- +goose Up
+-- Local writes waiting to be sent to the server, replayed in seq order.
+-- The item itself is read from stored_data at replay time.
+CREATE TABLE IF NOT EXISTS outbox (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL,
+    item_id TEXT NOT NULL,
+    op TEXT NOT NULL,
+    idempotency_key TEXT NOT NULL UNIQUE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    attempts INTEGER DEFAULT 0,
+    last_error TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_user_id ON outbox(user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Items uploaded before envelopes existed still show their title and
+-- metadata to the server. Until resealed is set, sync fetches every item
+-- once and sends those back sealed.
+ALTER TABLE sync_metadata ADD COLUMN resealed BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE sync_metadata DROP COLUMN resealed;
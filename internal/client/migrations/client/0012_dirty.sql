@@ -0,0 +1,12 @@
+-- +goose Up
+-- Set by local writes and cleared once the server has the item, so the
+-- changes to send no longer depend on the local clock. Items written after
+-- the last sync, or never synced, start out dirty.
+ALTER TABLE stored_data ADD COLUMN dirty BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE stored_data SET dirty = TRUE
+WHERE updated_at > COALESCE((SELECT last_sync_at FROM sync_metadata m WHERE m.user_id = stored_data.user_id), '');
+CREATE INDEX IF NOT EXISTS idx_stored_data_dirty ON stored_data(user_id, dirty);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_stored_data_dirty;
+ALTER TABLE stored_data DROP COLUMN dirty;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- Device keys written before this migration are stored in the clear and
+-- sealed under the vault key the next time they are read.
+ALTER TABLE device_identity ADD COLUMN sealed BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE device_identity DROP COLUMN sealed;
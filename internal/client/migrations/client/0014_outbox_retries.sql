@@ -0,0 +1,10 @@
+-- +goose Up
+-- Queued updates carry the server version they were made on top of.
+-- A write the server keeps failing is dead-lettered: it stays here with its
+-- last error but is no longer sent, and the item goes with the next sync.
+ALTER TABLE outbox ADD COLUMN base_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE outbox ADD COLUMN dead_at DATETIME;
+
+-- +goose Down
+ALTER TABLE outbox DROP COLUMN dead_at;
+ALTER TABLE outbox DROP COLUMN base_version;
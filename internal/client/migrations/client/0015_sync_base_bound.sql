@@ -0,0 +1,7 @@
+-- +goose Up
+-- Whether the server copy of an item has been seen bound to it. Once it
+-- has, an unbound copy of the same item is refused.
+ALTER TABLE sync_base ADD COLUMN bound BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE sync_base DROP COLUMN bound;
@@ -0,0 +1,178 @@
+package client
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+// Queued write operations.
+const (
+	OutboxAdd    = "add"
+	OutboxUpdate = "update"
+	OutboxDelete = "delete"
+)
+// ErrQueued is returned for a local write that was saved and queued but
+// could not be sent yet; a later sync sends it.
+var ErrQueued = errors.New("saved locally, not sent to the server yet")
+// OutboxEntry is a local write waiting to be sent to the server. The item
+// is read from the vault when the entry is replayed, so the outbox holds no
+// item content. IdempotencyKey stays the same across retries. BaseVersion
+// is the server version an update was made on top of. DeadAt is set once
+// the server has rejected the write.
+type OutboxEntry struct {
+	Seq            int64
+	ItemID         string
+	Op             string
+	IdempotencyKey string
+	BaseVersion    int
+	CreatedAt      time.Time
+	Attempts       int
+	LastError      string
+	DeadAt         *time.Time
+}
+func newOutboxEntry(op, itemID string) *OutboxEntry {
+	return &OutboxEntry{
+		ItemID:         itemID,
+		Op:             op,
+		IdempotencyKey: GenerateID(),
+		CreatedAt:      time.Now(),
+	}
+}
+// enqueue saves a local write together with entry, its outbox entry, and
+// tries to send the user's outbox. data is the item to save, nil for a
+// delete. It returns the server's refusal of the write, or ErrQueued while
+// the write waits behind one that cannot be sent yet.
+func enqueue(storage Storage, httpClient HTTPClient, encryptor Encryptor, authService AuthService, entry *OutboxEntry, data *models.StoredData) error {
+	var err error
+	if entry.Op == OutboxDelete {
+		err = storage.DeleteDataQueued(authService.GetUserID(), entry.ItemID, entry)
+	} else {
+		err = storage.SaveDataQueued(data, entry)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save data locally: %w", err)
+	}
+	refusals, err := replayOutbox(storage, httpClient, encryptor, authService)
+	if refusal, ok := refusals[entry.Seq]; ok {
+		return refusal
+	}
+	if err != nil {
+		logger.Warn("Queued %s of item %s: %v", entry.Op, entry.ItemID, err)
+		return fmt.Errorf("%w: %v", ErrQueued, err)
+	}
+	return nil
+}
+// replayOutbox sends the user's queued writes in order. It stops at the
+// first write that may succeed later, e.g. while the server is unreachable
+// or failing, and returns its error; the rest stay queued behind it and are
+// retried however long that takes. A write the server rejects outright is
+// dead-lettered, or dropped when it only lost a version race, and the item
+// goes with the next sync like any other local change. Rejections are
+// returned by Seq with their errors.
+func replayOutbox(storage Storage, httpClient HTTPClient, encryptor Encryptor, authService AuthService) (map[int64]error, error) {
+	userID := authService.GetUserID()
+	entries, err := storage.ListOutbox(userID)
+	if err != nil {
+		return nil, err
+	}
+	refusals := make(map[int64]error)
+	for _, entry := range entries {
+		err := sendQueued(storage, httpClient, encryptor, authService, &entry)
+		switch {
+		case err == nil:
+			err = storage.DeleteOutboxEntry(entry.Seq)
+		case errors.Is(err, models.ErrVersionConflict):
+			// The next sync records the conflict for the user to resolve.
+			logger.Warn("Server refused queued %s of item %s: %v", entry.Op, entry.ItemID, err)
+			refusals[entry.Seq] = err
+			err = storage.DeleteOutboxEntry(entry.Seq)
+		case refused(err):
+			logger.Warn("Server rejected queued %s of item %s: %v", entry.Op, entry.ItemID, err)
+			refusals[entry.Seq] = err
+			err = storage.DeadLetterOutboxEntry(entry.Seq, err.Error())
+		case serverFailed(err):
+			if recordErr := storage.RecordOutboxFailure(entry.Seq, err.Error()); recordErr != nil {
+				return refusals, recordErr
+			}
+			return refusals, err
+		default:
+			return refusals, err
+		}
+		if err != nil {
+			return refusals, err
+		}
+	}
+	return refusals, nil
+}
+// sendQueued sends one queued write and makes the local copy the sync base
+// once the server has it.
+func sendQueued(storage Storage, httpClient HTTPClient, encryptor Encryptor, authService AuthService, entry *OutboxEntry) error {
+	// The vault reports a missing item as an error.
+	local, _ := storage.GetData(entry.ItemID)
+	switch entry.Op {
+	case OutboxAdd:
+		if local == nil || local.IsDeleted {
+			// Deleted before it was sent; the queued delete follows.
+			return nil
+		}
+		sealed, err := sealItem(encryptor, authService.GetUserID(), local)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt data: %w", err)
+		}
+		if err := httpClient.AddData(sealed, entry.IdempotencyKey, authService.GetToken()); err != nil {
+			return fmt.Errorf("failed to add data to server: %w", err)
+		}
+	case OutboxUpdate:
+		if local == nil || local.IsDeleted {
+			return nil
+		}
+		sealed, err := sealItem(encryptor, authService.GetUserID(), local)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt data: %w", err)
+		}
+		if err := httpClient.UpdateData(sealed, entry.BaseVersion, entry.IdempotencyKey, authService.GetToken()); err != nil {
+			return fmt.Errorf("failed to update data on server: %w", err)
+		}
+	case OutboxDelete:
+		err := httpClient.DeleteData(entry.ItemID, entry.IdempotencyKey, authService.GetToken())
+		var reqErr *RequestError
+		if errors.As(err, &reqErr) && reqErr.StatusCode == http.StatusNotFound {
+			// Already gone from the server.
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete data from server: %w", err)
+		}
+	default:
+		logger.Warn("Dropping queued item %s: unknown operation %s", entry.ItemID, entry.Op)
+		return nil
+	}
+	if local == nil {
+		return nil
+	}
+	return storage.SaveSyncBase(local, true)
+}
+// refused reports whether the server rejected a request in a way a retry
+// would not change.
+func refused(err error) bool {
+	if errors.Is(err, models.ErrVersionConflict) {
+		return true
+	}
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		return false
+	}
+	switch reqErr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return reqErr.StatusCode >= 400 && reqErr.StatusCode < 500
+}
+// serverFailed reports whether the server answered a request with an error
+// of its own.
+func serverFailed(err error) bool {
+	var reqErr *RequestError
+	return errors.As(err, &reqErr) && reqErr.StatusCode >= 500
+}
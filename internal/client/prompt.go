@@ -0,0 +1,44 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+type TerminalPrompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func NewTerminalPrompter() *TerminalPrompter {
+	return &TerminalPrompter{
+		in:  bufio.NewReader(os.Stdin),
+		out: os.Stderr,
+	}
+}
+func (p *TerminalPrompter) ReadPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return p.ReadLine(prompt)
+	}
+	fmt.Fprint(p.out, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(p.out)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return string(password), nil
+}
+func (p *TerminalPrompter) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(p.out, prompt)
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
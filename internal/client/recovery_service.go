@@ -0,0 +1,126 @@
+package client
+import (
+	"bytes"
+	"fmt"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+// keyWrapper is a vault key that can be wrapped for the recovery slot: the
+// unlocked vault itself or a replacement key during a re-key.
+type keyWrapper interface {
+	Encryptor
+	WrapKey(kek *crypto.Encryptor, aad []byte) ([]byte, error)
+}
+// RecoveryServiceImpl manages the recovery kit: a random recovery key split
+// into Shamir shares for the user, and a slot on the server holding the
+// vault key wrapped with it.
+type RecoveryServiceImpl struct {
+	keys        KeyStore
+	httpClient  HTTPClient
+	vault       Vault
+	rekey       RekeyService
+	authService AuthService
+}
+func NewRecoveryService(keys KeyStore, httpClient HTTPClient, vault Vault, rekey RekeyService, authService AuthService) *RecoveryServiceImpl {
+	return &RecoveryServiceImpl{
+		keys:        keys,
+		httpClient:  httpClient,
+		vault:       vault,
+		rekey:       rekey,
+		authService: authService,
+	}
+}
+// Split creates a new recovery kit, replacing any earlier one, and returns
+// its shares. The recovery key itself is never stored.
+func (r *RecoveryServiceImpl) Split(shares, threshold int) ([]crypto.RecoveryShare, error) {
+	if !r.authService.IsAuthenticated() {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	userID := r.authService.GetUserID()
+	params, err := r.keys.GetKDFParams(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kdf parameters: %w", err)
+	}
+	if len(params.KeyCheck) == 0 {
+		return nil, fmt.Errorf("vault key predates key checks, change the master password first")
+	}
+	recoveryKey, err := crypto.NewRecoveryKey()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(recoveryKey)
+	slot, err := newRecoverySlot(userID, recoveryKey, r.vault, params.KeyCheck)
+	if err != nil {
+		return nil, err
+	}
+	kit, err := crypto.SplitRecoveryKey(recoveryKey, shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.httpClient.SaveRecoverySlot(slot, r.authService.GetToken()); err != nil {
+		return nil, fmt.Errorf("failed to save recovery slot: %w", err)
+	}
+	logger.Info("Recovery kit created: %d shares, %d needed", shares, threshold)
+	return kit, nil
+}
+// Combine rebuilds the recovery key from printed shares, opens the vault key
+// from the slot and sets a new master password.
+func (r *RecoveryServiceImpl) Combine(shares []string) error {
+	if !r.authService.IsAuthenticated() {
+		return fmt.Errorf("not authenticated")
+	}
+	userID := r.authService.GetUserID()
+	parsed := make([]crypto.RecoveryShare, len(shares))
+	for i, text := range shares {
+		share, err := crypto.ParseRecoveryShare(text)
+		if err != nil {
+			return fmt.Errorf("share %d: %w", i+1, err)
+		}
+		parsed[i] = share
+	}
+	recoveryKey, err := crypto.CombineRecoveryShares(parsed)
+	if err != nil {
+		return err
+	}
+	defer clear(recoveryKey)
+	kek, err := crypto.NewEncryptorFromKey(recoveryKey)
+	if err != nil {
+		return fmt.Errorf("failed to initialize recovery key: %w", err)
+	}
+	slot, err := r.httpClient.GetRecoverySlot(r.authService.GetToken())
+	if err != nil {
+		return err
+	}
+	recovered, err := crypto.UnwrapKey(kek, slot.WrappedKey, recoverySlotAAD(userID))
+	if err != nil {
+		return fmt.Errorf("shares do not open the recovery slot: %w", err)
+	}
+	if !bytes.Equal(recovered.KeyCheck(), slot.KeyCheck) {
+		return fmt.Errorf("recovery slot holds an unexpected key")
+	}
+	return r.rekey.ResetMasterPassword(recovered, slot.KeyCheck)
+}
+// newRecoverySlot wraps key with the recovery key and seals the recovery key
+// under key, so a later re-key can wrap its replacement.
+func newRecoverySlot(userID string, recoveryKey []byte, key keyWrapper, keyCheck []byte) (*models.RecoverySlot, error) {
+	kek, err := crypto.NewEncryptorFromKey(recoveryKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize recovery key: %w", err)
+	}
+	wrapped, err := key.WrapKey(kek, recoverySlotAAD(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap vault key: %w", err)
+	}
+	sealed, err := key.EncryptWithAAD(recoveryKey, recoveryKeyAAD(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal recovery key: %w", err)
+	}
+	return &models.RecoverySlot{WrappedKey: wrapped, SealedRecoveryKey: sealed, KeyCheck: keyCheck}, nil
+}
+func recoverySlotAAD(userID string) []byte {
+	return []byte("gophkeeper recovery slot:" + userID)
+}
+func recoveryKeyAAD(userID string) []byte {
+	return []byte("gophkeeper recovery key:" + userID)
+}
@@ -0,0 +1,178 @@
+package client
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+	"time"
+)
+// RekeyServiceImpl changes the master password. The new key parameters are
+// recorded before anything is re-encrypted; the server swaps all items in
+// one request and the local vault in one transaction, so an interrupted
+// change is finished by running it again.
+type RekeyServiceImpl struct {
+	storage     Storage
+	keys        KeyStore
+	httpClient  HTTPClient
+	vault       Vault
+	syncService SyncService
+	authService AuthService
+}
+func NewRekeyService(storage Storage, keys KeyStore, httpClient HTTPClient, vault Vault, syncService SyncService, authService AuthService) *RekeyServiceImpl {
+	return &RekeyServiceImpl{
+		storage:     storage,
+		keys:        keys,
+		httpClient:  httpClient,
+		vault:       vault,
+		syncService: syncService,
+		authService: authService,
+	}
+}
+func (r *RekeyServiceImpl) ChangeMasterPassword() error {
+	if !r.authService.IsAuthenticated() {
+		return fmt.Errorf("not authenticated")
+	}
+	userID := r.authService.GetUserID()
+	current, err := r.keys.GetKDFParams(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load kdf parameters: %w", err)
+	}
+	return r.rekey(userID, current.KeyCheck)
+}
+
+// ResetMasterPassword sets a new master password after the vault key was
+// recovered some other way than from the current one, e.g. from a recovery
+// kit. previousKeyCheck is the server's check for the recovered key.
+func (r *RekeyServiceImpl) ResetMasterPassword(recovered *crypto.Encryptor, previousKeyCheck []byte) error {
+	if !r.authService.IsAuthenticated() {
+		return fmt.Errorf("not authenticated")
+	}
+	r.vault.UseKey(recovered)
+	return r.rekey(r.authService.GetUserID(), previousKeyCheck)
+}
+func (r *RekeyServiceImpl) rekey(userID string, previousKeyCheck []byte) error {
+	params, err := r.storage.GetRekeyState(userID)
+	if err != nil {
+		return err
+	}
+	if params == nil {
+		// Everything on the server has to be local before it is sealed
+		// under the new key, or it would be left behind.
+		if err := r.syncService.SyncData(); err != nil {
+			return fmt.Errorf("failed to sync before changing the master password: %w", err)
+		}
+	}
+	items, err := r.storage.GetDataSince(userID, time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to get local data: %w", err)
+	}
+	var next *crypto.Encryptor
+	if params == nil {
+		params, next, err = r.vault.NewKey()
+		if err != nil {
+			return err
+		}
+		if err := r.storage.SaveRekeyState(userID, params); err != nil {
+			return err
+		}
+	} else {
+		logger.Info("Resuming an interrupted master password change")
+		next, err = r.vault.ResumeKey(params)
+		if err != nil {
+			return err
+		}
+	}
+	sealed := make([]models.StoredData, len(items))
+	for i := range items {
+		item, err := sealItem(next, userID, &items[i])
+		if err != nil {
+			return err
+		}
+		sealed[i] = *item
+	}
+	history, err := r.rekeyHistory(userID, next)
+	if err != nil {
+		return err
+	}
+	slot, err := r.rewrapRecoverySlot(userID, previousKeyCheck, next)
+	if err != nil {
+		return err
+	}
+	req := &models.RekeyRequest{
+		PreviousKeyCheck: previousKeyCheck,
+		KDF:              params,
+		Data:             sealed,
+		History:          history,
+		RecoverySlot:     slot,
+	}
+	if err := r.httpClient.Rekey(req, r.authService.GetToken()); err != nil {
+		return fmt.Errorf("failed to upload re-encrypted items: %w", err)
+	}
+	if err := r.storage.Rekey(userID, next, params); err != nil {
+		return fmt.Errorf("failed to re-encrypt local vault: %w", err)
+	}
+	r.vault.UseKey(next)
+	logger.Info("Re-encrypted %d items with the new master password", len(items))
+	return nil
+}
+
+// rekeyHistory re-encrypts the server's history of every item with next.
+// Entries already sealed with it are left from a resumed change.
+func (r *RekeyServiceImpl) rekeyHistory(userID string, next *crypto.Encryptor) ([]models.DataHistory, error) {
+	history, err := r.httpClient.GetHistory(r.authService.GetToken())
+	if err != nil {
+		return nil, err
+	}
+	for i := range history {
+		h := &history[i]
+		if len(h.Data) == 0 {
+			continue
+		}
+		open := func(encryptor Encryptor) (models.StoredData, error) {
+			entry := models.StoredData{ID: h.DataID, UserID: userID, Type: h.Type, Title: h.Title, Data: h.Data, Metadata: h.Metadata, Version: h.Version}
+			return entry, openItem(encryptor, userID, &entry, true)
+		}
+		entry, err := open(r.vault)
+		if err != nil {
+			var nextErr error
+			if entry, nextErr = open(next); nextErr != nil {
+				return nil, fmt.Errorf("failed to open history of item %s: %w", h.DataID, err)
+			}
+		}
+		sealed, err := sealItem(next, userID, &entry)
+		if err != nil {
+			return nil, err
+		}
+		h.Type, h.Title, h.Data, h.Metadata = sealed.Type, sealed.Title, sealed.Data, sealed.Metadata
+	}
+	return history, nil
+}
+
+// rewrapRecoverySlot moves an existing recovery kit over to the new key, so
+// the printed shares stay valid. It returns nil when there is no kit.
+func (r *RekeyServiceImpl) rewrapRecoverySlot(userID string, previousKeyCheck []byte, next *crypto.Encryptor) (*models.RecoverySlot, error) {
+	slot, err := r.httpClient.GetRecoverySlot(r.authService.GetToken())
+	if err != nil {
+		if errors.Is(err, models.ErrRecoveryNotConfigured) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// A resumed change may already have replaced the slot on the server.
+	var opener Encryptor = r.vault
+	switch {
+	case bytes.Equal(slot.KeyCheck, next.KeyCheck()):
+		opener = next
+	case !bytes.Equal(slot.KeyCheck, previousKeyCheck):
+		logger.Warn("Recovery kit does not match the current key and will be dropped")
+		return nil, nil
+	}
+	recoveryKey, err := opener.DecryptWithAAD(slot.SealedRecoveryKey, recoveryKeyAAD(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recovery key: %w", err)
+	}
+	defer clear(recoveryKey)
+	return newRecoverySlot(userID, recoveryKey, next, next.KeyCheck())
+}
@@ -0,0 +1,302 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const defaultSSOTimeout = 5 * time.Minute
+
+// SSOOptions tunes the single sign-on flow. Zero values select the defaults:
+// a 30s HTTP client, the system browser and a five minute wait.
+type SSOOptions struct {
+	HTTPClient  *http.Client
+	OpenBrowser func(authURL string) error
+	Timeout     time.Duration
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+type ssoCallback struct {
+	code string
+	err  error
+}
+
+func (a *AuthServiceImpl) SetSSOOptions(opts SSOOptions) {
+	a.sso = opts
+}
+
+// LoginSSO logs in through the identity provider with the authorization
+// code flow and PKCE. issuer may be empty when the server accepts a single
+// provider.
+func (a *AuthServiceImpl) LoginSSO(issuer string) (*models.AuthResponse, error) {
+	req, err := a.authorizeSSO(issuer)
+	if err != nil {
+		return nil, err
+	}
+	response, err := a.httpClient.OIDCLogin(req)
+	if err != nil {
+		logger.Error("SSO login failed: %v", err)
+		return nil, err
+	}
+	return a.finishLogin(response.User.Username, response)
+}
+
+// LinkSSO links an identity at the provider to the logged-in account, so it
+// can be used with LoginSSO afterwards. The password is proved after the
+// browser sign-in, so the handshake does not expire while it runs.
+func (a *AuthServiceImpl) LinkSSO(issuer, password string) (*models.OIDCIdentity, error) {
+	if a.token == "" {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	req, err := a.authorizeSSO(issuer)
+	if err != nil {
+		return nil, err
+	}
+	proof, err := a.provePassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return a.httpClient.LinkOIDCIdentity(&models.OIDCLinkRequest{OIDCTokenRequest: *req, Proof: *proof}, a.token)
+}
+
+func (a *AuthServiceImpl) ListSSO() ([]models.OIDCIdentity, error) {
+	if a.token == "" {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	return a.httpClient.ListOIDCIdentities(a.token)
+}
+
+func (a *AuthServiceImpl) UnlinkSSO(issuer, password string) error {
+	if a.token == "" {
+		return fmt.Errorf("not authenticated")
+	}
+	proof, err := a.provePassword(password)
+	if err != nil {
+		return err
+	}
+	if err := a.httpClient.UnlinkOIDCIdentity(&models.OIDCUnlinkRequest{Issuer: issuer, Proof: *proof}, a.token); err != nil {
+		return fmt.Errorf("failed to unlink identity: %w", err)
+	}
+	return nil
+}
+
+// authorizeSSO sends the user to the provider and returns the ID token it
+// issues. The redirect is received on a loopback listener (RFC 8252), the
+// code is bound to this client with PKCE, and the nonce comes from the
+// server, which accepts it only once.
+func (a *AuthServiceImpl) authorizeSSO(issuer string) (*models.OIDCTokenRequest, error) {
+	provider, err := a.ssoProvider(issuer)
+	if err != nil {
+		return nil, err
+	}
+	discovery, err := a.discoverSSO(provider.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := a.httpClient.OIDCNonce()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := crypto.NewPKCEVerifier()
+	if err != nil {
+		return nil, err
+	}
+	state, err := crypto.NewOIDCNonce()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start sso callback listener: %w", err)
+	}
+	redirectURI := fmt.Sprintf("http://%s/callback", listener.Addr().String())
+	callbacks := make(chan ssoCallback, 1)
+	server := &http.Server{
+		Handler:           ssoCallbackHandler(state, callbacks),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce.Nonce},
+		"code_challenge":        {crypto.PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	authURL := discovery.AuthorizationEndpoint + "?" + query.Encode()
+	fmt.Printf("Complete the sign-in in your browser. If it does not open, visit:\n\n  %s\n\n", authURL)
+	if err := a.openBrowser(authURL); err != nil {
+		logger.Warn("Failed to open browser: %v", err)
+	}
+	timeout := a.sso.Timeout
+	if timeout <= 0 {
+		timeout = defaultSSOTimeout
+	}
+	var callback ssoCallback
+	select {
+	case callback = <-callbacks:
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("sso sign-in timed out")
+	}
+	if callback.err != nil {
+		return nil, callback.err
+	}
+	idToken, err := a.exchangeSSOCode(discovery, provider, callback.code, verifier, redirectURI)
+	if err != nil {
+		return nil, err
+	}
+	return &models.OIDCTokenRequest{Issuer: provider.Issuer, IDToken: idToken}, nil
+}
+
+func ssoCallbackHandler(state string, callbacks chan<- ssoCallback) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		if query.Get("state") != state {
+			http.Error(w, "Sign-in state does not match, start again from the terminal.", http.StatusBadRequest)
+			return
+		}
+		var callback ssoCallback
+		switch {
+		case query.Get("error") != "":
+			callback.err = fmt.Errorf("identity provider refused sign-in: %s %s", query.Get("error"), query.Get("error_description"))
+		case query.Get("code") == "":
+			callback.err = fmt.Errorf("identity provider returned no authorization code")
+		default:
+			callback.code = query.Get("code")
+		}
+		select {
+		case callbacks <- callback:
+		default:
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if callback.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "Sign-in failed, see the terminal for details.")
+			return
+		}
+		fmt.Fprintln(w, "Signed in to GophKeeper. You can close this window.")
+	})
+}
+
+func (a *AuthServiceImpl) ssoProvider(issuer string) (*models.OIDCProvider, error) {
+	providers, err := a.httpClient.OIDCProviders()
+	if err != nil {
+		return nil, err
+	}
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("server has no identity providers configured")
+	}
+	if issuer == "" {
+		if len(providers) == 1 {
+			return &providers[0], nil
+		}
+		issuers := make([]string, len(providers))
+		for i, provider := range providers {
+			issuers[i] = provider.Issuer
+		}
+		return nil, fmt.Errorf("choose an identity provider: %s", strings.Join(issuers, ", "))
+	}
+	for i := range providers {
+		if providers[i].Issuer == issuer {
+			return &providers[i], nil
+		}
+	}
+	return nil, models.ErrOIDCUnknownIssuer
+}
+
+func (a *AuthServiceImpl) discoverSSO(issuer string) (*oidcDiscovery, error) {
+	resp, err := a.ssoHTTPClient().Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach identity provider: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("identity provider discovery failed with status %d", resp.StatusCode)
+	}
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("failed to decode discovery document: %w", err)
+	}
+	if discovery.Issuer != issuer || discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" {
+		return nil, fmt.Errorf("discovery document does not match the issuer %s", issuer)
+	}
+	return &discovery, nil
+}
+
+func (a *AuthServiceImpl) exchangeSSOCode(discovery *oidcDiscovery, provider *models.OIDCProvider, code, verifier, redirectURI string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {provider.ClientID},
+		"code_verifier": {verifier},
+	}
+	resp, err := a.ssoHTTPClient().PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		return "", fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("identity provider refused the code: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("identity provider returned no id token")
+	}
+	return token.IDToken, nil
+}
+
+func (a *AuthServiceImpl) ssoHTTPClient() *http.Client {
+	if a.sso.HTTPClient != nil {
+		return a.sso.HTTPClient
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+func (a *AuthServiceImpl) openBrowser(authURL string) error {
+	if a.sso.OpenBrowser != nil {
+		return a.sso.OpenBrowser(authURL)
+	}
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", authURL).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", authURL).Start()
+	default:
+		return exec.Command("xdg-open", authURL).Start()
+	}
+}
@@ -2,8 +2,11 @@ package client
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	cm "gophkeeper/internal/client/migrations"
+	"gophkeeper/internal/logger"
 	"gophkeeper/internal/models"
 	"os"
 	"path/filepath"
@@ -13,8 +16,13 @@ import (
 	"github.com/pressly/goose/v3"
 )
 
+// ClientStorage keeps the local vault. Once an encryptor is set, title, data
+// and metadata of every row are sealed with it before they touch the disk and
+// are only opened in memory on read.
 type ClientStorage struct {
-	db *sql.DB
+	db        *sql.DB
+	encryptor Encryptor
+	migrated  bool
 }
 
 func NewClientStorage(dbPath string) (*ClientStorage, error) {
@@ -39,6 +47,10 @@ func NewClientStorage(dbPath string) (*ClientStorage, error) {
 func (s *ClientStorage) Close() error {
 	return s.db.Close()
 }
+func (s *ClientStorage) SetEncryptor(encryptor Encryptor) {
+	s.encryptor = encryptor
+	s.migrated = false
+}
 func (s *ClientStorage) createTables() error {
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS stored_data (
@@ -80,7 +92,24 @@ func (s *ClientStorage) createTables() error {
 	}
 	return nil
 }
+// SaveData stores an item and marks it dirty until SaveSyncBase records
+// that the server has it.
 func (s *ClientStorage) SaveData(data *models.StoredData) error {
+	return s.saveData(data, nil)
+}
+// SaveDataQueued saves data and appends entry to the outbox of its user in
+// one transaction, so a local write is never kept without being queued.
+func (s *ClientStorage) SaveDataQueued(data *models.StoredData, entry *OutboxEntry) error {
+	return s.saveData(data, entry)
+}
+func (s *ClientStorage) saveData(data *models.StoredData, entry *OutboxEntry) error {
+	if err := s.ensureMigrated(); err != nil {
+		return err
+	}
+	row, err := s.seal(data.Title, data.Data, data.Metadata)
+	if err != nil {
+		return err
+	}
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -89,39 +118,48 @@ func (s *ClientStorage) SaveData(data *models.StoredData) error {
 	var existingVersion int
 	err = tx.QueryRow("SELECT version FROM stored_data WHERE id = ?", data.ID).Scan(&existingVersion)
 	if err == sql.ErrNoRows {
-		query := `INSERT INTO stored_data (id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted) 
-				  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		query := `INSERT INTO stored_data (id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted, encrypted, dirty) 
+				  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, TRUE)`
 		now := time.Now()
-		_, err = tx.Exec(query, data.ID, data.UserID, data.Type, data.Title, data.Data, data.Metadata, data.Version, now, now, now, data.IsDeleted)
+		_, err = tx.Exec(query, data.ID, data.UserID, data.Type, row.title, row.data, row.metadata, data.Version, now, now, now, data.IsDeleted, row.encrypted)
 		if err != nil {
 			return fmt.Errorf("failed to insert data: %w", err)
 		}
 	} else if err != nil {
 		return fmt.Errorf("failed to check existing data: %w", err)
 	} else {
-		query := `UPDATE stored_data SET type = ?, title = ?, data = ?, metadata = ?, version = ?, updated_at = ?, last_sync_at = ?, is_deleted = ? 
+		query := `UPDATE stored_data SET type = ?, title = ?, data = ?, metadata = ?, version = ?, updated_at = ?, last_sync_at = ?, is_deleted = ?, encrypted = ?, dirty = TRUE 
 				  WHERE id = ?`
 		now := time.Now()
-		_, err = tx.Exec(query, data.Type, data.Title, data.Data, data.Metadata, data.Version, now, now, data.IsDeleted, data.ID)
+		_, err = tx.Exec(query, data.Type, row.title, row.data, row.metadata, data.Version, now, now, data.IsDeleted, row.encrypted, data.ID)
 		if err != nil {
 			return fmt.Errorf("failed to update data: %w", err)
 		}
 	}
-	if err := s.saveToHistory(tx, data); err != nil {
+	if err := s.saveToHistory(tx, data, row); err != nil {
 		return fmt.Errorf("failed to save to history: %w", err)
 	}
 	if err := s.cleanupHistory(tx, data.ID); err != nil {
 		return fmt.Errorf("failed to cleanup history: %w", err)
 	}
+	if entry != nil {
+		if err := enqueueMutation(tx, data.UserID, entry); err != nil {
+			return err
+		}
+	}
 	return tx.Commit()
 }
 func (s *ClientStorage) GetData(id string) (*models.StoredData, error) {
-	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted 
+	if err := s.ensureMigrated(); err != nil {
+		return nil, err
+	}
+	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted, encrypted 
 			  FROM stored_data WHERE id = ?`
 	data := &models.StoredData{}
+	var encrypted bool
 	err := s.db.QueryRow(query, id).Scan(
 		&data.ID, &data.UserID, &data.Type, &data.Title, &data.Data, &data.Metadata,
-		&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted,
+		&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted, &encrypted,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -129,10 +167,16 @@ func (s *ClientStorage) GetData(id string) (*models.StoredData, error) {
 		}
 		return nil, fmt.Errorf("failed to get data: %w", err)
 	}
+	if err := s.open(encrypted, &data.Title, &data.Data, &data.Metadata); err != nil {
+		return nil, err
+	}
 	return data, nil
 }
 func (s *ClientStorage) GetAllData(userID string) ([]models.StoredData, error) {
-	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted 
+	if err := s.ensureMigrated(); err != nil {
+		return nil, err
+	}
+	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted, encrypted 
 			  FROM stored_data WHERE user_id = ? AND is_deleted = FALSE ORDER BY updated_at DESC`
 	rows, err := s.db.Query(query, userID)
 	if err != nil {
@@ -142,19 +186,26 @@ func (s *ClientStorage) GetAllData(userID string) ([]models.StoredData, error) {
 	var dataList []models.StoredData
 	for rows.Next() {
 		var data models.StoredData
+		var encrypted bool
 		err := rows.Scan(
 			&data.ID, &data.UserID, &data.Type, &data.Title, &data.Data, &data.Metadata,
-			&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted,
+			&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted, &encrypted,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan data: %w", err)
 		}
+		if err := s.open(encrypted, &data.Title, &data.Data, &data.Metadata); err != nil {
+			return nil, err
+		}
 		dataList = append(dataList, data)
 	}
 	return dataList, nil
 }
 func (s *ClientStorage) GetDataSince(userID string, since time.Time) ([]models.StoredData, error) {
-	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted 
+	if err := s.ensureMigrated(); err != nil {
+		return nil, err
+	}
+	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted, encrypted 
 			  FROM stored_data WHERE user_id = ? AND updated_at > ? ORDER BY updated_at DESC`
 	rows, err := s.db.Query(query, userID, since)
 	if err != nil {
@@ -164,58 +215,317 @@ func (s *ClientStorage) GetDataSince(userID string, since time.Time) ([]models.S
 	var dataList []models.StoredData
 	for rows.Next() {
 		var data models.StoredData
+		var encrypted bool
 		err := rows.Scan(
 			&data.ID, &data.UserID, &data.Type, &data.Title, &data.Data, &data.Metadata,
-			&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted,
+			&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted, &encrypted,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan data: %w", err)
 		}
+		if err := s.open(encrypted, &data.Title, &data.Data, &data.Metadata); err != nil {
+			return nil, err
+		}
 		dataList = append(dataList, data)
 	}
 	return dataList, nil
 }
+// CountUnsent returns how many of the user's items have local changes the
+// server does not have yet, queued or not. Nothing is decrypted, so it works
+// while the vault is locked.
+func (s *ClientStorage) CountUnsent(userID string) (int, error) {
+	if err := s.ensureMigrated(); err != nil {
+		return 0, err
+	}
+	query := `SELECT COUNT(*) FROM stored_data WHERE user_id = ?
+			  AND (dirty = TRUE OR id IN (SELECT item_id FROM outbox WHERE user_id = ? AND dead_at IS NULL))`
+	var count int
+	if err := s.db.QueryRow(query, userID, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count unsent changes: %w", err)
+	}
+	return count, nil
+}
+// GetDirtyData returns the user's items written locally that the server does
+// not have yet, deleted ones included.
+func (s *ClientStorage) GetDirtyData(userID string) ([]models.StoredData, error) {
+	if err := s.ensureMigrated(); err != nil {
+		return nil, err
+	}
+	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted, encrypted 
+			  FROM stored_data WHERE user_id = ? AND dirty = TRUE ORDER BY updated_at`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query data: %w", err)
+	}
+	defer rows.Close()
+	var dataList []models.StoredData
+	for rows.Next() {
+		var data models.StoredData
+		var encrypted bool
+		err := rows.Scan(
+			&data.ID, &data.UserID, &data.Type, &data.Title, &data.Data, &data.Metadata,
+			&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted, &encrypted,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan data: %w", err)
+		}
+		if err := s.open(encrypted, &data.Title, &data.Data, &data.Metadata); err != nil {
+			return nil, err
+		}
+		dataList = append(dataList, data)
+	}
+	return dataList, rows.Err()
+}
 func (s *ClientStorage) DeleteData(id string) error {
-	query := `UPDATE stored_data SET is_deleted = TRUE, updated_at = ? WHERE id = ?`
-	_, err := s.db.Exec(query, time.Now(), id)
+	return deleteData(s.db, id)
+}
+// DeleteDataQueued marks an item deleted and appends entry to the user's
+// outbox in one transaction.
+func (s *ClientStorage) DeleteDataQueued(userID, id string, entry *OutboxEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := deleteData(tx, id); err != nil {
+		return err
+	}
+	if err := enqueueMutation(tx, userID, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+func deleteData(db execer, id string) error {
+	query := `UPDATE stored_data SET is_deleted = TRUE, updated_at = ?, dirty = TRUE WHERE id = ?`
+	_, err := db.Exec(query, time.Now(), id)
 	if err != nil {
 		return fmt.Errorf("failed to delete data: %w", err)
 	}
 	return nil
 }
-func (s *ClientStorage) GetLastSyncTime(userID string) (time.Time, error) {
-	query := `SELECT MAX(last_sync_at) FROM stored_data WHERE user_id = ?`
-	var lastSyncStr sql.NullString
-	err := s.db.QueryRow(query, userID).Scan(&lastSyncStr)
+// GetSyncState returns where the user's last sync left off. Before the first
+// sync the cursor is empty and every local item counts as changed.
+func (s *ClientStorage) GetSyncState(userID string) (*SyncState, error) {
+	state := &SyncState{}
+	err := s.db.QueryRow(`SELECT cursor, last_sync_at, resealed FROM sync_metadata WHERE user_id = ?`, userID).Scan(&state.Cursor, &state.LastSyncAt, &state.Resealed)
+	if err == sql.ErrNoRows {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sync state: %w", err)
+	}
+	return state, nil
+}
+func (s *ClientStorage) SaveSyncState(userID string, state *SyncState) error {
+	_, err := s.db.Exec(`INSERT INTO sync_metadata (user_id, cursor, last_sync_at, resealed) VALUES (?, ?, ?, ?)
+			  ON CONFLICT(user_id) DO UPDATE SET cursor = excluded.cursor, last_sync_at = excluded.last_sync_at, resealed = excluded.resealed`,
+		userID, state.Cursor, state.LastSyncAt, state.Resealed)
+	if err != nil {
+		return fmt.Errorf("failed to save sync state: %w", err)
+	}
+	return nil
+}
+// SaveConflict stores the server copy of a conflicting item, replacing the
+// one recorded before.
+func (s *ClientStorage) SaveConflict(userID string, conflict *ItemConflict) error {
+	server := &conflict.Server
+	row, err := s.seal(server.Title, server.Data, server.Metadata)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO conflicts (id, user_id, reason, type, title, data, metadata, version, updated_at, is_deleted, encrypted, detected_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			  ON CONFLICT(id) DO UPDATE SET reason = excluded.reason, type = excluded.type, title = excluded.title, data = excluded.data,
+			  metadata = excluded.metadata, version = excluded.version, updated_at = excluded.updated_at, is_deleted = excluded.is_deleted,
+			  encrypted = excluded.encrypted, detected_at = excluded.detected_at`,
+		conflict.ID, userID, conflict.Reason, server.Type, row.title, row.data, row.metadata, server.Version, server.UpdatedAt, server.IsDeleted, row.encrypted, conflict.DetectedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save conflict: %w", err)
+	}
+	return nil
+}
+// GetConflict returns the open conflict of an item, or nil.
+func (s *ClientStorage) GetConflict(id string) (*ItemConflict, error) {
+	rows, err := s.queryConflicts(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
+func (s *ClientStorage) ListConflicts(userID string) ([]ItemConflict, error) {
+	return s.queryConflicts(`WHERE user_id = ? ORDER BY detected_at`, userID)
+}
+func (s *ClientStorage) DeleteConflict(id string) error {
+	if _, err := s.db.Exec(`DELETE FROM conflicts WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete conflict: %w", err)
+	}
+	return nil
+}
+func (s *ClientStorage) queryConflicts(where string, args ...interface{}) ([]ItemConflict, error) {
+	rows, err := s.db.Query(`SELECT id, user_id, reason, type, title, data, COALESCE(metadata, ''), version, updated_at, is_deleted, encrypted, detected_at
+			  FROM conflicts `+where, args...)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("failed to get last sync time: %w", err)
+		return nil, fmt.Errorf("failed to query conflicts: %w", err)
 	}
-	if lastSyncStr.Valid && lastSyncStr.String != "" {
-		formats := []string{
-			time.RFC3339,
-			time.RFC3339Nano,
-			"2006-01-02 15:04:05.999999999-07:00",
-			"2006-01-02 15:04:05-07:00",
-			"2006-01-02 15:04:05",
+	defer rows.Close()
+	var conflicts []ItemConflict
+	for rows.Next() {
+		var c ItemConflict
+		var encrypted bool
+		server := &c.Server
+		err := rows.Scan(&c.ID, &server.UserID, &c.Reason, &server.Type, &server.Title, &server.Data, &server.Metadata,
+			&server.Version, &server.UpdatedAt, &server.IsDeleted, &encrypted, &c.DetectedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan conflict: %w", err)
 		}
-		for _, format := range formats {
-			if lastSync, err := time.Parse(format, lastSyncStr.String); err == nil {
-				return lastSync, nil
-			}
+		server.ID = c.ID
+		if err := s.open(encrypted, &server.Title, &server.Data, &server.Metadata); err != nil {
+			return nil, err
 		}
-		return time.Time{}, fmt.Errorf("failed to parse last sync time: %s", lastSyncStr.String)
+		conflicts = append(conflicts, c)
 	}
-	return time.Time{}, nil
+	return conflicts, nil
 }
-func (s *ClientStorage) UpdateLastSyncTime(userID string, syncTime time.Time) error {
-	query := `UPDATE stored_data SET last_sync_at = ? WHERE user_id = ?`
-	_, err := s.db.Exec(query, syncTime, userID)
+// SaveSyncBase records data as the copy of the item the client and the
+// server last agreed on, and clears the item's dirty mark and its dead
+// letters, as the server now has the item. bound tells
+// whether the server copy was bound to the item; once set it stays set.
+func (s *ClientStorage) SaveSyncBase(data *models.StoredData, bound bool) error {
+	row, err := s.seal(data.Title, data.Data, data.Metadata)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO sync_base (id, user_id, type, title, data, metadata, version, is_deleted, encrypted, bound)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			  ON CONFLICT(id) DO UPDATE SET type = excluded.type, title = excluded.title, data = excluded.data, metadata = excluded.metadata,
+			  version = excluded.version, is_deleted = excluded.is_deleted, encrypted = excluded.encrypted, bound = sync_base.bound OR excluded.bound`,
+		data.ID, data.UserID, data.Type, row.title, row.data, row.metadata, data.Version, data.IsDeleted, row.encrypted, bound)
 	if err != nil {
-		return fmt.Errorf("failed to update last sync time: %w", err)
+		return fmt.Errorf("failed to save sync base: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE stored_data SET dirty = FALSE WHERE id = ?`, data.ID); err != nil {
+		return fmt.Errorf("failed to mark item synced: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM outbox WHERE item_id = ? AND dead_at IS NOT NULL`, data.ID); err != nil {
+		return fmt.Errorf("failed to clear dead letters: %w", err)
+	}
+	return tx.Commit()
+}
+// GetSyncBase returns the last agreed copy of an item, or nil for items
+// synced before bases were kept.
+func (s *ClientStorage) GetSyncBase(id string) (*models.StoredData, error) {
+	data := &models.StoredData{}
+	var encrypted bool
+	err := s.db.QueryRow(`SELECT id, user_id, type, title, data, COALESCE(metadata, ''), version, is_deleted, encrypted FROM sync_base WHERE id = ?`, id).Scan(
+		&data.ID, &data.UserID, &data.Type, &data.Title, &data.Data, &data.Metadata, &data.Version, &data.IsDeleted, &encrypted,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sync base: %w", err)
+	}
+	if err := s.open(encrypted, &data.Title, &data.Data, &data.Metadata); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+// SyncBaseBound reports whether a bound server copy of the item has been
+// synced.
+func (s *ClientStorage) SyncBaseBound(id string) (bool, error) {
+	var bound bool
+	err := s.db.QueryRow(`SELECT bound FROM sync_base WHERE id = ?`, id).Scan(&bound)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get sync base: %w", err)
+	}
+	return bound, nil
+}
+// enqueueMutation appends a local write to the user's outbox.
+func enqueueMutation(db execer, userID string, entry *OutboxEntry) error {
+	result, err := db.Exec(`INSERT INTO outbox (user_id, item_id, op, idempotency_key, base_version, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, entry.ItemID, entry.Op, entry.IdempotencyKey, entry.BaseVersion, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to queue change: %w", err)
+	}
+	entry.Seq, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to queue change: %w", err)
 	}
 	return nil
 }
-func (s *ClientStorage) saveToHistory(tx *sql.Tx, data *models.StoredData) error {
+// ListOutbox returns the user's queued writes, oldest first, leaving out
+// dead-lettered ones.
+func (s *ClientStorage) ListOutbox(userID string) ([]OutboxEntry, error) {
+	rows, err := s.db.Query(`SELECT seq, item_id, op, idempotency_key, base_version, created_at, attempts, COALESCE(last_error, '')
+			  FROM outbox WHERE user_id = ? AND dead_at IS NULL ORDER BY seq`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %w", err)
+	}
+	defer rows.Close()
+	var entries []OutboxEntry
+	for rows.Next() {
+		var e OutboxEntry
+		if err := rows.Scan(&e.Seq, &e.ItemID, &e.Op, &e.IdempotencyKey, &e.BaseVersion, &e.CreatedAt, &e.Attempts, &e.LastError); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+// ListDeadLetters returns the user's queued writes the server rejected,
+// oldest first.
+func (s *ClientStorage) ListDeadLetters(userID string) ([]OutboxEntry, error) {
+	rows, err := s.db.Query(`SELECT seq, item_id, op, idempotency_key, base_version, created_at, attempts, COALESCE(last_error, ''), dead_at
+			  FROM outbox WHERE user_id = ? AND dead_at IS NOT NULL ORDER BY seq`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letters: %w", err)
+	}
+	defer rows.Close()
+	var entries []OutboxEntry
+	for rows.Next() {
+		var e OutboxEntry
+		var deadAt time.Time
+		if err := rows.Scan(&e.Seq, &e.ItemID, &e.Op, &e.IdempotencyKey, &e.BaseVersion, &e.CreatedAt, &e.Attempts, &e.LastError, &deadAt); err != nil {
+			return nil, fmt.Errorf("failed to scan dead letter: %w", err)
+		}
+		e.DeadAt = &deadAt
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+// RecordOutboxFailure counts a failed attempt to send a queued write.
+func (s *ClientStorage) RecordOutboxFailure(seq int64, lastError string) error {
+	if _, err := s.db.Exec(`UPDATE outbox SET attempts = attempts + 1, last_error = ? WHERE seq = ?`, lastError, seq); err != nil {
+		return fmt.Errorf("failed to update outbox entry: %w", err)
+	}
+	return nil
+}
+// DeadLetterOutboxEntry stops sending a queued write the server rejected.
+// The entry is kept with the rejection until the item syncs.
+func (s *ClientStorage) DeadLetterOutboxEntry(seq int64, lastError string) error {
+	if _, err := s.db.Exec(`UPDATE outbox SET attempts = attempts + 1, last_error = ?, dead_at = ? WHERE seq = ?`, lastError, time.Now(), seq); err != nil {
+		return fmt.Errorf("failed to update outbox entry: %w", err)
+	}
+	return nil
+}
+func (s *ClientStorage) DeleteOutboxEntry(seq int64) error {
+	if _, err := s.db.Exec(`DELETE FROM outbox WHERE seq = ?`, seq); err != nil {
+		return fmt.Errorf("failed to delete outbox entry: %w", err)
+	}
+	return nil
+}
+func (s *ClientStorage) saveToHistory(tx *sql.Tx, data *models.StoredData, row sealedRow) error {
 	historyID := fmt.Sprintf("%s_v%d", data.ID, data.Version)
 	
 	var existingID string
@@ -228,10 +538,10 @@ func (s *ClientStorage) saveToHistory(tx *sql.Tx, data *models.StoredData) error
 		return fmt.Errorf("failed to check existing history: %w", err)
 	}
 	
-	query := `INSERT INTO data_history (id, data_id, user_id, type, title, data, metadata, version, created_at, updated_at, is_deleted) 
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO data_history (id, data_id, user_id, type, title, data, metadata, version, created_at, updated_at, is_deleted, encrypted) 
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	now := time.Now()
-	_, err = tx.Exec(query, historyID, data.ID, data.UserID, data.Type, data.Title, data.Data, data.Metadata, data.Version, now, now, data.IsDeleted)
+	_, err = tx.Exec(query, historyID, data.ID, data.UserID, data.Type, row.title, row.data, row.metadata, data.Version, now, now, data.IsDeleted, row.encrypted)
 	if err != nil {
 		return fmt.Errorf("failed to insert history: %w", err)
 	}
@@ -252,7 +562,10 @@ func (s *ClientStorage) cleanupHistory(tx *sql.Tx, dataID string) error {
 	return nil
 }
 func (s *ClientStorage) GetDataHistory(dataID string) ([]models.DataHistory, error) {
-	query := `SELECT id, data_id, user_id, type, title, data, metadata, version, created_at, updated_at, is_deleted 
+	if err := s.ensureMigrated(); err != nil {
+		return nil, err
+	}
+	query := `SELECT id, data_id, user_id, type, title, data, metadata, version, created_at, updated_at, is_deleted, encrypted 
 			  FROM data_history WHERE data_id = ? ORDER BY version DESC`
 	rows, err := s.db.Query(query, dataID)
 	if err != nil {
@@ -262,14 +575,380 @@ func (s *ClientStorage) GetDataHistory(dataID string) ([]models.DataHistory, err
 	var history []models.DataHistory
 	for rows.Next() {
 		var h models.DataHistory
+		var encrypted bool
 		err := rows.Scan(
 			&h.ID, &h.DataID, &h.UserID, &h.Type, &h.Title, &h.Data, &h.Metadata,
-			&h.Version, &h.CreatedAt, &h.UpdatedAt, &h.IsDeleted,
+			&h.Version, &h.CreatedAt, &h.UpdatedAt, &h.IsDeleted, &encrypted,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan history: %w", err)
 		}
+		if err := s.open(encrypted, &h.Title, &h.Data, &h.Metadata); err != nil {
+			return nil, err
+		}
 		history = append(history, h)
 	}
 	return history, nil
 }
+func (s *ClientStorage) SaveKDFParams(userID string, params *models.KDFParams) error {
+	return saveKDFParams(s.db, userID, params)
+}
+func saveKDFParams(db execer, userID string, params *models.KDFParams) error {
+	query := `INSERT INTO vault_keys (user_id, algorithm, salt, time_cost, memory_cost, parallelism, key_length, key_check, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			  ON CONFLICT(user_id) DO UPDATE SET algorithm = excluded.algorithm, salt = excluded.salt,
+			  time_cost = excluded.time_cost, memory_cost = excluded.memory_cost, parallelism = excluded.parallelism,
+			  key_length = excluded.key_length, key_check = excluded.key_check, updated_at = excluded.updated_at`
+	_, err := db.Exec(query, userID, params.Algorithm, params.Salt, params.Time, params.Memory, params.Parallelism, params.KeyLength, params.KeyCheck, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save kdf params: %w", err)
+	}
+	return nil
+}
+func (s *ClientStorage) GetKDFParams(userID string) (*models.KDFParams, error) {
+	query := `SELECT algorithm, salt, time_cost, memory_cost, parallelism, key_length, key_check
+			  FROM vault_keys WHERE user_id = ?`
+	params := &models.KDFParams{}
+	err := s.db.QueryRow(query, userID).Scan(
+		&params.Algorithm, &params.Salt, &params.Time, &params.Memory, &params.Parallelism, &params.KeyLength, &params.KeyCheck,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrKDFNotConfigured
+		}
+		return nil, fmt.Errorf("failed to get kdf params: %w", err)
+	}
+	return params, nil
+}
+
+// SaveRekeyState records the parameters of a new vault key before any item
+// is re-encrypted, so an interrupted master password change can resume.
+func (s *ClientStorage) SaveRekeyState(userID string, params *models.KDFParams) error {
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to encode rekey state: %w", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO rekey_state (user_id, kdf, started_at) VALUES (?, ?, ?)
+			  ON CONFLICT(user_id) DO UPDATE SET kdf = excluded.kdf, started_at = excluded.started_at`, userID, string(encoded), time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save rekey state: %w", err)
+	}
+	return nil
+}
+
+// GetRekeyState returns the parameters of an unfinished re-key, or nil.
+func (s *ClientStorage) GetRekeyState(userID string) (*models.KDFParams, error) {
+	var encoded string
+	err := s.db.QueryRow(`SELECT kdf FROM rekey_state WHERE user_id = ?`, userID).Scan(&encoded)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rekey state: %w", err)
+	}
+	params := &models.KDFParams{}
+	if err := json.Unmarshal([]byte(encoded), params); err != nil {
+		return nil, fmt.Errorf("failed to decode rekey state: %w", err)
+	}
+	return params, nil
+}
+
+// SaveDeviceIdentity stores the device ID and key this installation uses
+// for the user's account. The key is sealed under the vault key.
+func (s *ClientStorage) SaveDeviceIdentity(userID string, identity *DeviceIdentity) error {
+	key, sealed, err := s.sealDeviceKey(s.encryptor, userID, identity.PrivateKey)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO device_identity (user_id, device_id, private_key, sealed, created_at) VALUES (?, ?, ?, ?, ?)
+			  ON CONFLICT(user_id) DO UPDATE SET device_id = excluded.device_id, private_key = excluded.private_key, sealed = excluded.sealed`,
+		userID, identity.DeviceID, key, sealed, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save device identity: %w", err)
+	}
+	return nil
+}
+
+// GetDeviceIdentity returns the device registered for the user, or nil. A
+// key stored in the clear by an older client is sealed on the way.
+func (s *ClientStorage) GetDeviceIdentity(userID string) (*DeviceIdentity, error) {
+	identity := &DeviceIdentity{}
+	var key []byte
+	var sealed bool
+	err := s.db.QueryRow(`SELECT device_id, private_key, sealed FROM device_identity WHERE user_id = ?`, userID).Scan(&identity.DeviceID, &key, &sealed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device identity: %w", err)
+	}
+	if !sealed {
+		identity.PrivateKey = key
+		if s.encryptor != nil {
+			if err := s.SaveDeviceIdentity(userID, identity); err != nil {
+				return nil, err
+			}
+		}
+		return identity, nil
+	}
+	if s.encryptor == nil {
+		return nil, fmt.Errorf("device key is sealed and the vault is not available")
+	}
+	opened, err := s.encryptor.DecryptWithAAD(key, deviceKeyAAD(userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open device key: %w", err)
+	}
+	identity.PrivateKey = opened
+	return identity, nil
+}
+
+// sealDeviceKey seals a device key under encryptor; without one the key is
+// kept in the clear.
+func (s *ClientStorage) sealDeviceKey(encryptor Encryptor, userID string, key []byte) ([]byte, bool, error) {
+	if encryptor == nil {
+		return key, false, nil
+	}
+	sealed, err := encryptor.EncryptWithAAD(key, deviceKeyAAD(userID))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to seal device key: %w", err)
+	}
+	return sealed, true, nil
+}
+
+func (s *ClientStorage) DeleteDeviceIdentity(userID string) error {
+	if _, err := s.db.Exec(`DELETE FROM device_identity WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete device identity: %w", err)
+	}
+	return nil
+}
+
+// Rekey re-encrypts every row and history entry of the user, and the device
+// key, with to and stores the new KDF parameters in the same transaction,
+// which also clears the rekey state.
+func (s *ClientStorage) Rekey(userID string, to Encryptor, params *models.KDFParams) error {
+	identity, err := s.GetDeviceIdentity(userID)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"stored_data", "data_history", "conflicts", "sync_base"} {
+		rows, err := tx.Query(`SELECT id, title, data, COALESCE(metadata, ''), encrypted FROM `+table+` WHERE user_id = ?`, userID)
+		if err != nil {
+			return fmt.Errorf("failed to query rows: %w", err)
+		}
+		type plainRow struct {
+			id       string
+			title    string
+			data     []byte
+			metadata string
+		}
+		var pending []plainRow
+		for rows.Next() {
+			var r plainRow
+			var encrypted bool
+			if err := rows.Scan(&r.id, &r.title, &r.data, &r.metadata, &encrypted); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan row: %w", err)
+			}
+			if err := s.open(encrypted, &r.title, &r.data, &r.metadata); err != nil {
+				rows.Close()
+				return err
+			}
+			pending = append(pending, r)
+		}
+		rows.Close()
+		for _, r := range pending {
+			row, err := sealWith(to, r.title, r.data, r.metadata)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`UPDATE `+table+` SET title = ?, data = ?, metadata = ?, encrypted = 1 WHERE id = ?`, row.title, row.data, row.metadata, r.id)
+			if err != nil {
+				return fmt.Errorf("failed to rekey row %s: %w", r.id, err)
+			}
+		}
+	}
+	if identity != nil {
+		key, sealed, err := s.sealDeviceKey(to, userID, identity.PrivateKey)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE device_identity SET private_key = ?, sealed = ? WHERE user_id = ?`, key, sealed, userID); err != nil {
+			return fmt.Errorf("failed to rekey device key: %w", err)
+		}
+	}
+	if err := saveKDFParams(tx, userID, params); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM rekey_state WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to clear rekey state: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rekeyed rows: %w", err)
+	}
+	// Rows sealed with the old key can linger in free pages.
+	if _, err := s.db.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return nil
+}
+
+// ResetData drops the local copy of the user's items so the next sync
+// downloads them again, e.g. after the vault key changed on another device.
+func (s *ClientStorage) ResetData(userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"data_history", "stored_data", "conflicts", "sync_base", "outbox", "rekey_state", "sync_metadata"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("failed to reset %s: %w", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type sealedRow struct {
+	title     string
+	data      []byte
+	metadata  string
+	encrypted bool
+}
+
+func (s *ClientStorage) seal(title string, data []byte, metadata string) (sealedRow, error) {
+	if s.encryptor == nil {
+		return sealedRow{title: title, data: data, metadata: metadata}, nil
+	}
+	return sealWith(s.encryptor, title, data, metadata)
+}
+func sealWith(encryptor Encryptor, title string, data []byte, metadata string) (sealedRow, error) {
+	sealedTitle, err := encryptor.Encrypt([]byte(title))
+	if err != nil {
+		return sealedRow{}, fmt.Errorf("failed to encrypt title: %w", err)
+	}
+	sealedData, err := encryptor.Encrypt(data)
+	if err != nil {
+		return sealedRow{}, fmt.Errorf("failed to encrypt data: %w", err)
+	}
+	sealedMetadata, err := encryptor.Encrypt([]byte(metadata))
+	if err != nil {
+		return sealedRow{}, fmt.Errorf("failed to encrypt metadata: %w", err)
+	}
+	return sealedRow{
+		title:     base64.StdEncoding.EncodeToString(sealedTitle),
+		data:      sealedData,
+		metadata:  base64.StdEncoding.EncodeToString(sealedMetadata),
+		encrypted: true,
+	}, nil
+}
+func (s *ClientStorage) open(encrypted bool, title *string, data *[]byte, metadata *string) error {
+	if !encrypted {
+		return nil
+	}
+	if s.encryptor == nil {
+		return fmt.Errorf("local vault is encrypted but no key is available")
+	}
+	plainTitle, err := s.openText(*title)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt title: %w", err)
+	}
+	plainData, err := s.encryptor.Decrypt(*data)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt data: %w", err)
+	}
+	plainMetadata, err := s.openText(*metadata)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt metadata: %w", err)
+	}
+	*title, *data, *metadata = plainTitle, plainData, plainMetadata
+	return nil
+}
+func (s *ClientStorage) openText(value string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := s.encryptor.Decrypt(raw)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// ensureMigrated re-encrypts rows written by clients that stored the vault in
+// plaintext. It runs once per storage and only unlocks the vault when such
+// rows actually exist.
+func (s *ClientStorage) ensureMigrated() error {
+	if s.migrated || s.encryptor == nil {
+		return nil
+	}
+	var pending int
+	err := s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM stored_data WHERE encrypted = 0) + (SELECT COUNT(*) FROM data_history WHERE encrypted = 0)`).Scan(&pending)
+	if err != nil {
+		return fmt.Errorf("failed to count plaintext rows: %w", err)
+	}
+	if pending > 0 {
+		if err := s.encryptPlaintextRows(); err != nil {
+			return err
+		}
+		logger.Info("Encrypted %d plaintext rows in the local vault", pending)
+	}
+	s.migrated = true
+	return nil
+}
+func (s *ClientStorage) encryptPlaintextRows() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"stored_data", "data_history"} {
+		rows, err := tx.Query(`SELECT id, title, data, COALESCE(metadata, '') FROM ` + table + ` WHERE encrypted = 0`)
+		if err != nil {
+			return fmt.Errorf("failed to query plaintext rows: %w", err)
+		}
+		type plainRow struct {
+			id       string
+			title    string
+			data     []byte
+			metadata string
+		}
+		var pending []plainRow
+		for rows.Next() {
+			var r plainRow
+			if err := rows.Scan(&r.id, &r.title, &r.data, &r.metadata); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan plaintext row: %w", err)
+			}
+			pending = append(pending, r)
+		}
+		rows.Close()
+		for _, r := range pending {
+			row, err := s.seal(r.title, r.data, r.metadata)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`UPDATE `+table+` SET title = ?, data = ?, metadata = ?, encrypted = 1 WHERE id = ?`, row.title, row.data, row.metadata, r.id)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt row %s: %w", r.id, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit encrypted rows: %w", err)
+	}
+	// Plaintext can linger in free pages until the file is rebuilt.
+	if _, err := s.db.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return nil
+}
@@ -1,8 +1,41 @@
 package client
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/logger"
 	"gophkeeper/internal/models"
 )
+// RejectedItem is a server copy of an item that failed the binding check.
+type RejectedItem struct {
+	ID     string
+	Reason string
+}
+
+// RejectedItemsError reports items the server sent that were not saved
+// because they were swapped, rolled back or tampered with.
+type RejectedItemsError struct {
+	Items []RejectedItem
+}
+func (e *RejectedItemsError) Error() string {
+	parts := make([]string, len(e.Items))
+	for i, item := range e.Items {
+		parts[i] = item.ID + ": " + item.Reason
+	}
+	return fmt.Sprintf("rejected %d item(s) from the server: %s", len(e.Items), strings.Join(parts, "; "))
+}
+// SyncState is where the last sync left off. Cursor is the server's
+// position in the user's change feed, opaque to the client. LastSyncAt is
+// the local time the last sync finished; the changes to send are the items
+// marked dirty, not those updated after it. Resealed is set once every item uploaded before
+// envelopes existed has been sent back sealed.
+type SyncState struct {
+	Cursor     string
+	LastSyncAt time.Time
+	Resealed   bool
+}
 type SyncServiceImpl struct {
 	storage     Storage
 	httpClient  HTTPClient
@@ -22,57 +55,283 @@ func (s *SyncServiceImpl) SyncData() error {
 		return fmt.Errorf("not authenticated")
 	}
 	userID := s.authService.GetUserID()
-	lastSyncTime, err := s.storage.GetLastSyncTime(userID)
+	// Queued writes go first and in order, each with its own key. While
+	// they are blocked nothing else is sent, so nothing overtakes them, but
+	// server changes are still pulled.
+	_, pushErr := replayOutbox(s.storage, s.httpClient, s.encryptor, s.authService)
+	state, err := s.storage.GetSyncState(userID)
 	if err != nil {
-		return fmt.Errorf("failed to get last sync time: %w", err)
+		return fmt.Errorf("failed to get sync state: %w", err)
 	}
-	localData, err := s.storage.GetDataSince(userID, lastSyncTime)
+	changed, err := s.storage.GetDirtyData(userID)
 	if err != nil {
 		return fmt.Errorf("failed to get local data: %w", err)
 	}
+	unsent := make(map[string]bool, len(changed))
+	for _, data := range changed {
+		unsent[data.ID] = true
+	}
+	var localData []models.StoredData
+	if pushErr == nil {
+		localData, err = s.pendingChanges(changed)
+		if err != nil {
+			return err
+		}
+	}
 	encryptedLocalData := make([]models.StoredData, len(localData))
-	for i, data := range localData {
-		encryptedLocalData[i] = data
-		if err := s.encryptData(&encryptedLocalData[i]); err != nil {
+	for i := range localData {
+		sealed, err := sealItem(s.encryptor, userID, &localData[i])
+		if err != nil {
 			return fmt.Errorf("failed to encrypt data: %w", err)
 		}
+		encryptedLocalData[i] = *sealed
+	}
+	cursor := state.Cursor
+	if !state.Resealed {
+		// Fetch everything once, so items the server still has unsealed
+		// are found and sealed below.
+		cursor = ""
 	}
 	req := &models.DataSyncRequest{
-		LastSyncAt: lastSyncTime,
-		Data:       encryptedLocalData,
+		Cursor: cursor,
+		Data:   encryptedLocalData,
+	}
+	// A fresh key per sync: the server applies the pushed changes once even
+	// if the request is sent again, e.g. after the token was refreshed.
+	response, err := s.httpClient.SyncData(req, GenerateID(), s.authService.GetToken())
+	if errors.Is(err, models.ErrIdempotencyNotKept) {
+		// The server already applied this sync but kept no response to
+		// replay. Its changes are pulled back like any other, with a new key.
+		localData = nil
+		req.Data = nil
+		response, err = s.httpClient.SyncData(req, GenerateID(), s.authService.GetToken())
 	}
-	response, err := s.httpClient.SyncData(req, s.authService.GetToken())
 	if err != nil {
 		return fmt.Errorf("sync failed: %w", err)
 	}
-	for i := range response.Data {
-		if err := s.decryptData(&response.Data[i]); err != nil {
-			return fmt.Errorf("failed to decrypt server data: %w", err)
+	refused := make(map[string]bool, len(response.Conflicts))
+	for _, conflict := range response.Conflicts {
+		refused[conflict.LocalData.ID] = true
+	}
+	for i := range localData {
+		if refused[localData[i].ID] {
+			continue
+		}
+		if err := s.accept(&localData[i], true); err != nil {
+			return err
+		}
+	}
+	var rejected []RejectedItem
+	for _, conflict := range response.Conflicts {
+		reason, err := s.recordConflict(userID, &conflict, state.Resealed)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			logger.Warn("Rejected server copy of item %s: %s", conflict.ServerData.ID, reason)
+			rejected = append(rejected, RejectedItem{ID: conflict.ServerData.ID, Reason: reason})
 		}
 	}
 	for _, data := range response.Data {
-		if err := s.storage.SaveData(&data); err != nil {
-			return fmt.Errorf("failed to save server data: %w", err)
+		unsealed := data.Type != models.DataTypeSealed
+		bound := crypto.IsBound(data.Data)
+		if reason := s.openServerData(userID, &data, state.Resealed); reason != "" {
+			logger.Warn("Rejected server copy of item %s: %s", data.ID, reason)
+			rejected = append(rejected, RejectedItem{ID: data.ID, Reason: reason})
+			continue
+		}
+		if pushErr != nil && unsent[data.ID] {
+			// The local change has not reached the server; the item is
+			// pulled again once it has.
+			continue
+		}
+		open, err := s.storage.GetConflict(data.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get conflict: %w", err)
+		}
+		if open != nil {
+			// The local edit is still unresolved; it is merged with the
+			// newest server copy instead.
+			local, err := s.storage.GetData(data.ID)
+			if err == nil && local != nil {
+				if err := s.reconcile(userID, local, data, open.Reason, bound); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err := s.accept(&data, bound); err != nil {
+			return err
+		}
+		if unsealed {
+			if err := s.reseal(&data); err != nil {
+				return err
+			}
 		}
 	}
-	if err := s.storage.UpdateLastSyncTime(userID, response.LastSyncAt); err != nil {
-		return fmt.Errorf("failed to update last sync time: %w", err)
+	if !state.Resealed {
+		// Every item the server sent has been resealed or queued to be, so
+		// the full pull is not repeated even if this sync fails below.
+		state.Resealed = true
+		if err := s.storage.SaveSyncState(userID, state); err != nil {
+			return fmt.Errorf("failed to save sync state: %w", err)
+		}
+	}
+	if len(rejected) > 0 {
+		// Keep the cursor so the items are fetched and reported again.
+		return &RejectedItemsError{Items: rejected}
+	}
+	if pushErr != nil {
+		// Keep the cursor so the items held back are fetched again.
+		return fmt.Errorf("failed to send queued changes: %w", pushErr)
+	}
+	if err := s.storage.SaveSyncState(userID, &SyncState{Cursor: response.Cursor, LastSyncAt: time.Now(), Resealed: true}); err != nil {
+		return fmt.Errorf("failed to save sync state: %w", err)
 	}
 	return nil
 }
-func (s *SyncServiceImpl) encryptData(data *models.StoredData) error {
-	encrypted, err := s.encryptor.Encrypt(data.Data)
+// pendingChanges picks the items to send from those marked dirty. Items
+// with an open conflict wait until it is resolved, and items still equal to
+// their sync base are left out; the rest become the version after their
+// base, which the server only accepts on top of that base.
+func (s *SyncServiceImpl) pendingChanges(changed []models.StoredData) ([]models.StoredData, error) {
+	pending := make([]models.StoredData, 0, len(changed))
+	for _, data := range changed {
+		open, err := s.storage.GetConflict(data.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get conflict: %w", err)
+		}
+		if open != nil {
+			continue
+		}
+		base, err := s.storage.GetSyncBase(data.ID)
+		if err != nil {
+			return nil, err
+		}
+		if base != nil {
+			if sameContent(base, &data) {
+				continue
+			}
+			data.Version = base.Version + 1
+			data.BaseVersion = base.Version
+		}
+		pending = append(pending, data)
+	}
+	return pending, nil
+}
+// accept stores a copy both sides now agree on as the local copy and the
+// sync base of the item. bound tells whether the server's blob was bound.
+func (s *SyncServiceImpl) accept(data *models.StoredData, bound bool) error {
+	data.BaseVersion = 0
+	if err := s.storage.SaveData(data); err != nil {
+		return fmt.Errorf("failed to save server data: %w", err)
+	}
+	if err := s.storage.SaveSyncBase(data, bound); err != nil {
+		return err
+	}
+	return s.storage.DeleteConflict(data.ID)
+}
+// reseal sends an item the server still keeps with a readable title and
+// metadata back as its next version, sealed. If the item moved on meanwhile
+// the newer copy is checked when it is pulled.
+func (s *SyncServiceImpl) reseal(data *models.StoredData) error {
+	resealed := *data
+	err := pushResolved(s.storage, s.httpClient, s.encryptor, s.authService, &resealed, data.Version)
+	if errors.Is(err, models.ErrVersionConflict) {
+		logger.Warn("Item %s changed on the server before it could be sealed", data.ID)
+		return nil
+	}
+	if errors.Is(err, ErrQueued) {
+		logger.Info("Sealing of item %s is queued", data.ID)
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to encrypt data: %w", err)
+		return fmt.Errorf("failed to seal item %s: %w", data.ID, err)
 	}
-	data.Data = encrypted
+	logger.Info("Sealed item %s on the server", data.ID)
 	return nil
 }
-func (s *SyncServiceImpl) decryptData(data *models.StoredData) error {
-	decrypted, err := s.encryptor.Decrypt(data.Data)
+// recordConflict handles a local change the server refused and returns why
+// the server copy was rejected, if it was.
+func (s *SyncServiceImpl) recordConflict(userID string, conflict *models.Conflict, resealed bool) (string, error) {
+	server := conflict.ServerData
+	if server.ID == "" {
+		logger.Warn("Item %s was not synced: %s", conflict.LocalData.ID, conflict.Reason)
+		return "", nil
+	}
+	bound := crypto.IsBound(server.Data)
+	if reason := s.openBoundData(userID, &server, resealed); reason != "" {
+		return reason, nil
+	}
+	local, err := s.storage.GetData(server.ID)
+	if err != nil || local == nil {
+		return "", s.accept(&server, bound)
+	}
+	return "", s.reconcile(userID, local, server, conflict.Reason, bound)
+}
+// reconcile settles a local change the server copy has moved past. Equal
+// copies need nothing, edits to different fields are merged and pushed, and
+// only fields changed on both sides leave a conflict for the user.
+func (s *SyncServiceImpl) reconcile(userID string, local *models.StoredData, server models.StoredData, reason string, bound bool) error {
+	if sameContent(local, &server) {
+		return s.accept(&server, bound)
+	}
+	base, err := s.storage.GetSyncBase(server.ID)
+	if err != nil {
+		return err
+	}
+	merged, mergeErr := mergeItems(base, local, &server, nil)
+	if mergeErr == nil {
+		err := pushResolved(s.storage, s.httpClient, s.encryptor, s.authService, merged, server.Version)
+		if err == nil || errors.Is(err, ErrQueued) {
+			logger.Info("Merged local and server changes of item %s", server.ID)
+			return s.storage.DeleteConflict(server.ID)
+		}
+		if !errors.Is(err, models.ErrVersionConflict) {
+			return err
+		}
+		mergeErr = err
+	}
+	logger.Warn("Item %s conflicts with the server: %s (%v)", server.ID, reason, mergeErr)
+	return s.storage.SaveConflict(userID, &ItemConflict{
+		ID:         server.ID,
+		Reason:     reason,
+		Server:     server,
+		DetectedAt: time.Now(),
+	})
+}
+// openServerData decrypts a blob sent by the server and returns why it was
+// rejected, or "" when it is bound to this item and not older than the
+// local copy.
+func (s *SyncServiceImpl) openServerData(userID string, data *models.StoredData, resealed bool) string {
+	local, err := s.storage.GetData(data.ID)
+	if err == nil && local != nil && local.Version > data.Version {
+		return fmt.Sprintf("version %d is older than local version %d", data.Version, local.Version)
+	}
+	return s.openBoundData(userID, data, resealed)
+}
+// openBoundData decrypts a blob sent by the server and returns why it was
+// rejected, or "" when it is bound to this item. Unbound blobs are only
+// accepted during the first resealing pull, and only for items never seen
+// bound: afterwards the server has no reason to send one.
+func (s *SyncServiceImpl) openBoundData(userID string, data *models.StoredData, resealed bool) string {
+	legacyOK := !resealed
+	if legacyOK {
+		bound, err := s.storage.SyncBaseBound(data.ID)
+		if err != nil {
+			return err.Error()
+		}
+		legacyOK = !bound
+	}
+	if legacyOK && !crypto.IsBound(data.Data) {
+		logger.Warn("Item %s has no identity binding, accepting legacy ciphertext", data.ID)
+	}
+	err := openItem(s.encryptor, userID, data, legacyOK)
+	if errors.Is(err, crypto.ErrBindingMismatch) {
+		return "ciphertext does not belong to this item or version"
+	}
 	if err != nil {
-		return fmt.Errorf("failed to decrypt data: %w", err)
+		return err.Error()
 	}
-	data.Data = decrypted
-	return nil
+	return ""
 }
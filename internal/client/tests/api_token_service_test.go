@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"testing"
+
+	"gophkeeper/internal/client"
+	"gophkeeper/internal/client/tests/mocks"
+	"gophkeeper/internal/models"
+)
+
+func TestAPITokenService_CreateListRevoke(t *testing.T) {
+	httpClient := &mocks.MockHTTPClient{}
+	auth := &mocks.MockAuthService{Authenticated: true, UserID: "user-123", Token: "mock-token"}
+	service := client.NewAPITokenService(httpClient, auth)
+	response, err := service.Create(&models.APITokenRequest{Name: "ci", ReadOnly: true, ItemIDs: []string{"item-1"}})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if response.Token == "" || response.APIToken.ID != "token-1" || !response.APIToken.ReadOnly {
+		t.Fatalf("Unexpected token: %+v", response)
+	}
+	if err := service.Revoke("token-1"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	tokens, err := service.List()
+	if err != nil || len(tokens) != 1 || tokens[0].RevokedAt == nil {
+		t.Fatalf("Expected one revoked token, got %+v (%v)", tokens, err)
+	}
+	if err := service.Revoke("token-2"); err == nil {
+		t.Error("Expected error for an unknown token")
+	}
+	if _, err := service.Create(&models.APITokenRequest{}); err == nil {
+		t.Error("Expected error for a token without a name")
+	}
+}
+
+func TestAPITokenService_RequiresSession(t *testing.T) {
+	service := client.NewAPITokenService(&mocks.MockHTTPClient{}, &mocks.MockAuthService{})
+	if _, err := service.Create(&models.APITokenRequest{Name: "ci"}); err == nil {
+		t.Error("Expected error when not authenticated")
+	}
+	if _, err := service.List(); err == nil {
+		t.Error("Expected error when not authenticated")
+	}
+}
+
+func TestAuthService_UseAPIToken(t *testing.T) {
+	httpClient := &mocks.MockHTTPClient{}
+	tokens := &mocks.MockTokenManager{}
+	tokens.SaveToken("saved-session")
+	auth := client.NewAuthService(httpClient, tokens)
+	auth.UseAPIToken("gk_secret", "user-123", "ci")
+	if auth.GetToken() != "gk_secret" || auth.GetUserID() != "user-123" {
+		t.Fatalf("Expected the api token to be used, got %q for %q", auth.GetToken(), auth.GetUserID())
+	}
+	if _, err := auth.RefreshToken(); err == nil {
+		t.Error("Expected an api token not to be refreshed")
+	}
+	if err := auth.Logout(); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if httpClient.LoggedOut || auth.IsAuthenticated() {
+		t.Error("Expected logout to only forget the api token")
+	}
+	if saved, _ := tokens.LoadToken(); saved != "saved-session" {
+		t.Errorf("Expected the saved session to be kept, got %q", saved)
+	}
+}
@@ -3,12 +3,13 @@ import (
 	"testing"
 	"gophkeeper/internal/client"
 	"gophkeeper/internal/client/tests/mocks"
+	"gophkeeper/internal/models"
 )
 func TestAuthService_Register(t *testing.T) {
 	mockHTTP := &mocks.MockHTTPClient{}
 	mockToken := &mocks.MockTokenManager{}
 	authService := client.NewAuthService(mockHTTP, mockToken)
-	response, err := authService.Register("testuser", "test@example.com", "password123")
+	response, err := authService.Register("testuser", "test@example.com", "password123", nil)
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
@@ -55,3 +56,148 @@ func TestAuthService_Logout(t *testing.T) {
 		t.Errorf("Expected empty token after logout, got %s", savedToken)
 	}
 }
+func TestAuthService_RefreshToken(t *testing.T) {
+	mockHTTP := &mocks.MockHTTPClient{}
+	mockToken := &mocks.MockTokenManager{}
+	authService := client.NewAuthService(mockHTTP, mockToken)
+	_, _ = authService.Login("testuser", "password123")
+	token, err := authService.RefreshToken()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if token != "mock-token-refreshed" || authService.GetToken() != token {
+		t.Errorf("Expected refreshed token, got %s", token)
+	}
+	savedRefresh, _ := mockToken.LoadRefreshToken()
+	if savedRefresh != "mock-refresh-token-2" {
+		t.Errorf("Expected rotated refresh token to be saved, got %s", savedRefresh)
+	}
+}
+func TestAuthService_LogoutRevokesSession(t *testing.T) {
+	mockHTTP := &mocks.MockHTTPClient{}
+	mockToken := &mocks.MockTokenManager{}
+	authService := client.NewAuthService(mockHTTP, mockToken)
+	_, _ = authService.Login("testuser", "password123")
+	if err := authService.Logout(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !mockHTTP.LoggedOut {
+		t.Error("Expected logout to revoke the session on the server")
+	}
+	savedRefresh, _ := mockToken.LoadRefreshToken()
+	if savedRefresh != "" {
+		t.Errorf("Expected refresh token to be cleared, got %s", savedRefresh)
+	}
+}
+func TestAuthService_LoginWithSecondFactor(t *testing.T) {
+	mockHTTP := &mocks.MockHTTPClient{MFARequired: true}
+	mockToken := &mocks.MockTokenManager{}
+	authService := client.NewAuthService(mockHTTP, mockToken)
+	response, err := authService.Login("testuser", "password123")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !response.MFARequired || authService.IsAuthenticated() {
+		t.Fatal("Expected a second factor challenge without tokens")
+	}
+	if _, err := authService.LoginMFA(response.MFAToken, "000000"); err == nil {
+		t.Fatal("Expected wrong code to be rejected")
+	}
+	if _, err := authService.LoginMFA(response.MFAToken, " 123456 "); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !authService.IsAuthenticated() {
+		t.Error("Expected user to be authenticated after the second factor")
+	}
+	savedToken, _ := mockToken.LoadToken()
+	if savedToken != "mock-token" {
+		t.Errorf("Expected saved token 'mock-token', got %s", savedToken)
+	}
+}
+func TestAuthService_RecoveryCodeIsSentAsRecoveryCode(t *testing.T) {
+	mockHTTP := &mocks.MockHTTPClient{MFARequired: true}
+	authService := client.NewAuthService(mockHTTP, &mocks.MockTokenManager{})
+	if _, err := authService.LoginMFA("mock-mfa-token", "abcde-12345"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if mockHTTP.LastFactor != (models.TOTPCodeRequest{RecoveryCode: "abcde-12345"}) {
+		t.Errorf("Expected recovery code to be sent as such, got %+v", mockHTTP.LastFactor)
+	}
+}
+func TestAuthService_RegisterAndLoginWithSRP(t *testing.T) {
+	mockHTTP := &mocks.MockHTTPClient{}
+	mockToken := &mocks.MockTokenManager{}
+	authService := client.NewAuthService(mockHTTP, mockToken)
+	if _, err := authService.Register("srpuser", "srp@example.com", "password123", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := authService.Login("srpuser", "wrong-password"); err == nil {
+		t.Fatal("Expected wrong password to be rejected")
+	}
+	response, err := authService.Login("srpuser", "password123")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if response.Token != "mock-token" || !authService.IsAuthenticated() {
+		t.Error("Expected user to be authenticated after the srp handshake")
+	}
+	if mockHTTP.PasswordSent {
+		t.Error("Expected the password never to be sent to the server")
+	}
+}
+func TestAuthService_LegacyAccountFallsBackToPassword(t *testing.T) {
+	mockHTTP := &mocks.MockHTTPClient{}
+	authService := client.NewAuthService(mockHTTP, &mocks.MockTokenManager{})
+	if _, err := authService.Login("olduser", "password123"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !mockHTTP.PasswordSent {
+		t.Error("Expected accounts without a verifier to use the password login")
+	}
+}
+func TestAuthService_ChangePassword(t *testing.T) {
+	mockHTTP := &mocks.MockHTTPClient{}
+	authService := client.NewAuthService(mockHTTP, &mocks.MockTokenManager{})
+	if _, err := authService.Register("srpuser", "srp@example.com", "password123", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := authService.ChangePassword("wrong-password", "new-password"); err == nil {
+		t.Fatal("Expected a wrong current password to be rejected")
+	}
+	if err := authService.ChangePassword("password123", "new-password"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := authService.Login("srpuser", "password123"); err == nil {
+		t.Error("Expected the old password to stop working")
+	}
+	if _, err := authService.Login("srpuser", "new-password"); err != nil {
+		t.Errorf("Expected the new password to work, got %v", err)
+	}
+	if mockHTTP.PasswordSent {
+		t.Error("Expected the password never to be sent to the server")
+	}
+}
+func TestAuthService_DeleteAccount(t *testing.T) {
+	mockHTTP := &mocks.MockHTTPClient{}
+	mockToken := &mocks.MockTokenManager{}
+	authService := client.NewAuthService(mockHTTP, mockToken)
+	if _, err := authService.Register("srpuser", "srp@example.com", "password123", nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := authService.DeleteAccount("wrong-password"); err == nil || mockHTTP.Deleted {
+		t.Fatal("Expected a wrong password to be rejected")
+	}
+	response, err := authService.DeleteAccount("password123")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if response.Signature == "" || response.Receipt.Username != "srpuser" {
+		t.Errorf("Expected a signed receipt, got %+v", response)
+	}
+	if authService.IsAuthenticated() {
+		t.Error("Expected the session to be dropped after deletion")
+	}
+	if saved, _ := mockToken.LoadToken(); saved != "" {
+		t.Error("Expected the stored token to be cleared")
+	}
+}
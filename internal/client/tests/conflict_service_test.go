@@ -0,0 +1,153 @@
+package tests
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+	"gophkeeper/internal/client"
+	"gophkeeper/internal/client/tests/mocks"
+	"gophkeeper/internal/models"
+)
+func newConflictService(t *testing.T, local, server models.LoginPasswordData) (*client.ConflictServiceImpl, *mocks.MockStorage, *mocks.MockHTTPClient) {
+	t.Helper()
+	encode := func(data models.LoginPasswordData) []byte {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		return encoded
+	}
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeLoginPassword, Title: "mail", Data: encode(local), Version: 1, UpdatedAt: time.Now()})
+	mockStorage.SaveConflict("user-123", &client.ItemConflict{
+		ID:         "item-1",
+		Reason:     "Server has newer version",
+		Server:     models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeLoginPassword, Title: "mail", Data: encode(server), Version: 4},
+		DetectedAt: time.Now(),
+	})
+	mockHTTP := &mocks.MockHTTPClient{}
+	mockAuth := &mocks.MockAuthService{Authenticated: true, UserID: "user-123", Token: "mock-token"}
+	return client.NewConflictService(mockStorage, mockHTTP, &mocks.MockEncryptor{}, mockAuth), mockStorage, mockHTTP
+}
+func TestConflictService_Fields(t *testing.T) {
+	service, _, _ := newConflictService(t,
+		models.LoginPasswordData{Login: "alice", Password: "old"},
+		models.LoginPasswordData{Login: "alice", Password: "new"})
+	conflicts, err := service.List()
+	if err != nil || len(conflicts) != 1 {
+		t.Fatalf("Expected one conflict, got %d (%v)", len(conflicts), err)
+	}
+	var differing []string
+	for _, field := range conflicts[0].Fields() {
+		if field.Differs() {
+			differing = append(differing, field.Name)
+		}
+	}
+	if len(differing) != 1 || differing[0] != "password" {
+		t.Errorf("Expected only the password to differ, got %v", differing)
+	}
+}
+func TestConflictService_ResolveLocal(t *testing.T) {
+	service, mockStorage, mockHTTP := newConflictService(t,
+		models.LoginPasswordData{Login: "alice", Password: "old"},
+		models.LoginPasswordData{Login: "alice", Password: "new"})
+	result, err := service.Resolve("item-1", client.KeepLocal, nil)
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if len(mockHTTP.Updated) != 1 || mockHTTP.BaseVersions[0] != 4 || mockHTTP.Updated[0].Version != 5 {
+		t.Fatalf("Expected version 5 to be pushed on top of 4, got %+v %v", mockHTTP.Updated, mockHTTP.BaseVersions)
+	}
+	if mockHTTP.Updated[0].Type != models.DataTypeSealed {
+		t.Error("Expected the pushed copy to be sealed")
+	}
+	var login models.LoginPasswordData
+	json.Unmarshal(result.Data, &login)
+	if login.Password != "old" || result.Version != 5 {
+		t.Errorf("Expected the local copy at version 5, got %+v", login)
+	}
+	if open, _ := mockStorage.GetConflict("item-1"); open != nil {
+		t.Error("Expected the conflict to be cleared")
+	}
+}
+func TestConflictService_ResolveServer(t *testing.T) {
+	service, mockStorage, mockHTTP := newConflictService(t,
+		models.LoginPasswordData{Login: "alice", Password: "old"},
+		models.LoginPasswordData{Login: "alice", Password: "new"})
+	if _, err := service.Resolve("item-1", client.KeepServer, nil); err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if len(mockHTTP.Updated) != 0 {
+		t.Error("Expected nothing to be pushed when keeping the server copy")
+	}
+	local, _ := mockStorage.GetData("item-1")
+	var login models.LoginPasswordData
+	json.Unmarshal(local.Data, &login)
+	if login.Password != "new" || local.Version != 4 {
+		t.Errorf("Expected the server copy locally, got %+v at version %d", login, local.Version)
+	}
+}
+func TestConflictService_ResolveMerge(t *testing.T) {
+	service, _, mockHTTP := newConflictService(t,
+		models.LoginPasswordData{Login: "alice", Password: "old", Website: "mail.example.com"},
+		models.LoginPasswordData{Login: "alice2", Password: "new"})
+	var asked []string
+	choose := func(field client.ConflictField) (string, error) {
+		asked = append(asked, field.Name)
+		if field.Name == "login" {
+			return field.Server, nil
+		}
+		return field.Local, nil
+	}
+	result, err := service.Resolve("item-1", client.KeepMerge, choose)
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if len(asked) != 3 {
+		t.Errorf("Expected to be asked about login, password and website only, got %v", asked)
+	}
+	var login models.LoginPasswordData
+	json.Unmarshal(result.Data, &login)
+	if login.Login != "alice2" || login.Password != "old" || login.Website != "mail.example.com" {
+		t.Errorf("Unexpected merge result: %+v", login)
+	}
+	if len(mockHTTP.BaseVersions) != 1 || mockHTTP.BaseVersions[0] != 4 {
+		t.Errorf("Expected the merge to be pushed on top of version 4, got %v", mockHTTP.BaseVersions)
+	}
+}
+func TestConflictService_ResolveStaleBase(t *testing.T) {
+	service, mockStorage, mockHTTP := newConflictService(t,
+		models.LoginPasswordData{Login: "alice", Password: "old"},
+		models.LoginPasswordData{Login: "alice", Password: "new"})
+	mockHTTP.UpdateFail = models.ErrVersionConflict
+	_, err := service.Resolve("item-1", client.KeepLocal, nil)
+	if !errors.Is(err, models.ErrVersionConflict) {
+		t.Fatalf("Expected ErrVersionConflict, got %v", err)
+	}
+	if open, _ := mockStorage.GetConflict("item-1"); open == nil {
+		t.Error("Expected the conflict to stay open")
+	}
+}
+func TestConflictService_ResolveQueued(t *testing.T) {
+	service, mockStorage, mockHTTP := newConflictService(t,
+		models.LoginPasswordData{Login: "alice", Password: "old"},
+		models.LoginPasswordData{Login: "alice", Password: "new"})
+	mockHTTP.Unreachable = errors.New("connection refused")
+	result, err := service.Resolve("item-1", client.KeepLocal, nil)
+	if !errors.Is(err, client.ErrQueued) || result == nil || result.Version != 5 {
+		t.Fatalf("Expected version 5 to be queued, got %+v (%v)", result, err)
+	}
+	if len(mockStorage.Outbox) != 1 || mockStorage.Outbox[0].Op != client.OutboxUpdate || mockStorage.Outbox[0].BaseVersion != 4 {
+		t.Fatalf("Expected an update on top of version 4 in the queue, got %+v", mockStorage.Outbox)
+	}
+	if open, _ := mockStorage.GetConflict("item-1"); open != nil {
+		t.Error("Expected the conflict to be cleared once the resolution is queued")
+	}
+	mockHTTP.Unreachable = nil
+	if err := client.NewSyncService(mockStorage, mockHTTP, &mocks.MockEncryptor{}, &mocks.MockAuthService{Authenticated: true, UserID: "user-123", Token: "mock-token"}).SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mockHTTP.Updated) != 1 || mockHTTP.BaseVersions[0] != 4 || len(mockHTTP.SyncedData) != 0 {
+		t.Errorf("Expected the queued update sent on top of version 4 and nothing else, got %+v", mockHTTP.Updated)
+	}
+}
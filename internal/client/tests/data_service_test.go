@@ -1,8 +1,11 @@
 package tests
 import (
+	"errors"
+	"net/http"
 	"testing"
 	"gophkeeper/internal/client"
 	"gophkeeper/internal/client/tests/mocks"
+	"gophkeeper/internal/models"
 )
 func TestDataService_AddData(t *testing.T) {
 	mockStorage := mocks.NewMockStorage()
@@ -82,3 +85,88 @@ func TestDataService_DeleteData(t *testing.T) {
 		t.Errorf("Expected 0 data items after deletion, got %d", len(dataListAfter))
 	}
 }
+func TestDataService_QueuesWhileOffline(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockHTTP := &mocks.MockHTTPClient{Unreachable: errors.New("connection refused")}
+	mockAuth := &mocks.MockAuthService{
+		Authenticated: true,
+		UserID:        "user-123",
+		Token:         "mock-token",
+	}
+	mockStorage.SaveData(&models.StoredData{ID: "synced-1", UserID: "user-123", Type: models.DataTypeText, Version: 1})
+	dataService := client.NewDataService(mockStorage, mockHTTP, &mocks.MockEncryptor{}, mockAuth)
+	if err := dataService.AddData("text", "Offline note", []string{"Content"}); !errors.Is(err, client.ErrQueued) {
+		t.Fatalf("Expected the write to be queued offline, got %v", err)
+	}
+	if err := dataService.DeleteData("synced-1"); !errors.Is(err, client.ErrQueued) {
+		t.Fatalf("Expected the delete to be queued offline, got %v", err)
+	}
+	if len(mockStorage.Outbox) != 2 || mockStorage.Outbox[0].Op != client.OutboxAdd || mockStorage.Outbox[1].Op != client.OutboxDelete {
+		t.Fatalf("Expected add and delete queued in order, got %+v", mockStorage.Outbox)
+	}
+	if mockStorage.Outbox[0].Attempts != 0 {
+		t.Errorf("Expected an unreachable server not to count as a failed attempt, got %d", mockStorage.Outbox[0].Attempts)
+	}
+	mockHTTP.Unreachable = nil
+	if err := dataService.AddData("text", "Online note", []string{"Content"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mockStorage.Outbox) != 0 {
+		t.Errorf("Expected the queue to drain once online, got %d", len(mockStorage.Outbox))
+	}
+	if len(mockHTTP.Added) != 2 || len(mockHTTP.DeletedIDs) != 1 || mockHTTP.Keys[1] == "" || mockHTTP.Keys[0] == mockHTTP.Keys[1] {
+		t.Errorf("Expected each write sent once with its own key, got keys %v", mockHTTP.Keys)
+	}
+}
+func TestDataService_RetriesFailingWrites(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockHTTP := &mocks.MockHTTPClient{Unreachable: &client.RequestError{StatusCode: http.StatusInternalServerError}}
+	mockAuth := &mocks.MockAuthService{
+		Authenticated: true,
+		UserID:        "user-123",
+		Token:         "mock-token",
+	}
+	dataService := client.NewDataService(mockStorage, mockHTTP, &mocks.MockEncryptor{}, mockAuth)
+	for i := 0; i < 10; i++ {
+		if err := dataService.AddData("text", "Waiting note", []string{"Content"}); !errors.Is(err, client.ErrQueued) {
+			t.Fatalf("Expected the write to be queued while the server fails, got %v", err)
+		}
+	}
+	if len(mockStorage.Outbox) != 10 || mockStorage.Outbox[0].Attempts != 10 || len(mockStorage.DeadLetters) != 0 {
+		t.Fatalf("Expected the head of the queue to be retried without giving up, got %+v", mockStorage.Outbox)
+	}
+	mockHTTP.Unreachable = nil
+	if err := dataService.AddData("text", "Online note", []string{"Content"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mockStorage.Outbox) != 0 || len(mockHTTP.Added) != 11 {
+		t.Errorf("Expected the queue to drain once the server recovers, got %d queued and %d sent", len(mockStorage.Outbox), len(mockHTTP.Added))
+	}
+}
+func TestDataService_DeadLettersRejectedWrites(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockHTTP := &mocks.MockHTTPClient{Unreachable: &client.RequestError{StatusCode: http.StatusBadRequest}}
+	mockAuth := &mocks.MockAuthService{
+		Authenticated: true,
+		UserID:        "user-123",
+		Token:         "mock-token",
+	}
+	dataService := client.NewDataService(mockStorage, mockHTTP, &mocks.MockEncryptor{}, mockAuth)
+	if err := dataService.AddData("text", "Rejected note", []string{"Content"}); !errors.Is(err, client.ErrQueued) {
+		t.Fatalf("Expected the write to be left for the next sync, got %v", err)
+	}
+	if len(mockStorage.Outbox) != 0 || len(mockStorage.DeadLetters) != 1 || mockStorage.DeadLetters[0].LastError == "" {
+		t.Fatalf("Expected the write to be dead-lettered with its error, got %+v", mockStorage.DeadLetters)
+	}
+	itemID := mockStorage.DeadLetters[0].ItemID
+	if !mockStorage.Dirty[itemID] {
+		t.Error("Expected the dead-lettered item to stay marked for the next sync")
+	}
+	stored, _ := mockStorage.GetData(itemID)
+	if err := mockStorage.SaveSyncBase(stored, true); err != nil {
+		t.Fatalf("SaveSyncBase failed: %v", err)
+	}
+	if len(mockStorage.DeadLetters) != 0 {
+		t.Errorf("Expected the dead letter to clear once the item syncs, got %+v", mockStorage.DeadLetters)
+	}
+}
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"gophkeeper/internal/client"
+	"gophkeeper/internal/client/tests/mocks"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/models"
+)
+
+func TestDeviceService_RegisterAndResume(t *testing.T) {
+	storage := mocks.NewMockStorage()
+	httpClient := &mocks.MockHTTPClient{}
+	auth := &mocks.MockAuthService{Authenticated: true, UserID: "user-123", Token: "mock-token",
+		Claims: &crypto.JWTClaims{UserID: "user-123", SessionID: "family-1"}}
+	service := client.NewDeviceService(storage, httpClient, auth, "laptop", "linux/amd64")
+	if err := service.Register(); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	first := httpClient.LastDevice
+	if first.DeviceID != "" || !crypto.VerifyDeviceProof(first.PublicKey, "", "family-1", first.Signature) {
+		t.Fatal("Expected a new device to sign its proof without an ID")
+	}
+	identity, _ := storage.GetDeviceIdentity("user-123")
+	if identity == nil || identity.DeviceID != "device-1" {
+		t.Fatalf("Expected the device ID to be stored, got %+v", identity)
+	}
+	if auth.Refreshed != 1 {
+		t.Errorf("Expected the token to be refreshed to carry the device, got %d refreshes", auth.Refreshed)
+	}
+	auth.Claims = &crypto.JWTClaims{UserID: "user-123", SessionID: "family-2", DeviceID: "device-1"}
+	if err := service.Register(); err != nil {
+		t.Fatalf("Second Register failed: %v", err)
+	}
+	resumed := httpClient.LastDevice
+	if resumed.DeviceID != "device-1" || !bytes.Equal(resumed.PublicKey, first.PublicKey) ||
+		!crypto.VerifyDeviceProof(resumed.PublicKey, "device-1", "family-2", resumed.Signature) {
+		t.Error("Expected the known device to resume with its key")
+	}
+	if len(httpClient.Devices) != 1 || auth.Refreshed != 1 {
+		t.Errorf("Expected no new device and no refresh, got %d devices, %d refreshes", len(httpClient.Devices), auth.Refreshed)
+	}
+}
+func TestDeviceService_RevokedDevice(t *testing.T) {
+	storage := mocks.NewMockStorage()
+	httpClient := &mocks.MockHTTPClient{}
+	auth := &mocks.MockAuthService{Authenticated: true, UserID: "user-123", Token: "mock-token",
+		Claims: &crypto.JWTClaims{UserID: "user-123", SessionID: "family-1"}}
+	service := client.NewDeviceService(storage, httpClient, auth, "ci-runner", "linux/arm64")
+	if err := service.Register(); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := service.Revoke("device-1"); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if err := service.Register(); !errors.Is(err, models.ErrDeviceRevoked) {
+		t.Errorf("Expected a revoked device to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gophkeeper/internal/client"
+	"gophkeeper/internal/models"
+)
+
+func TestHTTPClient_RefreshesOnUnauthorized(t *testing.T) {
+	var seen, keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if r.Header.Get("Authorization") != "Bearer fresh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"success":false,"error":"Unauthorized"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"data":{"message":"ok"}}`))
+	}))
+	defer srv.Close()
+	httpClient := client.NewHTTPClient(srv.URL)
+	refreshed := 0
+	httpClient.SetTokenRefresher(func() (string, error) {
+		refreshed++
+		return "fresh-token", nil
+	})
+	if err := httpClient.DeleteData("item-1", "key-1", "stale-token"); err != nil {
+		t.Fatalf("Expected retry with refreshed token to succeed, got %v", err)
+	}
+	if refreshed != 1 {
+		t.Errorf("Expected one refresh, got %d", refreshed)
+	}
+	if len(seen) != 2 || seen[0] != "Bearer stale-token" {
+		t.Errorf("Unexpected requests: %v", seen)
+	}
+	if len(keys) != 2 || keys[0] != "key-1" || keys[1] != "key-1" {
+		t.Errorf("Expected the retry to keep the idempotency key, got %v", keys)
+	}
+}
+func TestHTTPClient_NoRefreshForAnonymousRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"success":false,"error":"invalid credentials"}`))
+	}))
+	defer srv.Close()
+	httpClient := client.NewHTTPClient(srv.URL)
+	httpClient.SetTokenRefresher(func() (string, error) {
+		t.Fatal("Refresher must not be called without an access token")
+		return "", nil
+	})
+	if _, err := httpClient.RefreshToken("bad"); err == nil {
+		t.Error("Expected error for rejected refresh token")
+	}
+}
+func TestHTTPClient_DeviceRevoked(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"success":false,"error":"device revoked"}`))
+	}))
+	defer srv.Close()
+	httpClient := client.NewHTTPClient(srv.URL)
+	wiped := 0
+	httpClient.SetDeviceRevokedHandler(func() { wiped++ })
+	if err := httpClient.DeleteData("item-1", "", "token"); !errors.Is(err, models.ErrDeviceRevoked) {
+		t.Errorf("Expected ErrDeviceRevoked, got %v", err)
+	}
+	if wiped != 1 {
+		t.Errorf("Expected the revoked handler to run once, got %d", wiped)
+	}
+}
@@ -25,7 +25,7 @@ func TestClient_Integration(t *testing.T) {
 	dataService := client.NewDataService(storage, mockHTTP, mockEncryptor, authService)
 	syncService := client.NewSyncService(storage, mockHTTP, mockEncryptor, authService)
 	t.Run("Authentication", func(t *testing.T) {
-		_, err := authService.Register("testuser", "test@example.com", "password123")
+		_, err := authService.Register("testuser", "test@example.com", "password123", nil)
 		if err != nil {
 			t.Fatalf("Registration failed: %v", err)
 		}
@@ -109,7 +109,7 @@ func TestClient_FullIntegration(t *testing.T) {
 	dataService := client.NewDataService(storage, mockHTTP, mockEncryptor, authService)
 	syncService := client.NewSyncService(storage, mockHTTP, mockEncryptor, authService)
 	t.Run("FullWorkflow", func(t *testing.T) {
-		_, err := authService.Register("fulltest", "full@test.com", "password123")
+		_, err := authService.Register("fulltest", "full@test.com", "password123", nil)
 		if err != nil {
 			t.Fatalf("Registration failed: %v", err)
 		}
@@ -175,7 +175,7 @@ func TestClient_ErrorHandling(t *testing.T) {
 	dataService := client.NewDataService(storage, mockHTTP, mockEncryptor, authService)
 	syncService := client.NewSyncService(storage, mockHTTP, mockEncryptor, authService)
 	t.Run("AuthenticationErrors", func(t *testing.T) {
-		_, err := authService.Register("testuser", "test@example.com", "password123")
+		_, err := authService.Register("testuser", "test@example.com", "password123", nil)
 		if err == nil {
 			t.Error("Expected registration to fail with failing HTTP client")
 		}
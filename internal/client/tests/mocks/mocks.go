@@ -1,18 +1,53 @@
 package mocks
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
+	"gophkeeper/internal/client"
+	"gophkeeper/internal/crypto"
 	"gophkeeper/internal/models"
 )
 type MockStorage struct {
-	data map[string]*models.StoredData
+	data    map[string]*models.StoredData
+	kdf     map[string]*models.KDFParams
+	rekey   map[string]*models.KDFParams
+	devices map[string]*client.DeviceIdentity
+	sync    map[string]client.SyncState
+	// Conflicts holds the open conflicts by item ID.
+	Conflicts map[string]client.ItemConflict
+	// Bases holds the last synced copy of each item.
+	Bases map[string]models.StoredData
+	// BoundBases holds the IDs of items whose synced copy was bound.
+	BoundBases map[string]bool
+	// Outbox holds the queued writes in order, DeadLetters those the server
+	// rejected.
+	Outbox      []client.OutboxEntry
+	DeadLetters []client.OutboxEntry
+	nextSeq     int64
+	// Dirty holds the IDs of items saved since their last sync base.
+	Dirty map[string]bool
 }
 func NewMockStorage() *MockStorage {
 	return &MockStorage{
-		data: make(map[string]*models.StoredData),
+		data:       make(map[string]*models.StoredData),
+		kdf:        make(map[string]*models.KDFParams),
+		rekey:      make(map[string]*models.KDFParams),
+		devices:    make(map[string]*client.DeviceIdentity),
+		sync:       make(map[string]client.SyncState),
+		Conflicts:  make(map[string]client.ItemConflict),
+		Bases:      make(map[string]models.StoredData),
+		BoundBases: make(map[string]bool),
+		Dirty:      make(map[string]bool),
 	}
 }
 func (m *MockStorage) SaveData(data *models.StoredData) error {
 	m.data[data.ID] = data
+	m.Dirty[data.ID] = true
 	return nil
 }
 func (m *MockStorage) GetData(id string) (*models.StoredData, error) {
@@ -39,6 +74,28 @@ func (m *MockStorage) GetDataSince(userID string, since time.Time) ([]models.Sto
 	}
 	return result, nil
 }
+func (m *MockStorage) GetDirtyData(userID string) ([]models.StoredData, error) {
+	var result []models.StoredData
+	for _, data := range m.data {
+		if data.UserID == userID && m.Dirty[data.ID] {
+			result = append(result, *data)
+		}
+	}
+	return result, nil
+}
+func (m *MockStorage) CountUnsent(userID string) (int, error) {
+	queued := make(map[string]bool)
+	for _, entry := range m.Outbox {
+		queued[entry.ItemID] = true
+	}
+	count := 0
+	for _, data := range m.data {
+		if data.UserID == userID && (m.Dirty[data.ID] || queued[data.ID]) {
+			count++
+		}
+	}
+	return count, nil
+}
 func (m *MockStorage) DeleteData(id string) error {
 	delete(m.data, id)
 	return nil
@@ -46,24 +103,218 @@ func (m *MockStorage) DeleteData(id string) error {
 func (m *MockStorage) GetDataHistory(id string) ([]models.DataHistory, error) {
 	return []models.DataHistory{}, nil
 }
-func (m *MockStorage) GetLastSyncTime(userID string) (time.Time, error) {
-	return time.Time{}, nil
+func (m *MockStorage) GetSyncState(userID string) (*client.SyncState, error) {
+	state := m.sync[userID]
+	return &state, nil
+}
+func (m *MockStorage) SaveSyncState(userID string, state *client.SyncState) error {
+	m.sync[userID] = *state
+	return nil
+}
+func (m *MockStorage) SaveConflict(userID string, conflict *client.ItemConflict) error {
+	m.Conflicts[conflict.ID] = *conflict
+	return nil
+}
+func (m *MockStorage) GetConflict(id string) (*client.ItemConflict, error) {
+	if conflict, exists := m.Conflicts[id]; exists {
+		return &conflict, nil
+	}
+	return nil, nil
+}
+func (m *MockStorage) ListConflicts(userID string) ([]client.ItemConflict, error) {
+	var result []client.ItemConflict
+	for _, conflict := range m.Conflicts {
+		result = append(result, conflict)
+	}
+	return result, nil
+}
+func (m *MockStorage) DeleteConflict(id string) error {
+	delete(m.Conflicts, id)
+	return nil
+}
+func (m *MockStorage) SaveSyncBase(data *models.StoredData, bound bool) error {
+	m.Bases[data.ID] = *data
+	if bound {
+		m.BoundBases[data.ID] = true
+	}
+	delete(m.Dirty, data.ID)
+	var letters []client.OutboxEntry
+	for _, entry := range m.DeadLetters {
+		if entry.ItemID != data.ID {
+			letters = append(letters, entry)
+		}
+	}
+	m.DeadLetters = letters
+	return nil
+}
+func (m *MockStorage) SyncBaseBound(id string) (bool, error) {
+	return m.BoundBases[id], nil
+}
+func (m *MockStorage) GetSyncBase(id string) (*models.StoredData, error) {
+	if base, exists := m.Bases[id]; exists {
+		return &base, nil
+	}
+	return nil, nil
+}
+func (m *MockStorage) SaveDataQueued(data *models.StoredData, entry *client.OutboxEntry) error {
+	m.SaveData(data)
+	return m.EnqueueMutation(data.UserID, entry)
+}
+func (m *MockStorage) DeleteDataQueued(userID, id string, entry *client.OutboxEntry) error {
+	m.DeleteData(id)
+	return m.EnqueueMutation(userID, entry)
+}
+// EnqueueMutation queues a write without saving an item.
+func (m *MockStorage) EnqueueMutation(userID string, entry *client.OutboxEntry) error {
+	m.nextSeq++
+	entry.Seq = m.nextSeq
+	m.Outbox = append(m.Outbox, *entry)
+	return nil
 }
-func (m *MockStorage) UpdateLastSyncTime(userID string, t time.Time) error {
+func (m *MockStorage) ListOutbox(userID string) ([]client.OutboxEntry, error) {
+	return append([]client.OutboxEntry{}, m.Outbox...), nil
+}
+func (m *MockStorage) ListDeadLetters(userID string) ([]client.OutboxEntry, error) {
+	return append([]client.OutboxEntry{}, m.DeadLetters...), nil
+}
+func (m *MockStorage) RecordOutboxFailure(seq int64, lastError string) error {
+	for i := range m.Outbox {
+		if m.Outbox[i].Seq == seq {
+			m.Outbox[i].Attempts++
+			m.Outbox[i].LastError = lastError
+		}
+	}
+	return nil
+}
+func (m *MockStorage) DeadLetterOutboxEntry(seq int64, lastError string) error {
+	for i := range m.Outbox {
+		if m.Outbox[i].Seq == seq {
+			m.Outbox[i].Attempts++
+			m.Outbox[i].LastError = lastError
+			deadAt := time.Now()
+			m.Outbox[i].DeadAt = &deadAt
+			m.DeadLetters = append(m.DeadLetters, m.Outbox[i])
+		}
+	}
+	return m.DeleteOutboxEntry(seq)
+}
+func (m *MockStorage) DeleteOutboxEntry(seq int64) error {
+	for i := range m.Outbox {
+		if m.Outbox[i].Seq == seq {
+			m.Outbox = append(m.Outbox[:i], m.Outbox[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+func (m *MockStorage) SaveRekeyState(userID string, params *models.KDFParams) error {
+	m.rekey[userID] = params
+	return nil
+}
+func (m *MockStorage) GetRekeyState(userID string) (*models.KDFParams, error) {
+	return m.rekey[userID], nil
+}
+func (m *MockStorage) Rekey(userID string, to client.Encryptor, params *models.KDFParams) error {
+	m.kdf[userID] = params
+	delete(m.rekey, userID)
+	return nil
+}
+func (m *MockStorage) ResetData(userID string) error {
+	for id, data := range m.data {
+		if data.UserID == userID {
+			delete(m.data, id)
+		}
+	}
+	delete(m.rekey, userID)
+	return nil
+}
+func (m *MockStorage) SaveDeviceIdentity(userID string, identity *client.DeviceIdentity) error {
+	stored := *identity
+	m.devices[userID] = &stored
+	return nil
+}
+func (m *MockStorage) GetDeviceIdentity(userID string) (*client.DeviceIdentity, error) {
+	if identity, exists := m.devices[userID]; exists {
+		stored := *identity
+		return &stored, nil
+	}
+	return nil, nil
+}
+func (m *MockStorage) DeleteDeviceIdentity(userID string) error {
+	delete(m.devices, userID)
 	return nil
 }
 func (m *MockStorage) Close() error {
 	return nil
 }
+func (m *MockStorage) SaveKDFParams(userID string, params *models.KDFParams) error {
+	m.kdf[userID] = params
+	return nil
+}
+func (m *MockStorage) GetKDFParams(userID string) (*models.KDFParams, error) {
+	if params, exists := m.kdf[userID]; exists {
+		return params, nil
+	}
+	return nil, models.ErrKDFNotConfigured
+}
 type MockHTTPClient struct {
-	ShouldFail bool
+	ShouldFail   bool
+	LoggedOut    bool
+	MFARequired  bool
+	LastFactor   models.TOTPCodeRequest
+	PasswordSent bool
+	ServerData   []models.StoredData
+	SyncedData   []models.StoredData
+	SyncCursor   string
+	SentCursor   string
+	SyncKey      string
+	Conflicts    []models.Conflict
+	// SyncFailOnce is returned by the next SyncData, which then applies
+	// the request as the server would have.
+	SyncFailOnce error
+	SyncRequests int
+	// Updated records the items sent with UpdateData and their base
+	// versions; UpdateFail is returned instead when set.
+	Updated      []models.StoredData
+	BaseVersions []int
+	UpdateFail   error
+	// Added and DeletedIDs record the writes sent with AddData and
+	// DeleteData, Keys the idempotency keys of those and of updates;
+	// Unreachable is returned instead when set.
+	Added        []models.StoredData
+	DeletedIDs   []string
+	Keys         []string
+	Unreachable  error
+	RekeyFail    error
+	History      []models.DataHistory
+	LastRekey    *models.RekeyRequest
+	RecoverySlot *models.RecoverySlot
+	Email        string
+	Deleted      bool
+	Devices      []models.Device
+	LastDevice   *models.DeviceRegistrationRequest
+	APITokens    []models.APIToken
+	Providers    []models.OIDCProvider
+	Nonces       []string
+	LastOIDC     *models.OIDCTokenRequest
+	Identities   []models.OIDCIdentity
+	srpUsername  string
+	srpVerifier  *models.SRPVerifier
+	srpServer    *crypto.SRPServer
+	srpClient    []byte
 }
 func (m *MockHTTPClient) Register(req *models.UserRegistrationRequest) (*models.AuthResponse, error) {
 	if m.ShouldFail {
 		return nil, models.ErrUserAlreadyExists
 	}
+	m.PasswordSent = m.PasswordSent || req.Password != ""
+	if req.SRP != nil {
+		m.srpUsername = req.Username
+		m.srpVerifier = req.SRP
+	}
 	return &models.AuthResponse{
-		Token: "mock-token",
+		Token:        "mock-token",
+		RefreshToken: "mock-refresh-token",
 		User: models.User{
 			ID:       "user-123",
 			Username: req.Username,
@@ -75,35 +326,362 @@ func (m *MockHTTPClient) Login(req *models.UserLoginRequest) (*models.AuthRespon
 	if m.ShouldFail {
 		return nil, models.ErrInvalidCredentials
 	}
+	m.PasswordSent = true
+	if m.MFARequired {
+		return &models.AuthResponse{MFARequired: true, MFAToken: "mock-mfa-token"}, nil
+	}
 	return &models.AuthResponse{
-		Token: "mock-token",
+		Token:        "mock-token",
+		RefreshToken: "mock-refresh-token",
 		User: models.User{
 			ID:       "user-123",
 			Username: req.Username,
 		},
 	}, nil
 }
-func (m *MockHTTPClient) AddData(data *models.StoredData, token string) error {
+func (m *MockHTTPClient) AddData(data *models.StoredData, idempotencyKey, token string) error {
 	if m.ShouldFail {
 		return models.ErrUnauthorized
 	}
+	if m.Unreachable != nil {
+		return m.Unreachable
+	}
+	m.Added = append(m.Added, *data)
+	m.Keys = append(m.Keys, idempotencyKey)
 	return nil
 }
-func (m *MockHTTPClient) DeleteData(id, token string) error {
+func (m *MockHTTPClient) DeleteData(id, idempotencyKey, token string) error {
 	if m.ShouldFail {
 		return models.ErrUnauthorized
 	}
+	if m.Unreachable != nil {
+		return m.Unreachable
+	}
+	m.DeletedIDs = append(m.DeletedIDs, id)
+	m.Keys = append(m.Keys, idempotencyKey)
 	return nil
 }
-func (m *MockHTTPClient) SyncData(req *models.DataSyncRequest, token string) (*models.DataSyncResponse, error) {
+func (m *MockHTTPClient) SyncData(req *models.DataSyncRequest, idempotencyKey, token string) (*models.DataSyncResponse, error) {
 	if m.ShouldFail {
 		return nil, models.ErrUnauthorized
 	}
+	m.SyncRequests++
+	if err := m.SyncFailOnce; err != nil {
+		m.SyncFailOnce = nil
+		m.ServerData = append(m.ServerData, req.Data...)
+		return nil, err
+	}
+	m.SyncKey = idempotencyKey
+	m.SyncedData = req.Data
+	m.SentCursor = req.Cursor
 	return &models.DataSyncResponse{
-		Data:       []models.StoredData{},
-		LastSyncAt: time.Now(),
+		Data:      append([]models.StoredData{}, m.ServerData...),
+		Cursor:    m.SyncCursor,
+		Conflicts: m.Conflicts,
+	}, nil
+}
+func (m *MockHTTPClient) UpdateData(data *models.StoredData, baseVersion int, idempotencyKey, token string) error {
+	if m.UpdateFail != nil {
+		return m.UpdateFail
+	}
+	if m.Unreachable != nil {
+		return m.Unreachable
+	}
+	m.Updated = append(m.Updated, *data)
+	m.BaseVersions = append(m.BaseVersions, baseVersion)
+	m.Keys = append(m.Keys, idempotencyKey)
+	return nil
+}
+func (m *MockHTTPClient) SetKDFParams(params *models.KDFParams, token string) error {
+	if m.ShouldFail {
+		return models.ErrUnauthorized
+	}
+	return nil
+}
+func (m *MockHTTPClient) GetHistory(token string) ([]models.DataHistory, error) {
+	return append([]models.DataHistory{}, m.History...), nil
+}
+func (m *MockHTTPClient) Rekey(req *models.RekeyRequest, token string) error {
+	if m.RekeyFail != nil {
+		return m.RekeyFail
+	}
+	m.LastRekey = req
+	m.RecoverySlot = req.RecoverySlot
+	return nil
+}
+func (m *MockHTTPClient) ChangePassword(req *models.ChangePasswordRequest, token string) error {
+	if !m.checkPasswordProof(&req.Proof) {
+		return models.ErrInvalidCredentials
+	}
+	m.srpVerifier = req.SRP
+	return nil
+}
+func (m *MockHTTPClient) ChangeEmail(req *models.ChangeEmailRequest, token string) (*models.User, error) {
+	if m.ShouldFail {
+		return nil, models.ErrEmailInUse
+	}
+	m.Email = req.Email
+	return &models.User{ID: "user-123", Username: m.srpUsername, Email: req.Email}, nil
+}
+func (m *MockHTTPClient) DeleteAccount(req *models.DeleteAccountRequest, token string) (*models.AccountDeletionResponse, error) {
+	if !m.checkPasswordProof(&req.Proof) {
+		return nil, models.ErrInvalidCredentials
+	}
+	m.Deleted = true
+	return &models.AccountDeletionResponse{
+		Receipt:   models.DeletionReceipt{UserID: "user-123", Username: m.srpUsername, DeletedItems: 2, DeletedAt: time.Now().Unix()},
+		Signature: "mock-receipt",
+	}, nil
+}
+// checkPasswordProof accepts answers to the last SRPInit handshake.
+func (m *MockHTTPClient) checkPasswordProof(proof *models.PasswordProof) bool {
+	if m.srpServer == nil || proof.HandshakeID != "mock-handshake" {
+		return false
+	}
+	_, _, err := m.srpServer.VerifyClientProof(m.srpUsername, m.srpVerifier.Salt, m.srpClient, proof.ClientProof)
+	m.srpServer = nil
+	return err == nil
+}
+func (m *MockHTTPClient) SaveRecoverySlot(slot *models.RecoverySlot, token string) error {
+	if m.ShouldFail {
+		return models.ErrUnauthorized
+	}
+	m.RecoverySlot = slot
+	return nil
+}
+func (m *MockHTTPClient) GetRecoverySlot(token string) (*models.RecoverySlot, error) {
+	if m.RecoverySlot == nil {
+		return nil, models.ErrRecoveryNotConfigured
+	}
+	return m.RecoverySlot, nil
+}
+// RegisterDevice acts like the server registry: unknown devices get a new
+// ID, revoked ones are refused.
+func (m *MockHTTPClient) RegisterDevice(req *models.DeviceRegistrationRequest, token string) (*models.Device, error) {
+	m.LastDevice = req
+	for i := range m.Devices {
+		device := &m.Devices[i]
+		if device.ID != req.DeviceID {
+			continue
+		}
+		if device.RevokedAt != nil {
+			return nil, models.ErrDeviceRevoked
+		}
+		if !bytes.Equal(device.PublicKey, req.PublicKey) {
+			return nil, models.ErrInvalidDeviceProof
+		}
+		return device, nil
+	}
+	m.Devices = append(m.Devices, models.Device{
+		ID:        fmt.Sprintf("device-%d", len(m.Devices)+1),
+		Name:      req.Name,
+		Platform:  req.Platform,
+		PublicKey: req.PublicKey,
+		CreatedAt: time.Now(),
+	})
+	return &m.Devices[len(m.Devices)-1], nil
+}
+func (m *MockHTTPClient) ListDevices(token string) ([]models.Device, error) {
+	return m.Devices, nil
+}
+func (m *MockHTTPClient) RevokeDevice(id, token string) error {
+	for i := range m.Devices {
+		if m.Devices[i].ID == id {
+			now := time.Now()
+			m.Devices[i].RevokedAt = &now
+			return nil
+		}
+	}
+	return models.ErrDeviceNotFound
+}
+func (m *MockHTTPClient) CreateAPIToken(req *models.APITokenRequest, token string) (*models.APITokenResponse, error) {
+	if m.ShouldFail {
+		return nil, models.ErrUnauthorized
+	}
+	created := models.APIToken{
+		ID:         fmt.Sprintf("token-%d", len(m.APITokens)+1),
+		Name:       req.Name,
+		ReadOnly:   req.ReadOnly,
+		ItemIDs:    req.ItemIDs,
+		AllowedIPs: req.AllowedIPs,
+		ExpiresAt:  req.ExpiresAt,
+		CreatedAt:  time.Now(),
+	}
+	m.APITokens = append(m.APITokens, created)
+	return &models.APITokenResponse{Token: "gk_mock-" + created.ID, APIToken: created}, nil
+}
+func (m *MockHTTPClient) ListAPITokens(token string) ([]models.APIToken, error) {
+	return m.APITokens, nil
+}
+func (m *MockHTTPClient) RevokeAPIToken(id, token string) error {
+	for i := range m.APITokens {
+		if m.APITokens[i].ID == id {
+			now := time.Now()
+			m.APITokens[i].RevokedAt = &now
+			return nil
+		}
+	}
+	return models.ErrAPITokenNotFound
+}
+func (m *MockHTTPClient) GetAPITokenInfo(token string) (*models.APITokenInfo, error) {
+	for _, issued := range m.APITokens {
+		if "gk_mock-"+issued.ID == token && issued.RevokedAt == nil {
+			return &models.APITokenInfo{APIToken: issued, UserID: "user-123", Username: "testuser"}, nil
+		}
+	}
+	return nil, models.ErrInvalidAPIToken
+}
+func (m *MockHTTPClient) OIDCProviders() ([]models.OIDCProvider, error) {
+	return m.Providers, nil
+}
+func (m *MockHTTPClient) OIDCNonce() (*models.OIDCNonceResponse, error) {
+	nonce := fmt.Sprintf("nonce-%d", len(m.Nonces)+1)
+	m.Nonces = append(m.Nonces, nonce)
+	return &models.OIDCNonceResponse{Nonce: nonce, ExpiresAt: time.Now().Add(10 * time.Minute)}, nil
+}
+// OIDCLogin accepts any ID token carrying the last nonce handed out; the
+// signature is checked by the server tests.
+func (m *MockHTTPClient) OIDCLogin(req *models.OIDCTokenRequest) (*models.AuthResponse, error) {
+	m.LastOIDC = req
+	if err := m.checkOIDCNonce(req); err != nil {
+		return nil, err
+	}
+	return &models.AuthResponse{
+		Token:        "mock-sso-token",
+		RefreshToken: "mock-refresh-token",
+		User:         models.User{ID: "user-123", Username: "testuser"},
+	}, nil
+}
+func (m *MockHTTPClient) LinkOIDCIdentity(req *models.OIDCLinkRequest, token string) (*models.OIDCIdentity, error) {
+	m.LastOIDC = &req.OIDCTokenRequest
+	if !m.checkPasswordProof(&req.Proof) {
+		return nil, models.ErrInvalidCredentials
+	}
+	if err := m.checkOIDCNonce(&req.OIDCTokenRequest); err != nil {
+		return nil, err
+	}
+	identity := models.OIDCIdentity{Issuer: req.Issuer, Subject: "subject-123", UserID: "user-123", CreatedAt: time.Now()}
+	m.Identities = append(m.Identities, identity)
+	return &identity, nil
+}
+func (m *MockHTTPClient) ListOIDCIdentities(token string) ([]models.OIDCIdentity, error) {
+	return m.Identities, nil
+}
+func (m *MockHTTPClient) UnlinkOIDCIdentity(req *models.OIDCUnlinkRequest, token string) error {
+	if !m.checkPasswordProof(&req.Proof) {
+		return models.ErrInvalidCredentials
+	}
+	for i, identity := range m.Identities {
+		if identity.Issuer == req.Issuer {
+			m.Identities = append(m.Identities[:i], m.Identities[i+1:]...)
+			return nil
+		}
+	}
+	return models.ErrOIDCIdentityNotFound
+}
+func (m *MockHTTPClient) checkOIDCNonce(req *models.OIDCTokenRequest) error {
+	parts := strings.Split(req.IDToken, ".")
+	if len(parts) != 3 || len(m.Nonces) == 0 {
+		return models.ErrInvalidIDToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return models.ErrInvalidIDToken
+	}
+	var claims crypto.IDTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Nonce != m.Nonces[len(m.Nonces)-1] {
+		return models.ErrInvalidIDToken
+	}
+	return nil
+}
+func (m *MockHTTPClient) RefreshToken(refreshToken string) (*models.AuthResponse, error) {
+	if m.ShouldFail {
+		return nil, models.ErrUnauthorized
+	}
+	return &models.AuthResponse{
+		Token:        "mock-token-refreshed",
+		RefreshToken: "mock-refresh-token-2",
+		User:         models.User{ID: "user-123"},
 	}, nil
 }
+func (m *MockHTTPClient) Logout(token, refreshToken string) error {
+	m.LoggedOut = true
+	return nil
+}
+// SRPInit answers like a server that has not migrated the account unless a
+// verifier was registered through Register.
+func (m *MockHTTPClient) SRPInit(req *models.SRPInitRequest) (*models.SRPInitResponse, error) {
+	if m.ShouldFail {
+		return nil, models.ErrInvalidCredentials
+	}
+	if m.srpVerifier == nil || req.Username != m.srpUsername {
+		return &models.SRPInitResponse{Legacy: true}, nil
+	}
+	server, err := crypto.NewSRPServer(m.srpVerifier.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	m.srpServer = server
+	m.srpClient = req.ClientPublic
+	return &models.SRPInitResponse{
+		HandshakeID:  "mock-handshake",
+		Salt:         m.srpVerifier.Salt,
+		ServerPublic: server.PublicKey(),
+		Time:         m.srpVerifier.Time,
+		Memory:       m.srpVerifier.Memory,
+		Parallelism:  m.srpVerifier.Parallelism,
+	}, nil
+}
+func (m *MockHTTPClient) SRPVerify(req *models.SRPVerifyRequest) (*models.AuthResponse, error) {
+	if m.srpServer == nil || req.HandshakeID != "mock-handshake" {
+		return nil, models.ErrInvalidCredentials
+	}
+	serverProof, _, err := m.srpServer.VerifyClientProof(m.srpUsername, m.srpVerifier.Salt, m.srpClient, req.ClientProof)
+	m.srpServer = nil
+	if err != nil {
+		return nil, models.ErrInvalidCredentials
+	}
+	if m.MFARequired {
+		return &models.AuthResponse{MFARequired: true, MFAToken: "mock-mfa-token", ServerProof: serverProof}, nil
+	}
+	return &models.AuthResponse{
+		Token:        "mock-token",
+		RefreshToken: "mock-refresh-token",
+		User:         models.User{ID: "user-123", Username: m.srpUsername},
+		ServerProof:  serverProof,
+	}, nil
+}
+func (m *MockHTTPClient) LoginMFA(req *models.MFALoginRequest) (*models.AuthResponse, error) {
+	m.LastFactor = models.TOTPCodeRequest{Code: req.Code, RecoveryCode: req.RecoveryCode}
+	if req.MFAToken != "mock-mfa-token" || (req.Code != "123456" && req.RecoveryCode == "") {
+		return nil, models.ErrInvalidSecondFactor
+	}
+	return &models.AuthResponse{
+		Token:        "mock-token",
+		RefreshToken: "mock-refresh-token",
+		User:         models.User{ID: "user-123"},
+	}, nil
+}
+func (m *MockHTTPClient) EnrollTOTP(token string) (*models.TOTPEnrollResponse, error) {
+	if m.ShouldFail {
+		return nil, models.ErrUnauthorized
+	}
+	return &models.TOTPEnrollResponse{Secret: "JBSWY3DPEHPK3PXP", URI: "otpauth://totp/GophKeeper:user?secret=JBSWY3DPEHPK3PXP"}, nil
+}
+func (m *MockHTTPClient) ConfirmTOTP(code, token string) (*models.RecoveryCodesResponse, error) {
+	if code != "123456" {
+		return nil, models.ErrInvalidSecondFactor
+	}
+	return &models.RecoveryCodesResponse{Codes: []string{"aaaaa-bbbbb", "ccccc-ddddd"}}, nil
+}
+func (m *MockHTTPClient) DisableTOTP(req *models.TOTPCodeRequest, token string) error {
+	m.LastFactor = *req
+	return nil
+}
+func (m *MockHTTPClient) RegenerateRecoveryCodes(req *models.TOTPCodeRequest, token string) (*models.RecoveryCodesResponse, error) {
+	m.LastFactor = *req
+	return &models.RecoveryCodesResponse{Codes: []string{"eeeee-fffff"}}, nil
+}
 type MockEncryptor struct{}
 func (m *MockEncryptor) Encrypt(data []byte) ([]byte, error) {
 	return append([]byte("encrypted:"), data...), nil
@@ -114,8 +692,33 @@ func (m *MockEncryptor) Decrypt(data []byte) ([]byte, error) {
 	}
 	return data, nil
 }
+
+// EncryptWithAAD prefixes the binding header and a digest of aad, so tests
+// can detect blobs opened under the wrong binding.
+func (m *MockEncryptor) EncryptWithAAD(data, aad []byte) ([]byte, error) {
+	sum := sha256.Sum256(aad)
+	out := append([]byte("GK\x01"), sum[:8]...)
+	return append(out, data...), nil
+}
+func (m *MockEncryptor) DecryptWithAAD(data, aad []byte) ([]byte, error) {
+	if !crypto.IsBound(data) {
+		return m.Decrypt(data)
+	}
+	sum := sha256.Sum256(aad)
+	if len(data) < 11 || !bytes.Equal(data[3:11], sum[:8]) {
+		return nil, crypto.ErrBindingMismatch
+	}
+	return data[11:], nil
+}
+func (m *MockEncryptor) DecryptBound(data, aad []byte) ([]byte, error) {
+	if !crypto.IsBound(data) {
+		return nil, crypto.ErrBindingMismatch
+	}
+	return m.DecryptWithAAD(data, aad)
+}
 type MockTokenManager struct {
-	token string
+	token        string
+	refreshToken string
 }
 func (m *MockTokenManager) SaveToken(token string) error {
 	m.token = token
@@ -124,21 +727,74 @@ func (m *MockTokenManager) SaveToken(token string) error {
 func (m *MockTokenManager) LoadToken() (string, error) {
 	return m.token, nil
 }
+func (m *MockTokenManager) SaveRefreshToken(token string) error {
+	m.refreshToken = token
+	return nil
+}
+func (m *MockTokenManager) LoadRefreshToken() (string, error) {
+	return m.refreshToken, nil
+}
 func (m *MockTokenManager) ClearToken() error {
 	m.token = ""
+	m.refreshToken = ""
 	return nil
 }
 type MockAuthService struct {
 	Authenticated bool
 	UserID        string
 	Token         string
+	Claims        *crypto.JWTClaims
+	Refreshed     int
 }
-func (m *MockAuthService) Register(username, email, password string) (*models.AuthResponse, error) {
+func (m *MockAuthService) Register(username, email, password string, kdf *models.KDFParams) (*models.AuthResponse, error) {
 	return nil, nil
 }
+func (m *MockAuthService) SetKDFParams(params *models.KDFParams) error {
+	return nil
+}
 func (m *MockAuthService) Login(username, password string) (*models.AuthResponse, error) {
 	return nil, nil
 }
+func (m *MockAuthService) LoginMFA(mfaToken, code string) (*models.AuthResponse, error) {
+	return nil, nil
+}
+func (m *MockAuthService) LoginSSO(issuer string) (*models.AuthResponse, error) {
+	return nil, nil
+}
+func (m *MockAuthService) LinkSSO(issuer, password string) (*models.OIDCIdentity, error) {
+	return nil, nil
+}
+func (m *MockAuthService) ListSSO() ([]models.OIDCIdentity, error) {
+	return nil, nil
+}
+func (m *MockAuthService) UnlinkSSO(issuer, password string) error {
+	return nil
+}
+func (m *MockAuthService) EnrollTOTP() (*models.TOTPEnrollResponse, error) {
+	return nil, nil
+}
+func (m *MockAuthService) ConfirmTOTP(code string) ([]string, error) {
+	return nil, nil
+}
+func (m *MockAuthService) DisableTOTP(code string) error {
+	return nil
+}
+func (m *MockAuthService) RegenerateRecoveryCodes(code string) ([]string, error) {
+	return nil, nil
+}
+func (m *MockAuthService) ChangePassword(oldPassword, newPassword string) error {
+	return nil
+}
+func (m *MockAuthService) ChangeEmail(email string) error {
+	return nil
+}
+func (m *MockAuthService) DeleteAccount(password string) (*models.AccountDeletionResponse, error) {
+	return &models.AccountDeletionResponse{}, nil
+}
+func (m *MockAuthService) RefreshToken() (string, error) {
+	m.Refreshed++
+	return m.Token, nil
+}
 func (m *MockAuthService) IsAuthenticated() bool {
 	return m.Authenticated
 }
@@ -148,9 +804,41 @@ func (m *MockAuthService) GetToken() string {
 func (m *MockAuthService) GetUserID() string {
 	return m.UserID
 }
+func (m *MockAuthService) TokenClaims() (*crypto.JWTClaims, error) {
+	if m.Claims != nil {
+		return m.Claims, nil
+	}
+	return &crypto.JWTClaims{UserID: m.UserID}, nil
+}
+func (m *MockAuthService) ClearSession() error {
+	m.Authenticated = false
+	m.Token = ""
+	m.UserID = ""
+	return nil
+}
 func (m *MockAuthService) Logout() error {
 	m.Authenticated = false
 	m.Token = ""
 	m.UserID = ""
 	return nil
 }
+type MockPrompter struct {
+	Passwords []string
+	Lines     []string
+}
+func (m *MockPrompter) ReadPassword(prompt string) (string, error) {
+	if len(m.Passwords) == 0 {
+		return "", errors.New("no more passwords")
+	}
+	password := m.Passwords[0]
+	m.Passwords = m.Passwords[1:]
+	return password, nil
+}
+func (m *MockPrompter) ReadLine(prompt string) (string, error) {
+	if len(m.Lines) == 0 {
+		return "", errors.New("no more input")
+	}
+	line := m.Lines[0]
+	m.Lines = m.Lines[1:]
+	return line, nil
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"gophkeeper/internal/client"
+	"gophkeeper/internal/client/tests/mocks"
+	"gophkeeper/internal/crypto"
+)
+
+func newTestRecoveryService(storage *mocks.MockStorage, httpClient *mocks.MockHTTPClient, prompter *mocks.MockPrompter) *client.RecoveryServiceImpl {
+	auth := &mocks.MockAuthService{Authenticated: true, UserID: "user-123", Token: "mock-token"}
+	rekey, vault := newTestRekeyService(storage, httpClient, prompter)
+	return client.NewRecoveryService(storage, httpClient, vault, rekey, auth)
+}
+func TestRecoveryService_SplitAndCombine(t *testing.T) {
+	storage := mocks.NewMockStorage()
+	old := setupRekeyVault(t, storage)
+	httpClient := &mocks.MockHTTPClient{}
+	kit, err := newTestRecoveryService(storage, httpClient, &mocks.MockPrompter{Passwords: []string{"old-master"}}).Split(5, 3)
+	if err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	if len(kit) != 5 || httpClient.RecoverySlot == nil {
+		t.Fatalf("Expected 5 shares and a stored slot, got %d shares", len(kit))
+	}
+	if !bytes.Equal(httpClient.RecoverySlot.KeyCheck, old.KeyCheck) {
+		t.Error("Expected the slot to wrap the current vault key")
+	}
+	forgotten := newTestRecoveryService(storage, httpClient, &mocks.MockPrompter{Passwords: []string{"new-master", "new-master"}})
+	if err := forgotten.Combine([]string{kit[4].Mnemonic(), kit[0].Base32(), kit[2].Mnemonic()}); err != nil {
+		t.Fatalf("Combine failed: %v", err)
+	}
+	req := httpClient.LastRekey
+	if req == nil || !bytes.Equal(req.PreviousKeyCheck, old.KeyCheck) || len(req.Data) != 1 {
+		t.Fatalf("Expected the vault to be re-keyed from the recovered key, got %+v", req)
+	}
+	if req.RecoverySlot == nil || !bytes.Equal(req.RecoverySlot.KeyCheck, req.KDF.KeyCheck) {
+		t.Fatal("Expected the recovery slot to be re-wrapped for the new key")
+	}
+	again := newTestRecoveryService(storage, httpClient, &mocks.MockPrompter{Passwords: []string{"newer-master", "newer-master"}})
+	if err := again.Combine([]string{kit[1].Base32(), kit[3].Base32(), kit[4].Base32()}); err != nil {
+		t.Fatalf("Expected the same kit to work after the reset, got %v", err)
+	}
+}
+func TestRecoveryService_RejectsForeignShares(t *testing.T) {
+	storage := mocks.NewMockStorage()
+	setupRekeyVault(t, storage)
+	httpClient := &mocks.MockHTTPClient{}
+	if _, err := newTestRecoveryService(storage, httpClient, &mocks.MockPrompter{Passwords: []string{"old-master"}}).Split(3, 2); err != nil {
+		t.Fatalf("Split failed: %v", err)
+	}
+	key, _ := crypto.NewRecoveryKey()
+	other, _ := crypto.SplitRecoveryKey(key, 3, 2)
+	service := newTestRecoveryService(storage, httpClient, &mocks.MockPrompter{})
+	if err := service.Combine([]string{other[0].Mnemonic(), other[1].Mnemonic()}); err == nil {
+		t.Fatal("Expected shares of another kit to be rejected")
+	}
+	if httpClient.LastRekey != nil {
+		t.Error("Expected nothing to be re-keyed")
+	}
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/client"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/client/tests/mocks"
+	"gophkeeper/internal/models"
+)
+
+func newTestRekeyService(storage *mocks.MockStorage, httpClient *mocks.MockHTTPClient, prompter *mocks.MockPrompter) (*client.RekeyServiceImpl, *client.VaultImpl) {
+	auth := &mocks.MockAuthService{Authenticated: true, UserID: "user-123", Token: "mock-token"}
+	vault := newTestVault(storage, prompter, "")
+	sync := client.NewSyncService(storage, httpClient, vault, auth)
+	return client.NewRekeyService(storage, storage, httpClient, vault, sync, auth), vault
+}
+func setupRekeyVault(t *testing.T, storage *mocks.MockStorage) *models.KDFParams {
+	t.Helper()
+	vault := newTestVault(storage, &mocks.MockPrompter{Passwords: []string{"old-master", "old-master"}}, "")
+	params, err := vault.Create()
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	_ = vault.Persist("user-123", params)
+	storage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Title: "Note", Data: []byte("secret"), Version: 1, UpdatedAt: time.Now()})
+	return params
+}
+func TestRekeyService_ChangeMasterPassword(t *testing.T) {
+	storage := mocks.NewMockStorage()
+	old := setupRekeyVault(t, storage)
+	httpClient := &mocks.MockHTTPClient{}
+	prompter := &mocks.MockPrompter{Passwords: []string{"old-master", "new-master", "new-master"}}
+	service, _ := newTestRekeyService(storage, httpClient, prompter)
+	if err := service.ChangeMasterPassword(); err != nil {
+		t.Fatalf("ChangeMasterPassword failed: %v", err)
+	}
+	req := httpClient.LastRekey
+	if req == nil || len(req.Data) != 1 {
+		t.Fatalf("Expected the re-encrypted item to be uploaded, got %+v", req)
+	}
+	if string(req.PreviousKeyCheck) != string(old.KeyCheck) || string(req.KDF.Salt) == string(old.Salt) {
+		t.Error("Expected the request to name the old key and carry new parameters")
+	}
+	if req.Data[0].Title != "" || req.Data[0].Type != models.DataTypeSealed {
+		t.Error("Expected uploaded items to be sealed")
+	}
+	if state, _ := storage.GetRekeyState("user-123"); state != nil {
+		t.Error("Expected rekey state to be cleared")
+	}
+	if saved, _ := storage.GetKDFParams("user-123"); string(saved.Salt) != string(req.KDF.Salt) {
+		t.Error("Expected new kdf params to be stored locally")
+	}
+}
+func TestRekeyService_ReencryptsHistory(t *testing.T) {
+	storage := mocks.NewMockStorage()
+	setupRekeyVault(t, storage)
+	old := newTestVault(storage, &mocks.MockPrompter{Passwords: []string{"old-master"}}, "")
+	blob, err := old.EncryptWithAAD([]byte("first draft"), crypto.ItemAAD("user-123", "item-1", string(models.DataTypeText), 1))
+	if err != nil {
+		t.Fatalf("EncryptWithAAD failed: %v", err)
+	}
+	httpClient := &mocks.MockHTTPClient{History: []models.DataHistory{
+		{ID: "item-1_v1", DataID: "item-1", Type: models.DataTypeText, Title: "Note", Data: blob, Version: 1},
+	}}
+	service, vault := newTestRekeyService(storage, httpClient, &mocks.MockPrompter{Passwords: []string{"old-master", "new-master", "new-master"}})
+	if err := service.ChangeMasterPassword(); err != nil {
+		t.Fatalf("ChangeMasterPassword failed: %v", err)
+	}
+	history := httpClient.LastRekey.History
+	if len(history) != 1 || history[0].ID != "item-1_v1" {
+		t.Fatalf("Expected the history entry to be uploaded, got %+v", history)
+	}
+	if history[0].Type != models.DataTypeSealed || history[0].Title != "" {
+		t.Error("Expected the history entry to be sealed")
+	}
+	if _, err := vault.DecryptWithAAD(history[0].Data, crypto.ItemAAD("user-123", "item-1", string(models.DataTypeSealed), 1)); err != nil {
+		t.Errorf("Expected the history entry to open with the new key: %v", err)
+	}
+}
+func TestRekeyService_ResumesAfterFailedUpload(t *testing.T) {
+	storage := mocks.NewMockStorage()
+	setupRekeyVault(t, storage)
+	httpClient := &mocks.MockHTTPClient{RekeyFail: errors.New("connection reset")}
+	service, _ := newTestRekeyService(storage, httpClient, &mocks.MockPrompter{Passwords: []string{"old-master", "new-master", "new-master"}})
+	if err := service.ChangeMasterPassword(); err == nil {
+		t.Fatal("Expected the failed upload to be reported")
+	}
+	pending, _ := storage.GetRekeyState("user-123")
+	if pending == nil {
+		t.Fatal("Expected rekey state to survive the failure")
+	}
+	httpClient.RekeyFail = nil
+	wrong, _ := newTestRekeyService(storage, httpClient, &mocks.MockPrompter{Passwords: []string{"other-master"}})
+	if err := wrong.ChangeMasterPassword(); !errors.Is(err, client.ErrWrongMasterPassword) {
+		t.Fatalf("Expected a different new password to be rejected, got %v", err)
+	}
+	resumed, _ := newTestRekeyService(storage, httpClient, &mocks.MockPrompter{Passwords: []string{"new-master"}})
+	if err := resumed.ChangeMasterPassword(); err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if string(httpClient.LastRekey.KDF.Salt) != string(pending.Salt) {
+		t.Error("Expected the resumed upload to reuse the pending parameters")
+	}
+}
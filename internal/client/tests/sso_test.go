@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/client"
+	"gophkeeper/internal/client/tests/mocks"
+	"gophkeeper/internal/models"
+	"gophkeeper/internal/testutil"
+)
+
+func newSSOAuthService(t *testing.T) (*client.AuthServiceImpl, *mocks.MockHTTPClient, *testutil.MockIdP) {
+	t.Helper()
+	idp := testutil.NewMockIdP("gophkeeper-cli")
+	t.Cleanup(idp.Close)
+	httpClient := &mocks.MockHTTPClient{
+		Providers: []models.OIDCProvider{{Issuer: idp.Issuer(), ClientID: idp.ClientID}},
+	}
+	auth := client.NewAuthService(httpClient, &mocks.MockTokenManager{})
+	auth.SetSSOOptions(client.SSOOptions{OpenBrowser: idp.Browser, Timeout: 5 * time.Second})
+	return auth, httpClient, idp
+}
+
+func TestAuthService_LoginSSO(t *testing.T) {
+	auth, httpClient, idp := newSSOAuthService(t)
+	response, err := auth.LoginSSO("")
+	if err != nil {
+		t.Fatalf("LoginSSO failed: %v", err)
+	}
+	if response.Token != "mock-sso-token" || auth.GetToken() != "mock-sso-token" || auth.GetUserID() != "user-123" {
+		t.Errorf("Expected the sso session to be stored, got %q", auth.GetToken())
+	}
+	if httpClient.LastOIDC.Issuer != idp.Issuer() {
+		t.Errorf("Expected the token to name the issuer, got %q", httpClient.LastOIDC.Issuer)
+	}
+}
+
+func TestAuthService_LinkSSO(t *testing.T) {
+	auth, httpClient, idp := newSSOAuthService(t)
+	if _, err := auth.LinkSSO(idp.Issuer(), "password123"); err == nil {
+		t.Fatal("Expected linking to need a session")
+	}
+	if _, err := auth.Register("ssouser", "sso@example.com", "password123", nil); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if _, err := auth.LinkSSO(idp.Issuer(), "wrong-password"); err == nil || len(httpClient.Identities) != 0 {
+		t.Fatal("Expected a wrong password to be rejected")
+	}
+	identity, err := auth.LinkSSO(idp.Issuer(), "password123")
+	if err != nil {
+		t.Fatalf("LinkSSO failed: %v", err)
+	}
+	if identity.Issuer != idp.Issuer() || len(httpClient.Identities) != 1 {
+		t.Errorf("Expected the identity to be linked, got %+v", identity)
+	}
+	if err := auth.UnlinkSSO(idp.Issuer(), "wrong-password"); err == nil || len(httpClient.Identities) != 1 {
+		t.Error("Expected unlinking to need the password")
+	}
+	if err := auth.UnlinkSSO(idp.Issuer(), "password123"); err != nil || len(httpClient.Identities) != 0 {
+		t.Errorf("Expected the identity to be unlinked (%v)", err)
+	}
+}
+
+func TestAuthService_LoginSSOProviderChoice(t *testing.T) {
+	auth, httpClient, idp := newSSOAuthService(t)
+	if _, err := auth.LoginSSO("https://unknown.example.com"); err == nil {
+		t.Error("Expected error for an issuer the server does not accept")
+	}
+	httpClient.Providers = append(httpClient.Providers, models.OIDCProvider{Issuer: "https://other.example.com", ClientID: "cli"})
+	if _, err := auth.LoginSSO(""); err == nil || !strings.Contains(err.Error(), idp.Issuer()) {
+		t.Errorf("Expected to be asked to choose a provider, got %v", err)
+	}
+	if _, err := auth.LoginSSO(idp.Issuer()); err != nil {
+		t.Errorf("Expected login with an explicit issuer to succeed, got %v", err)
+	}
+}
+
+func TestAuthService_LoginSSOTimeout(t *testing.T) {
+	auth, _, _ := newSSOAuthService(t)
+	auth.SetSSOOptions(client.SSOOptions{
+		OpenBrowser: func(string) error { return nil },
+		Timeout:     100 * time.Millisecond,
+	})
+	if _, err := auth.LoginSSO(""); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected the sign-in to time out, got %v", err)
+	}
+	if auth.IsAuthenticated() {
+		t.Error("Expected no session after a failed sign-in")
+	}
+}
@@ -0,0 +1,400 @@
+package tests
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/client"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/models"
+)
+
+func newTestEncryptor(t *testing.T) *crypto.Encryptor {
+	enc, err := crypto.NewEncryptorFromKey(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+	return enc
+}
+func readRawRow(t *testing.T, dbPath, id string) (string, []byte, string, bool) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open raw database: %v", err)
+	}
+	defer db.Close()
+	var title, metadata string
+	var data []byte
+	var encrypted bool
+	err = db.QueryRow("SELECT title, data, metadata, encrypted FROM stored_data WHERE id = ?", id).Scan(&title, &data, &metadata, &encrypted)
+	if err != nil {
+		t.Fatalf("Failed to read raw row: %v", err)
+	}
+	return title, data, metadata, encrypted
+}
+func TestClientStorage_EncryptsRowsAtRest(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "data.db")
+	storage, err := client.NewClientStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer storage.Close()
+	storage.SetEncryptor(newTestEncryptor(t))
+	item := &models.StoredData{
+		ID:       "item-1",
+		UserID:   "user-1",
+		Type:     models.DataTypeLoginPassword,
+		Title:    "Bank account",
+		Data:     []byte(`{"login":"alice","password":"hunter2"}`),
+		Metadata: "personal",
+		Version:  1,
+	}
+	if err := storage.SaveData(item); err != nil {
+		t.Fatalf("SaveData failed: %v", err)
+	}
+	title, data, metadata, encrypted := readRawRow(t, dbPath, "item-1")
+	if !encrypted {
+		t.Error("Expected row to be marked as encrypted")
+	}
+	if strings.Contains(title, "Bank") || strings.Contains(metadata, "personal") || bytes.Contains(data, []byte("hunter2")) {
+		t.Error("Expected no plaintext in the database file")
+	}
+	got, err := storage.GetData("item-1")
+	if err != nil {
+		t.Fatalf("GetData failed: %v", err)
+	}
+	if got.Title != item.Title || got.Metadata != item.Metadata || !bytes.Equal(got.Data, item.Data) {
+		t.Errorf("Expected decrypted item, got %+v", got)
+	}
+	history, err := storage.GetDataHistory("item-1")
+	if err != nil {
+		t.Fatalf("GetDataHistory failed: %v", err)
+	}
+	if len(history) != 1 || history[0].Title != item.Title {
+		t.Errorf("Expected decrypted history entry, got %+v", history)
+	}
+}
+func TestClientStorage_MigratesPlaintextRows(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "data.db")
+	legacy, err := client.NewClientStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	item := &models.StoredData{ID: "item-1", UserID: "user-1", Type: models.DataTypeText, Title: "Notes", Data: []byte("plain secret"), Version: 1}
+	if err := legacy.SaveData(item); err != nil {
+		t.Fatalf("SaveData failed: %v", err)
+	}
+	legacy.Close()
+	if _, data, _, encrypted := readRawRow(t, dbPath, "item-1"); encrypted || string(data) != "plain secret" {
+		t.Fatal("Expected legacy row to be stored in plaintext")
+	}
+	storage, err := client.NewClientStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer storage.Close()
+	storage.SetEncryptor(newTestEncryptor(t))
+	list, err := storage.GetAllData("user-1")
+	if err != nil {
+		t.Fatalf("GetAllData failed: %v", err)
+	}
+	if len(list) != 1 || string(list[0].Data) != "plain secret" || list[0].Title != "Notes" {
+		t.Errorf("Expected migrated item to read back unchanged, got %+v", list)
+	}
+	title, data, _, encrypted := readRawRow(t, dbPath, "item-1")
+	if !encrypted || title == "Notes" || bytes.Contains(data, []byte("plain secret")) {
+		t.Error("Expected legacy row to be re-encrypted in place")
+	}
+}
+func TestClientStorage_Rekey(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "data.db")
+	storage, err := client.NewClientStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer storage.Close()
+	storage.SetEncryptor(newTestEncryptor(t))
+	item := &models.StoredData{ID: "item-1", UserID: "user-1", Type: models.DataTypeText, Title: "Note", Data: []byte("secret"), Version: 1}
+	if err := storage.SaveData(item); err != nil {
+		t.Fatalf("SaveData failed: %v", err)
+	}
+	params := &models.KDFParams{Algorithm: "argon2id", Salt: []byte("new-salt"), Time: 1, Memory: 1024, Parallelism: 1, KeyLength: 32}
+	if err := storage.SaveRekeyState("user-1", params); err != nil {
+		t.Fatalf("SaveRekeyState failed: %v", err)
+	}
+	if state, err := storage.GetRekeyState("user-1"); err != nil || state == nil || string(state.Salt) != "new-salt" {
+		t.Fatalf("Expected pending rekey state, got %+v, %v", state, err)
+	}
+	next, _ := crypto.NewEncryptorFromKey(bytes.Repeat([]byte{9}, 32))
+	if err := storage.Rekey("user-1", next, params); err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+	if state, _ := storage.GetRekeyState("user-1"); state != nil {
+		t.Error("Expected rekey state to be cleared")
+	}
+	if saved, err := storage.GetKDFParams("user-1"); err != nil || string(saved.Salt) != "new-salt" {
+		t.Errorf("Expected new kdf params to be stored, got %+v, %v", saved, err)
+	}
+	_, data, _, _ := readRawRow(t, dbPath, "item-1")
+	if _, err := newTestEncryptor(t).Decrypt(data); err == nil {
+		t.Error("Expected old key to no longer open the row")
+	}
+	storage.SetEncryptor(next)
+	reopened, err := storage.GetData("item-1")
+	if err != nil {
+		t.Fatalf("GetData with new key failed: %v", err)
+	}
+	if reopened.Title != "Note" || string(reopened.Data) != "secret" {
+		t.Errorf("Unexpected row after rekey: %+v", reopened)
+	}
+	history, err := storage.GetDataHistory("item-1")
+	if err != nil || len(history) == 0 || string(history[0].Data) != "secret" {
+		t.Errorf("Expected history to be rekeyed too, got %+v, %v", history, err)
+	}
+}
+func TestClientStorage_SealsDeviceKey(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "data.db")
+	storage, err := client.NewClientStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer storage.Close()
+	_, key, err := crypto.NewDeviceKey()
+	if err != nil {
+		t.Fatalf("NewDeviceKey failed: %v", err)
+	}
+	// Written by a client that kept the key in the clear.
+	if err := storage.SaveDeviceIdentity("user-1", &client.DeviceIdentity{DeviceID: "device-1", PrivateKey: key}); err != nil {
+		t.Fatalf("SaveDeviceIdentity failed: %v", err)
+	}
+	storage.SetEncryptor(newTestEncryptor(t))
+	if identity, err := storage.GetDeviceIdentity("user-1"); err != nil || !bytes.Equal(identity.PrivateKey, key) {
+		t.Fatalf("Expected the plaintext key to be read, got %v", err)
+	}
+	rawKey := func() []byte {
+		db, err := sql.Open("sqlite3", dbPath)
+		if err != nil {
+			t.Fatalf("Failed to open raw database: %v", err)
+		}
+		defer db.Close()
+		var raw []byte
+		if err := db.QueryRow("SELECT private_key FROM device_identity WHERE user_id = ?", "user-1").Scan(&raw); err != nil {
+			t.Fatalf("Failed to read raw key: %v", err)
+		}
+		return raw
+	}
+	if bytes.Contains(rawKey(), key.Seed()) {
+		t.Fatal("Expected the device key to be sealed once the vault is available")
+	}
+	params := &models.KDFParams{Algorithm: "argon2id", Salt: []byte("new-salt"), Time: 1, Memory: 1024, Parallelism: 1, KeyLength: 32}
+	next, _ := crypto.NewEncryptorFromKey(bytes.Repeat([]byte{9}, 32))
+	if err := storage.Rekey("user-1", next, params); err != nil {
+		t.Fatalf("Rekey failed: %v", err)
+	}
+	storage.SetEncryptor(next)
+	if identity, err := storage.GetDeviceIdentity("user-1"); err != nil || !bytes.Equal(identity.PrivateKey, key) {
+		t.Errorf("Expected the device key to open with the new vault key, got %v", err)
+	}
+}
+func TestClientStorage_SyncState(t *testing.T) {
+	storage, err := client.NewClientStorage(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer storage.Close()
+	state, err := storage.GetSyncState("user-1")
+	if err != nil {
+		t.Fatalf("GetSyncState failed: %v", err)
+	}
+	if state.Cursor != "" || !state.LastSyncAt.IsZero() {
+		t.Fatalf("Expected an empty state before the first sync, got %+v", state)
+	}
+	syncedAt := time.Now().Truncate(time.Second)
+	for _, cursor := range []string{"41", "42"} {
+		if err := storage.SaveSyncState("user-1", &client.SyncState{Cursor: cursor, LastSyncAt: syncedAt}); err != nil {
+			t.Fatalf("SaveSyncState failed: %v", err)
+		}
+	}
+	state, err = storage.GetSyncState("user-1")
+	if err != nil {
+		t.Fatalf("GetSyncState failed: %v", err)
+	}
+	if state.Cursor != "42" || !state.LastSyncAt.Equal(syncedAt) {
+		t.Errorf("Expected the last saved state, got %+v", state)
+	}
+	if other, _ := storage.GetSyncState("user-2"); other.Cursor != "" {
+		t.Errorf("Expected sync state to be kept per user, got %+v", other)
+	}
+	if err := storage.ResetData("user-1"); err != nil {
+		t.Fatalf("ResetData failed: %v", err)
+	}
+	if state, _ := storage.GetSyncState("user-1"); state.Cursor != "" {
+		t.Errorf("Expected ResetData to drop the cursor, got %+v", state)
+	}
+}
+
+func TestClientStorage_DirtyItems(t *testing.T) {
+	storage, err := client.NewClientStorage(filepath.Join(t.TempDir(), "data.db"))
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer storage.Close()
+	storage.SetEncryptor(newTestEncryptor(t))
+	item := &models.StoredData{ID: "item-1", UserID: "user-1", Type: models.DataTypeText, Title: "Note", Data: []byte("one"), Version: 1}
+	if err := storage.SaveData(item); err != nil {
+		t.Fatalf("SaveData failed: %v", err)
+	}
+	dirty, err := storage.GetDirtyData("user-1")
+	if err != nil || len(dirty) != 1 || string(dirty[0].Data) != "one" {
+		t.Fatalf("Expected the new item to be dirty, got %+v, %v", dirty, err)
+	}
+	if err := storage.SaveSyncBase(item, true); err != nil {
+		t.Fatalf("SaveSyncBase failed: %v", err)
+	}
+	if dirty, _ := storage.GetDirtyData("user-1"); len(dirty) != 0 {
+		t.Fatalf("Expected a synced item to be clean, got %d dirty", len(dirty))
+	}
+	if err := storage.DeleteData("item-1"); err != nil {
+		t.Fatalf("DeleteData failed: %v", err)
+	}
+	if dirty, _ := storage.GetDirtyData("user-1"); len(dirty) != 1 || !dirty[0].IsDeleted {
+		t.Errorf("Expected the deletion to be dirty, got %+v", dirty)
+	}
+	if dirty, _ := storage.GetDirtyData("user-2"); len(dirty) != 0 {
+		t.Errorf("Expected dirty items to be kept per user, got %d", len(dirty))
+	}
+	if unsent, err := storage.CountUnsent("user-1"); err != nil || unsent != 1 {
+		t.Errorf("Expected one unsent change, got %d, %v", unsent, err)
+	}
+}
+func TestClientStorage_Conflicts(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "data.db")
+	storage, err := client.NewClientStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer storage.Close()
+	storage.SetEncryptor(newTestEncryptor(t))
+	conflict := &client.ItemConflict{
+		ID:     "item-1",
+		Reason: "Server has newer version",
+		Server: models.StoredData{
+			ID: "item-1", Type: models.DataTypeLoginPassword, Title: "bank login",
+			Data: []byte(`{"login":"alice","password":"s3cret"}`), Version: 4, UpdatedAt: time.Now(),
+		},
+		DetectedAt: time.Now(),
+	}
+	if err := storage.SaveConflict("user-1", conflict); err != nil {
+		t.Fatalf("SaveConflict failed: %v", err)
+	}
+	conflict.Server.Version = 5
+	if err := storage.SaveConflict("user-1", conflict); err != nil {
+		t.Fatalf("SaveConflict failed: %v", err)
+	}
+	conflicts, err := storage.ListConflicts("user-1")
+	if err != nil {
+		t.Fatalf("ListConflicts failed: %v", err)
+	}
+	if len(conflicts) != 1 || conflicts[0].Server.Version != 5 || conflicts[0].Server.Title != "bank login" ||
+		!bytes.Equal(conflicts[0].Server.Data, conflict.Server.Data) {
+		t.Fatalf("Expected the latest server copy, got %+v", conflicts)
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	var raw []byte
+	if err := db.QueryRow(`SELECT data FROM conflicts WHERE id = ?`, "item-1").Scan(&raw); err != nil {
+		t.Fatalf("Failed to read raw row: %v", err)
+	}
+	if bytes.Contains(raw, []byte("s3cret")) {
+		t.Error("Expected the server copy to be encrypted at rest")
+	}
+	if err := storage.DeleteConflict("item-1"); err != nil {
+		t.Fatalf("DeleteConflict failed: %v", err)
+	}
+	if open, err := storage.GetConflict("item-1"); err != nil || open != nil {
+		t.Errorf("Expected no conflict after delete, got %+v (%v)", open, err)
+	}
+}
+func TestClientStorage_Outbox(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "data.db")
+	storage, err := client.NewClientStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	defer storage.Close()
+	first := &client.OutboxEntry{ItemID: "item-1", Op: client.OutboxAdd, IdempotencyKey: "key-1", CreatedAt: time.Now()}
+	item := &models.StoredData{ID: "item-1", UserID: "user-1", Type: models.DataTypeText, Data: []byte("queued"), Version: 1}
+	if err := storage.SaveDataQueued(item, first); err != nil {
+		t.Fatalf("SaveDataQueued failed: %v", err)
+	}
+	second := &client.OutboxEntry{ItemID: "item-1", Op: client.OutboxDelete, IdempotencyKey: "key-2", CreatedAt: time.Now()}
+	if err := storage.DeleteDataQueued("user-1", "item-1", second); err != nil {
+		t.Fatalf("DeleteDataQueued failed: %v", err)
+	}
+	if saved, err := storage.GetData("item-1"); err != nil || !saved.IsDeleted {
+		t.Fatalf("Expected the queued delete to mark the item deleted, got %+v (%v)", saved, err)
+	}
+	// A write is never queued without its item, nor saved without its entry.
+	if err := storage.SaveDataQueued(&models.StoredData{ID: "item-2", UserID: "user-1", Type: models.DataTypeText, Version: 1}, &client.OutboxEntry{ItemID: "item-2", Op: client.OutboxAdd, IdempotencyKey: "key-1"}); err == nil {
+		t.Fatal("Expected a reused idempotency key to fail the write")
+	}
+	if saved, _ := storage.GetData("item-2"); saved != nil {
+		t.Errorf("Expected the item of a failed write not to be saved, got %+v", saved)
+	}
+	if err := storage.RecordOutboxFailure(first.Seq, "connection refused"); err != nil {
+		t.Fatalf("RecordOutboxFailure failed: %v", err)
+	}
+	entries, err := storage.ListOutbox("user-1")
+	if err != nil {
+		t.Fatalf("ListOutbox failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].IdempotencyKey != "key-1" || entries[1].Op != client.OutboxDelete {
+		t.Fatalf("Expected both entries in order, got %+v", entries)
+	}
+	if entries[0].Attempts != 1 || entries[0].LastError != "connection refused" {
+		t.Errorf("Expected the failure to be recorded, got %+v", entries[0])
+	}
+	if other, _ := storage.ListOutbox("user-2"); len(other) != 0 {
+		t.Errorf("Expected no entries for another user, got %d", len(other))
+	}
+	if err := storage.DeleteOutboxEntry(first.Seq); err != nil {
+		t.Fatalf("DeleteOutboxEntry failed: %v", err)
+	}
+	if err := storage.SaveSyncBase(item, true); err != nil {
+		t.Fatalf("SaveSyncBase failed: %v", err)
+	}
+	if err := storage.DeadLetterOutboxEntry(second.Seq, "bad request"); err != nil {
+		t.Fatalf("DeadLetterOutboxEntry failed: %v", err)
+	}
+	if entries, _ := storage.ListOutbox("user-1"); len(entries) != 0 {
+		t.Errorf("Expected a dead-lettered entry not to be sent again, got %+v", entries)
+	}
+	if count, _ := storage.CountUnsent("user-1"); count != 0 {
+		t.Errorf("Expected a dead-lettered entry not to count as unsent, got %d", count)
+	}
+	letters, err := storage.ListDeadLetters("user-1")
+	if err != nil {
+		t.Fatalf("ListDeadLetters failed: %v", err)
+	}
+	if len(letters) != 1 || letters[0].Op != client.OutboxDelete || letters[0].LastError != "bad request" || letters[0].DeadAt == nil {
+		t.Fatalf("Expected the rejected delete with its error, got %+v", letters)
+	}
+	if err := storage.SaveSyncBase(item, true); err != nil {
+		t.Fatalf("SaveSyncBase failed: %v", err)
+	}
+	if letters, _ := storage.ListDeadLetters("user-1"); len(letters) != 0 {
+		t.Errorf("Expected syncing the item to clear its dead letters, got %+v", letters)
+	}
+	if err := storage.ResetData("user-1"); err != nil {
+		t.Fatalf("ResetData failed: %v", err)
+	}
+	if entries, _ := storage.ListOutbox("user-1"); len(entries) != 0 {
+		t.Errorf("Expected reset to clear the outbox, got %d", len(entries))
+	}
+}
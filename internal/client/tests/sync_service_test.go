@@ -1,8 +1,14 @@
 package tests
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 	"gophkeeper/internal/client"
 	"gophkeeper/internal/client/tests/mocks"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/models"
 )
 func TestSyncService_SyncData(t *testing.T) {
 	mockStorage := mocks.NewMockStorage()
@@ -51,3 +57,452 @@ func TestSyncService_SyncData_HTTPError(t *testing.T) {
 		t.Errorf("Expected 'sync failed: unauthorized' error, got %s", err.Error())
 	}
 }
+func newBindingSyncService(mockStorage *mocks.MockStorage, mockHTTP *mocks.MockHTTPClient) *client.SyncServiceImpl {
+	mockAuth := &mocks.MockAuthService{
+		Authenticated: true,
+		UserID:        "user-123",
+		Token:         "mock-token",
+	}
+	return client.NewSyncService(mockStorage, mockHTTP, &mocks.MockEncryptor{}, mockAuth)
+}
+func sealItem(t *testing.T, userID string, data models.StoredData, plaintext string) models.StoredData {
+	t.Helper()
+	sealed, err := (&mocks.MockEncryptor{}).EncryptWithAAD([]byte(plaintext), crypto.ItemAAD(userID, data.ID, string(data.Type), data.Version))
+	if err != nil {
+		t.Fatalf("EncryptWithAAD failed: %v", err)
+	}
+	data.Data = sealed
+	return data
+}
+func TestSyncService_SyncData_BindsUploads(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Title: "prod root ssh", Data: []byte("hello"), Version: 3, UpdatedAt: time.Now()})
+	mockHTTP := &mocks.MockHTTPClient{}
+	if err := newBindingSyncService(mockStorage, mockHTTP).SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mockHTTP.SyncedData) != 1 {
+		t.Fatalf("Expected one uploaded item, got %d", len(mockHTTP.SyncedData))
+	}
+	uploaded := mockHTTP.SyncedData[0]
+	aad := crypto.ItemAAD("user-123", "item-1", string(models.DataTypeSealed), 3)
+	if _, err := (&mocks.MockEncryptor{}).DecryptWithAAD(uploaded.Data, aad); err != nil {
+		t.Fatalf("Expected upload to be bound to the item, got %v", err)
+	}
+}
+func TestSyncService_SyncData_RejectsSwappedBlobs(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	first := models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Version: 1}
+	second := models.StoredData{ID: "item-2", UserID: "user-123", Type: models.DataTypeText, Version: 1}
+	good := sealItem(t, "user-123", first, "first")
+	swapped := second
+	swapped.Data = sealItem(t, "user-123", first, "first").Data
+	mockHTTP := &mocks.MockHTTPClient{ServerData: []models.StoredData{good, swapped}}
+	err := newBindingSyncService(mockStorage, mockHTTP).SyncData()
+	var rejected *client.RejectedItemsError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("Expected RejectedItemsError, got %v", err)
+	}
+	if len(rejected.Items) != 1 || rejected.Items[0].ID != "item-2" {
+		t.Fatalf("Expected only item-2 to be rejected, got %+v", rejected.Items)
+	}
+	if saved, _ := mockStorage.GetData("item-1"); saved == nil || string(saved.Data) != "first" {
+		t.Errorf("Expected item-1 to be saved, got %+v", saved)
+	}
+	if saved, _ := mockStorage.GetData("item-2"); saved != nil {
+		t.Error("Expected swapped item-2 not to be saved")
+	}
+}
+func TestSyncService_SyncData_RejectsRollback(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("new"), Version: 2})
+	old := sealItem(t, "user-123", models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Version: 1}, "old")
+	mockHTTP := &mocks.MockHTTPClient{ServerData: []models.StoredData{old}}
+	err := newBindingSyncService(mockStorage, mockHTTP).SyncData()
+	var rejected *client.RejectedItemsError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("Expected RejectedItemsError, got %v", err)
+	}
+	if saved, _ := mockStorage.GetData("item-1"); string(saved.Data) != "new" {
+		t.Errorf("Expected local item to keep its newer version, got %q", saved.Data)
+	}
+}
+func TestSyncService_SyncData_AcceptsLegacyBlobs(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	legacy := models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("encrypted:plain"), Version: 1}
+	mockHTTP := &mocks.MockHTTPClient{ServerData: []models.StoredData{legacy}}
+	if err := newBindingSyncService(mockStorage, mockHTTP).SyncData(); err != nil {
+		t.Fatalf("Expected legacy blob to be accepted, got %v", err)
+	}
+	if saved, _ := mockStorage.GetData("item-1"); saved == nil || string(saved.Data) != "plain" {
+		t.Errorf("Expected legacy item to be saved, got %+v", saved)
+	}
+}
+func TestSyncService_SyncData_RejectsLegacyBlobsAfterReseal(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveSyncState("user-123", &client.SyncState{Cursor: "3", Resealed: true})
+	item := &models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("mine"), Version: 1}
+	mockStorage.SaveData(item)
+	mockStorage.SaveSyncBase(item, true)
+	legacy := models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("encrypted:planted"), Version: 2}
+	mockHTTP := &mocks.MockHTTPClient{ServerData: []models.StoredData{legacy}}
+	err := newBindingSyncService(mockStorage, mockHTTP).SyncData()
+	var rejected *client.RejectedItemsError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("Expected RejectedItemsError, got %v", err)
+	}
+	if rejected.Items[0].Reason != "ciphertext does not belong to this item or version" {
+		t.Errorf("Expected a binding rejection, got %q", rejected.Items[0].Reason)
+	}
+	if saved, _ := mockStorage.GetData("item-1"); string(saved.Data) != "mine" {
+		t.Errorf("Expected the local copy to be kept, got %q", saved.Data)
+	}
+}
+func TestSyncService_SyncData_RejectsLegacyBlobsForBoundItems(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveSyncBase(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("mine"), Version: 1}, true)
+	legacy := models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("encrypted:planted"), Version: 2}
+	mockHTTP := &mocks.MockHTTPClient{ServerData: []models.StoredData{legacy}}
+	err := newBindingSyncService(mockStorage, mockHTTP).SyncData()
+	var rejected *client.RejectedItemsError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("Expected an item seen bound to refuse an unbound copy, got %v", err)
+	}
+}
+func TestSyncService_SyncData_HidesTitlesFromServer(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeBankCard, Title: "Chase online banking", Metadata: "personal", Data: []byte("card"), Version: 1, UpdatedAt: time.Now()})
+	mockHTTP := &mocks.MockHTTPClient{}
+	if err := newBindingSyncService(mockStorage, mockHTTP).SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	uploaded := mockHTTP.SyncedData[0]
+	if uploaded.Type != models.DataTypeSealed || uploaded.Title != "" || uploaded.Metadata != "" {
+		t.Fatalf("Expected type, title and metadata to be sealed, got %q %q %q", uploaded.Type, uploaded.Title, uploaded.Metadata)
+	}
+	if len(uploaded.Data) < 256 {
+		t.Errorf("Expected the envelope to be padded, got %d bytes", len(uploaded.Data))
+	}
+	otherDevice := mocks.NewMockStorage()
+	mockHTTP.ServerData = mockHTTP.SyncedData
+	if err := newBindingSyncService(otherDevice, mockHTTP).SyncData(); err != nil {
+		t.Fatalf("Expected sealed item to open, got %v", err)
+	}
+	saved, _ := otherDevice.GetData("item-1")
+	if saved == nil || saved.Title != "Chase online banking" || saved.Type != models.DataTypeBankCard || saved.Metadata != "personal" || string(saved.Data) != "card" {
+		t.Fatalf("Expected envelope fields to be restored, got %+v", saved)
+	}
+}
+func TestSyncService_SyncData_FollowsCursor(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("hello"), Version: 1, UpdatedAt: time.Now()})
+	mockHTTP := &mocks.MockHTTPClient{SyncCursor: "7"}
+	service := newBindingSyncService(mockStorage, mockHTTP)
+	if err := service.SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if mockHTTP.SentCursor != "" || len(mockHTTP.SyncedData) != 1 {
+		t.Fatalf("Expected the first sync to send no cursor and the local item, got %q and %d items", mockHTTP.SentCursor, len(mockHTTP.SyncedData))
+	}
+	mockHTTP.SyncCursor = "9"
+	if err := service.SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if mockHTTP.SentCursor != "7" {
+		t.Errorf("Expected the saved cursor to be sent, got %q", mockHTTP.SentCursor)
+	}
+	if len(mockHTTP.SyncedData) != 0 {
+		t.Errorf("Expected synced items not to be sent again, got %d", len(mockHTTP.SyncedData))
+	}
+	state, _ := mockStorage.GetSyncState("user-123")
+	if state.Cursor != "9" {
+		t.Errorf("Expected the new cursor to be saved, got %q", state.Cursor)
+	}
+}
+func TestSyncService_SyncData_PullsAfterUnkeptReplay(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("hello"), Version: 1, UpdatedAt: time.Now()})
+	mockHTTP := &mocks.MockHTTPClient{SyncFailOnce: fmt.Errorf("sync failed: %w", models.ErrIdempotencyNotKept)}
+	if err := newBindingSyncService(mockStorage, mockHTTP).SyncData(); err != nil {
+		t.Fatalf("Expected an applied sync to be pulled back, got %v", err)
+	}
+	if mockHTTP.SyncRequests != 2 || len(mockHTTP.SyncedData) != 0 {
+		t.Fatalf("Expected one more sync without changes, got %d requests sending %d items", mockHTTP.SyncRequests, len(mockHTTP.SyncedData))
+	}
+	if mockStorage.Dirty["item-1"] {
+		t.Error("Expected the applied change to be marked synced")
+	}
+	if saved, _ := mockStorage.GetData("item-1"); saved == nil || string(saved.Data) != "hello" {
+		t.Errorf("Expected the item to be kept, got %+v", saved)
+	}
+}
+func TestSyncService_SyncData_KeepsCursorOnRejection(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveSyncState("user-123", &client.SyncState{Cursor: "3"})
+	swapped := sealItem(t, "user-123", models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Version: 1}, "first")
+	swapped.ID = "item-2"
+	mockHTTP := &mocks.MockHTTPClient{ServerData: []models.StoredData{swapped}, SyncCursor: "5"}
+	err := newBindingSyncService(mockStorage, mockHTTP).SyncData()
+	var rejected *client.RejectedItemsError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("Expected RejectedItemsError, got %v", err)
+	}
+	if state, _ := mockStorage.GetSyncState("user-123"); state.Cursor != "3" {
+		t.Errorf("Expected the cursor to stay at 3, got %q", state.Cursor)
+	}
+}
+func TestSyncService_SyncData_RecordsConflicts(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("mine"), Version: 1, UpdatedAt: time.Now()})
+	mockStorage.SaveData(&models.StoredData{ID: "item-2", UserID: "user-123", Type: models.DataTypeText, Data: []byte("same"), Version: 1, UpdatedAt: time.Now()})
+	mockHTTP := &mocks.MockHTTPClient{Conflicts: []models.Conflict{
+		{LocalData: models.StoredData{ID: "item-1"}, ServerData: sealItem(t, "user-123", models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Version: 2}, "theirs"), Reason: "Server has newer version"},
+		{LocalData: models.StoredData{ID: "item-2"}, ServerData: sealItem(t, "user-123", models.StoredData{ID: "item-2", UserID: "user-123", Type: models.DataTypeText, Version: 2}, "same"), Reason: "Server has newer version"},
+	}}
+	service := newBindingSyncService(mockStorage, mockHTTP)
+	if err := service.SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if local, _ := mockStorage.GetData("item-1"); string(local.Data) != "mine" {
+		t.Errorf("Expected the local edit to be kept, got %q", local.Data)
+	}
+	conflict, _ := mockStorage.GetConflict("item-1")
+	if conflict == nil || string(conflict.Server.Data) != "theirs" || conflict.Server.Version != 2 {
+		t.Fatalf("Expected the server copy to be recorded, got %+v", conflict)
+	}
+	if open, _ := mockStorage.GetConflict("item-2"); open != nil {
+		t.Error("Expected identical copies not to be recorded as a conflict")
+	}
+	if local, _ := mockStorage.GetData("item-2"); local.Version != 2 {
+		t.Errorf("Expected the server version of an identical copy to be taken, got %d", local.Version)
+	}
+	// A newer server copy replaces the recorded one, not the local edit.
+	mockHTTP.Conflicts = nil
+	mockHTTP.ServerData = []models.StoredData{sealItem(t, "user-123", models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Version: 3}, "newer")}
+	if err := service.SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if local, _ := mockStorage.GetData("item-1"); string(local.Data) != "mine" {
+		t.Errorf("Expected the local edit to survive, got %q", local.Data)
+	}
+	if conflict, _ := mockStorage.GetConflict("item-1"); string(conflict.Server.Data) != "newer" || conflict.Server.Version != 3 {
+		t.Errorf("Expected the recorded server copy to be updated, got %+v", conflict)
+	}
+}
+func loginJSON(t *testing.T, data models.LoginPasswordData) string {
+	t.Helper()
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	return string(encoded)
+}
+func TestSyncService_SyncData_SendsBaseVersion(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	base := models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("base"), Version: 4}
+	mockStorage.SaveSyncBase(&base, true)
+	mockStorage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("edited"), Version: 4, UpdatedAt: time.Now()})
+	mockStorage.SaveSyncBase(&models.StoredData{ID: "item-2", UserID: "user-123", Type: models.DataTypeText, Data: []byte("same"), Version: 2}, true)
+	mockStorage.SaveData(&models.StoredData{ID: "item-2", UserID: "user-123", Type: models.DataTypeText, Data: []byte("same"), Version: 2, UpdatedAt: time.Now()})
+	mockHTTP := &mocks.MockHTTPClient{}
+	if err := newBindingSyncService(mockStorage, mockHTTP).SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mockHTTP.SyncedData) != 1 {
+		t.Fatalf("Expected only the edited item to be sent, got %d", len(mockHTTP.SyncedData))
+	}
+	if sent := mockHTTP.SyncedData[0]; sent.BaseVersion != 4 || sent.Version != 5 {
+		t.Errorf("Expected version 5 based on 4, got %d based on %d", sent.Version, sent.BaseVersion)
+	}
+	if base, _ := mockStorage.GetSyncBase("item-1"); base == nil || string(base.Data) != "edited" || base.Version != 5 {
+		t.Errorf("Expected the accepted change to become the base, got %+v", base)
+	}
+}
+func TestSyncService_SyncData_MergesDifferentFields(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	base := models.LoginPasswordData{Login: "alice", Password: "old", Notes: "old notes"}
+	mockStorage.SaveSyncBase(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeLoginPassword, Data: []byte(loginJSON(t, base)), Version: 1}, true)
+	local := base
+	local.Notes = "new notes"
+	mockStorage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeLoginPassword, Data: []byte(loginJSON(t, local)), Version: 1, UpdatedAt: time.Now()})
+	server := base
+	server.Password = "new"
+	mockHTTP := &mocks.MockHTTPClient{Conflicts: []models.Conflict{{
+		LocalData:  models.StoredData{ID: "item-1"},
+		ServerData: sealItem(t, "user-123", models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeLoginPassword, Version: 2}, loginJSON(t, server)),
+		Reason:     "Server has version 2, change was based on 1",
+	}}}
+	if err := newBindingSyncService(mockStorage, mockHTTP).SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if open, _ := mockStorage.GetConflict("item-1"); open != nil {
+		t.Fatalf("Expected edits to different fields to merge, got conflict %+v", open)
+	}
+	if len(mockHTTP.Updated) != 1 || mockHTTP.BaseVersions[0] != 2 || mockHTTP.Updated[0].Version != 3 {
+		t.Fatalf("Expected the merge to be pushed as version 3 on top of 2, got %v", mockHTTP.BaseVersions)
+	}
+	stored, _ := mockStorage.GetData("item-1")
+	var merged models.LoginPasswordData
+	json.Unmarshal(stored.Data, &merged)
+	if merged.Password != "new" || merged.Notes != "new notes" || merged.Login != "alice" {
+		t.Errorf("Unexpected merge: %+v", merged)
+	}
+	if base, _ := mockStorage.GetSyncBase("item-1"); base == nil || base.Version != 3 {
+		t.Errorf("Expected the merge to become the base, got %+v", base)
+	}
+}
+func TestSyncService_SyncData_SameFieldConflicts(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	base := models.LoginPasswordData{Login: "alice", Password: "old"}
+	mockStorage.SaveSyncBase(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeLoginPassword, Data: []byte(loginJSON(t, base)), Version: 1}, true)
+	local := base
+	local.Password = "mine"
+	mockStorage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeLoginPassword, Data: []byte(loginJSON(t, local)), Version: 1, UpdatedAt: time.Now()})
+	server := base
+	server.Password = "theirs"
+	mockHTTP := &mocks.MockHTTPClient{Conflicts: []models.Conflict{{
+		LocalData:  models.StoredData{ID: "item-1"},
+		ServerData: sealItem(t, "user-123", models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeLoginPassword, Version: 2}, loginJSON(t, server)),
+		Reason:     "Server has version 2, change was based on 1",
+	}}}
+	if err := newBindingSyncService(mockStorage, mockHTTP).SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if open, _ := mockStorage.GetConflict("item-1"); open == nil {
+		t.Fatal("Expected edits to the same field to be recorded as a conflict")
+	}
+	if len(mockHTTP.Updated) != 0 {
+		t.Error("Expected nothing to be pushed for a real conflict")
+	}
+}
+func TestSyncService_SyncData_ReplaysOutbox(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveData(&models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("queued"), Version: 1, UpdatedAt: time.Now()})
+	mockStorage.EnqueueMutation("user-123", &client.OutboxEntry{ItemID: "item-1", Op: client.OutboxAdd, IdempotencyKey: "key-add"})
+	mockStorage.EnqueueMutation("user-123", &client.OutboxEntry{ItemID: "item-2", Op: client.OutboxDelete, IdempotencyKey: "key-delete"})
+	// The server takes no writes but still serves changes: one to an item
+	// with a queued write, one to another item.
+	held := sealItem(t, "user-123", models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Version: 1}, "theirs")
+	pulled := sealItem(t, "user-123", models.StoredData{ID: "item-3", UserID: "user-123", Type: models.DataTypeText, Version: 1}, "pulled")
+	mockHTTP := &mocks.MockHTTPClient{Unreachable: errors.New("connection refused"), ServerData: []models.StoredData{held, pulled}, SyncCursor: "7"}
+	service := newBindingSyncService(mockStorage, mockHTTP)
+	if err := service.SyncData(); err == nil {
+		t.Fatal("Expected sync to fail while the queued writes cannot be sent")
+	}
+	// The pulled item, stored unsealed on the server, is sealed again in
+	// an update queued behind the blocked writes.
+	if len(mockStorage.Outbox) != 3 || mockStorage.Outbox[0].Attempts != 0 || mockStorage.Outbox[2].Op != client.OutboxUpdate || len(mockHTTP.SyncedData) != 0 {
+		t.Fatalf("Expected the queue to stay intact and nothing else to be sent, got %+v", mockStorage.Outbox)
+	}
+	if saved, _ := mockStorage.GetData("item-3"); saved == nil || string(saved.Data) != "pulled" {
+		t.Errorf("Expected server changes to be pulled while the queue is blocked, got %+v", saved)
+	}
+	if saved, _ := mockStorage.GetData("item-1"); saved == nil || string(saved.Data) != "queued" {
+		t.Errorf("Expected the queued local copy to be kept, got %+v", saved)
+	}
+	if state, _ := mockStorage.GetSyncState("user-123"); state.Cursor != "" {
+		t.Errorf("Expected the cursor to stay until the held back item is pulled again, got %q", state.Cursor)
+	}
+	mockHTTP.Unreachable, mockHTTP.ServerData = nil, nil
+	if err := service.SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mockHTTP.Keys) != 3 || mockHTTP.Keys[0] != "key-add" || mockHTTP.Keys[1] != "key-delete" || mockHTTP.Keys[2] == "" {
+		t.Fatalf("Expected queued writes replayed in order with their keys, got %v", mockHTTP.Keys)
+	}
+	if len(mockStorage.Outbox) != 0 || len(mockHTTP.Updated) != 1 || mockHTTP.Updated[0].ID != "item-3" {
+		t.Errorf("Expected an empty queue, got %d", len(mockStorage.Outbox))
+	}
+	if len(mockHTTP.SyncedData) != 0 {
+		t.Errorf("Expected the replayed items not to be sent again, got %d", len(mockHTTP.SyncedData))
+	}
+	firstKey := mockHTTP.SyncKey
+	if err := service.SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if firstKey == "" || mockHTTP.SyncKey == "" || mockHTTP.SyncKey == firstKey {
+		t.Errorf("Expected each sync sent with a key of its own, got %q and %q", firstKey, mockHTTP.SyncKey)
+	}
+}
+func TestSyncService_SyncData_ResealsLegacyItems(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveSyncState("user-123", &client.SyncState{Cursor: "4"})
+	legacy := models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Title: "Chase online banking", Metadata: "personal", Data: []byte("encrypted:plain"), Version: 1}
+	mockHTTP := &mocks.MockHTTPClient{ServerData: []models.StoredData{legacy}, SyncCursor: "6"}
+	service := newBindingSyncService(mockStorage, mockHTTP)
+	if err := service.SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if mockHTTP.SentCursor != "" {
+		t.Errorf("Expected the first sync after the upgrade to fetch everything, got cursor %q", mockHTTP.SentCursor)
+	}
+	if len(mockHTTP.Updated) != 1 || mockHTTP.BaseVersions[0] != 1 {
+		t.Fatalf("Expected the legacy item to be sent again on top of version 1, got %d updates", len(mockHTTP.Updated))
+	}
+	pushed := mockHTTP.Updated[0]
+	if pushed.Type != models.DataTypeSealed || pushed.Title != "" || pushed.Metadata != "" || pushed.Version != 2 {
+		t.Fatalf("Expected the server row to be sealed as version 2, got %q %q %q v%d", pushed.Type, pushed.Title, pushed.Metadata, pushed.Version)
+	}
+	if saved, _ := mockStorage.GetData("item-1"); saved == nil || saved.Title != "Chase online banking" || saved.Version != 2 {
+		t.Errorf("Expected the local copy to keep its title at version 2, got %+v", saved)
+	}
+	mockHTTP.ServerData = nil
+	if err := service.SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if mockHTTP.SentCursor != "6" {
+		t.Errorf("Expected the cursor to be followed once everything is sealed, got %q", mockHTTP.SentCursor)
+	}
+}
+func TestSyncService_SyncData_ResealsOnceDespiteRejections(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	mockStorage.SaveSyncState("user-123", &client.SyncState{Cursor: "4"})
+	legacy := models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Data: []byte("encrypted:plain"), Version: 1}
+	swapped := sealItem(t, "user-123", models.StoredData{ID: "item-2", UserID: "user-123", Type: models.DataTypeText, Version: 1}, "second")
+	swapped.ID = "item-3"
+	mockHTTP := &mocks.MockHTTPClient{ServerData: []models.StoredData{legacy, swapped}, SyncCursor: "6"}
+	service := newBindingSyncService(mockStorage, mockHTTP)
+	var rejected *client.RejectedItemsError
+	if err := service.SyncData(); !errors.As(err, &rejected) {
+		t.Fatalf("Expected RejectedItemsError, got %v", err)
+	}
+	if len(mockHTTP.Updated) != 1 {
+		t.Fatalf("Expected the legacy item to be resealed, got %d updates", len(mockHTTP.Updated))
+	}
+	state, _ := mockStorage.GetSyncState("user-123")
+	if !state.Resealed || state.Cursor != "4" {
+		t.Fatalf("Expected the reseal to be recorded and the cursor kept, got %+v", state)
+	}
+	mockHTTP.ServerData = []models.StoredData{swapped}
+	if err := service.SyncData(); !errors.As(err, &rejected) {
+		t.Fatalf("Expected RejectedItemsError, got %v", err)
+	}
+	if mockHTTP.SentCursor != "4" {
+		t.Errorf("Expected the next sync to follow the cursor, got %q", mockHTTP.SentCursor)
+	}
+}
+func TestSyncService_SyncData_SendsDirtyItemsOnly(t *testing.T) {
+	mockStorage := mocks.NewMockStorage()
+	pulled := sealItem(t, "user-123", models.StoredData{ID: "item-1", UserID: "user-123", Type: models.DataTypeText, Version: 1}, "theirs")
+	mockHTTP := &mocks.MockHTTPClient{ServerData: []models.StoredData{pulled}}
+	service := newBindingSyncService(mockStorage, mockHTTP)
+	if err := service.SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// An edit stamped by a clock far behind the last sync still goes out,
+	// and the item pulled above does not.
+	mockHTTP.ServerData = nil
+	mockStorage.SaveData(&models.StoredData{ID: "item-2", UserID: "user-123", Type: models.DataTypeText, Data: []byte("mine"), Version: 1, UpdatedAt: time.Now().Add(-24 * time.Hour)})
+	if err := service.SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mockHTTP.SyncedData) != 1 || mockHTTP.SyncedData[0].ID != "item-2" {
+		t.Fatalf("Expected only the local edit to be sent, got %+v", mockHTTP.SyncedData)
+	}
+	if err := service.SyncData(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(mockHTTP.SyncedData) != 0 {
+		t.Errorf("Expected nothing to be sent once the server has it, got %d", len(mockHTTP.SyncedData))
+	}
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"gophkeeper/internal/client"
+	"gophkeeper/internal/client/tests/mocks"
+)
+
+func newTestVault(storage *mocks.MockStorage, prompter *mocks.MockPrompter, legacyKey string) *client.VaultImpl {
+	auth := &mocks.MockAuthService{Authenticated: true, UserID: "user-123", Token: "mock-token"}
+	return client.NewVault(storage, auth, prompter, client.VaultOptions{
+		LegacyKey:      legacyKey,
+		KDFTime:        1,
+		KDFMemory:      1024,
+		KDFParallelism: 1,
+	})
+}
+func TestVault_CreateAndUnlock(t *testing.T) {
+	storage := mocks.NewMockStorage()
+	vault := newTestVault(storage, &mocks.MockPrompter{Passwords: []string{"master-pass", "master-pass"}}, "")
+	params, err := vault.Create()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := vault.Persist("user-123", params); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	encrypted, err := vault.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	reopened := newTestVault(storage, &mocks.MockPrompter{Passwords: []string{"master-pass"}}, "")
+	decrypted, err := reopened.Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypt after lazy unlock failed: %v", err)
+	}
+	if string(decrypted) != "secret" {
+		t.Errorf("Expected 'secret', got %s", string(decrypted))
+	}
+}
+func TestVault_WrongMasterPassword(t *testing.T) {
+	storage := mocks.NewMockStorage()
+	vault := newTestVault(storage, &mocks.MockPrompter{Passwords: []string{"master-pass", "master-pass"}}, "")
+	params, _ := vault.Create()
+	_ = vault.Persist("user-123", params)
+	reopened := newTestVault(storage, &mocks.MockPrompter{Passwords: []string{"wrong-pass"}}, "")
+	_, err := reopened.Encrypt([]byte("secret"))
+	if !errors.Is(err, client.ErrWrongMasterPassword) {
+		t.Fatalf("Expected wrong master password error, got %v", err)
+	}
+}
+func TestVault_CreatesMissingKeyCheck(t *testing.T) {
+	storage := mocks.NewMockStorage()
+	vault := newTestVault(storage, &mocks.MockPrompter{Passwords: []string{"master-pass", "master-pass"}}, "")
+	params, _ := vault.Create()
+	params.KeyCheck = nil
+	_ = vault.Persist("user-123", params)
+	mismatch := newTestVault(storage, &mocks.MockPrompter{Passwords: []string{"master-pass", "typo-pass"}}, "")
+	if _, err := mismatch.Encrypt([]byte("secret")); err == nil {
+		t.Fatal("Expected an unconfirmed password to be refused")
+	}
+	first := newTestVault(storage, &mocks.MockPrompter{Passwords: []string{"master-pass", "master-pass"}}, "")
+	if _, err := first.Encrypt([]byte("secret")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	stored, _ := storage.GetKDFParams("user-123")
+	if len(stored.KeyCheck) == 0 {
+		t.Fatal("Expected the key check to be created on first unlock")
+	}
+	wrong := newTestVault(storage, &mocks.MockPrompter{Passwords: []string{"wrong-pass"}}, "")
+	if _, err := wrong.Encrypt([]byte("secret")); !errors.Is(err, client.ErrWrongMasterPassword) {
+		t.Errorf("Expected wrong master password error, got %v", err)
+	}
+}
+func TestVault_CreateRequiresConfirmation(t *testing.T) {
+	vault := newTestVault(mocks.NewMockStorage(), &mocks.MockPrompter{Passwords: []string{"master-pass", "other-pass"}}, "")
+	if _, err := vault.Create(); err == nil {
+		t.Fatal("Expected error when confirmation does not match")
+	}
+}
+func TestVault_NotInitialized(t *testing.T) {
+	vault := newTestVault(mocks.NewMockStorage(), &mocks.MockPrompter{}, "")
+	if _, err := vault.Encrypt([]byte("secret")); err == nil {
+		t.Fatal("Expected error when no kdf parameters are stored")
+	}
+}
@@ -30,10 +30,30 @@ func (t *TokenManagerImpl) LoadToken() (string, error) {
 	}
 	return string(data), nil
 }
+func (t *TokenManagerImpl) SaveRefreshToken(token string) error {
+	tokenFile := filepath.Join(t.configDir, "refresh_token")
+	if err := os.WriteFile(tokenFile, []byte(token), 0600); err != nil {
+		return fmt.Errorf("failed to save refresh token: %w", err)
+	}
+	return nil
+}
+func (t *TokenManagerImpl) LoadRefreshToken() (string, error) {
+	tokenFile := filepath.Join(t.configDir, "refresh_token")
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to load refresh token: %w", err)
+	}
+	return string(data), nil
+}
 func (t *TokenManagerImpl) ClearToken() error {
-	tokenFile := filepath.Join(t.configDir, "token")
-	if err := os.Remove(tokenFile); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to clear token: %w", err)
+	for _, name := range []string{"token", "refresh_token"} {
+		tokenFile := filepath.Join(t.configDir, name)
+		if err := os.Remove(tokenFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to clear token: %w", err)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,280 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+
+var ErrWrongMasterPassword = errors.New("wrong master password")
+
+type VaultOptions struct {
+	MasterPassword string
+	LegacyKey      string
+	KDFTime        uint32
+	KDFMemory      uint32
+	KDFParallelism uint8
+}
+
+// VaultImpl holds the master-password derived key in memory. The key is
+// derived lazily, so commands that never touch vault items never prompt.
+type VaultImpl struct {
+	keys        KeyStore
+	authService AuthService
+	prompter    Prompter
+	opts        VaultOptions
+	legacy      *crypto.Encryptor
+	encryptor   *crypto.Encryptor
+}
+
+func NewVault(keys KeyStore, authService AuthService, prompter Prompter, opts VaultOptions) *VaultImpl {
+	v := &VaultImpl{
+		keys:        keys,
+		authService: authService,
+		prompter:    prompter,
+		opts:        opts,
+	}
+	if opts.LegacyKey != "" {
+		v.legacy = crypto.NewEncryptor(opts.LegacyKey)
+	}
+	return v
+}
+func (v *VaultImpl) Create() (*models.KDFParams, error) {
+	password, err := v.readNewPassword()
+	if err != nil {
+		return nil, err
+	}
+	params, err := crypto.NewKDFParams(v.opts.KDFTime, v.opts.KDFMemory, v.opts.KDFParallelism)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate kdf parameters: %w", err)
+	}
+	key, err := crypto.DeriveKey(password, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive vault key: %w", err)
+	}
+	params.KeyCheck = crypto.KeyCheck(key)
+	if err := v.setKey(key); err != nil {
+		return nil, err
+	}
+	logger.Info("New vault key derived")
+	return params, nil
+}
+func (v *VaultImpl) Unlock(userID string, params *models.KDFParams) error {
+	password, err := v.readPassword("Master password: ")
+	if err != nil {
+		return err
+	}
+	key, err := crypto.DeriveKey(password, params)
+	if err != nil {
+		return fmt.Errorf("failed to derive vault key: %w", err)
+	}
+	if len(params.KeyCheck) == 0 {
+		// Vaults created before key checks: the password is confirmed once
+		// and the check created, so later typos are caught.
+		if err := v.confirmPassword(password); err != nil {
+			return err
+		}
+		params.KeyCheck = crypto.KeyCheck(key)
+		logger.Info("Created key check for the vault of user %s", userID)
+	} else if !crypto.VerifyKeyCheck(key, params.KeyCheck) {
+		return ErrWrongMasterPassword
+	}
+	if err := v.setKey(key); err != nil {
+		return err
+	}
+	if err := v.Persist(userID, params); err != nil {
+		return err
+	}
+	logger.Debug("Vault unlocked for user %s", userID)
+	return nil
+}
+func (v *VaultImpl) Persist(userID string, params *models.KDFParams) error {
+	if err := v.keys.SaveKDFParams(userID, params); err != nil {
+		return fmt.Errorf("failed to save kdf parameters: %w", err)
+	}
+	return nil
+}
+// NewKey derives a replacement key from a freshly prompted master password
+// without switching to it. The environment master password is ignored,
+// since it holds the password being replaced.
+func (v *VaultImpl) NewKey() (*models.KDFParams, *crypto.Encryptor, error) {
+	password, err := v.promptNewPassword()
+	if err != nil {
+		return nil, nil, err
+	}
+	params, err := crypto.NewKDFParams(v.opts.KDFTime, v.opts.KDFMemory, v.opts.KDFParallelism)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate kdf parameters: %w", err)
+	}
+	key, err := crypto.DeriveKey(password, params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to derive vault key: %w", err)
+	}
+	params.KeyCheck = crypto.KeyCheck(key)
+	encryptor, err := crypto.NewEncryptorFromKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize vault key: %w", err)
+	}
+	return params, encryptor, nil
+}
+
+// ResumeKey derives the key of an unfinished re-key again from the new
+// master password.
+func (v *VaultImpl) ResumeKey(params *models.KDFParams) (*crypto.Encryptor, error) {
+	password, err := v.prompter.ReadPassword("New master password: ")
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.DeriveKey(password, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive vault key: %w", err)
+	}
+	if !crypto.VerifyKeyCheck(key, params.KeyCheck) {
+		return nil, ErrWrongMasterPassword
+	}
+	return crypto.NewEncryptorFromKey(key)
+}
+
+// UseKey switches to a key returned by NewKey or ResumeKey once every item
+// has been re-encrypted with it.
+func (v *VaultImpl) UseKey(encryptor *crypto.Encryptor) {
+	v.encryptor = encryptor
+}
+// WrapKey encrypts the vault key under kek for the recovery slot.
+func (v *VaultImpl) WrapKey(kek *crypto.Encryptor, aad []byte) ([]byte, error) {
+	if err := v.ensureUnlocked(); err != nil {
+		return nil, err
+	}
+	return v.encryptor.WrapKey(kek, aad)
+}
+// KeyCheck returns the check of the key the vault is unlocked with, or nil
+// while it is locked.
+func (v *VaultImpl) KeyCheck() []byte {
+	if v.encryptor == nil {
+		return nil
+	}
+	return v.encryptor.KeyCheck()
+}
+func (v *VaultImpl) Lock() {
+	v.encryptor = nil
+}
+func (v *VaultImpl) Encrypt(data []byte) ([]byte, error) {
+	if err := v.ensureUnlocked(); err != nil {
+		return nil, err
+	}
+	return v.encryptor.Encrypt(data)
+}
+func (v *VaultImpl) Decrypt(data []byte) ([]byte, error) {
+	if err := v.ensureUnlocked(); err != nil {
+		return nil, err
+	}
+	plaintext, err := v.encryptor.Decrypt(data)
+	if err != nil && v.legacy != nil {
+		if legacyPlaintext, legacyErr := v.legacy.Decrypt(data); legacyErr == nil {
+			return legacyPlaintext, nil
+		}
+	}
+	return plaintext, err
+}
+func (v *VaultImpl) EncryptWithAAD(data, aad []byte) ([]byte, error) {
+	if err := v.ensureUnlocked(); err != nil {
+		return nil, err
+	}
+	return v.encryptor.EncryptWithAAD(data, aad)
+}
+
+// DecryptWithAAD opens item blobs. Unbound blobs predate associated data
+// and may still be sealed with the legacy key.
+func (v *VaultImpl) DecryptWithAAD(data, aad []byte) ([]byte, error) {
+	if !crypto.IsBound(data) {
+		return v.Decrypt(data)
+	}
+	if err := v.ensureUnlocked(); err != nil {
+		return nil, err
+	}
+	return v.encryptor.DecryptWithAAD(data, aad)
+}
+
+// DecryptBound opens only blobs sealed with associated data.
+func (v *VaultImpl) DecryptBound(data, aad []byte) ([]byte, error) {
+	if err := v.ensureUnlocked(); err != nil {
+		return nil, err
+	}
+	return v.encryptor.DecryptBound(data, aad)
+}
+func (v *VaultImpl) ensureUnlocked() error {
+	if v.encryptor != nil {
+		return nil
+	}
+	userID := v.authService.GetUserID()
+	if userID == "" {
+		return fmt.Errorf("not authenticated")
+	}
+	params, err := v.keys.GetKDFParams(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrKDFNotConfigured) {
+			return fmt.Errorf("vault is not initialized on this device, please log in again")
+		}
+		return fmt.Errorf("failed to load kdf parameters: %w", err)
+	}
+	return v.Unlock(userID, params)
+}
+func (v *VaultImpl) setKey(key []byte) error {
+	encryptor, err := crypto.NewEncryptorFromKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to initialize vault key: %w", err)
+	}
+	v.encryptor = encryptor
+	return nil
+}
+func (v *VaultImpl) readPassword(prompt string) (string, error) {
+	if v.opts.MasterPassword != "" {
+		return v.opts.MasterPassword, nil
+	}
+	password, err := v.prompter.ReadPassword(prompt)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", fmt.Errorf("master password is required")
+	}
+	return password, nil
+}
+func (v *VaultImpl) confirmPassword(password string) error {
+	if v.opts.MasterPassword != "" {
+		return nil
+	}
+	confirm, err := v.prompter.ReadPassword("Repeat master password: ")
+	if err != nil {
+		return err
+	}
+	if confirm != password {
+		return fmt.Errorf("master passwords do not match")
+	}
+	return nil
+}
+func (v *VaultImpl) readNewPassword() (string, error) {
+	if v.opts.MasterPassword != "" {
+		return v.opts.MasterPassword, nil
+	}
+	return v.promptNewPassword()
+}
+func (v *VaultImpl) promptNewPassword() (string, error) {
+	password, err := v.prompter.ReadPassword("New master password: ")
+	if err != nil {
+		return "", err
+	}
+	if len(password) < 8 {
+		return "", fmt.Errorf("master password must be at least 8 characters long")
+	}
+	confirm, err := v.prompter.ReadPassword("Repeat master password: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != password {
+		return "", fmt.Errorf("master passwords do not match")
+	}
+	return password, nil
+}
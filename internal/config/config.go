@@ -5,30 +5,89 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type ServerConfig struct {
-	Port          string
-	DBHost        string
-	DBPort        string
-	DBUser        string
-	DBPassword    string
-	DBName        string
-	JWTSecret     string
-	EncryptionKey string
+	Port       string
+	DBHost     string
+	DBPort     string
+	DBUser     string
+	DBPassword string
+	DBName     string
+	JWTSecret  string
+	// AuditKey keys the audit log hashes; AuditPreviousKeys, from a
+	// comma-separated AUDIT_PREVIOUS_KEYS, check events written under
+	// replaced keys.
+	AuditKey          string
+	AuditPreviousKeys []string
+	// JWT signing: EdDSA or ES256 keys, rotated every JWTKeyRotation, with
+	// the issuer and audience written into and required from every token.
+	JWTSigningAlg  string
+	JWTKeyRotation time.Duration
+	JWTIssuer      string
+	JWTAudience    string
+	EncryptionKey  string
+	ZeroKnowledge  bool
+	// MasterKeyFile points at a JSON keyfile with versioned master keys. When
+	// empty, a single master key is derived from EncryptionKey.
+	MasterKeyFile       string
+	KeyRotationInterval time.Duration
+	KeyRotationBatch    int
+	// Argon2id costs for account password hashes; stored hashes with other
+	// costs are upgraded on the next successful login.
+	PasswordHashTime        uint32
+	PasswordHashMemory      uint32
+	PasswordHashParallelism uint8
+	AccessTokenTTL          time.Duration
+	RefreshTokenTTL         time.Duration
+	// LoginThrottleStore is "memory" for a single instance or "postgres" to
+	// share login failure counters between instances.
+	LoginThrottleStore   string
+	LoginMaxFailures     int
+	LoginLockoutDuration time.Duration
+	LoginBackoffMax      time.Duration
+	// TrustForwardedFor takes the client IP from X-Forwarded-For, for
+	// servers behind a reverse proxy.
+	TrustForwardedFor bool
+	// AdminToken enables the admin API; it is disabled when empty.
+	AdminToken string
+	// IdempotencyKeyTTL is how long responses to requests sent with an
+	// Idempotency-Key are replayed to retries.
+	IdempotencyKeyTTL time.Duration
+	// OIDCProviders lists the identity providers accepted for single
+	// sign-on, from OIDC_PROVIDERS as "issuer|client_id" pairs.
+	OIDCProviders []OIDCProviderConfig
 	LogLevel      string
 	LogFile       string
 }
+type OIDCProviderConfig struct {
+	Issuer   string
+	ClientID string
+}
 type ClientConfig struct {
-	ServerURL     string
-	ConfigDir     string
-	EncryptionKey string
-	LogLevel      string
-	LogFile       string
+	ServerURL           string
+	ConfigDir           string
+	LegacyEncryptionKey string
+	MasterPassword      string
+	KDFTime             uint32
+	KDFMemory           uint32
+	KDFParallelism      uint8
+	DeviceName          string
+	APIToken            string
+	LogLevel            string
+	LogFile             string
 }
 
+const legacyDefaultEncryptionKey = "your-encryption-key"
+
+// ExampleAuditKey is the AUDIT_KEY of .env.example, which the server
+// refuses.
+const ExampleAuditKey = "your-audit-key-change-this-in-production"
+
 func LoadEnv() {
 	_ = godotenv.Load()
 }
@@ -39,22 +98,70 @@ func getenv(key, def string) string {
 	return def
 }
 func LoadServerConfig() ServerConfig {
+	cfg := loadServerConfig()
+	applyEncryptionKeyDefault(&cfg)
+	return cfg
+}
+func loadServerConfig() ServerConfig {
 	LoadEnv()
 	return ServerConfig{
-		Port:          getenv("SERVER_PORT", "8080"),
-		DBHost:        getenv("DB_HOST", "localhost"),
-		DBPort:        getenv("DB_PORT", "5432"),
-		DBUser:        getenv("DB_USER", "gophkeeper"),
-		DBPassword:    getenv("DB_PASSWORD", "password"),
-		DBName:        getenv("DB_NAME", "gophkeeper"),
-		JWTSecret:     getenv("JWT_SECRET", "your-secret-key"),
-		EncryptionKey: getenv("ENCRYPTION_KEY", "your-encryption-key"),
-		LogLevel:      getenv("LOG_LEVEL", "INFO"),
-		LogFile:       getenv("LOG_FILE", "logs/app.log"),
+		Port:                    getenv("SERVER_PORT", "8080"),
+		DBHost:                  getenv("DB_HOST", "localhost"),
+		DBPort:                  getenv("DB_PORT", "5432"),
+		DBUser:                  getenv("DB_USER", "gophkeeper"),
+		DBPassword:              getenv("DB_PASSWORD", "password"),
+		DBName:                  getenv("DB_NAME", "gophkeeper"),
+		JWTSecret:               getenv("JWT_SECRET", "your-secret-key"),
+		AuditKey:                getenv("AUDIT_KEY", ""),
+		AuditPreviousKeys:       splitList(getenv("AUDIT_PREVIOUS_KEYS", "")),
+		JWTSigningAlg:           getenv("JWT_SIGNING_ALG", "EdDSA"),
+		JWTKeyRotation:          GetDuration("JWT_KEY_ROTATION_INTERVAL", 30*24*time.Hour),
+		JWTIssuer:               getenv("JWT_ISSUER", "gophkeeper"),
+		JWTAudience:             getenv("JWT_AUDIENCE", "gophkeeper-api"),
+		EncryptionKey:           getenv("ENCRYPTION_KEY", ""),
+		ZeroKnowledge:           GetBool("ZERO_KNOWLEDGE", false),
+		MasterKeyFile:           getenv("MASTER_KEY_FILE", ""),
+		KeyRotationInterval:     GetDuration("KEY_ROTATION_INTERVAL", 10*time.Minute),
+		KeyRotationBatch:        int(GetUint("KEY_ROTATION_BATCH", 100)),
+		PasswordHashTime:        uint32(GetUint("PASSWORD_HASH_TIME", 2)),
+		PasswordHashMemory:      uint32(GetUint("PASSWORD_HASH_MEMORY_KB", 19*1024)),
+		PasswordHashParallelism: uint8(GetUint("PASSWORD_HASH_PARALLELISM", 1)),
+		AccessTokenTTL:          GetDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:         GetDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		LoginThrottleStore:      getenv("LOGIN_THROTTLE_STORE", "memory"),
+		LoginMaxFailures:        int(GetUint("LOGIN_MAX_FAILURES", 10)),
+		LoginLockoutDuration:    GetDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		LoginBackoffMax:         GetDuration("LOGIN_BACKOFF_MAX", 5*time.Minute),
+		TrustForwardedFor:       GetBool("TRUST_FORWARDED_FOR", false),
+		AdminToken:              getenv("ADMIN_TOKEN", ""),
+		IdempotencyKeyTTL:       GetDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		OIDCProviders:           parseOIDCProviders(getenv("OIDC_PROVIDERS", "")),
+		LogLevel:                getenv("LOG_LEVEL", "INFO"),
+		LogFile:                 getenv("LOG_FILE", "logs/app.log"),
 	}
 }
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+func parseOIDCProviders(value string) []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, entry := range strings.Split(value, ",") {
+		issuer, clientID, ok := strings.Cut(strings.TrimSpace(entry), "|")
+		if !ok || issuer == "" || clientID == "" {
+			continue
+		}
+		providers = append(providers, OIDCProviderConfig{Issuer: issuer, ClientID: clientID})
+	}
+	return providers
+}
 func LoadServerConfigWithFlags() ServerConfig {
-	cfg := LoadServerConfig()
+	cfg := loadServerConfig()
 	var (
 		port       = flag.String("port", "", "Server port (override env)")
 		dbHost     = flag.String("db-host", "", "Database host")
@@ -63,7 +170,10 @@ func LoadServerConfigWithFlags() ServerConfig {
 		dbPassword = flag.String("db-password", "", "Database password")
 		dbName     = flag.String("db-name", "", "Database name")
 		jwtSecret  = flag.String("jwt-secret", "", "JWT secret key")
+		auditKey   = flag.String("audit-key", "", "Audit log key")
 		encKey     = flag.String("encryption-key", "", "Data encryption key")
+		zk         = flag.Bool("zero-knowledge", cfg.ZeroKnowledge, "Store client ciphertext as-is and never decrypt it")
+		keyFile    = flag.String("master-key-file", "", "Path to the master key file")
 	)
 	flag.Parse()
 	if *port != "" {
@@ -87,21 +197,44 @@ func LoadServerConfigWithFlags() ServerConfig {
 	if *jwtSecret != "" {
 		cfg.JWTSecret = *jwtSecret
 	}
+	if *auditKey != "" {
+		cfg.AuditKey = *auditKey
+	}
 	if *encKey != "" {
 		cfg.EncryptionKey = *encKey
 	}
+	if *keyFile != "" {
+		cfg.MasterKeyFile = *keyFile
+	}
+	cfg.ZeroKnowledge = *zk
+	applyEncryptionKeyDefault(&cfg)
 	return cfg
 }
+
+// In zero-knowledge mode the key is only needed to unwrap rows written
+// before the mode was enabled, so there is no implicit default.
+func applyEncryptionKeyDefault(cfg *ServerConfig) {
+	if cfg.EncryptionKey == "" && !cfg.ZeroKnowledge {
+		cfg.EncryptionKey = legacyDefaultEncryptionKey
+	}
+}
 func LoadClientConfig() ClientConfig {
 	LoadEnv()
 	home, _ := os.UserHomeDir()
 	defaultDir := filepath.Join(home, ".gophkeeper")
+	hostname, _ := os.Hostname()
 	return ClientConfig{
-		ServerURL:     getenv("CLIENT_SERVER_URL", "http://localhost:8080"),
-		ConfigDir:     getenv("CLIENT_CONFIG_DIR", defaultDir),
-		EncryptionKey: getenv("ENCRYPTION_KEY", "your-encryption-key"),
-		LogLevel:      getenv("LOG_LEVEL", "INFO"),
-		LogFile:       getenv("LOG_FILE", "logs/client.log"),
+		ServerURL:           getenv("CLIENT_SERVER_URL", "http://localhost:8080"),
+		ConfigDir:           getenv("CLIENT_CONFIG_DIR", defaultDir),
+		LegacyEncryptionKey: getenv("ENCRYPTION_KEY", ""),
+		MasterPassword:      getenv("GOPHKEEPER_MASTER_PASSWORD", ""),
+		KDFTime:             uint32(GetUint("KDF_TIME", 3)),
+		KDFMemory:           uint32(GetUint("KDF_MEMORY_KB", 64*1024)),
+		KDFParallelism:      uint8(GetUint("KDF_PARALLELISM", 4)),
+		DeviceName:          getenv("GOPHKEEPER_DEVICE_NAME", hostname),
+		APIToken:            getenv("GOPHKEEPER_API_TOKEN", ""),
+		LogLevel:            getenv("LOG_LEVEL", "INFO"),
+		LogFile:             getenv("LOG_FILE", "logs/client.log"),
 	}
 }
 func LoadClientConfigWithFlags() ClientConfig {
@@ -128,3 +261,21 @@ func GetBool(key string, def bool) bool {
 	}
 	return def
 }
+func GetUint(key string, def uint64) uint64 {
+	if v := os.Getenv(key); v != "" {
+		u, err := strconv.ParseUint(v, 10, 32)
+		if err == nil {
+			return u
+		}
+	}
+	return def
+}
+func GetDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			return d
+		}
+	}
+	return def
+}
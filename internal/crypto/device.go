@@ -0,0 +1,37 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+)
+
+const deviceProofContext = "gophkeeper-device-v1"
+
+// NewDeviceKey generates the Ed25519 key pair that identifies a client
+// device to the server.
+func NewDeviceKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate device key: %w", err)
+	}
+	return public, private, nil
+}
+
+// SignDeviceProof binds a device to a login session. deviceID is empty when
+// the device registers for the first time.
+func SignDeviceProof(key ed25519.PrivateKey, deviceID, sessionID string) []byte {
+	return ed25519.Sign(key, deviceProofMessage(deviceID, sessionID))
+}
+
+// VerifyDeviceProof checks a signature made by SignDeviceProof.
+func VerifyDeviceProof(publicKey []byte, deviceID, sessionID string, signature []byte) bool {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(publicKey, deviceProofMessage(deviceID, sessionID), signature)
+}
+
+func deviceProofMessage(deviceID, sessionID string) []byte {
+	return []byte(deviceProofContext + "\x00" + deviceID + "\x00" + sessionID)
+}
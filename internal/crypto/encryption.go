@@ -1,14 +1,27 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 )
 
+// Bound ciphertexts start with a header naming the format, so blobs written
+// before associated data was used still decrypt:
+//
+//	v1: "GK" 0x01 | nonce | AES-256-GCM(plaintext, aad)
+var boundHeaderV1 = []byte{'G', 'K', 0x01}
+
+// ErrBindingMismatch means a bound ciphertext was presented with different
+// associated data than it was sealed with, or was tampered with.
+var ErrBindingMismatch = errors.New("ciphertext does not match its item binding")
+
 type Encryptor struct {
 	key []byte
 }
@@ -20,6 +33,16 @@ func NewEncryptor(key string) *Encryptor {
 	}
 }
 
+// NewEncryptorFromKey uses a raw 32-byte key, e.g. one produced by DeriveKey.
+func NewEncryptorFromKey(key []byte) (*Encryptor, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key length: expected 32 bytes, got %d", len(key))
+	}
+	k := make([]byte, len(key))
+	copy(k, key)
+	return &Encryptor{key: k}, nil
+}
+
 // Encrypt encrypts the given data using AES-256-GCM.
 func (e *Encryptor) Encrypt(plaintext []byte) ([]byte, error) {
 	block, err := aes.NewCipher(e.key)
@@ -57,3 +80,90 @@ func (e *Encryptor) Decrypt(ciphertext []byte) ([]byte, error) {
 	}
 	return plaintext, nil
 }
+
+// EncryptWithAAD encrypts plaintext bound to aad and prefixes the versioned
+// header. Decrypting it with any other aad fails.
+func (e *Encryptor) EncryptWithAAD(plaintext, aad []byte) ([]byte, error) {
+	gcm, err := e.gcm()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	out := make([]byte, 0, len(boundHeaderV1)+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, boundHeaderV1...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, aad), nil
+}
+
+// DecryptWithAAD opens a ciphertext from EncryptWithAAD, or a headerless
+// one from Encrypt, which carries no binding.
+func (e *Encryptor) DecryptWithAAD(ciphertext, aad []byte) ([]byte, error) {
+	if !IsBound(ciphertext) {
+		return e.Decrypt(ciphertext)
+	}
+	plaintext, err := e.DecryptBound(ciphertext, aad)
+	if errors.Is(err, ErrBindingMismatch) {
+		// A legacy nonce may start with the header by chance.
+		if legacy, legacyErr := e.Decrypt(ciphertext); legacyErr == nil {
+			return legacy, nil
+		}
+	}
+	return plaintext, err
+}
+
+// DecryptBound opens only a ciphertext from EncryptWithAAD. Anything
+// without the header, or sealed under other associated data, fails with
+// ErrBindingMismatch.
+func (e *Encryptor) DecryptBound(ciphertext, aad []byte) ([]byte, error) {
+	if !IsBound(ciphertext) {
+		return nil, ErrBindingMismatch
+	}
+	gcm, err := e.gcm()
+	if err != nil {
+		return nil, err
+	}
+	body := ciphertext[len(boundHeaderV1):]
+	if len(body) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, sealed := body[:gcm.NonceSize()], body[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return nil, ErrBindingMismatch
+	}
+	return plaintext, nil
+}
+
+// IsBound reports whether a ciphertext carries the versioned header, i.e.
+// was sealed with associated data.
+func IsBound(ciphertext []byte) bool {
+	return bytes.HasPrefix(ciphertext, boundHeaderV1)
+}
+
+// ItemAAD is the associated data binding a vault item blob to its owner,
+// ID, type and version, so blobs cannot be swapped between items or
+// replayed under another version.
+func ItemAAD(userID, itemID, itemType string, version int) []byte {
+	var b bytes.Buffer
+	b.WriteString("gophkeeper-item-v1")
+	for _, field := range []string{userID, itemID, itemType} {
+		binary.Write(&b, binary.BigEndian, uint32(len(field)))
+		b.WriteString(field)
+	}
+	binary.Write(&b, binary.BigEndian, int64(version))
+	return b.Bytes()
+}
+func (e *Encryptor) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(e.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
@@ -6,23 +6,119 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	passwordSaltLength = 16
+	passwordHashLength = 32
+	argon2idPrefix     = "$argon2id$"
 )
 
+// PasswordHashParams are the Argon2id costs used for account passwords.
+type PasswordHashParams struct {
+	Time        uint32
+	Memory      uint32
+	Parallelism uint8
+}
+
+// DefaultPasswordHashParams follow the OWASP baseline for Argon2id.
+var DefaultPasswordHashParams = PasswordHashParams{Time: 2, Memory: 19 * 1024, Parallelism: 1}
+
 func HashPassword(password string) (string, error) {
-	salt := make([]byte, 32)
+	return HashPasswordWithParams(password, DefaultPasswordHashParams)
+}
+
+// HashPasswordWithParams returns a PHC-encoded Argon2id hash:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<parallelism>$<salt>$<hash>
+func HashPasswordWithParams(password string, params PasswordHashParams) (string, error) {
+	if err := ValidatePasswordHashParams(params); err != nil {
+		return "", err
+	}
+	salt := make([]byte, passwordSaltLength)
 	if _, err := rand.Read(salt); err != nil {
 		return "", fmt.Errorf("failed to generate salt: %w", err)
 	}
-	hasher := sha256.New()
-	hasher.Write(salt)
-	hasher.Write([]byte(password))
-	hash := hasher.Sum(nil)
-	combined := make([]byte, 64)
-	copy(combined[:32], salt)
-	copy(combined[32:], hash)
-	return base64.StdEncoding.EncodeToString(combined), nil
+	hash := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Parallelism, passwordHashLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		params.Memory, params.Time, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
 }
+
+// ValidatePasswordHashParams rejects zero costs and costs above the KDF
+// maximums, so parameters sent by the other side cannot make hashing hang or
+// exhaust memory.
+func ValidatePasswordHashParams(params PasswordHashParams) error {
+	if params.Time == 0 || params.Memory == 0 || params.Parallelism == 0 {
+		return fmt.Errorf("invalid password hash parameters")
+	}
+	if params.Time > MaxKDFTime || params.Memory > MaxKDFMemory || params.Parallelism > MaxKDFParallelism {
+		return fmt.Errorf("password hash parameters exceed the allowed maximum")
+	}
+	return nil
+}
+
+// VerifyPassword accepts both the PHC Argon2id format and the legacy
+// base64(salt||sha256(salt||password)) format.
 func VerifyPassword(password, hashedPassword string) (bool, error) {
+	if strings.HasPrefix(hashedPassword, argon2idPrefix) {
+		params, salt, hash, err := decodeArgon2id(hashedPassword)
+		if err != nil {
+			return false, err
+		}
+		expectedHash := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Parallelism, uint32(len(hash)))
+		return subtle.ConstantTimeCompare(hash, expectedHash) == 1, nil
+	}
+	return verifyLegacyPassword(password, hashedPassword)
+}
+
+// NeedsRehash reports whether a stored hash uses the legacy format or
+// different costs than params, so it should be replaced after a successful login.
+func NeedsRehash(hashedPassword string, params PasswordHashParams) bool {
+	if !strings.HasPrefix(hashedPassword, argon2idPrefix) {
+		return true
+	}
+	current, _, _, err := decodeArgon2id(hashedPassword)
+	if err != nil {
+		return true
+	}
+	return current != params
+}
+func decodeArgon2id(encoded string) (PasswordHashParams, []byte, []byte, error) {
+	var params PasswordHashParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash format")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version: %d", version)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+	if params.Time == 0 || params.Memory == 0 || params.Parallelism == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("failed to decode hash: %w", err)
+	}
+	if len(hash) == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash length")
+	}
+	return params, salt, hash, nil
+}
+func verifyLegacyPassword(password, hashedPassword string) (bool, error) {
 	decoded, err := base64.StdEncoding.DecodeString(hashedPassword)
 	if err != nil {
 		return false, fmt.Errorf("failed to decode hash: %w", err)
@@ -1,41 +1,243 @@
 package crypto
 
 import (
-	"crypto/hmac"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"sync"
 	"time"
 )
 
+// Token signing algorithms the server supports.
+const (
+	JWTAlgEdDSA = "EdDSA"
+	JWTAlgES256 = "ES256"
+)
+
+// JWTLeeway absorbs clock skew between the server and services that verify
+// its tokens.
+const JWTLeeway = time.Minute
+
 type JWTClaims struct {
-	UserID    string `json:"user_id"`
-	Username  string `json:"username"`
-	ExpiresAt int64  `json:"exp"`
-	IssuedAt  int64  `json:"iat"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	UserID    string   `json:"user_id"`
+	Username  string   `json:"username"`
+	TokenID   string   `json:"jti,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	DeviceID  string   `json:"did,omitempty"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf,omitempty"`
+}
+
+// SigningKey is an asymmetric key the server signs tokens with.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	private   crypto.Signer
+}
+
+// GenerateSigningKey creates a key for alg with a random key ID.
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case JWTAlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case JWTAlgES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	id, err := randomToken(12)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{ID: id, Algorithm: alg, private: private}, nil
+}
+
+// ParseSigningKey restores a key stored with MarshalPrivateKey.
+func ParseSigningKey(id, alg string, der []byte) (*SigningKey, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	switch private := parsed.(type) {
+	case ed25519.PrivateKey:
+		if alg == JWTAlgEdDSA {
+			return &SigningKey{ID: id, Algorithm: alg, private: private}, nil
+		}
+	case *ecdsa.PrivateKey:
+		if alg == JWTAlgES256 && private.Curve == elliptic.P256() {
+			return &SigningKey{ID: id, Algorithm: alg, private: private}, nil
+		}
+	}
+	return nil, fmt.Errorf("signing key %s does not fit algorithm %s", id, alg)
+}
+
+// MarshalPrivateKey encodes the private key as PKCS #8 DER.
+func (k *SigningKey) MarshalPrivateKey() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal signing key: %w", err)
+	}
+	return der, nil
+}
+
+// PublicJWK returns the public half of the key as published in the JWKS.
+func (k *SigningKey) PublicJWK() JWK {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Algorithm}
+	switch public := k.private.Public().(type) {
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, 32)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, 32)))
+	}
+	return jwk
+}
+func (k *SigningKey) sign(signed []byte) ([]byte, error) {
+	switch private := k.private.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(private, signed), nil
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(signed)
+		r, s, err := ecdsa.Sign(rand.Reader, private, digest[:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign token: %w", err)
+		}
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), nil
+	default:
+		return nil, fmt.Errorf("unsupported signing key")
+	}
+}
+
+// KeyRing holds the key new tokens are signed with and every key that is
+// still accepted for verification, selected by kid. It is safe for
+// concurrent use and can be swapped out while the server runs.
+type KeyRing struct {
+	mu     sync.RWMutex
+	signer *SigningKey
+	keys   *JWKS
+}
+
+func NewKeyRing(signer *SigningKey, keys ...*SigningKey) *KeyRing {
+	ring := &KeyRing{}
+	ring.Set(signer, keys...)
+	return ring
+}
+
+// Set makes signer the signing key and publishes it together with keys.
+func (r *KeyRing) Set(signer *SigningKey, keys ...*SigningKey) {
+	published := &JWKS{Keys: []JWK{}}
+	seen := make(map[string]bool)
+	for _, key := range append([]*SigningKey{signer}, keys...) {
+		if key == nil || seen[key.ID] {
+			continue
+		}
+		seen[key.ID] = true
+		published.Keys = append(published.Keys, key.PublicJWK())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.signer = signer
+	r.keys = published
+}
+func (r *KeyRing) Signer() *SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.signer
+}
+
+// JWKS returns the published public keys. The set must not be modified.
+func (r *KeyRing) JWKS() *JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keys
 }
+
+// JWTManager issues and validates tokens signed with the keys of a KeyRing.
+// Tokens name the key in the kid header, so keys can be rotated without
+// invalidating tokens already handed out.
 type JWTManager struct {
-	secretKey []byte
+	keys     *KeyRing
+	issuer   string
+	audience string
 }
 
-func NewJWTManager(secretKey string) *JWTManager {
+func NewJWTManager(keys *KeyRing, issuer, audience string) *JWTManager {
 	return &JWTManager{
-		secretKey: []byte(secretKey),
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
 	}
 }
+func (j *JWTManager) KeyRing() *KeyRing {
+	return j.keys
+}
 func (j *JWTManager) GenerateToken(userID, username string, expiration time.Duration) (string, error) {
+	return j.GenerateSessionToken(userID, username, "", expiration)
+}
+
+// GenerateSessionToken issues an access token bound to a server-side session,
+// so revoking the session invalidates the token before it expires.
+func (j *JWTManager) GenerateSessionToken(userID, username, sessionID string, expiration time.Duration) (string, error) {
+	return j.GenerateDeviceToken(userID, username, sessionID, "", expiration)
+}
+
+// GenerateDeviceToken issues a session token that also names the device the
+// session is bound to.
+func (j *JWTManager) GenerateDeviceToken(userID, username, sessionID, deviceID string, expiration time.Duration) (string, error) {
+	tokenID, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
 	now := time.Now()
 	claims := JWTClaims{
+		Issuer:    j.issuer,
+		Audience:  Audience{j.audience},
 		UserID:    userID,
 		Username:  username,
+		TokenID:   tokenID,
+		SessionID: sessionID,
+		DeviceID:  deviceID,
 		ExpiresAt: now.Add(expiration).Unix(),
 		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
 	}
-	header := map[string]string{
-		"alg": "HS256",
-		"typ": "JWT",
+	return j.SignClaims(claims)
+}
+
+// SignClaims encodes claims as a compact JWS signed with the current key.
+func (j *JWTManager) SignClaims(claims interface{}) (string, error) {
+	signer := j.keys.Signer()
+	if signer == nil {
+		return "", fmt.Errorf("no signing key available")
+	}
+	return signer.SignClaims(claims)
+}
+
+// SignClaims encodes claims as a compact JWS signed with k.
+func (k *SigningKey) SignClaims(claims interface{}) (string, error) {
+	header := JWSHeader{
+		Alg: k.Algorithm,
+		Kid: k.ID,
+		Typ: "JWT",
 	}
 	headerJSON, err := json.Marshal(header)
 	if err != nil {
@@ -48,42 +250,52 @@ func (j *JWTManager) GenerateToken(userID, username string, expiration time.Dura
 	headerB64 := base64.RawURLEncoding.EncodeToString(headerJSON)
 	claimsB64 := base64.RawURLEncoding.EncodeToString(claimsJSON)
 	payload := headerB64 + "." + claimsB64
-	signature := j.sign(payload)
+	signature, err := k.sign([]byte(payload))
+	if err != nil {
+		return "", err
+	}
 	signatureB64 := base64.RawURLEncoding.EncodeToString(signature)
 	return payload + "." + signatureB64, nil
 }
+
+// ValidateToken checks the signature, issuer, audience and lifetime of an
+// access token.
 func (j *JWTManager) ValidateToken(token string) (*JWTClaims, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("invalid token format")
-	}
-	payload := parts[0] + "." + parts[1]
-	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	var claims JWTClaims
+	if err := j.VerifyClaims(token, &claims); err != nil {
+		return nil, err
 	}
-	if !j.verifySignature(payload, signature) {
-		return nil, fmt.Errorf("invalid signature")
+	if claims.Issuer != j.issuer {
+		return nil, fmt.Errorf("unexpected issuer: %s", claims.Issuer)
 	}
-	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode claims: %w", err)
+	audienceOK := false
+	for _, aud := range claims.Audience {
+		if aud == j.audience {
+			audienceOK = true
+		}
 	}
-	var claims JWTClaims
-	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal claims: %w", err)
+	if !audienceOK {
+		return nil, fmt.Errorf("token is not issued for this audience")
 	}
-	if time.Now().Unix() > claims.ExpiresAt {
+	now := time.Now()
+	if now.Add(-JWTLeeway).Unix() > claims.ExpiresAt {
 		return nil, fmt.Errorf("token expired")
 	}
+	if claims.NotBefore != 0 && now.Add(JWTLeeway).Unix() < claims.NotBefore {
+		return nil, fmt.Errorf("token is not valid yet")
+	}
 	return &claims, nil
 }
-func (j *JWTManager) sign(payload string) []byte {
-	h := hmac.New(sha256.New, j.secretKey)
-	h.Write([]byte(payload))
-	return h.Sum(nil)
-}
-func (j *JWTManager) verifySignature(payload string, signature []byte) bool {
-	expectedSignature := j.sign(payload)
-	return hmac.Equal(signature, expectedSignature)
+
+// VerifyClaims checks the signature of a token made by SignClaims against
+// the published keys and decodes its claims. Expiry is left to the caller.
+func (j *JWTManager) VerifyClaims(token string, claims interface{}) error {
+	_, payload, err := VerifyJWS(token, j.keys.JWKS())
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return fmt.Errorf("failed to unmarshal claims: %w", err)
+	}
+	return nil
 }
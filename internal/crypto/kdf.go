@@ -0,0 +1,97 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"gophkeeper/internal/models"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	KDFArgon2id = "argon2id"
+
+	DefaultKDFTime        uint32 = 3
+	DefaultKDFMemory      uint32 = 64 * 1024
+	DefaultKDFParallelism uint8  = 4
+
+	// Upper bounds for cost parameters read from storage or sent by a
+	// client, so a tampered record cannot make key derivation hang or
+	// exhaust memory.
+	MaxKDFTime        uint32 = 16
+	MaxKDFMemory      uint32 = 1024 * 1024
+	MaxKDFParallelism uint8  = 64
+
+	kdfSaltLength    = 16
+	maxKDFSaltLength = 64
+	vaultKeyLength   = 32
+)
+
+// NewKDFParams returns Argon2id parameters with a fresh random salt.
+// Zero cost values fall back to the package defaults.
+func NewKDFParams(time, memory uint32, parallelism uint8) (*models.KDFParams, error) {
+	if time == 0 {
+		time = DefaultKDFTime
+	}
+	if memory == 0 {
+		memory = DefaultKDFMemory
+	}
+	if parallelism == 0 {
+		parallelism = DefaultKDFParallelism
+	}
+	salt := make([]byte, kdfSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return &models.KDFParams{
+		Algorithm:   KDFArgon2id,
+		Salt:        salt,
+		Time:        time,
+		Memory:      memory,
+		Parallelism: parallelism,
+		KeyLength:   vaultKeyLength,
+	}, nil
+}
+
+// DeriveKey derives the vault key from the master password.
+func DeriveKey(password string, params *models.KDFParams) ([]byte, error) {
+	if err := ValidateKDFParams(params); err != nil {
+		return nil, err
+	}
+	return argon2.IDKey([]byte(password), params.Salt, params.Time, params.Memory, params.Parallelism, params.KeyLength), nil
+}
+func ValidateKDFParams(params *models.KDFParams) error {
+	if params == nil {
+		return fmt.Errorf("kdf parameters are missing")
+	}
+	if params.Algorithm != KDFArgon2id {
+		return fmt.Errorf("unsupported kdf algorithm: %s", params.Algorithm)
+	}
+	if len(params.Salt) < kdfSaltLength || len(params.Salt) > maxKDFSaltLength {
+		return fmt.Errorf("invalid kdf salt length: %d", len(params.Salt))
+	}
+	if params.Time == 0 || params.Memory == 0 || params.Parallelism == 0 {
+		return fmt.Errorf("invalid kdf cost parameters")
+	}
+	if params.Time > MaxKDFTime || params.Memory > MaxKDFMemory || params.Parallelism > MaxKDFParallelism {
+		return fmt.Errorf("kdf cost parameters exceed the allowed maximum")
+	}
+	if params.KeyLength != vaultKeyLength {
+		return fmt.Errorf("invalid kdf key length: %d", params.KeyLength)
+	}
+	return nil
+}
+
+// KeyCheck returns a value that lets a client tell a wrong master password
+// apart from corrupted data without decrypting any vault item.
+func KeyCheck(key []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte("gophkeeper-vault-key-check"))
+	return h.Sum(nil)[:16]
+}
+func VerifyKeyCheck(key, check []byte) bool {
+	return hmac.Equal(KeyCheck(key), check)
+}
@@ -0,0 +1,124 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const dataKeyLength = 32
+
+// KeyProvider holds the server master keys. Data keys are wrapped with the
+// active master key; retired keys stay available for unwrapping until every
+// data key has been rewrapped. A KMS client can implement it as well.
+type KeyProvider interface {
+	ActiveKeyID() string
+	Wrap(key []byte) (keyID string, wrapped []byte, err error)
+	Unwrap(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// NewDataKey returns a fresh random data-encryption key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, dataKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return key, nil
+}
+
+// StaticKeyProvider derives a single master key from a configured secret,
+// e.g. ENCRYPTION_KEY. Its key ID is a fingerprint of the secret, so data keys
+// wrapped under a different secret are reported instead of failing silently.
+type StaticKeyProvider struct {
+	id        string
+	encryptor *Encryptor
+}
+
+func NewStaticKeyProvider(secret string) *StaticKeyProvider {
+	key := sha256.Sum256([]byte("gophkeeper-master-key:" + secret))
+	fingerprint := sha256.Sum256(key[:])
+	encryptor, _ := NewEncryptorFromKey(key[:])
+	return &StaticKeyProvider{
+		id:        "env-" + hex.EncodeToString(fingerprint[:6]),
+		encryptor: encryptor,
+	}
+}
+func (p *StaticKeyProvider) ActiveKeyID() string {
+	return p.id
+}
+func (p *StaticKeyProvider) Wrap(key []byte) (string, []byte, error) {
+	wrapped, err := p.encryptor.Encrypt(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to wrap key: %w", err)
+	}
+	return p.id, wrapped, nil
+}
+func (p *StaticKeyProvider) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != p.id {
+		return nil, fmt.Errorf("unknown master key: %s", keyID)
+	}
+	return p.encryptor.Decrypt(wrapped)
+}
+
+// FileKeyProvider loads master keys from a JSON keyfile:
+//
+//	{"active": "2025-06", "keys": {"2025-01": "<base64>", "2025-06": "<base64>"}}
+//
+// Rotating means adding a new key, pointing "active" at it and keeping the
+// old entry until the rotation job has rewrapped every data key.
+type FileKeyProvider struct {
+	active string
+	keys   map[string]*Encryptor
+}
+type keyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+func LoadKeyFile(path string) (*FileKeyProvider, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	var kf keyFile
+	if err := json.Unmarshal(raw, &kf); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %w", err)
+	}
+	provider := &FileKeyProvider{active: kf.Active, keys: make(map[string]*Encryptor, len(kf.Keys))}
+	for id, encoded := range kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode master key %s: %w", id, err)
+		}
+		encryptor, err := NewEncryptorFromKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %s: %w", id, err)
+		}
+		provider.keys[id] = encryptor
+	}
+	if _, ok := provider.keys[kf.Active]; !ok {
+		return nil, fmt.Errorf("active master key %q is not present in key file", kf.Active)
+	}
+	return provider, nil
+}
+func (p *FileKeyProvider) ActiveKeyID() string {
+	return p.active
+}
+func (p *FileKeyProvider) Wrap(key []byte) (string, []byte, error) {
+	wrapped, err := p.keys[p.active].Encrypt(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to wrap key: %w", err)
+	}
+	return p.active, wrapped, nil
+}
+func (p *FileKeyProvider) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	encryptor, ok := p.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key: %s", keyID)
+	}
+	return encryptor.Decrypt(wrapped)
+}
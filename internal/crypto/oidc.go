@@ -0,0 +1,316 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// idTokenLeeway absorbs clock skew between the server and identity providers.
+const idTokenLeeway = time.Minute
+
+// ErrUnknownKey is returned when a token is signed with a key ID the key set
+// does not contain, which usually means the provider rotated its keys.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// JWK is a public JSON Web Key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Find returns the signing key with the given ID. An empty kid matches a set
+// with a single key.
+func (s *JWKS) Find(kid string) (*JWK, error) {
+	for i := range s.Keys {
+		key := &s.Keys[i]
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if key.Kid == kid || (kid == "" && len(s.Keys) == 1) {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// PublicKey decodes the key into an *rsa.PublicKey, *ecdsa.PublicKey or
+// ed25519.PublicKey.
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < 2048 || !e.IsInt64() {
+			return nil, fmt.Errorf("unsupported rsa key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("ec point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// JWSHeader is the protected header of a compact JWS.
+type JWSHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// VerifyJWS checks the signature of a compact JWS against keys and returns
+// its payload. Only asymmetric algorithms are accepted, and the algorithm
+// must fit the key type.
+func VerifyJWS(token string, keys *JWKS) (*JWSHeader, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, fmt.Errorf("invalid token format")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode header: %w", err)
+	}
+	var header JWSHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal header: %w", err)
+	}
+	jwk, err := keys.Find(header.Kid)
+	if err != nil {
+		return &header, nil, err
+	}
+	if jwk.Alg != "" && jwk.Alg != header.Alg {
+		return &header, nil, fmt.Errorf("token algorithm %s does not match the key", header.Alg)
+	}
+	key, err := jwk.PublicKey()
+	if err != nil {
+		return &header, nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return &header, nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return &header, nil, err
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return &header, nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	return &header, payload, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	invalid := fmt.Errorf("invalid signature")
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256":
+		public, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s needs an rsa key", alg)
+		}
+		hash, digest := jwsDigest(alg, signed)
+		var err error
+		if alg == "PS256" {
+			err = rsa.VerifyPSS(public, hash, digest, signature, nil)
+		} else {
+			err = rsa.VerifyPKCS1v15(public, hash, digest, signature)
+		}
+		if err != nil {
+			return invalid
+		}
+		return nil
+	case "ES256", "ES384":
+		public, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s needs an ec key", alg)
+		}
+		size := (public.Curve.Params().BitSize + 7) / 8
+		if (alg == "ES256") != (size == 32) || len(signature) != 2*size {
+			return invalid
+		}
+		_, digest := jwsDigest(alg, signed)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(public, digest, r, s) {
+			return invalid
+		}
+		return nil
+	case "EdDSA":
+		public, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("algorithm %s needs an ed25519 key", alg)
+		}
+		if !ed25519.Verify(public, signed, signature) {
+			return invalid
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm: %s", alg)
+	}
+}
+
+func jwsDigest(alg string, signed []byte) (crypto.Hash, []byte) {
+	switch alg[2:] {
+	case "384":
+		sum := sha512.Sum384(signed)
+		return crypto.SHA384, sum[:]
+	case "512":
+		sum := sha512.Sum512(signed)
+		return crypto.SHA512, sum[:]
+	default:
+		sum := sha256.Sum256(signed)
+		return crypto.SHA256, sum[:]
+	}
+}
+
+// Audience is the aud claim, which may be a string or a list of strings.
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// IDTokenClaims are the OpenID Connect ID token claims the server uses.
+type IDTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          Audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp,omitempty"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	NotBefore         int64    `json:"nbf,omitempty"`
+	Nonce             string   `json:"nonce,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	EmailVerified     bool     `json:"email_verified,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+}
+
+// VerifyIDToken checks an ID token's signature against keys and its
+// issuer, audience and lifetime. The nonce is left to the caller.
+func VerifyIDToken(token string, keys *JWKS, issuer, clientID string, now time.Time) (*IDTokenClaims, error) {
+	_, payload, err := VerifyJWS(token, keys)
+	if err != nil {
+		return nil, err
+	}
+	var claims IDTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal claims: %w", err)
+	}
+	if claims.Issuer != issuer {
+		return nil, fmt.Errorf("unexpected issuer: %s", claims.Issuer)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("token has no subject")
+	}
+	audienceOK := false
+	for _, aud := range claims.Audience {
+		if aud == clientID {
+			audienceOK = true
+		}
+	}
+	if !audienceOK || (len(claims.Audience) > 1 && claims.AuthorizedParty != "" && claims.AuthorizedParty != clientID) {
+		return nil, fmt.Errorf("token is not issued for this client")
+	}
+	if now.Add(-idTokenLeeway).Unix() > claims.ExpiresAt {
+		return nil, fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != 0 && now.Add(idTokenLeeway).Unix() < claims.NotBefore {
+		return nil, fmt.Errorf("token is not valid yet")
+	}
+	if now.Add(idTokenLeeway).Unix() < claims.IssuedAt {
+		return nil, fmt.Errorf("token is issued in the future")
+	}
+	return &claims, nil
+}
+
+// NewPKCEVerifier returns a random PKCE code verifier (RFC 7636).
+func NewPKCEVerifier() (string, error) {
+	return randomToken(32)
+}
+
+// PKCEChallenge derives the S256 code challenge for verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewOIDCNonce returns a random value for the state and nonce parameters.
+func NewOIDCNonce() (string, error) {
+	return randomToken(24)
+}
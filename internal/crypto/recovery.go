@@ -0,0 +1,201 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// RecoveryKeySize is the length of the key that wraps the vault key in the
+// recovery slot and is split into shares.
+const RecoveryKeySize = 32
+
+// A printed share is set id (2) | threshold (1) | x (1) | y (32) |
+// checksum (4), written as proquint words or as base32.
+const (
+	shareHeaderSize    = 4
+	shareChecksumSize  = 4
+	proquintConsonants = "bdfghjklmnprstvz"
+	proquintVowels     = "aiou"
+)
+
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// RecoveryShare is one printable piece of a split recovery key. SetID ties
+// the shares of one split together so they are not mixed with older ones.
+type RecoveryShare struct {
+	SetID     uint16
+	Threshold byte
+	X         byte
+	Y         []byte
+}
+
+// NewRecoveryKey returns a random recovery key.
+func NewRecoveryKey() ([]byte, error) {
+	key := make([]byte, RecoveryKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate recovery key: %w", err)
+	}
+	return key, nil
+}
+
+// SplitRecoveryKey splits key into n shares, any threshold of which rebuild it.
+func SplitRecoveryKey(key []byte, n, threshold int) ([]RecoveryShare, error) {
+	if len(key) != RecoveryKeySize {
+		return nil, fmt.Errorf("invalid recovery key length: %d", len(key))
+	}
+	raw, err := ShamirSplit(key, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate share set id: %w", err)
+	}
+	shares := make([]RecoveryShare, n)
+	for i, share := range raw {
+		shares[i] = RecoveryShare{
+			SetID:     binary.BigEndian.Uint16(id[:]),
+			Threshold: byte(threshold),
+			X:         share[0],
+			Y:         share[1:],
+		}
+	}
+	return shares, nil
+}
+
+// CombineRecoveryShares rebuilds the recovery key from shares of one set.
+func CombineRecoveryShares(shares []RecoveryShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no shares given")
+	}
+	first := shares[0]
+	if len(shares) < int(first.Threshold) {
+		return nil, fmt.Errorf("need %d shares, got %d", first.Threshold, len(shares))
+	}
+	raw := make([][]byte, len(shares))
+	for i, share := range shares {
+		if share.SetID != first.SetID || share.Threshold != first.Threshold {
+			return nil, fmt.Errorf("share %d belongs to a different recovery kit", i+1)
+		}
+		raw[i] = append([]byte{share.X}, share.Y...)
+	}
+	return ShamirCombine(raw)
+}
+
+// Mnemonic writes the share as dash separated proquint words, five
+// pronounceable letters per two bytes.
+func (s RecoveryShare) Mnemonic() string {
+	b := s.bytes()
+	words := make([]string, 0, (len(b)+1)/2)
+	for i := 0; i < len(b); i += 2 {
+		v := uint16(b[i]) << 8
+		if i+1 < len(b) {
+			v |= uint16(b[i+1])
+		}
+		words = append(words, string([]byte{
+			proquintConsonants[v>>12&0xf],
+			proquintVowels[v>>10&0x3],
+			proquintConsonants[v>>6&0xf],
+			proquintVowels[v>>4&0x3],
+			proquintConsonants[v&0xf],
+		}))
+	}
+	return strings.Join(words, "-")
+}
+
+// Base32 writes the share as base32 in groups of four characters.
+func (s RecoveryShare) Base32() string {
+	encoded := shareEncoding.EncodeToString(s.bytes())
+	var groups []string
+	for len(encoded) > 4 {
+		groups = append(groups, encoded[:4])
+		encoded = encoded[4:]
+	}
+	return strings.Join(append(groups, encoded), "-")
+}
+
+// ParseRecoveryShare reads a share in either printed form. Case, spaces
+// and dashes are ignored, and the checksum catches typos.
+func ParseRecoveryShare(text string) (RecoveryShare, error) {
+	compact := strings.NewReplacer(" ", "", "-", "", "\t", "", "\n", "").Replace(strings.TrimSpace(text))
+	if b, ok := decodeProquints(strings.ToLower(compact)); ok {
+		if share, err := parseShareBytes(b); err == nil {
+			return share, nil
+		}
+	}
+	b, err := shareEncoding.DecodeString(strings.ToUpper(compact))
+	if err != nil {
+		return RecoveryShare{}, fmt.Errorf("share is neither a mnemonic nor base32")
+	}
+	return parseShareBytes(b)
+}
+func (s RecoveryShare) bytes() []byte {
+	b := make([]byte, 0, shareHeaderSize+len(s.Y)+shareChecksumSize)
+	b = binary.BigEndian.AppendUint16(b, s.SetID)
+	b = append(b, s.Threshold, s.X)
+	b = append(b, s.Y...)
+	sum := sha256.Sum256(b)
+	return append(b, sum[:shareChecksumSize]...)
+}
+func parseShareBytes(b []byte) (RecoveryShare, error) {
+	if len(b) <= shareHeaderSize+shareChecksumSize {
+		return RecoveryShare{}, fmt.Errorf("share is too short")
+	}
+	body, checksum := b[:len(b)-shareChecksumSize], b[len(b)-shareChecksumSize:]
+	sum := sha256.Sum256(body)
+	if string(sum[:shareChecksumSize]) != string(checksum) {
+		return RecoveryShare{}, fmt.Errorf("share checksum mismatch, check for typos")
+	}
+	return RecoveryShare{
+		SetID:     binary.BigEndian.Uint16(body),
+		Threshold: body[2],
+		X:         body[3],
+		Y:         append([]byte(nil), body[shareHeaderSize:]...),
+	}, nil
+}
+func decodeProquints(s string) ([]byte, bool) {
+	if len(s) == 0 || len(s)%5 != 0 {
+		return nil, false
+	}
+	out := make([]byte, 0, len(s)/5*2)
+	for i := 0; i < len(s); i += 5 {
+		var v uint16
+		for j, c := range []byte(s[i : i+5]) {
+			alphabet, bits := proquintConsonants, 4
+			if j%2 == 1 {
+				alphabet, bits = proquintVowels, 2
+			}
+			idx := strings.IndexByte(alphabet, c)
+			if idx < 0 {
+				return nil, false
+			}
+			v = v<<bits | uint16(idx)
+		}
+		out = binary.BigEndian.AppendUint16(out, v)
+	}
+	return out, true
+}
+
+// WrapKey encrypts the encryptor's key under kek, e.g. for a recovery slot.
+func (e *Encryptor) WrapKey(kek *Encryptor, aad []byte) ([]byte, error) {
+	return kek.EncryptWithAAD(e.key, aad)
+}
+
+// KeyCheck returns the key check of the encryptor's key.
+func (e *Encryptor) KeyCheck() []byte {
+	return KeyCheck(e.key)
+}
+
+// UnwrapKey opens a key wrapped by WrapKey.
+func UnwrapKey(kek *Encryptor, wrapped, aad []byte) (*Encryptor, error) {
+	key, err := kek.DecryptWithAAD(wrapped, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	defer clear(key)
+	return NewEncryptorFromKey(key)
+}
@@ -0,0 +1,112 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Shamir's secret sharing over GF(2^8) with the AES reduction polynomial.
+// Every byte of the secret is shared with its own random polynomial; share
+// i holds the evaluations at x = i.
+var gfExp, gfLog = gfTables()
+
+// ShamirSplit splits secret into n shares of which any threshold recover it.
+// Each share is the x coordinate followed by one y byte per secret byte.
+func ShamirSplit(secret []byte, n, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("invalid shamir parameters: need 2 <= threshold <= shares <= 255")
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret is empty")
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+	coeffs := make([]byte, threshold)
+	for b, s := range secret {
+		coeffs[0] = s
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, fmt.Errorf("failed to generate polynomial: %w", err)
+		}
+		for _, share := range shares {
+			share[b+1] = gfEval(coeffs, share[0])
+		}
+	}
+	clear(coeffs)
+	return shares, nil
+}
+
+// ShamirCombine recovers the secret from at least threshold distinct shares.
+// With fewer shares it returns an unrelated value, so callers need a way to
+// check the result.
+func ShamirCombine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("at least two shares are required")
+	}
+	size := len(shares[0])
+	seen := make(map[byte]bool, len(shares))
+	for _, share := range shares {
+		if len(share) != size || size < 2 {
+			return nil, fmt.Errorf("shares have different lengths")
+		}
+		if share[0] == 0 || seen[share[0]] {
+			return nil, fmt.Errorf("shares must have distinct non-zero indexes")
+		}
+		seen[share[0]] = true
+	}
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		// Lagrange basis at x = 0: prod x_j / (x_j - x_i); subtraction is xor.
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(other[0], other[0]^share[0]))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(share[b+1], basis)
+		}
+	}
+	return secret, nil
+}
+func gfEval(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfTables builds exp/log tables for the generator 3. The exp table is
+// doubled so products can index it without a modulo.
+func gfTables() ([510]byte, [256]byte) {
+	var exp [510]byte
+	var log [256]byte
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		exp[i+255] = x
+		log[x] = byte(i)
+		// multiply by 3 = x*2 xor x, reducing by 0x11b
+		x2 := x << 1
+		if x&0x80 != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	return exp, log
+}
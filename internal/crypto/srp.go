@@ -0,0 +1,259 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// SRP-6a over the 2048-bit group from RFC 5054 with SHA-256. The private
+// key x is stretched with Argon2id, so a leaked verifier is as expensive to
+// attack as a stored password hash.
+const (
+	srpGroupHex = "AC6BDB41324A9A9BF166DE5E1389582FAF72B6651987EE07FC3192943DB56050" +
+		"A37329CBB4A099ED8193E0757767A13DD52312AB4B03310DCD7F48A9DA04FD50" +
+		"E8083969EDB767B0CF6095179A163AB3661A05FBD5FAAAE82918A9962F0B93B8" +
+		"55F97993EC975EEAA80D740ADBF4FF747359D041D5C33EA71D281E446B14773B" +
+		"CA97B43A23FB801676BD207A436C6481F1D2B9078717461A5B9D32E688F87748" +
+		"544523B524B0D57D5EA77A2775D2ECFA032CFBDBF52FB3786160279004E57AE6" +
+		"AF874E7303CE53299CCC041C7BC308D82A5698F3A8D0C38271AE35F8E9DBFBB6" +
+		"94B5C803D89F7AE435DE236D525F54759B65E372FCD68EF20FA7111F9E4AFF73"
+	srpSaltLength   = 16
+	srpSecretLength = 32
+)
+
+var (
+	srpN = mustParseHex(srpGroupHex)
+	srpG = big.NewInt(2)
+	srpK = new(big.Int).SetBytes(srpHash(srpN.Bytes(), srpPad(srpG)))
+)
+
+// NewSRPVerifier returns a fresh salt and the verifier the server stores in
+// place of the password.
+func NewSRPVerifier(username, password string, params PasswordHashParams) ([]byte, []byte, error) {
+	salt := make([]byte, srpSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	verifier, err := ComputeSRPVerifier(username, password, salt, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	return salt, verifier, nil
+}
+
+// ComputeSRPVerifier returns v = g^x mod N for the given salt.
+func ComputeSRPVerifier(username, password string, salt []byte, params PasswordHashParams) ([]byte, error) {
+	x, err := srpPrivateKey(username, password, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Exp(srpG, x, srpN).Bytes(), nil
+}
+
+// FakeSRPSalt derives a stable salt for usernames without a verifier, so
+// the handshake does not reveal which accounts exist.
+func FakeSRPSalt(key []byte, username string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("srp-fake-salt:" + username))
+	return mac.Sum(nil)[:srpSaltLength]
+}
+
+// FakeSRPLegacy decides, stably per username, whether an unknown username
+// is answered like an account that still has to log in with its password.
+func FakeSRPLegacy(key []byte, username string) bool {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("srp-fake-legacy:" + username))
+	return mac.Sum(nil)[0]&1 == 1
+}
+
+// SRPClient holds the client side of one handshake.
+type SRPClient struct {
+	username string
+	a        *big.Int
+	A        *big.Int
+	key      []byte
+	m1       []byte
+}
+
+func NewSRPClient(username string) (*SRPClient, error) {
+	a, err := srpRandomSecret()
+	if err != nil {
+		return nil, err
+	}
+	return &SRPClient{
+		username: username,
+		a:        a,
+		A:        new(big.Int).Exp(srpG, a, srpN),
+	}, nil
+}
+
+// PublicKey returns A, sent with the first round.
+func (c *SRPClient) PublicKey() []byte {
+	return c.A.Bytes()
+}
+
+// ComputeProof derives the session key from the server's reply and returns
+// the client proof M1.
+func (c *SRPClient) ComputeProof(password string, salt, serverPublic []byte, params PasswordHashParams) ([]byte, error) {
+	B := new(big.Int).SetBytes(serverPublic)
+	if new(big.Int).Mod(B, srpN).Sign() == 0 {
+		return nil, fmt.Errorf("invalid server public key")
+	}
+	u := srpScramble(c.A, B)
+	if u.Sign() == 0 {
+		return nil, fmt.Errorf("invalid server public key")
+	}
+	x, err := srpPrivateKey(c.username, password, salt, params)
+	if err != nil {
+		return nil, err
+	}
+	// S = (B - k*g^x) ^ (a + u*x) mod N
+	kgx := new(big.Int).Mul(srpK, new(big.Int).Exp(srpG, x, srpN))
+	base := new(big.Int).Mod(new(big.Int).Sub(B, kgx), srpN)
+	exp := new(big.Int).Add(c.a, new(big.Int).Mul(u, x))
+	S := new(big.Int).Exp(base, exp, srpN)
+	c.key = srpHash(S.Bytes())
+	c.m1 = srpClientProof(c.username, salt, c.A, B, c.key)
+	return c.m1, nil
+}
+
+// VerifyServerProof checks M2, proving the server knew the verifier.
+func (c *SRPClient) VerifyServerProof(proof []byte) bool {
+	if c.key == nil {
+		return false
+	}
+	expected := srpHash(srpPad(c.A), c.m1, c.key)
+	return subtle.ConstantTimeCompare(expected, proof) == 1
+}
+
+// SessionKey returns the shared key K once the proof was computed.
+func (c *SRPClient) SessionKey() []byte {
+	return c.key
+}
+
+// SRPServer holds the server side of one handshake. The secret can be
+// stored between the two rounds and restored with RestoreSRPServer.
+type SRPServer struct {
+	v *big.Int
+	b *big.Int
+	B *big.Int
+}
+
+func NewSRPServer(verifier []byte) (*SRPServer, error) {
+	b, err := srpRandomSecret()
+	if err != nil {
+		return nil, err
+	}
+	return newSRPServer(verifier, b), nil
+}
+func RestoreSRPServer(verifier, secret []byte) *SRPServer {
+	return newSRPServer(verifier, new(big.Int).SetBytes(secret))
+}
+
+// FakeSRPPublicKey returns a B indistinguishable from a real one, for
+// usernames without a verifier.
+func FakeSRPPublicKey() ([]byte, error) {
+	b, err := srpRandomSecret()
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Exp(srpG, b, srpN).Bytes(), nil
+}
+func newSRPServer(verifier []byte, b *big.Int) *SRPServer {
+	v := new(big.Int).SetBytes(verifier)
+	// B = k*v + g^b mod N
+	B := new(big.Int).Mul(srpK, v)
+	B.Add(B, new(big.Int).Exp(srpG, b, srpN))
+	B.Mod(B, srpN)
+	return &SRPServer{v: v, b: b, B: B}
+}
+func (s *SRPServer) PublicKey() []byte {
+	return s.B.Bytes()
+}
+func (s *SRPServer) Secret() []byte {
+	return s.b.Bytes()
+}
+
+// VerifyClientProof checks M1 and returns the server proof M2 together with
+// the shared session key.
+func (s *SRPServer) VerifyClientProof(username string, salt, clientPublic, proof []byte) ([]byte, []byte, error) {
+	A := new(big.Int).SetBytes(clientPublic)
+	if new(big.Int).Mod(A, srpN).Sign() == 0 {
+		return nil, nil, fmt.Errorf("invalid client public key")
+	}
+	u := srpScramble(A, s.B)
+	if u.Sign() == 0 {
+		return nil, nil, fmt.Errorf("invalid client public key")
+	}
+	// S = (A * v^u) ^ b mod N
+	base := new(big.Int).Mul(A, new(big.Int).Exp(s.v, u, srpN))
+	S := new(big.Int).Exp(base.Mod(base, srpN), s.b, srpN)
+	key := srpHash(S.Bytes())
+	expected := srpClientProof(username, salt, A, s.B, key)
+	if subtle.ConstantTimeCompare(expected, proof) != 1 {
+		return nil, nil, fmt.Errorf("invalid client proof")
+	}
+	return srpHash(srpPad(A), proof, key), key, nil
+}
+
+// ValidateSRPVerifier rejects verifiers outside the group.
+func ValidateSRPVerifier(verifier []byte) error {
+	v := new(big.Int).SetBytes(verifier)
+	if v.Sign() == 0 || v.Cmp(srpN) >= 0 {
+		return fmt.Errorf("invalid srp verifier")
+	}
+	return nil
+}
+func srpPrivateKey(username, password string, salt []byte, params PasswordHashParams) (*big.Int, error) {
+	if err := ValidatePasswordHashParams(params); err != nil {
+		return nil, fmt.Errorf("invalid srp parameters: %w", err)
+	}
+	if len(salt) == 0 {
+		return nil, fmt.Errorf("invalid srp salt")
+	}
+	stretched := argon2.IDKey([]byte(username+":"+password), salt, params.Time, params.Memory, params.Parallelism, passwordHashLength)
+	return new(big.Int).SetBytes(srpHash(salt, stretched)), nil
+}
+
+// srpClientProof is M1 = H(H(N) xor H(g) | H(I) | s | A | B | K).
+func srpClientProof(username string, salt []byte, A, B *big.Int, key []byte) []byte {
+	hn := srpHash(srpN.Bytes())
+	hg := srpHash(srpPad(srpG))
+	for i := range hn {
+		hn[i] ^= hg[i]
+	}
+	return srpHash(hn, srpHash([]byte(username)), salt, srpPad(A), srpPad(B), key)
+}
+func srpScramble(A, B *big.Int) *big.Int {
+	return new(big.Int).SetBytes(srpHash(srpPad(A), srpPad(B)))
+}
+func srpRandomSecret() (*big.Int, error) {
+	buf := make([]byte, srpSecretLength)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate srp secret: %w", err)
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
+func srpHash(parts ...[]byte) []byte {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+func srpPad(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, (srpN.BitLen()+7)/8))
+}
+func mustParseHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("crypto: invalid srp group")
+	}
+	return n
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"gophkeeper/internal/crypto"
+)
+
+func TestEncryptWithAAD_RoundTrip(t *testing.T) {
+	encryptor := crypto.NewEncryptor("test-key")
+	aad := crypto.ItemAAD("user", "item", "text", 1)
+	ciphertext, err := encryptor.EncryptWithAAD([]byte("secret"), aad)
+	if err != nil {
+		t.Fatalf("EncryptWithAAD failed: %v", err)
+	}
+	if !crypto.IsBound(ciphertext) {
+		t.Fatal("Expected ciphertext to carry the binding header")
+	}
+	plaintext, err := encryptor.DecryptWithAAD(ciphertext, aad)
+	if err != nil {
+		t.Fatalf("DecryptWithAAD failed: %v", err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("Expected %q, got %q", "secret", plaintext)
+	}
+}
+func TestDecryptWithAAD_RejectsOtherBinding(t *testing.T) {
+	encryptor := crypto.NewEncryptor("test-key")
+	ciphertext, _ := encryptor.EncryptWithAAD([]byte("secret"), crypto.ItemAAD("user", "item", "text", 2))
+	others := map[string][]byte{
+		"user":    crypto.ItemAAD("other", "item", "text", 2),
+		"item":    crypto.ItemAAD("user", "other", "text", 2),
+		"type":    crypto.ItemAAD("user", "item", "binary", 2),
+		"version": crypto.ItemAAD("user", "item", "text", 1),
+	}
+	for name, aad := range others {
+		if _, err := encryptor.DecryptWithAAD(ciphertext, aad); !errors.Is(err, crypto.ErrBindingMismatch) {
+			t.Errorf("Expected binding mismatch for other %s, got %v", name, err)
+		}
+	}
+}
+func TestDecryptWithAAD_AcceptsLegacyCiphertext(t *testing.T) {
+	encryptor := crypto.NewEncryptor("test-key")
+	legacy, _ := encryptor.Encrypt([]byte("old"))
+	plaintext, err := encryptor.DecryptWithAAD(legacy, crypto.ItemAAD("user", "item", "text", 1))
+	if err != nil {
+		t.Fatalf("Expected legacy ciphertext to decrypt, got %v", err)
+	}
+	if string(plaintext) != "old" {
+		t.Errorf("Expected %q, got %q", "old", plaintext)
+	}
+}
+func TestDecryptBound_RejectsLegacyCiphertext(t *testing.T) {
+	encryptor := crypto.NewEncryptor("test-key")
+	legacy, _ := encryptor.Encrypt([]byte("old"))
+	if _, err := encryptor.DecryptBound(legacy, crypto.ItemAAD("user", "item", "text", 1)); !errors.Is(err, crypto.ErrBindingMismatch) {
+		t.Errorf("Expected binding mismatch for legacy ciphertext, got %v", err)
+	}
+}
+func TestItemAAD_FieldsAreUnambiguous(t *testing.T) {
+	if bytes.Equal(crypto.ItemAAD("ab", "c", "t", 1), crypto.ItemAAD("a", "bc", "t", 1)) {
+		t.Error("Expected length-prefixed fields to differ")
+	}
+}
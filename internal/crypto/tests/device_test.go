@@ -0,0 +1,27 @@
+package tests
+
+import (
+	"testing"
+
+	"gophkeeper/internal/crypto"
+)
+
+func TestDeviceProof(t *testing.T) {
+	public, private, err := crypto.NewDeviceKey()
+	if err != nil {
+		t.Fatalf("NewDeviceKey failed: %v", err)
+	}
+	sig := crypto.SignDeviceProof(private, "device-1", "session-1")
+	if !crypto.VerifyDeviceProof(public, "device-1", "session-1", sig) {
+		t.Fatal("Expected proof to verify")
+	}
+	if crypto.VerifyDeviceProof(public, "device-1", "session-2", sig) {
+		t.Error("Expected proof to be bound to the session")
+	}
+	if crypto.VerifyDeviceProof(public, "device-2", "session-1", sig) {
+		t.Error("Expected proof to be bound to the device")
+	}
+	if crypto.VerifyDeviceProof(public[:16], "device-1", "session-1", sig) {
+		t.Error("Expected a malformed key to be rejected")
+	}
+}
@@ -1,6 +1,9 @@
 package tests
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"gophkeeper/internal/crypto"
+	"strings"
 	"testing"
 )
 func TestHashPassword(t *testing.T) {
@@ -60,3 +63,45 @@ func TestVerifyPassword_EmptyPassword(t *testing.T) {
 		t.Fatal("Empty password should be valid")
 	}
 }
+func TestHashPassword_Argon2idFormat(t *testing.T) {
+	hash, err := crypto.HashPasswordWithParams("test-password", crypto.PasswordHashParams{Time: 1, Memory: 1024, Parallelism: 1})
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("Unexpected hash format: %s", hash)
+	}
+	valid, err := crypto.VerifyPassword("test-password", hash)
+	if err != nil || !valid {
+		t.Fatalf("Expected valid password, got %v, %v", valid, err)
+	}
+}
+func TestVerifyPassword_LegacyFormat(t *testing.T) {
+	salt := make([]byte, 32)
+	sum := sha256.Sum256(append(salt, []byte("test-password")...))
+	legacy := base64.StdEncoding.EncodeToString(append(salt, sum[:]...))
+	valid, err := crypto.VerifyPassword("test-password", legacy)
+	if err != nil || !valid {
+		t.Fatalf("Expected legacy hash to verify, got %v, %v", valid, err)
+	}
+	valid, err = crypto.VerifyPassword("wrong-password", legacy)
+	if err != nil || valid {
+		t.Fatalf("Expected wrong password to fail, got %v, %v", valid, err)
+	}
+	if !crypto.NeedsRehash(legacy, crypto.DefaultPasswordHashParams) {
+		t.Error("Expected legacy hash to need rehash")
+	}
+}
+func TestNeedsRehash(t *testing.T) {
+	params := crypto.PasswordHashParams{Time: 1, Memory: 1024, Parallelism: 1}
+	hash, err := crypto.HashPasswordWithParams("test-password", params)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if crypto.NeedsRehash(hash, params) {
+		t.Error("Expected hash with current params not to need rehash")
+	}
+	if !crypto.NeedsRehash(hash, crypto.PasswordHashParams{Time: 2, Memory: 1024, Parallelism: 1}) {
+		t.Error("Expected hash with outdated params to need rehash")
+	}
+}
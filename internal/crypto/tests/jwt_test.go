@@ -1,11 +1,26 @@
 package tests
 import (
+	"encoding/base64"
+	"encoding/json"
 	"gophkeeper/internal/crypto"
+	"strings"
 	"testing"
 	"time"
 )
+func newTestKey(t *testing.T, alg string) *crypto.SigningKey {
+	t.Helper()
+	key, err := crypto.GenerateSigningKey(alg)
+	if err != nil {
+		t.Fatalf("Failed to generate signing key: %v", err)
+	}
+	return key
+}
+func newTestJWTManager(t *testing.T) *crypto.JWTManager {
+	t.Helper()
+	return crypto.NewJWTManager(crypto.NewKeyRing(newTestKey(t, crypto.JWTAlgEdDSA)), "gophkeeper", "gophkeeper-api")
+}
 func TestJWTManager_GenerateToken(t *testing.T) {
-	manager := crypto.NewJWTManager("test-secret")
+	manager := newTestJWTManager(t)
 	userID := "user123"
 	username := "testuser"
 	expiration := 1 * time.Hour
@@ -22,7 +37,7 @@ func TestJWTManager_GenerateToken(t *testing.T) {
 	}
 }
 func TestJWTManager_ValidateToken(t *testing.T) {
-	manager := crypto.NewJWTManager("test-secret")
+	manager := newTestJWTManager(t)
 	userID := "user123"
 	username := "testuser"
 	expiration := 1 * time.Hour
@@ -42,15 +57,15 @@ func TestJWTManager_ValidateToken(t *testing.T) {
 	}
 }
 func TestJWTManager_ValidateToken_InvalidFormat(t *testing.T) {
-	manager := crypto.NewJWTManager("test-secret")
+	manager := newTestJWTManager(t)
 	_, err := manager.ValidateToken("invalid-token")
 	if err == nil {
 		t.Fatal("Expected error for invalid token format")
 	}
 }
-func TestJWTManager_ValidateToken_WrongSecret(t *testing.T) {
-	manager1 := crypto.NewJWTManager("secret1")
-	manager2 := crypto.NewJWTManager("secret2")
+func TestJWTManager_ValidateToken_WrongKey(t *testing.T) {
+	manager1 := newTestJWTManager(t)
+	manager2 := newTestJWTManager(t)
 	userID := "user123"
 	username := "testuser"
 	expiration := 1 * time.Hour
@@ -60,11 +75,11 @@ func TestJWTManager_ValidateToken_WrongSecret(t *testing.T) {
 	}
 	_, err = manager2.ValidateToken(token)
 	if err == nil {
-		t.Fatal("Expected error for token with wrong secret")
+		t.Fatal("Expected error for token signed with another key")
 	}
 }
 func TestJWTManager_ValidateToken_Expired(t *testing.T) {
-	manager := crypto.NewJWTManager("test-secret")
+	manager := newTestJWTManager(t)
 	userID := "user123"
 	username := "testuser"
 	expiration := -1 * time.Hour // Expired token
@@ -77,6 +92,142 @@ func TestJWTManager_ValidateToken_Expired(t *testing.T) {
 		t.Fatal("Expected error for expired token")
 	}
 }
+func TestJWTManager_SessionToken(t *testing.T) {
+	manager := newTestJWTManager(t)
+	first, err := manager.GenerateSessionToken("user123", "testuser", "session-1", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	second, err := manager.GenerateSessionToken("user123", "testuser", "session-1", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, err := manager.ValidateToken(first)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+	if claims.SessionID != "session-1" {
+		t.Errorf("Expected sid session-1, got %s", claims.SessionID)
+	}
+	otherClaims, _ := manager.ValidateToken(second)
+	if claims.TokenID == "" || claims.TokenID == otherClaims.TokenID {
+		t.Error("Expected a unique jti per token")
+	}
+}
+func TestJWTManager_SignedClaims(t *testing.T) {
+	type receipt struct {
+		UserID string `json:"user_id"`
+		Items  int    `json:"items"`
+	}
+	manager := newTestJWTManager(t)
+	token, err := manager.SignClaims(receipt{UserID: "user123", Items: 3})
+	if err != nil {
+		t.Fatalf("Failed to sign claims: %v", err)
+	}
+	var decoded receipt
+	if err := manager.VerifyClaims(token, &decoded); err != nil || decoded.UserID != "user123" || decoded.Items != 3 {
+		t.Fatalf("Expected signed claims to round-trip, got %+v (%v)", decoded, err)
+	}
+	if err := newTestJWTManager(t).VerifyClaims(token, &decoded); err == nil {
+		t.Error("Expected claims signed with another key to be rejected")
+	}
+}
+func TestJWTManager_KeyRotation(t *testing.T) {
+	for _, alg := range []string{crypto.JWTAlgEdDSA, crypto.JWTAlgES256} {
+		oldKey := newTestKey(t, alg)
+		ring := crypto.NewKeyRing(oldKey)
+		manager := crypto.NewJWTManager(ring, "gophkeeper", "gophkeeper-api")
+		oldToken, err := manager.GenerateToken("user123", "testuser", time.Hour)
+		if err != nil {
+			t.Fatalf("%s: failed to generate token: %v", alg, err)
+		}
+		var header crypto.JWSHeader
+		raw, _ := base64.RawURLEncoding.DecodeString(strings.Split(oldToken, ".")[0])
+		json.Unmarshal(raw, &header)
+		if header.Alg != alg || header.Kid != oldKey.ID {
+			t.Fatalf("%s: expected the header to name the key, got %+v", alg, header)
+		}
+		newKey := newTestKey(t, alg)
+		ring.Set(newKey, oldKey)
+		newToken, _ := manager.GenerateToken("user123", "testuser", time.Hour)
+		if _, err := manager.ValidateToken(oldToken); err != nil {
+			t.Errorf("%s: expected a token of the previous key to stay valid, got %v", alg, err)
+		}
+		if claims, err := manager.ValidateToken(newToken); err != nil || claims.UserID != "user123" {
+			t.Errorf("%s: expected the new key to sign, got %v", alg, err)
+		}
+		ring.Set(newKey)
+		if _, err := manager.ValidateToken(oldToken); err == nil {
+			t.Errorf("%s: expected a token of a removed key to be rejected", alg)
+		}
+		if len(ring.JWKS().Keys) != 1 || ring.JWKS().Keys[0].Kid != newKey.ID {
+			t.Errorf("%s: expected only the new key to be published", alg)
+		}
+	}
+}
+func TestJWTManager_IssuerAndAudience(t *testing.T) {
+	ring := crypto.NewKeyRing(newTestKey(t, crypto.JWTAlgEdDSA))
+	manager := crypto.NewJWTManager(ring, "gophkeeper", "gophkeeper-api")
+	token, err := manager.GenerateToken("user123", "testuser", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	claims, err := manager.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+	if claims.Issuer != "gophkeeper" || len(claims.Audience) != 1 || claims.Audience[0] != "gophkeeper-api" || claims.NotBefore == 0 {
+		t.Errorf("Expected iss, aud and nbf claims, got %+v", claims)
+	}
+	if _, err := crypto.NewJWTManager(ring, "other-issuer", "gophkeeper-api").ValidateToken(token); err == nil {
+		t.Error("Expected a token from another issuer to be rejected")
+	}
+	if _, err := crypto.NewJWTManager(ring, "gophkeeper", "other-service").ValidateToken(token); err == nil {
+		t.Error("Expected a token for another audience to be rejected")
+	}
+	future, _ := manager.SignClaims(crypto.JWTClaims{
+		Issuer:    "gophkeeper",
+		Audience:  crypto.Audience{"gophkeeper-api"},
+		UserID:    "user123",
+		ExpiresAt: time.Now().Add(2 * time.Hour).Unix(),
+		NotBefore: time.Now().Add(time.Hour).Unix(),
+	})
+	if _, err := manager.ValidateToken(future); err == nil {
+		t.Error("Expected a token that is not valid yet to be rejected")
+	}
+}
+func TestJWTManager_RejectsAlgorithmSwap(t *testing.T) {
+	manager := newTestJWTManager(t)
+	token, _ := manager.GenerateToken("user123", "testuser", time.Hour)
+	parts := strings.Split(token, ".")
+	var header crypto.JWSHeader
+	raw, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	json.Unmarshal(raw, &header)
+	for _, alg := range []string{"HS256", "none", "ES256"} {
+		swapped, _ := json.Marshal(crypto.JWSHeader{Alg: alg, Kid: header.Kid, Typ: "JWT"})
+		forged := base64.RawURLEncoding.EncodeToString(swapped) + "." + parts[1] + "." + parts[2]
+		if _, err := manager.ValidateToken(forged); err == nil {
+			t.Errorf("Expected a token with alg %s to be rejected", alg)
+		}
+	}
+}
+func TestParseSigningKey(t *testing.T) {
+	key := newTestKey(t, crypto.JWTAlgES256)
+	der, err := key.MarshalPrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to marshal signing key: %v", err)
+	}
+	parsed, err := crypto.ParseSigningKey(key.ID, crypto.JWTAlgES256, der)
+	if err != nil {
+		t.Fatalf("Failed to parse signing key: %v", err)
+	}
+	if parsed.PublicJWK() != key.PublicJWK() {
+		t.Error("Expected the parsed key to match")
+	}
+	if _, err := crypto.ParseSigningKey(key.ID, crypto.JWTAlgEdDSA, der); err == nil {
+		t.Error("Expected a key that does not fit the algorithm to be rejected")
+	}
+}
 func splitToken(token string) []string {
 	parts := make([]string, 0)
 	start := 0
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"bytes"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/models"
+	"testing"
+)
+
+func TestDeriveKey_Deterministic(t *testing.T) {
+	params, err := crypto.NewKDFParams(1, 1024, 1)
+	if err != nil {
+		t.Fatalf("Failed to generate params: %v", err)
+	}
+	key1, err := crypto.DeriveKey("master-password", params)
+	if err != nil {
+		t.Fatalf("Failed to derive key: %v", err)
+	}
+	key2, err := crypto.DeriveKey("master-password", params)
+	if err != nil {
+		t.Fatalf("Failed to derive key second time: %v", err)
+	}
+	if len(key1) != 32 {
+		t.Fatalf("Expected 32-byte key, got %d", len(key1))
+	}
+	if !bytes.Equal(key1, key2) {
+		t.Fatal("Same password and params should derive the same key")
+	}
+}
+func TestDeriveKey_PerUserSalt(t *testing.T) {
+	params1, _ := crypto.NewKDFParams(1, 1024, 1)
+	params2, _ := crypto.NewKDFParams(1, 1024, 1)
+	if bytes.Equal(params1.Salt, params2.Salt) {
+		t.Fatal("Salts should be random")
+	}
+	key1, _ := crypto.DeriveKey("master-password", params1)
+	key2, _ := crypto.DeriveKey("master-password", params2)
+	if bytes.Equal(key1, key2) {
+		t.Fatal("Different salts should derive different keys")
+	}
+}
+func TestDeriveKey_InvalidParams(t *testing.T) {
+	params, _ := crypto.NewKDFParams(1, 1024, 1)
+	params.Algorithm = "sha256"
+	if _, err := crypto.DeriveKey("master-password", params); err == nil {
+		t.Fatal("Expected error for unsupported algorithm")
+	}
+	if _, err := crypto.DeriveKey("master-password", nil); err == nil {
+		t.Fatal("Expected error for missing params")
+	}
+}
+func TestDeriveKey_CostCaps(t *testing.T) {
+	for _, tamper := range []func(p *models.KDFParams){
+		func(p *models.KDFParams) { p.Time = crypto.MaxKDFTime + 1 },
+		func(p *models.KDFParams) { p.Memory = crypto.MaxKDFMemory + 1 },
+		func(p *models.KDFParams) { p.Parallelism = crypto.MaxKDFParallelism + 1 },
+		func(p *models.KDFParams) { p.Salt = make([]byte, 65) },
+	} {
+		params, _ := crypto.NewKDFParams(1, 1024, 1)
+		tamper(params)
+		if _, err := crypto.DeriveKey("master-password", params); err == nil {
+			t.Errorf("Expected excessive parameters to be refused: %+v", params)
+		}
+	}
+}
+func TestKeyCheck(t *testing.T) {
+	params, _ := crypto.NewKDFParams(1, 1024, 1)
+	key, _ := crypto.DeriveKey("master-password", params)
+	wrongKey, _ := crypto.DeriveKey("wrong-password", params)
+	check := crypto.KeyCheck(key)
+	if !crypto.VerifyKeyCheck(key, check) {
+		t.Fatal("Key check should match the derived key")
+	}
+	if crypto.VerifyKeyCheck(wrongKey, check) {
+		t.Fatal("Key check should not match a key from a different password")
+	}
+}
+func TestNewEncryptorFromKey(t *testing.T) {
+	params, _ := crypto.NewKDFParams(1, 1024, 1)
+	key, _ := crypto.DeriveKey("master-password", params)
+	encryptor, err := crypto.NewEncryptorFromKey(key)
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+	encrypted, err := encryptor.Encrypt([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	decrypted, err := encryptor.Decrypt(encrypted)
+	if err != nil || string(decrypted) != "secret" {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+	if _, err := crypto.NewEncryptorFromKey([]byte("short")); err == nil {
+		t.Fatal("Expected error for short key")
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gophkeeper/internal/crypto"
+)
+
+func writeKeyFile(t *testing.T, active string, keys map[string][]byte) string {
+	encoded := make(map[string]string, len(keys))
+	for id, key := range keys {
+		encoded[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	raw, _ := json.Marshal(map[string]interface{}{"active": active, "keys": encoded})
+	path := filepath.Join(t.TempDir(), "master.json")
+	if err := os.WriteFile(path, raw, 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	return path
+}
+func TestStaticKeyProvider_WrapUnwrap(t *testing.T) {
+	provider := crypto.NewStaticKeyProvider("secret")
+	dek, err := crypto.NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey failed: %v", err)
+	}
+	keyID, wrapped, err := provider.Wrap(dek)
+	if err != nil {
+		t.Fatalf("Wrap failed: %v", err)
+	}
+	if keyID != provider.ActiveKeyID() {
+		t.Errorf("Expected key ID %s, got %s", provider.ActiveKeyID(), keyID)
+	}
+	unwrapped, err := provider.Unwrap(keyID, wrapped)
+	if err != nil {
+		t.Fatalf("Unwrap failed: %v", err)
+	}
+	if !bytes.Equal(unwrapped, dek) {
+		t.Error("Unwrapped key doesn't match original")
+	}
+	other := crypto.NewStaticKeyProvider("other-secret")
+	if other.ActiveKeyID() == provider.ActiveKeyID() {
+		t.Error("Expected different secrets to have different key IDs")
+	}
+	if _, err := other.Unwrap(keyID, wrapped); err == nil {
+		t.Error("Expected unwrap with a different secret to fail")
+	}
+}
+func TestFileKeyProvider_Rotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	before, err := crypto.LoadKeyFile(writeKeyFile(t, "k1", map[string][]byte{"k1": oldKey}))
+	if err != nil {
+		t.Fatalf("LoadKeyFile failed: %v", err)
+	}
+	dek, _ := crypto.NewDataKey()
+	keyID, wrapped, err := before.Wrap(dek)
+	if err != nil {
+		t.Fatalf("Wrap failed: %v", err)
+	}
+	after, err := crypto.LoadKeyFile(writeKeyFile(t, "k2", map[string][]byte{"k1": oldKey, "k2": newKey}))
+	if err != nil {
+		t.Fatalf("LoadKeyFile failed: %v", err)
+	}
+	unwrapped, err := after.Unwrap(keyID, wrapped)
+	if err != nil {
+		t.Fatalf("Expected retired key to still unwrap, got %v", err)
+	}
+	newKeyID, _, err := after.Wrap(unwrapped)
+	if err != nil {
+		t.Fatalf("Wrap failed: %v", err)
+	}
+	if newKeyID != "k2" {
+		t.Errorf("Expected rewrap under k2, got %s", newKeyID)
+	}
+}
+func TestFileKeyProvider_MissingActiveKey(t *testing.T) {
+	_, err := crypto.LoadKeyFile(writeKeyFile(t, "k2", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}))
+	if err == nil {
+		t.Error("Expected error when active key is absent")
+	}
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/crypto"
+)
+
+func signTestJWS(t *testing.T, alg, kid string, claims map[string]interface{}, sign func([]byte) []byte) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func testIDTokenClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": "https://sso.example.com",
+		"sub": "subject-1",
+		"aud": []string{"gophkeeper-cli"},
+		"exp": now.Add(time.Minute).Unix(),
+		"iat": now.Unix(),
+	}
+}
+
+func TestVerifyIDToken_EdDSAAndES256(t *testing.T) {
+	edPublic, edPrivate, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keys := &crypto.JWKS{Keys: []crypto.JWK{
+		{Kty: "OKP", Kid: "ed", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(edPublic)},
+		{Kty: "EC", Kid: "ec", Crv: "P-256",
+			X: base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+			Y: base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32)))},
+	}}
+	edToken := signTestJWS(t, "EdDSA", "ed", testIDTokenClaims(), func(signed []byte) []byte {
+		return ed25519.Sign(edPrivate, signed)
+	})
+	ecToken := signTestJWS(t, "ES256", "ec", testIDTokenClaims(), func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	})
+	for name, token := range map[string]string{"EdDSA": edToken, "ES256": ecToken} {
+		claims, err := crypto.VerifyIDToken(token, keys, "https://sso.example.com", "gophkeeper-cli", time.Now())
+		if err != nil {
+			t.Fatalf("%s: VerifyIDToken failed: %v", name, err)
+		}
+		if claims.Subject != "subject-1" {
+			t.Errorf("%s: unexpected subject %q", name, claims.Subject)
+		}
+	}
+	swapped := strings.Replace(edToken, strings.Split(edToken, ".")[0],
+		base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","kid":"ed"}`)), 1)
+	if _, err := crypto.VerifyIDToken(swapped, keys, "https://sso.example.com", "gophkeeper-cli", time.Now()); err == nil {
+		t.Error("Expected an algorithm that does not fit the key to be rejected")
+	}
+	if _, err := crypto.VerifyIDToken(edToken, keys, "https://sso.example.com", "other-client", time.Now()); err == nil {
+		t.Error("Expected a token for another client to be rejected")
+	}
+	if _, err := crypto.VerifyIDToken(edToken, keys, "https://sso.example.com", "gophkeeper-cli", time.Now().Add(time.Hour)); err == nil {
+		t.Error("Expected an expired token to be rejected")
+	}
+}
+
+func TestVerifyJWS_RejectsSymmetricAlgorithms(t *testing.T) {
+	keys := &crypto.JWKS{Keys: []crypto.JWK{{Kty: "oct", Kid: "k"}}}
+	token := signTestJWS(t, "HS256", "k", testIDTokenClaims(), func([]byte) []byte { return []byte("sig") })
+	if _, _, err := crypto.VerifyJWS(token, keys); err == nil {
+		t.Error("Expected HS256 to be rejected")
+	}
+	token = signTestJWS(t, "EdDSA", "missing", testIDTokenClaims(), func([]byte) []byte { return []byte("sig") })
+	if _, _, err := crypto.VerifyJWS(token, keys); err != crypto.ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// RFC 7636, appendix B.
+	if got := crypto.PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Unexpected challenge %q", got)
+	}
+	verifier, err := crypto.NewPKCEVerifier()
+	if err != nil || len(verifier) < 43 {
+		t.Errorf("Expected a verifier of at least 43 characters, got %q (%v)", verifier, err)
+	}
+}
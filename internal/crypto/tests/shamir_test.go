@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gophkeeper/internal/crypto"
+)
+
+func TestShamir_AnyThresholdSubsetRecovers(t *testing.T) {
+	secret := []byte("a 32 byte secret for the vault!!")
+	shares, err := crypto.ShamirSplit(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("ShamirSplit failed: %v", err)
+	}
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		picked := make([][]byte, len(subset))
+		for i, idx := range subset {
+			picked[i] = shares[idx]
+		}
+		recovered, err := crypto.ShamirCombine(picked)
+		if err != nil {
+			t.Fatalf("ShamirCombine failed: %v", err)
+		}
+		if !bytes.Equal(recovered, secret) {
+			t.Errorf("Subset %v recovered %q", subset, recovered)
+		}
+	}
+	recovered, _ := crypto.ShamirCombine(shares[:2])
+	if bytes.Equal(recovered, secret) {
+		t.Error("Expected fewer than threshold shares not to recover the secret")
+	}
+}
+func TestShamir_RejectsInvalidParameters(t *testing.T) {
+	for _, p := range [][2]int{{3, 1}, {2, 3}, {256, 3}} {
+		if _, err := crypto.ShamirSplit([]byte("secret"), p[0], p[1]); err == nil {
+			t.Errorf("Expected %d shares with threshold %d to be rejected", p[0], p[1])
+		}
+	}
+}
+func TestRecoveryShares_PrintedForms(t *testing.T) {
+	key, _ := crypto.NewRecoveryKey()
+	shares, err := crypto.SplitRecoveryKey(key, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitRecoveryKey failed: %v", err)
+	}
+	mnemonic, err := crypto.ParseRecoveryShare(strings.ToUpper(shares[0].Mnemonic()))
+	if err != nil {
+		t.Fatalf("Failed to parse mnemonic share: %v", err)
+	}
+	encoded, err := crypto.ParseRecoveryShare(strings.ReplaceAll(shares[3].Base32(), "-", " "))
+	if err != nil {
+		t.Fatalf("Failed to parse base32 share: %v", err)
+	}
+	recovered, err := crypto.CombineRecoveryShares([]crypto.RecoveryShare{mnemonic, encoded, shares[4]})
+	if err != nil {
+		t.Fatalf("CombineRecoveryShares failed: %v", err)
+	}
+	if !bytes.Equal(recovered, key) {
+		t.Error("Expected printed shares to recover the key")
+	}
+	if _, err := crypto.CombineRecoveryShares(shares[:2]); err == nil {
+		t.Error("Expected fewer than threshold shares to be rejected")
+	}
+}
+func TestRecoveryShares_DetectTyposAndMixedKits(t *testing.T) {
+	key, _ := crypto.NewRecoveryKey()
+	shares, _ := crypto.SplitRecoveryKey(key, 3, 2)
+	mnemonic := []byte(shares[0].Mnemonic())
+	if mnemonic[0] == 'b' {
+		mnemonic[0] = 'd'
+	} else {
+		mnemonic[0] = 'b'
+	}
+	if _, err := crypto.ParseRecoveryShare(string(mnemonic)); err == nil {
+		t.Error("Expected a typo to fail the checksum")
+	}
+	other, _ := crypto.SplitRecoveryKey(key, 3, 2)
+	if other[0].SetID == shares[0].SetID {
+		other[0].SetID++
+	}
+	if _, err := crypto.CombineRecoveryShares([]crypto.RecoveryShare{shares[0], other[1]}); err == nil {
+		t.Error("Expected shares from different kits to be rejected")
+	}
+}
+func TestUnwrapKey(t *testing.T) {
+	vaultKey, _ := crypto.NewEncryptorFromKey(bytes.Repeat([]byte{1}, 32))
+	kek, _ := crypto.NewEncryptorFromKey(bytes.Repeat([]byte{2}, 32))
+	wrapped, err := vaultKey.WrapKey(kek, []byte("slot"))
+	if err != nil {
+		t.Fatalf("WrapKey failed: %v", err)
+	}
+	unwrapped, err := crypto.UnwrapKey(kek, wrapped, []byte("slot"))
+	if err != nil {
+		t.Fatalf("UnwrapKey failed: %v", err)
+	}
+	if !bytes.Equal(unwrapped.KeyCheck(), vaultKey.KeyCheck()) {
+		t.Error("Expected the unwrapped key to match")
+	}
+	if _, err := crypto.UnwrapKey(kek, wrapped, []byte("other")); err == nil {
+		t.Error("Expected a slot for another user to be rejected")
+	}
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"bytes"
+	"testing"
+
+	"gophkeeper/internal/crypto"
+)
+
+var srpTestParams = crypto.PasswordHashParams{Time: 1, Memory: 1024, Parallelism: 1}
+
+func srpHandshake(t *testing.T, password string) (*crypto.SRPClient, *crypto.SRPServer, []byte, []byte, error) {
+	salt, verifier, err := crypto.NewSRPVerifier("alice", "correct horse", srpTestParams)
+	if err != nil {
+		t.Fatalf("NewSRPVerifier failed: %v", err)
+	}
+	client, err := crypto.NewSRPClient("alice")
+	if err != nil {
+		t.Fatalf("NewSRPClient failed: %v", err)
+	}
+	server, err := crypto.NewSRPServer(verifier)
+	if err != nil {
+		t.Fatalf("NewSRPServer failed: %v", err)
+	}
+	proof, err := client.ComputeProof(password, salt, server.PublicKey(), srpTestParams)
+	if err != nil {
+		t.Fatalf("ComputeProof failed: %v", err)
+	}
+	serverProof, key, err := server.VerifyClientProof("alice", salt, client.PublicKey(), proof)
+	return client, server, serverProof, key, err
+}
+func TestSRP_Handshake(t *testing.T) {
+	client, _, serverProof, key, err := srpHandshake(t, "correct horse")
+	if err != nil {
+		t.Fatalf("Expected valid proof, got %v", err)
+	}
+	if !client.VerifyServerProof(serverProof) {
+		t.Error("Expected client to accept the server proof")
+	}
+	if !bytes.Equal(client.SessionKey(), key) {
+		t.Error("Expected both sides to derive the same session key")
+	}
+}
+func TestSRP_WrongPasswordIsRejected(t *testing.T) {
+	if _, _, _, _, err := srpHandshake(t, "wrong horse"); err == nil {
+		t.Fatal("Expected wrong password to be rejected")
+	}
+}
+func TestSRP_RestoredServerMatches(t *testing.T) {
+	salt, verifier, _ := crypto.NewSRPVerifier("alice", "pw", srpTestParams)
+	server, _ := crypto.NewSRPServer(verifier)
+	restored := crypto.RestoreSRPServer(verifier, server.Secret())
+	if !bytes.Equal(server.PublicKey(), restored.PublicKey()) {
+		t.Fatal("Expected restored server to have the same public key")
+	}
+	client, _ := crypto.NewSRPClient("alice")
+	proof, err := client.ComputeProof("pw", salt, restored.PublicKey(), srpTestParams)
+	if err != nil {
+		t.Fatalf("ComputeProof failed: %v", err)
+	}
+	if _, _, err := restored.VerifyClientProof("alice", salt, client.PublicKey(), proof); err != nil {
+		t.Fatalf("Expected restored server to verify the proof, got %v", err)
+	}
+}
+func TestSRP_RejectsZeroPublicKey(t *testing.T) {
+	salt, verifier, _ := crypto.NewSRPVerifier("alice", "pw", srpTestParams)
+	server, _ := crypto.NewSRPServer(verifier)
+	if _, _, err := server.VerifyClientProof("alice", salt, []byte{0}, []byte("proof")); err == nil {
+		t.Error("Expected A = 0 to be rejected")
+	}
+	client, _ := crypto.NewSRPClient("alice")
+	if _, err := client.ComputeProof("pw", salt, []byte{0}, srpTestParams); err == nil {
+		t.Error("Expected B = 0 to be rejected")
+	}
+}
+func TestFakeSRPSalt_IsStable(t *testing.T) {
+	a := crypto.FakeSRPSalt([]byte("key"), "ghost")
+	b := crypto.FakeSRPSalt([]byte("key"), "ghost")
+	c := crypto.FakeSRPSalt([]byte("key"), "other")
+	if !bytes.Equal(a, b) || bytes.Equal(a, c) {
+		t.Error("Expected fake salts to be stable per username")
+	}
+}
+func TestFakeSRPLegacy_IsStable(t *testing.T) {
+	legacy := 0
+	for i := 0; i < 64; i++ {
+		name := string(rune('a'+i%26)) + string(rune('a'+i/26))
+		if crypto.FakeSRPLegacy([]byte("key"), name) != crypto.FakeSRPLegacy([]byte("key"), name) {
+			t.Fatalf("Expected a stable answer for %s", name)
+		}
+		if crypto.FakeSRPLegacy([]byte("key"), name) {
+			legacy++
+		}
+	}
+	if legacy == 0 || legacy == 64 {
+		t.Errorf("Expected a mix of legacy and srp answers, got %d of 64 legacy", legacy)
+	}
+}
+func TestSRP_RejectsExcessiveCosts(t *testing.T) {
+	params := crypto.PasswordHashParams{Time: 1, Memory: crypto.MaxKDFMemory + 1, Parallelism: 1}
+	if _, _, err := crypto.NewSRPVerifier("alice", "pw", params); err == nil {
+		t.Error("Expected memory above the maximum to be rejected")
+	}
+	client, _ := crypto.NewSRPClient("alice")
+	serverPublic, _ := crypto.FakeSRPPublicKey()
+	params = crypto.PasswordHashParams{Time: crypto.MaxKDFTime + 1, Memory: 1024, Parallelism: 1}
+	if _, err := client.ComputeProof("pw", make([]byte, 16), serverPublic, params); err == nil {
+		t.Error("Expected time above the maximum to be rejected")
+	}
+}
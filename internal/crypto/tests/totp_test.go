@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/crypto"
+)
+
+// base32 of the RFC 6238 SHA1 test key "12345678901234567890".
+const rfcTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode_RFC6238Vectors(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for ts, expected := range vectors {
+		code, err := crypto.TOTPCode(rfcTOTPSecret, time.Unix(ts, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode failed: %v", err)
+		}
+		if code != expected {
+			t.Errorf("At %d expected %s, got %s", ts, expected, code)
+		}
+	}
+}
+func TestValidateTOTP_AllowsOneStepOfDrift(t *testing.T) {
+	secret, err := crypto.NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("NewTOTPSecret failed: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	previous, _ := crypto.TOTPCode(secret, now.Add(-30*time.Second))
+	counter, ok := crypto.ValidateTOTP(secret, previous, now)
+	if !ok {
+		t.Fatal("Expected code from the previous step to be accepted")
+	}
+	if counter != now.Unix()/30-1 {
+		t.Errorf("Expected counter of the previous step, got %d", counter)
+	}
+	stale, _ := crypto.TOTPCode(secret, now.Add(-90*time.Second))
+	if _, ok := crypto.ValidateTOTP(secret, stale, now); ok {
+		t.Error("Expected code three steps old to be rejected")
+	}
+	if _, ok := crypto.ValidateTOTP(secret, "12345", now); ok {
+		t.Error("Expected malformed code to be rejected")
+	}
+}
+func TestTOTPURI(t *testing.T) {
+	uri := crypto.TOTPURI("GophKeeper", "alice", rfcTOTPSecret)
+	if !strings.HasPrefix(uri, "otpauth://totp/GophKeeper:alice?") {
+		t.Errorf("Unexpected URI prefix: %s", uri)
+	}
+	if !strings.Contains(uri, "secret="+rfcTOTPSecret) || !strings.Contains(uri, "issuer=GophKeeper") {
+		t.Errorf("URI is missing secret or issuer: %s", uri)
+	}
+}
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, err := crypto.NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes failed: %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("Unexpected recovery code format: %s", code)
+		}
+		normalized := crypto.NormalizeRecoveryCode(strings.ToUpper(code))
+		if seen[normalized] {
+			t.Errorf("Duplicate recovery code %s", code)
+		}
+		seen[normalized] = true
+		if normalized != strings.ReplaceAll(code, "-", "") {
+			t.Errorf("Normalization mismatch for %s: %s", code, normalized)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const refreshTokenLength = 32
+
+// NewRefreshToken returns an opaque random refresh token. Only its hash is
+// ever stored on the server.
+func NewRefreshToken() (string, error) {
+	return randomToken(refreshTokenLength)
+}
+
+// HashToken returns the lookup hash stored for opaque bearer secrets.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// APITokenPrefix marks long-lived API tokens, which are opaque secrets
+// rather than JWTs.
+const APITokenPrefix = "gk_"
+
+// NewAPIToken returns a random API token. Only its hash is stored.
+func NewAPIToken() (string, error) {
+	secret, err := randomToken(refreshTokenLength)
+	if err != nil {
+		return "", err
+	}
+	return APITokenPrefix + secret, nil
+}
+
+// IsAPIToken tells API tokens from JWT access tokens.
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
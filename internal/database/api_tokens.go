@@ -0,0 +1,100 @@
+package database
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+	"github.com/lib/pq"
+)
+const apiTokenColumns = `id, user_id, name, read_only, item_ids, allowed_ips, expires_at, created_at, last_used_at, last_used_ip, revoked_at`
+// CreateAPIToken stores a token under the hash of its secret.
+func (db *DB) CreateAPIToken(token *models.APIToken, tokenHash string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	token.CreatedAt = time.Now()
+	query := `INSERT INTO api_tokens (id, user_id, name, token_hash, read_only, item_ids, allowed_ips, expires_at, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err = tx.Exec(query, token.ID, token.UserID, token.Name, tokenHash, token.ReadOnly,
+		pq.Array(nonNil(token.ItemIDs)), pq.Array(nonNil(token.AllowedIPs)), token.ExpiresAt, token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create api token: %w", err)
+	}
+	return db.commit(tx)
+}
+func (db *DB) GetAPITokenByHash(tokenHash string) (*models.APIToken, error) {
+	query := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE token_hash = $1`
+	token, err := scanAPIToken(db.conn.QueryRow(query, tokenHash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrAPITokenNotFound
+		}
+		return nil, fmt.Errorf("failed to get api token: %w", err)
+	}
+	return token, nil
+}
+func (db *DB) ListAPITokens(userID string) ([]models.APIToken, error) {
+	query := `SELECT ` + apiTokenColumns + ` FROM api_tokens WHERE user_id = $1 ORDER BY created_at`
+	rows, err := db.conn.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api tokens: %w", err)
+	}
+	defer rows.Close()
+	var tokens []models.APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api token: %w", err)
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+func (db *DB) RevokeAPIToken(userID, id string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`UPDATE api_tokens SET revoked_at = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to revoke api token: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM api_tokens WHERE id = $1 AND user_id = $2)`, id, userID).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to revoke api token: %w", err)
+		}
+		if !exists {
+			return models.ErrAPITokenNotFound
+		}
+	}
+	return db.commit(tx)
+}
+// TouchAPIToken records when and from where the token was last used.
+func (db *DB) TouchAPIToken(id, ip string, at time.Time) error {
+	if _, err := db.conn.Exec(`UPDATE api_tokens SET last_used_at = $2, last_used_ip = $3 WHERE id = $1`, id, at, ip); err != nil {
+		return fmt.Errorf("failed to update api token: %w", err)
+	}
+	return nil
+}
+func scanAPIToken(row rowScanner) (*models.APIToken, error) {
+	token := &models.APIToken{}
+	var lastUsedIP sql.NullString
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.ReadOnly, pq.Array(&token.ItemIDs), pq.Array(&token.AllowedIPs),
+		&token.ExpiresAt, &token.CreatedAt, &token.LastUsedAt, &lastUsedIP, &token.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	token.LastUsedIP = lastUsedIP.String
+	return token, nil
+}
+// nonNil keeps empty lists from being stored as NULL.
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -0,0 +1,136 @@
+package database
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+	"gophkeeper/internal/models"
+)
+// auditLockClass namespaces the advisory locks that serialize appends to
+// one user's chain.
+const auditLockClass = 0x61756474
+const auditEventColumns = `id, COALESCE(seq, 0), COALESCE(user_id, ''), event_type, COALESCE(item_id, ''), COALESCE(device_id, ''),
+			  COALESCE(api_token_id, ''), COALESCE(ip, ''), COALESCE(detail, ''), created_at, prev_hash, hash, COALESCE(key_id, '')`
+// pendingAudit is an event to append in the transaction of the next write.
+type pendingAudit struct {
+	event *models.AuditEvent
+	key   []byte
+}
+// Audited returns a handle on the same database whose writes append event,
+// hashed with key, in the transaction that stores them. A write fails and
+// is rolled back when its event cannot be appended.
+func (db *DB) Audited(event *models.AuditEvent, key []byte) *DB {
+	return &DB{conn: db.conn, audit: &pendingAudit{event: event, key: key}}
+}
+// commit appends the pending audit event, if any, and commits tx.
+func (db *DB) commit(tx *sql.Tx) error {
+	if db.audit != nil {
+		if err := appendAuditEvent(tx, db.audit.event, db.audit.key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+// AppendAuditEvent stores an event that goes with no write of its own.
+func (db *DB) AppendAuditEvent(event *models.AuditEvent, key []byte) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := appendAuditEvent(tx, event, key); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit audit event: %w", err)
+	}
+	return nil
+}
+// appendAuditEvent numbers the event, links it to the last event of its
+// user and stores it. Only appends to the same chain wait for each other.
+// ID, Seq, CreatedAt, PrevHash and Hash are set on the event.
+func appendAuditEvent(tx *sql.Tx, event *models.AuditEvent, key []byte) error {
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, hashtext($2))`, auditLockClass, event.UserID); err != nil {
+		return fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+	var lastSeq int64
+	var lastHash string
+	query := `SELECT seq, hash FROM audit_events WHERE COALESCE(user_id, '') = $1 AND seq IS NOT NULL ORDER BY seq DESC LIMIT 1`
+	err := tx.QueryRow(query, event.UserID).Scan(&lastSeq, &lastHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read audit head: %w", err)
+	}
+	event.Seq = lastSeq + 1
+	event.PrevHash = lastHash
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	event.Hash = event.ChainHash(key)
+	query = `INSERT INTO audit_events (seq, user_id, event_type, item_id, device_id, api_token_id, ip, detail, created_at, prev_hash, hash, key_id)
+			  VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11, NULLIF($12, ''))
+			  RETURNING id`
+	err = tx.QueryRow(query, event.Seq, event.UserID, event.Type, event.ItemID, event.DeviceID, event.APITokenID,
+		event.IP, event.Detail, event.CreatedAt, event.PrevHash, event.Hash, event.KeyID).Scan(&event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to append audit event: %w", err)
+	}
+	return nil
+}
+// ListAuditEvents returns the user's events newest first.
+func (db *DB) ListAuditEvents(userID string, q *models.AuditQuery) ([]models.AuditEvent, error) {
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events WHERE user_id = $1`
+	args := []interface{}{userID}
+	if q.Type != "" {
+		args = append(args, q.Type)
+		query += ` AND event_type = $` + strconv.Itoa(len(args))
+	}
+	if q.Since != nil {
+		args = append(args, q.Since.UTC())
+		query += ` AND created_at >= $` + strconv.Itoa(len(args))
+	}
+	if q.Before > 0 {
+		args = append(args, q.Before)
+		query += ` AND id < $` + strconv.Itoa(len(args))
+	}
+	args = append(args, q.Limit)
+	query += ` ORDER BY id DESC LIMIT $` + strconv.Itoa(len(args))
+	return db.queryAuditEvents(query, args...)
+}
+// ListAuditChains returns the users that have a chain, with an empty ID for
+// the events that belong to no account.
+func (db *DB) ListAuditChains() ([]string, error) {
+	rows, err := db.conn.Query(`SELECT DISTINCT COALESCE(user_id, '') FROM audit_events WHERE seq IS NOT NULL ORDER BY 1`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit chains: %w", err)
+	}
+	defer rows.Close()
+	var users []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan audit chain: %w", err)
+		}
+		users = append(users, userID)
+	}
+	return users, rows.Err()
+}
+// ListAuditChain returns up to limit events of the user's chain following
+// afterSeq, for verification.
+func (db *DB) ListAuditChain(userID string, afterSeq int64, limit int) ([]models.AuditEvent, error) {
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events WHERE COALESCE(user_id, '') = $1 AND seq > $2 ORDER BY seq LIMIT $3`
+	return db.queryAuditEvents(query, userID, afterSeq, limit)
+}
+func (db *DB) queryAuditEvents(query string, args ...interface{}) ([]models.AuditEvent, error) {
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var e models.AuditEvent
+		if err := rows.Scan(&e.ID, &e.Seq, &e.UserID, &e.Type, &e.ItemID, &e.DeviceID, &e.APITokenID, &e.IP, &e.Detail, &e.CreatedAt, &e.PrevHash, &e.Hash, &e.KeyID); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
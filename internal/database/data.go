@@ -11,13 +11,21 @@ func (db *DB) CreateStoredData(data *models.StoredData) error {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
-	query := `INSERT INTO stored_data (id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted) 
-			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	seq, err := nextChangeSeq(tx, data.UserID)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO stored_data (id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted, key_id, change_seq) 
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			  ON CONFLICT (id) DO NOTHING`
 	now := time.Now()
-	_, err = tx.Exec(query, data.ID, data.UserID, data.Type, data.Title, data.Data, data.Metadata, data.Version, now, now, now, data.IsDeleted)
+	result, err := tx.Exec(query, data.ID, data.UserID, data.Type, data.Title, data.Data, data.Metadata, data.Version, now, now, now, data.IsDeleted, data.KeyID, seq)
 	if err != nil {
 		return fmt.Errorf("failed to create stored data: %w", err)
 	}
+	if err := expectOneRow(result, models.ErrDataIDInUse); err != nil {
+		return err
+	}
 	if err := db.saveToHistory(tx, data); err != nil {
 		return fmt.Errorf("failed to save to history: %w", err)
 	}
@@ -27,26 +35,27 @@ func (db *DB) CreateStoredData(data *models.StoredData) error {
 	data.CreatedAt = now
 	data.UpdatedAt = now
 	data.LastSyncAt = now
-	return tx.Commit()
+	data.ChangeSeq = seq
+	return db.commit(tx)
 }
-func (db *DB) GetStoredDataByID(id string) (*models.StoredData, error) {
-	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted 
-			  FROM stored_data WHERE id = $1`
+func (db *DB) GetStoredDataByID(userID, id string) (*models.StoredData, error) {
+	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted, key_id 
+			  FROM stored_data WHERE id = $1 AND user_id = $2`
 	data := &models.StoredData{}
-	err := db.conn.QueryRow(query, id).Scan(
+	err := db.conn.QueryRow(query, id, userID).Scan(
 		&data.ID, &data.UserID, &data.Type, &data.Title, &data.Data, &data.Metadata,
-		&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted,
+		&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted, &data.KeyID,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("stored data not found")
+			return nil, models.ErrDataNotFound
 		}
 		return nil, fmt.Errorf("failed to get stored data: %w", err)
 	}
 	return data, nil
 }
 func (db *DB) GetStoredDataByUserID(userID string) ([]models.StoredData, error) {
-	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted 
+	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted, key_id 
 			  FROM stored_data WHERE user_id = $1 AND is_deleted = FALSE ORDER BY updated_at DESC`
 	rows, err := db.conn.Query(query, userID)
 	if err != nil {
@@ -58,7 +67,7 @@ func (db *DB) GetStoredDataByUserID(userID string) ([]models.StoredData, error)
 		var data models.StoredData
 		err := rows.Scan(
 			&data.ID, &data.UserID, &data.Type, &data.Title, &data.Data, &data.Metadata,
-			&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted,
+			&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted, &data.KeyID,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan stored data: %w", err)
@@ -67,10 +76,12 @@ func (db *DB) GetStoredDataByUserID(userID string) ([]models.StoredData, error)
 	}
 	return dataList, nil
 }
-func (db *DB) GetStoredDataByUserIDSince(userID string, since time.Time) ([]models.StoredData, error) {
-	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted 
-			  FROM stored_data WHERE user_id = $1 AND updated_at > $2 ORDER BY updated_at DESC`
-	rows, err := db.conn.Query(query, userID, since)
+// GetStoredDataChangedAfter returns the user's items, deleted ones included,
+// written after change afterSeq, in the order they were written.
+func (db *DB) GetStoredDataChangedAfter(userID string, afterSeq int64) ([]models.StoredData, error) {
+	query := `SELECT id, user_id, type, title, data, metadata, version, created_at, updated_at, last_sync_at, is_deleted, key_id, change_seq 
+			  FROM stored_data WHERE user_id = $1 AND change_seq > $2 ORDER BY change_seq, id`
+	rows, err := db.conn.Query(query, userID, afterSeq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query stored data: %w", err)
 	}
@@ -80,28 +91,57 @@ func (db *DB) GetStoredDataByUserIDSince(userID string, since time.Time) ([]mode
 		var data models.StoredData
 		err := rows.Scan(
 			&data.ID, &data.UserID, &data.Type, &data.Title, &data.Data, &data.Metadata,
-			&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted,
+			&data.Version, &data.CreatedAt, &data.UpdatedAt, &data.LastSyncAt, &data.IsDeleted, &data.KeyID, &data.ChangeSeq,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan stored data: %w", err)
 		}
 		dataList = append(dataList, data)
 	}
-	return dataList, nil
+	return dataList, rows.Err()
 }
+// UpdateStoredData writes data only if its version is newer than the stored
+// one, and returns ErrVersionConflict otherwise, so a replayed or stale write
+// cannot roll the item or its history back.
 func (db *DB) UpdateStoredData(data *models.StoredData) error {
+	return db.updateStoredData(data, nil)
+}
+// UpdateStoredDataFrom writes data as version baseVersion+1 only if the
+// stored item still has baseVersion, and returns ErrVersionConflict
+// otherwise. The version is taken from the stored row, not from data.
+func (db *DB) UpdateStoredDataFrom(data *models.StoredData, baseVersion int) error {
+	return db.updateStoredData(data, &baseVersion)
+}
+func (db *DB) updateStoredData(data *models.StoredData, baseVersion *int) error {
 	tx, err := db.conn.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
-	query := `UPDATE stored_data SET type = $2, title = $3, data = $4, metadata = $5, version = $6, updated_at = $7, last_sync_at = $8, is_deleted = $9 
-			  WHERE id = $1`
+	seq, err := nextChangeSeq(tx, data.UserID)
+	if err != nil {
+		return err
+	}
+	// Against a base version the row moves to the version after it.
+	query := `UPDATE stored_data SET type = $2, title = $3, data = $4, metadata = $5, updated_at = $7, last_sync_at = $8, is_deleted = $9, key_id = $10, change_seq = $12,
+			  version = CASE WHEN $13::int IS NULL THEN $6 ELSE version + 1 END
+			  WHERE id = $1 AND user_id = $11 AND (($13::int IS NULL AND version < $6) OR version = $13)
+			  RETURNING version`
 	now := time.Now()
 	data.UpdatedAt = now
 	data.LastSyncAt = now
-	data.Version++
-	_, err = tx.Exec(query, data.ID, data.Type, data.Title, data.Data, data.Metadata, data.Version, data.UpdatedAt, data.LastSyncAt, data.IsDeleted)
+	data.ChangeSeq = seq
+	err = tx.QueryRow(query, data.ID, data.Type, data.Title, data.Data, data.Metadata, data.Version, data.UpdatedAt, data.LastSyncAt, data.IsDeleted, data.KeyID, data.UserID, seq, baseVersion).Scan(&data.Version)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM stored_data WHERE id = $1 AND user_id = $2)`, data.ID, data.UserID).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to check stored data: %w", err)
+		}
+		if exists {
+			return models.ErrVersionConflict
+		}
+		return models.ErrDataNotFound
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update stored data: %w", err)
 	}
@@ -111,58 +151,71 @@ func (db *DB) UpdateStoredData(data *models.StoredData) error {
 	if err := db.cleanupHistory(tx, data.ID); err != nil {
 		return fmt.Errorf("failed to cleanup history: %w", err)
 	}
-	return tx.Commit()
-}
-func (db *DB) DeleteStoredData(id string) error {
-	query := `UPDATE stored_data SET is_deleted = TRUE, updated_at = $1 WHERE id = $2`
-	_, err := db.conn.Exec(query, time.Now(), id)
-	if err != nil {
-		return fmt.Errorf("failed to delete stored data: %w", err)
+	if data.Type == models.DataTypeSealed {
+		// Once an item is sealed its older versions should not keep the
+		// title and metadata readable either.
+		_, err := tx.Exec(`UPDATE data_history SET title = '', metadata = '' WHERE data_id = $1 AND user_id = $2`, data.ID, data.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to scrub history: %w", err)
+		}
 	}
-	return nil
+	return db.commit(tx)
 }
-func (db *DB) SyncStoredData(userID string, dataList []models.StoredData, lastSyncAt time.Time) ([]models.StoredData, error) {
+func (db *DB) DeleteStoredData(userID, id string) error {
 	tx, err := db.conn.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
-	serverData, err := db.GetStoredDataByUserIDSince(userID, lastSyncAt)
+	seq, err := nextChangeSeq(tx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get server data: %w", err)
+		return err
 	}
-	for _, clientData := range dataList {
-		existingData, err := db.GetStoredDataByID(clientData.ID)
-		if err != nil && err.Error() != "stored data not found" {
-			return nil, fmt.Errorf("failed to check existing data: %w", err)
-		}
-		if existingData == nil {
-			clientData.UserID = userID
-			clientData.LastSyncAt = time.Now()
-			if err := db.CreateStoredData(&clientData); err != nil {
-				return nil, fmt.Errorf("failed to create new data: %w", err)
-			}
-		} else {
-			if clientData.Version > existingData.Version {
-				clientData.UserID = userID
-				clientData.LastSyncAt = time.Now()
-				if err := db.UpdateStoredData(&clientData); err != nil {
-					return nil, fmt.Errorf("failed to update data: %w", err)
-				}
-			}
-		}
+	query := `UPDATE stored_data SET is_deleted = TRUE, updated_at = $1, change_seq = $4 WHERE id = $2 AND user_id = $3`
+	result, err := tx.Exec(query, time.Now(), id, userID, seq)
+	if err != nil {
+		return fmt.Errorf("failed to delete stored data: %w", err)
+	}
+	if err := expectOneRow(result, models.ErrDataNotFound); err != nil {
+		return err
+	}
+	return db.commit(tx)
+}
+// nextChangeSeq takes the user's next change sequence number for the items
+// written in tx. The counter row stays locked until tx ends, so writers of
+// one user commit in sequence order and a reader that sees change N has
+// also seen every change before it.
+func nextChangeSeq(tx *sql.Tx, userID string) (int64, error) {
+	var seq int64
+	err := tx.QueryRow(`UPDATE users SET change_seq = change_seq + 1 WHERE id = $1 RETURNING change_seq`, userID).Scan(&seq)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("user not found")
 	}
-	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	if err != nil {
+		return 0, fmt.Errorf("failed to advance change sequence: %w", err)
 	}
-	return serverData, nil
+	return seq, nil
 }
+// expectOneRow maps a write that matched no row, because the item is missing
+// or owned by someone else, to notFound.
+func expectOneRow(result sql.Result, notFound error) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %w", err)
+	}
+	if rows == 0 {
+		return notFound
+	}
+	return nil
+}
+// saveToHistory records a new version. History rows are never rewritten, so
+// a version that is already recorded fails the write.
 func (db *DB) saveToHistory(tx *sql.Tx, data *models.StoredData) error {
-	query := `INSERT INTO data_history (id, data_id, user_id, type, title, data, metadata, version, created_at, updated_at, is_deleted) 
-			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	query := `INSERT INTO data_history (id, data_id, user_id, type, title, data, metadata, version, created_at, updated_at, is_deleted, key_id) 
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
 	historyID := fmt.Sprintf("%s_v%d", data.ID, data.Version)
 	now := time.Now()
-	_, err := tx.Exec(query, historyID, data.ID, data.UserID, data.Type, data.Title, data.Data, data.Metadata, data.Version, now, now, data.IsDeleted)
+	_, err := tx.Exec(query, historyID, data.ID, data.UserID, data.Type, data.Title, data.Data, data.Metadata, data.Version, now, now, data.IsDeleted, data.KeyID)
 	if err != nil {
 		return fmt.Errorf("failed to insert history: %w", err)
 	}
@@ -182,10 +235,10 @@ func (db *DB) cleanupHistory(tx *sql.Tx, dataID string) error {
 	}
 	return nil
 }
-func (db *DB) GetDataHistory(dataID string) ([]models.DataHistory, error) {
-	query := `SELECT id, data_id, user_id, type, title, data, metadata, version, created_at, updated_at, is_deleted 
-			  FROM data_history WHERE data_id = $1 ORDER BY version DESC`
-	rows, err := db.conn.Query(query, dataID)
+func (db *DB) GetDataHistory(userID, dataID string) ([]models.DataHistory, error) {
+	query := `SELECT id, data_id, user_id, type, title, data, metadata, version, created_at, updated_at, is_deleted, key_id 
+			  FROM data_history WHERE data_id = $1 AND user_id = $2 ORDER BY version DESC`
+	rows, err := db.conn.Query(query, dataID, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query history: %w", err)
 	}
@@ -195,7 +248,7 @@ func (db *DB) GetDataHistory(dataID string) ([]models.DataHistory, error) {
 		var h models.DataHistory
 		err := rows.Scan(
 			&h.ID, &h.DataID, &h.UserID, &h.Type, &h.Title, &h.Data, &h.Metadata,
-			&h.Version, &h.CreatedAt, &h.UpdatedAt, &h.IsDeleted,
+			&h.Version, &h.CreatedAt, &h.UpdatedAt, &h.IsDeleted, &h.KeyID,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan history: %w", err)
@@ -204,3 +257,81 @@ func (db *DB) GetDataHistory(dataID string) ([]models.DataHistory, error) {
 	}
 	return history, nil
 }
+// GetUserHistory returns every history entry of the user.
+func (db *DB) GetUserHistory(userID string) ([]models.DataHistory, error) {
+	query := `SELECT id, data_id, user_id, type, title, data, metadata, version, created_at, updated_at, is_deleted, key_id 
+			  FROM data_history WHERE user_id = $1 ORDER BY data_id, version`
+	rows, err := db.conn.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+	var history []models.DataHistory
+	for rows.Next() {
+		var h models.DataHistory
+		err := rows.Scan(
+			&h.ID, &h.DataID, &h.UserID, &h.Type, &h.Title, &h.Data, &h.Metadata,
+			&h.Version, &h.CreatedAt, &h.UpdatedAt, &h.IsDeleted, &h.KeyID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan history: %w", err)
+		}
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+// GetServerEncryptedData returns rows still wrapped by a server key. An empty
+// keyID matches any key; otherwise only rows under that key are returned.
+func (db *DB) GetServerEncryptedData(keyID string, limit int) ([]models.StoredData, error) {
+	query := `SELECT id, user_id, data, version, key_id FROM stored_data
+			  WHERE key_id <> '' AND ($1::text = '' OR key_id = $1) ORDER BY id LIMIT $2`
+	rows, err := db.conn.Query(query, keyID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query server encrypted data: %w", err)
+	}
+	defer rows.Close()
+	var dataList []models.StoredData
+	for rows.Next() {
+		var data models.StoredData
+		if err := rows.Scan(&data.ID, &data.UserID, &data.Data, &data.Version, &data.KeyID); err != nil {
+			return nil, fmt.Errorf("failed to scan stored data: %w", err)
+		}
+		dataList = append(dataList, data)
+	}
+	return dataList, rows.Err()
+}
+func (db *DB) GetServerEncryptedHistory(keyID string, limit int) ([]models.DataHistory, error) {
+	query := `SELECT id, data_id, user_id, data, key_id FROM data_history
+			  WHERE key_id <> '' AND ($1::text = '' OR key_id = $1) ORDER BY id LIMIT $2`
+	rows, err := db.conn.Query(query, keyID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query server encrypted history: %w", err)
+	}
+	defer rows.Close()
+	var history []models.DataHistory
+	for rows.Next() {
+		var h models.DataHistory
+		if err := rows.Scan(&h.ID, &h.DataID, &h.UserID, &h.Data, &h.KeyID); err != nil {
+			return nil, fmt.Errorf("failed to scan history: %w", err)
+		}
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+// ReplaceStoredDataBlob swaps the stored ciphertext without touching the
+// version or history, for maintenance jobs that only change the server layer.
+// The write is skipped when the row changed since it was read.
+func (db *DB) ReplaceStoredDataBlob(id string, version int, fromKeyID string, blob []byte, keyID string) error {
+	query := `UPDATE stored_data SET data = $4, key_id = $5 WHERE id = $1 AND version = $2 AND key_id = $3`
+	if _, err := db.conn.Exec(query, id, version, fromKeyID, blob, keyID); err != nil {
+		return fmt.Errorf("failed to replace stored data: %w", err)
+	}
+	return nil
+}
+func (db *DB) ReplaceHistoryBlob(id string, fromKeyID string, blob []byte, keyID string) error {
+	query := `UPDATE data_history SET data = $3, key_id = $4 WHERE id = $1 AND key_id = $2`
+	if _, err := db.conn.Exec(query, id, fromKeyID, blob, keyID); err != nil {
+		return fmt.Errorf("failed to replace history: %w", err)
+	}
+	return nil
+}
@@ -6,6 +6,8 @@ import (
 )
 type DB struct {
 	conn *sql.DB
+	// audit is set on handles returned by Audited.
+	audit *pendingAudit
 }
 func (db *DB) Conn() *sql.DB {
 	return db.conn
@@ -0,0 +1,131 @@
+package database
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+)
+const deviceColumns = `id, user_id, name, platform, public_key, created_at, last_seen_at, revoked_at`
+// RegisterDevice stores a new device and binds the session family to it.
+func (db *DB) RegisterDevice(device *models.Device, familyID string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	device.CreatedAt = now
+	device.LastSeenAt = &now
+	query := `INSERT INTO devices (id, user_id, name, platform, public_key, created_at, last_seen_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $6)`
+	if _, err := tx.Exec(query, device.ID, device.UserID, device.Name, device.Platform, device.PublicKey, now); err != nil {
+		return fmt.Errorf("failed to create device: %w", err)
+	}
+	if err := bindSessionDevice(tx, device.UserID, familyID, device.ID); err != nil {
+		return err
+	}
+	return db.commit(tx)
+}
+// ResumeDevice binds the session family to a known device and refreshes its
+// name, platform and last-seen time. Revoked devices are refused.
+func (db *DB) ResumeDevice(device *models.Device, familyID string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	query := `UPDATE devices SET name = $3, platform = $4, last_seen_at = $5
+			  WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	result, err := tx.Exec(query, device.ID, device.UserID, device.Name, device.Platform, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return models.ErrDeviceRevoked
+	}
+	if err := bindSessionDevice(tx, device.UserID, familyID, device.ID); err != nil {
+		return err
+	}
+	return db.commit(tx)
+}
+// bindSessionDevice binds a session family that has no device yet. A family
+// already bound, or not found, fails with ErrSessionBound.
+func bindSessionDevice(exec execer, userID, familyID, deviceID string) error {
+	query := `UPDATE sessions SET device_id = $3 WHERE family_id = $1 AND user_id = $2 AND device_id IS NULL`
+	result, err := exec.Exec(query, familyID, userID, deviceID)
+	if err != nil {
+		return fmt.Errorf("failed to bind session to device: %w", err)
+	}
+	return expectOneRow(result, models.ErrSessionBound)
+}
+func (db *DB) GetDevice(userID, id string) (*models.Device, error) {
+	query := `SELECT ` + deviceColumns + ` FROM devices WHERE id = $1 AND user_id = $2`
+	device, err := scanDevice(db.conn.QueryRow(query, id, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrDeviceNotFound
+		}
+		return nil, fmt.Errorf("failed to get device: %w", err)
+	}
+	return device, nil
+}
+func (db *DB) ListDevices(userID string) ([]models.Device, error) {
+	query := `SELECT ` + deviceColumns + ` FROM devices WHERE user_id = $1 ORDER BY created_at`
+	rows, err := db.conn.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	defer rows.Close()
+	var devices []models.Device
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		devices = append(devices, *device)
+	}
+	return devices, rows.Err()
+}
+func (db *DB) TouchDevice(id string) error {
+	if _, err := db.conn.Exec(`UPDATE devices SET last_seen_at = $2 WHERE id = $1`, id, time.Now()); err != nil {
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+	return nil
+}
+// RevokeDevice marks the device revoked and ends every session bound to it.
+// Revoking a device again keeps its revocation time but still ends any
+// session found bound to it.
+func (db *DB) RevokeDevice(userID, id string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	result, err := tx.Exec(`UPDATE devices SET revoked_at = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, id, userID, now)
+	if err != nil {
+		return fmt.Errorf("failed to revoke device: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// Already revoked, or not the user's device.
+		if _, err := db.GetDevice(userID, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE sessions SET revoked_at = $2 WHERE device_id = $1 AND revoked_at IS NULL`, id, now); err != nil {
+		return fmt.Errorf("failed to revoke device sessions: %w", err)
+	}
+	return db.commit(tx)
+}
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+func scanDevice(row rowScanner) (*models.Device, error) {
+	device := &models.Device{}
+	err := row.Scan(&device.ID, &device.UserID, &device.Name, &device.Platform, &device.PublicKey,
+		&device.CreatedAt, &device.LastSeenAt, &device.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
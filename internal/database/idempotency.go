@@ -0,0 +1,67 @@
+package database
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+)
+// ReserveIdempotencyKey claims record.Key for a new request of the user.
+// A key is free when unused, created before expiredBefore, or still
+// unanswered since before abandonedBefore, e.g. after the server stopped
+// mid-request; taking it over is part of the same statement, so two
+// requests never both get it. It returns nil when the key was claimed and
+// otherwise the record already kept for it.
+func (db *DB) ReserveIdempotencyKey(record *models.IdempotencyRecord, expiredBefore, abandonedBefore time.Time) (*models.IdempotencyRecord, error) {
+	result, err := db.conn.Exec(`INSERT INTO idempotency_keys (user_id, key, request_hash, created_at)
+			  VALUES ($1, $2, $3, $4)
+			  ON CONFLICT (user_id, key) DO UPDATE SET request_hash = excluded.request_hash, status_code = NULL,
+			  response = NULL, created_at = excluded.created_at
+			  WHERE idempotency_keys.created_at < $5
+			  OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < $6)`,
+		record.UserID, record.Key, record.RequestHash, record.CreatedAt, expiredBefore, abandonedBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if rows == 1 {
+		return nil, nil
+	}
+	existing := &models.IdempotencyRecord{UserID: record.UserID, Key: record.Key}
+	var status sql.NullInt64
+	err = db.conn.QueryRow(`SELECT request_hash, status_code, response, created_at
+			  FROM idempotency_keys WHERE user_id = $1 AND key = $2`,
+		record.UserID, record.Key).Scan(&existing.RequestHash, &status, &existing.Response, &existing.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+	existing.StatusCode = int(status.Int64)
+	return existing, nil
+}
+// CompleteIdempotencyKey stores the response to the request holding the key.
+func (db *DB) CompleteIdempotencyKey(record *models.IdempotencyRecord) error {
+	_, err := db.conn.Exec(`UPDATE idempotency_keys SET status_code = $3, response = $4 WHERE user_id = $1 AND key = $2`,
+		record.UserID, record.Key, record.StatusCode, record.Response)
+	if err != nil {
+		return fmt.Errorf("failed to save idempotent response: %w", err)
+	}
+	return nil
+}
+// ReleaseIdempotencyKey frees a key so the request can be tried again.
+func (db *DB) ReleaseIdempotencyKey(userID, key string) error {
+	if _, err := db.conn.Exec(`DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2`, userID, key); err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+// DeleteExpiredIdempotencyKeys drops the records of every user created
+// before the given time.
+func (db *DB) DeleteExpiredIdempotencyKeys(before time.Time) (int64, error) {
+	result, err := db.conn.Exec(`DELETE FROM idempotency_keys WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,143 @@
+package database
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+)
+// CreateDataKey stores a new active data key unless the user already has one.
+// It reports false when another request won the race.
+func (db *DB) CreateDataKey(key *models.DataKey) (bool, error) {
+	query := `INSERT INTO user_data_keys (id, user_id, master_key_id, wrapped_key, active, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, TRUE, $5, $5)
+			  ON CONFLICT (user_id) WHERE active DO NOTHING`
+	now := time.Now()
+	res, err := db.conn.Exec(query, key.ID, key.UserID, key.MasterKeyID, key.WrappedKey, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to create data key: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to create data key: %w", err)
+	}
+	key.Active = true
+	key.CreatedAt = now
+	key.UpdatedAt = now
+	return n > 0, nil
+}
+func (db *DB) GetActiveDataKey(userID string) (*models.DataKey, error) {
+	query := `SELECT id, user_id, master_key_id, wrapped_key, active, created_at, updated_at
+			  FROM user_data_keys WHERE user_id = $1 AND active`
+	return db.scanDataKey(db.conn.QueryRow(query, userID))
+}
+func (db *DB) GetDataKey(id string) (*models.DataKey, error) {
+	query := `SELECT id, user_id, master_key_id, wrapped_key, active, created_at, updated_at
+			  FROM user_data_keys WHERE id = $1`
+	return db.scanDataKey(db.conn.QueryRow(query, id))
+}
+func (db *DB) scanDataKey(row *sql.Row) (*models.DataKey, error) {
+	key := &models.DataKey{}
+	err := row.Scan(&key.ID, &key.UserID, &key.MasterKeyID, &key.WrappedKey, &key.Active, &key.CreatedAt, &key.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrDataKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+	return key, nil
+}
+// GetDataKeysNotWrappedBy returns data keys still wrapped by a retired master key.
+func (db *DB) GetDataKeysNotWrappedBy(masterKeyID string, limit int) ([]models.DataKey, error) {
+	query := `SELECT id, user_id, master_key_id, wrapped_key, active, created_at, updated_at
+			  FROM user_data_keys WHERE master_key_id <> $1 ORDER BY id LIMIT $2`
+	rows, err := db.conn.Query(query, masterKeyID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query data keys: %w", err)
+	}
+	defer rows.Close()
+	var keys []models.DataKey
+	for rows.Next() {
+		var key models.DataKey
+		if err := rows.Scan(&key.ID, &key.UserID, &key.MasterKeyID, &key.WrappedKey, &key.Active, &key.CreatedAt, &key.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan data key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+func (db *DB) RewrapDataKey(id, fromMasterKeyID, masterKeyID string, wrapped []byte) error {
+	query := `UPDATE user_data_keys SET master_key_id = $3, wrapped_key = $4, updated_at = $5
+			  WHERE id = $1 AND master_key_id = $2`
+	if _, err := db.conn.Exec(query, id, fromMasterKeyID, masterKeyID, wrapped, time.Now()); err != nil {
+		return fmt.Errorf("failed to rewrap data key: %w", err)
+	}
+	return nil
+}
+// CreateSigningKey stores a JWT signing key. It reports false when another
+// instance already scheduled a key for the same activation time.
+func (db *DB) CreateSigningKey(key *models.SigningKey) (bool, error) {
+	query := `INSERT INTO jwt_signing_keys (id, algorithm, wrapping_key_id, private_key, created_at, activates_at, expires_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7)
+			  ON CONFLICT (activates_at) DO NOTHING`
+	key.CreatedAt = time.Now()
+	res, err := db.conn.Exec(query, key.ID, key.Algorithm, key.WrappingKeyID, key.PrivateKey, key.CreatedAt, key.ActivatesAt, key.ExpiresAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create signing key: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to create signing key: %w", err)
+	}
+	return n > 0, nil
+}
+// ListSigningKeys returns the signing keys that have not expired at now,
+// oldest activation first, and deletes the expired ones.
+func (db *DB) ListSigningKeys(now time.Time) ([]models.SigningKey, error) {
+	if _, err := db.conn.Exec(`DELETE FROM jwt_signing_keys WHERE expires_at <= $1`, now); err != nil {
+		return nil, fmt.Errorf("failed to delete expired signing keys: %w", err)
+	}
+	query := `SELECT id, algorithm, wrapping_key_id, private_key, created_at, activates_at, expires_at
+			  FROM jwt_signing_keys WHERE expires_at > $1 ORDER BY activates_at`
+	rows, err := db.conn.Query(query, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query signing keys: %w", err)
+	}
+	defer rows.Close()
+	var keys []models.SigningKey
+	for rows.Next() {
+		var key models.SigningKey
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.WrappingKeyID, &key.PrivateKey, &key.CreatedAt, &key.ActivatesAt, &key.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan signing key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+func (db *DB) CreateReceiptKey(key *models.ReceiptKey) error {
+	query := `INSERT INTO receipt_signing_keys (id, algorithm, wrapping_key_id, private_key, public_key, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6)`
+	key.CreatedAt = time.Now()
+	if _, err := db.conn.Exec(query, key.ID, key.Algorithm, key.WrappingKeyID, key.PrivateKey, key.PublicKey, key.CreatedAt); err != nil {
+		return fmt.Errorf("failed to create receipt key: %w", err)
+	}
+	return nil
+}
+// ListReceiptKeys returns every receipt key, oldest first.
+func (db *DB) ListReceiptKeys() ([]models.ReceiptKey, error) {
+	query := `SELECT id, algorithm, wrapping_key_id, private_key, public_key, created_at
+			  FROM receipt_signing_keys ORDER BY created_at, id`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query receipt keys: %w", err)
+	}
+	defer rows.Close()
+	var keys []models.ReceiptKey
+	for rows.Next() {
+		var key models.ReceiptKey
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.WrappingKeyID, &key.PrivateKey, &key.PublicKey, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan receipt key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS user_kdf_params (
+    user_id VARCHAR(36) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    algorithm VARCHAR(32) NOT NULL,
+    salt BYTEA NOT NULL,
+    time_cost INTEGER NOT NULL,
+    memory_cost INTEGER NOT NULL,
+    parallelism SMALLINT NOT NULL,
+    key_length INTEGER NOT NULL,
+    key_check BYTEA,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS user_kdf_params;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE stored_data ADD COLUMN IF NOT EXISTS server_encrypted BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE data_history ADD COLUMN IF NOT EXISTS server_encrypted BOOLEAN NOT NULL DEFAULT TRUE;
+CREATE INDEX IF NOT EXISTS idx_stored_data_server_encrypted ON stored_data(server_encrypted) WHERE server_encrypted;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_stored_data_server_encrypted;
+ALTER TABLE data_history DROP COLUMN IF EXISTS server_encrypted;
+ALTER TABLE stored_data DROP COLUMN IF EXISTS server_encrypted;
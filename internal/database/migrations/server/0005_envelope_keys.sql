@@ -0,0 +1,34 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS user_data_keys (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    master_key_id VARCHAR(64) NOT NULL,
+    wrapped_key BYTEA NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_data_keys_active ON user_data_keys(user_id) WHERE active;
+CREATE INDEX IF NOT EXISTS idx_user_data_keys_master_key_id ON user_data_keys(master_key_id);
+
+ALTER TABLE stored_data ADD COLUMN IF NOT EXISTS key_id VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE data_history ADD COLUMN IF NOT EXISTS key_id VARCHAR(64) NOT NULL DEFAULT '';
+UPDATE stored_data SET key_id = 'legacy' WHERE server_encrypted;
+UPDATE data_history SET key_id = 'legacy' WHERE server_encrypted;
+DROP INDEX IF EXISTS idx_stored_data_server_encrypted;
+ALTER TABLE stored_data DROP COLUMN IF EXISTS server_encrypted;
+ALTER TABLE data_history DROP COLUMN IF EXISTS server_encrypted;
+CREATE INDEX IF NOT EXISTS idx_stored_data_key_id ON stored_data(key_id);
+CREATE INDEX IF NOT EXISTS idx_data_history_key_id ON data_history(key_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_data_history_key_id;
+DROP INDEX IF EXISTS idx_stored_data_key_id;
+ALTER TABLE stored_data ADD COLUMN IF NOT EXISTS server_encrypted BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE data_history ADD COLUMN IF NOT EXISTS server_encrypted BOOLEAN NOT NULL DEFAULT TRUE;
+UPDATE stored_data SET server_encrypted = (key_id <> '');
+UPDATE data_history SET server_encrypted = (key_id <> '');
+CREATE INDEX IF NOT EXISTS idx_stored_data_server_encrypted ON stored_data(server_encrypted) WHERE server_encrypted;
+ALTER TABLE data_history DROP COLUMN IF EXISTS key_id;
+ALTER TABLE stored_data DROP COLUMN IF EXISTS key_id;
+DROP TABLE IF EXISTS user_data_keys;
@@ -0,0 +1,25 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS user_srp_verifiers (
+    user_id VARCHAR(36) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    salt BYTEA NOT NULL,
+    verifier BYTEA NOT NULL,
+    time_cost INTEGER NOT NULL,
+    memory_cost INTEGER NOT NULL,
+    parallelism SMALLINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS srp_handshakes (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_public BYTEA NOT NULL,
+    server_secret BYTEA NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_srp_handshakes_expires_at ON srp_handshakes(expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_srp_handshakes_expires_at;
+DROP TABLE IF EXISTS srp_handshakes;
+DROP TABLE IF EXISTS user_srp_verifiers;
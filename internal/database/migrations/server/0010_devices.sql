@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS devices (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    platform VARCHAR(100) NOT NULL,
+    public_key BYTEA NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_devices_user_id ON devices(user_id);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_id VARCHAR(36) REFERENCES devices(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_sessions_device_id ON sessions(device_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_sessions_device_id;
+ALTER TABLE sessions DROP COLUMN IF EXISTS device_id;
+DROP INDEX IF EXISTS idx_devices_user_id;
+DROP TABLE IF EXISTS devices;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS login_throttle (
+    key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL,
+    blocked_until TIMESTAMP,
+    locked BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS idx_login_throttle_last_failure_at ON login_throttle(last_failure_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_login_throttle_last_failure_at;
+DROP TABLE IF EXISTS login_throttle;
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS oidc_identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id VARCHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP,
+    PRIMARY KEY (issuer, subject),
+    UNIQUE (user_id, issuer)
+);
+CREATE TABLE IF NOT EXISTS oidc_nonces (
+    nonce_hash VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_oidc_nonces_expires_at ON oidc_nonces(expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_oidc_nonces_expires_at;
+DROP TABLE IF EXISTS oidc_nonces;
+DROP TABLE IF EXISTS oidc_identities;
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS jwt_signing_keys (
+    id VARCHAR(32) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    wrapping_key_id VARCHAR(64) NOT NULL,
+    private_key BYTEA NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    activates_at TIMESTAMP NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_jwt_signing_keys_expires_at ON jwt_signing_keys(expires_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_jwt_signing_keys_expires_at;
+DROP TABLE IF EXISTS jwt_signing_keys;
@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE stored_data ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT 0;
+UPDATE stored_data SET change_seq = numbered.seq
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY updated_at, id) AS seq FROM stored_data
+) AS numbered
+WHERE stored_data.id = numbered.id;
+UPDATE users SET change_seq = COALESCE((SELECT MAX(change_seq) FROM stored_data WHERE stored_data.user_id = users.id), 0);
+CREATE INDEX IF NOT EXISTS idx_stored_data_user_change_seq ON stored_data(user_id, change_seq);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_stored_data_user_change_seq;
+ALTER TABLE stored_data DROP COLUMN IF EXISTS change_seq;
+ALTER TABLE users DROP COLUMN IF EXISTS change_seq;
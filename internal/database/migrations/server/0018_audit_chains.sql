@@ -0,0 +1,15 @@
+-- +goose Up
+-- From here on each user's events form their own chain, numbered by seq and
+-- keyed with a server secret. Earlier events keep their rows but have no seq
+-- and belong to no chain.
+ALTER TABLE audit_events ADD COLUMN seq BIGINT;
+CREATE SEQUENCE IF NOT EXISTS audit_events_id_seq OWNED BY audit_events.id;
+SELECT setval('audit_events_id_seq', COALESCE((SELECT MAX(id) FROM audit_events), 0) + 1, false);
+ALTER TABLE audit_events ALTER COLUMN id SET DEFAULT nextval('audit_events_id_seq');
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_events_chain ON audit_events ((COALESCE(user_id, '')), seq) WHERE seq IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_audit_events_chain;
+ALTER TABLE audit_events ALTER COLUMN id DROP DEFAULT;
+DROP SEQUENCE IF EXISTS audit_events_id_seq;
+ALTER TABLE audit_events DROP COLUMN seq;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- A user has at most one login challenge. A new one replaces the old and
+-- keeps its attempts while it was live, so wrong codes add up across
+-- challenges instead of starting over with each password check.
+DELETE FROM mfa_challenges c USING mfa_challenges newer
+WHERE c.user_id = newer.user_id AND (c.created_at, c.id) < (newer.created_at, newer.id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mfa_challenges_user_id ON mfa_challenges(user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_mfa_challenges_user_id;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- Events name the key their hash was made with, so the audit key can be
+-- replaced. Events without one were keyed from the JWT secret.
+ALTER TABLE audit_events ADD COLUMN key_id VARCHAR(32);
+
+-- +goose Down
+ALTER TABLE audit_events DROP COLUMN key_id;
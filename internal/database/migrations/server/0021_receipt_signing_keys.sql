@@ -0,0 +1,12 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS receipt_signing_keys (
+    id VARCHAR(32) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    wrapping_key_id VARCHAR(64) NOT NULL,
+    private_key BYTEA NOT NULL,
+    public_key BYTEA NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS receipt_signing_keys;
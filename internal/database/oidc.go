@@ -0,0 +1,133 @@
+package database
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+)
+// CreateOIDCNonce stores the hash of a nonce handed out for an SSO login.
+func (db *DB) CreateOIDCNonce(nonceHash string, expiresAt time.Time) error {
+	now := time.Now()
+	query := `INSERT INTO oidc_nonces (nonce_hash, expires_at, created_at) VALUES ($1, $2, $3)`
+	if _, err := db.conn.Exec(query, nonceHash, expiresAt, now); err != nil {
+		return fmt.Errorf("failed to create oidc nonce: %w", err)
+	}
+	return nil
+}
+// DeleteExpiredOIDCNonces removes nonces that were never used.
+func (db *DB) DeleteExpiredOIDCNonces(now time.Time) (int64, error) {
+	result, err := db.conn.Exec(`DELETE FROM oidc_nonces WHERE expires_at < $1`, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired oidc nonces: %w", err)
+	}
+	return result.RowsAffected()
+}
+// TakeOIDCNonce removes an unexpired nonce, so each ID token is accepted
+// only once.
+func (db *DB) TakeOIDCNonce(nonceHash string) error {
+	result, err := db.conn.Exec(`DELETE FROM oidc_nonces WHERE nonce_hash = $1 AND expires_at > $2`, nonceHash, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to take oidc nonce: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to take oidc nonce: %w", err)
+	}
+	if rows == 0 {
+		return models.ErrInvalidIDToken
+	}
+	return nil
+}
+// GetUserByOIDCIdentity returns the account linked to the subject and
+// records the login.
+func (db *DB) GetUserByOIDCIdentity(issuer, subject string) (*models.User, error) {
+	var userID string
+	query := `UPDATE oidc_identities SET last_login_at = $3 WHERE issuer = $1 AND subject = $2 RETURNING user_id`
+	err := db.conn.QueryRow(query, issuer, subject, time.Now()).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrOIDCNotLinked
+		}
+		return nil, fmt.Errorf("failed to get oidc identity: %w", err)
+	}
+	return db.GetUserByID(userID)
+}
+// LinkOIDCIdentity links a subject to an account. Linking the same pair
+// again is a no-op.
+func (db *DB) LinkOIDCIdentity(identity *models.OIDCIdentity) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var owner string
+	err = tx.QueryRow(`SELECT user_id FROM oidc_identities WHERE issuer = $1 AND subject = $2`,
+		identity.Issuer, identity.Subject).Scan(&owner)
+	switch {
+	case err == nil && owner == identity.UserID:
+		return nil
+	case err == nil:
+		return models.ErrOIDCIdentityInUse
+	case err != sql.ErrNoRows:
+		return fmt.Errorf("failed to get oidc identity: %w", err)
+	}
+	identity.CreatedAt = time.Now()
+	query := `INSERT INTO oidc_identities (issuer, subject, user_id, email, created_at)
+			  VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`
+	result, err := tx.Exec(query, identity.Issuer, identity.Subject, identity.UserID, identity.Email, identity.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to link oidc identity: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to link oidc identity: %w", err)
+	}
+	if rows == 0 {
+		return models.ErrOIDCAlreadyLinked
+	}
+	if err := db.commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+func (db *DB) ListOIDCIdentities(userID string) ([]models.OIDCIdentity, error) {
+	query := `SELECT issuer, subject, user_id, COALESCE(email, ''), created_at, last_login_at
+			  FROM oidc_identities WHERE user_id = $1 ORDER BY created_at`
+	rows, err := db.conn.Query(query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list oidc identities: %w", err)
+	}
+	defer rows.Close()
+	var identities []models.OIDCIdentity
+	for rows.Next() {
+		var identity models.OIDCIdentity
+		if err := rows.Scan(&identity.Issuer, &identity.Subject, &identity.UserID, &identity.Email,
+			&identity.CreatedAt, &identity.LastLoginAt); err != nil {
+			return nil, fmt.Errorf("failed to scan oidc identity: %w", err)
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+func (db *DB) UnlinkOIDCIdentity(userID, issuer string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`DELETE FROM oidc_identities WHERE user_id = $1 AND issuer = $2`, userID, issuer)
+	if err != nil {
+		return fmt.Errorf("failed to unlink oidc identity: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to unlink oidc identity: %w", err)
+	}
+	if rows == 0 {
+		return models.ErrOIDCIdentityNotFound
+	}
+	if err := db.commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
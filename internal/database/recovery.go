@@ -0,0 +1,46 @@
+package database
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+)
+// execer is the part of *sql.DB and *sql.Tx used by writes that run both
+// alone and inside a larger transaction.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+func (db *DB) SaveRecoverySlot(userID string, slot *models.RecoverySlot) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := saveRecoverySlot(tx, userID, slot); err != nil {
+		return err
+	}
+	if err := db.commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+func (db *DB) GetRecoverySlot(userID string) (*models.RecoverySlot, error) {
+	slot := &models.RecoverySlot{}
+	err := db.conn.QueryRow(`SELECT wrapped_key, sealed_recovery_key, key_check FROM user_recovery_slots WHERE user_id = $1`, userID).Scan(&slot.WrappedKey, &slot.SealedRecoveryKey, &slot.KeyCheck)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrRecoveryNotConfigured
+		}
+		return nil, fmt.Errorf("failed to get recovery slot: %w", err)
+	}
+	return slot, nil
+}
+func saveRecoverySlot(exec execer, userID string, slot *models.RecoverySlot) error {
+	query := `INSERT INTO user_recovery_slots (user_id, wrapped_key, sealed_recovery_key, key_check, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $5)
+			  ON CONFLICT (user_id) DO UPDATE SET wrapped_key = $2, sealed_recovery_key = $3, key_check = $4, updated_at = $5`
+	if _, err := exec.Exec(query, userID, slot.WrappedKey, slot.SealedRecoveryKey, slot.KeyCheck, time.Now()); err != nil {
+		return fmt.Errorf("failed to save recovery slot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package database
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+)
+// RekeyVault swaps every item and history entry of the user for its
+// re-encrypted copy and stores the new KDF parameters in one transaction. Replaying a re-key that
+// already went through is accepted, so an interrupted client can resume.
+// The recovery slot wraps the old key, so it is replaced by slot or dropped.
+func (db *DB) RekeyVault(userID string, previousKeyCheck []byte, params *models.KDFParams, slot *models.RecoverySlot, dataList []models.StoredData, history []models.DataHistory) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var current []byte
+	err = tx.QueryRow(`SELECT key_check FROM user_kdf_params WHERE user_id = $1 FOR UPDATE`, userID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return models.ErrKDFNotConfigured
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock kdf params: %w", err)
+	}
+	if !bytes.Equal(current, previousKeyCheck) && !bytes.Equal(current, params.KeyCheck) {
+		return models.ErrRekeyConflict
+	}
+	var stored int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM stored_data WHERE user_id = $1`, userID).Scan(&stored); err != nil {
+		return fmt.Errorf("failed to count stored data: %w", err)
+	}
+	if stored != len(dataList) {
+		return models.ErrRekeyIncomplete
+	}
+	seen := make(map[string]bool, len(dataList))
+	for _, data := range dataList {
+		if seen[data.ID] {
+			return models.ErrRekeyIncomplete
+		}
+		seen[data.ID] = true
+	}
+	// Every device has to fetch the re-encrypted copies, so they are
+	// written as one change.
+	seq, err := nextChangeSeq(tx, userID)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE stored_data SET type = $4, title = $5, data = $6, metadata = $7, key_id = $8, updated_at = $9, change_seq = $10
+			  WHERE id = $1 AND user_id = $2 AND version = $3`
+	now := time.Now()
+	for i := range dataList {
+		data := &dataList[i]
+		data.UserID = userID
+		data.UpdatedAt = now
+		data.ChangeSeq = seq
+		result, err := tx.Exec(query, data.ID, userID, data.Version, data.Type, data.Title, data.Data, data.Metadata, data.KeyID, now, seq)
+		if err != nil {
+			return fmt.Errorf("failed to rekey stored data: %w", err)
+		}
+		// A missing, foreign or outdated item would leave a row under
+		// the old key, so the whole re-key is refused.
+		if err := expectOneRow(result, models.ErrRekeyIncomplete); err != nil {
+			return err
+		}
+	}
+	if err := rekeyHistory(tx, userID, history); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE user_kdf_params SET algorithm = $2, salt = $3, time_cost = $4, memory_cost = $5,
+			  parallelism = $6, key_length = $7, key_check = $8, updated_at = $9 WHERE user_id = $1`,
+		userID, params.Algorithm, params.Salt, params.Time, params.Memory, params.Parallelism, params.KeyLength, params.KeyCheck, now)
+	if err != nil {
+		return fmt.Errorf("failed to save kdf params: %w", err)
+	}
+	if slot != nil {
+		if err := saveRecoverySlot(tx, userID, slot); err != nil {
+			return err
+		}
+	} else if _, err := tx.Exec(`DELETE FROM user_recovery_slots WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to drop recovery slot: %w", err)
+	}
+	return tx.Commit()
+}
+// rekeyHistory replaces every history entry of the user with its
+// re-encrypted copy. An entry missing from history would stay sealed under
+// the old key, so the re-key is refused unless all of them are present.
+func rekeyHistory(tx *sql.Tx, userID string, history []models.DataHistory) error {
+	var stored int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM data_history WHERE user_id = $1`, userID).Scan(&stored); err != nil {
+		return fmt.Errorf("failed to count history: %w", err)
+	}
+	if stored != len(history) {
+		return models.ErrRekeyIncomplete
+	}
+	seen := make(map[string]bool, len(history))
+	query := `UPDATE data_history SET type = $5, title = $6, data = $7, metadata = $8, key_id = $9
+			  WHERE id = $1 AND user_id = $2 AND data_id = $3 AND version = $4`
+	for _, h := range history {
+		if seen[h.ID] {
+			return models.ErrRekeyIncomplete
+		}
+		seen[h.ID] = true
+		result, err := tx.Exec(query, h.ID, userID, h.DataID, h.Version, h.Type, h.Title, h.Data, h.Metadata, h.KeyID)
+		if err != nil {
+			return fmt.Errorf("failed to rekey history: %w", err)
+		}
+		if err := expectOneRow(result, models.ErrRekeyIncomplete); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package database
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+)
+func (db *DB) CreateSession(session *models.Session) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	query := `INSERT INTO sessions (id, family_id, user_id, token_hash, expires_at, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6)`
+	session.CreatedAt = time.Now()
+	if _, err := tx.Exec(query, session.ID, session.FamilyID, session.UserID, session.TokenHash, session.ExpiresAt, session.CreatedAt); err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return db.commit(tx)
+}
+// RotateSession exchanges the refresh token identified by tokenHash for next,
+// which must carry a fresh ID and token hash. Presenting a token that was
+// already rotated revokes the whole family and returns ErrRefreshTokenReused.
+func (db *DB) RotateSession(tokenHash string, next *models.Session) (*models.Session, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	current := &models.Session{}
+	query := `SELECT id, family_id, user_id, token_hash, expires_at, created_at, used_at, revoked_at, device_id
+			  FROM sessions WHERE token_hash = $1 FOR UPDATE`
+	err = tx.QueryRow(query, tokenHash).Scan(
+		&current.ID, &current.FamilyID, &current.UserID, &current.TokenHash,
+		&current.ExpiresAt, &current.CreatedAt, &current.UsedAt, &current.RevokedAt, &current.DeviceID,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if current.RevokedAt != nil {
+		return nil, models.ErrSessionRevoked
+	}
+	now := time.Now()
+	if current.UsedAt != nil {
+		if _, err := tx.Exec(`UPDATE sessions SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL`, current.FamilyID, now); err != nil {
+			return nil, fmt.Errorf("failed to revoke session family: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil, models.ErrRefreshTokenReused
+	}
+	if now.After(current.ExpiresAt) {
+		return nil, models.ErrSessionExpired
+	}
+	if _, err := tx.Exec(`UPDATE sessions SET used_at = $2 WHERE id = $1`, current.ID, now); err != nil {
+		return nil, fmt.Errorf("failed to mark session used: %w", err)
+	}
+	next.FamilyID = current.FamilyID
+	next.UserID = current.UserID
+	next.CreatedAt = now
+	next.DeviceID = current.DeviceID
+	_, err = tx.Exec(`INSERT INTO sessions (id, family_id, user_id, token_hash, expires_at, created_at, device_id) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		next.ID, next.FamilyID, next.UserID, next.TokenHash, next.ExpiresAt, next.CreatedAt, next.DeviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	if err := db.commit(tx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return next, nil
+}
+func (db *DB) GetSessionByTokenHash(tokenHash string) (*models.Session, error) {
+	query := `SELECT id, family_id, user_id, token_hash, expires_at, created_at, used_at, revoked_at, device_id
+			  FROM sessions WHERE token_hash = $1`
+	session := &models.Session{}
+	err := db.conn.QueryRow(query, tokenHash).Scan(
+		&session.ID, &session.FamilyID, &session.UserID, &session.TokenHash,
+		&session.ExpiresAt, &session.CreatedAt, &session.UsedAt, &session.RevokedAt, &session.DeviceID,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return session, nil
+}
+// IsSessionActive reports whether the family still holds an unrevoked,
+// unexpired refresh token.
+func (db *DB) IsSessionActive(familyID string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM sessions WHERE family_id = $1 AND revoked_at IS NULL AND expires_at > $2)`
+	var active bool
+	if err := db.conn.QueryRow(query, familyID, time.Now()).Scan(&active); err != nil {
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+	return active, nil
+}
+func (db *DB) RevokeSessionFamily(familyID string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	query := `UPDATE sessions SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL`
+	if _, err := tx.Exec(query, familyID, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return db.commit(tx)
+}
@@ -0,0 +1,77 @@
+package database
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+)
+func (db *DB) SaveSRPVerifier(userID string, verifier *models.SRPVerifier) error {
+	return saveSRPVerifier(db.conn, userID, verifier)
+}
+func saveSRPVerifier(exec execer, userID string, verifier *models.SRPVerifier) error {
+	query := `INSERT INTO user_srp_verifiers (user_id, salt, verifier, time_cost, memory_cost, parallelism, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+			  ON CONFLICT (user_id) DO UPDATE SET salt = $2, verifier = $3, time_cost = $4, memory_cost = $5,
+			  parallelism = $6, updated_at = $7`
+	_, err := exec.Exec(query, userID, verifier.Salt, verifier.Verifier, verifier.Time, verifier.Memory, verifier.Parallelism, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save srp verifier: %w", err)
+	}
+	return nil
+}
+func (db *DB) GetSRPVerifier(userID string) (*models.SRPVerifier, error) {
+	query := `SELECT salt, verifier, time_cost, memory_cost, parallelism FROM user_srp_verifiers WHERE user_id = $1`
+	verifier := &models.SRPVerifier{}
+	err := db.conn.QueryRow(query, userID).Scan(
+		&verifier.Salt, &verifier.Verifier, &verifier.Time, &verifier.Memory, &verifier.Parallelism,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrSRPNotConfigured
+		}
+		return nil, fmt.Errorf("failed to get srp verifier: %w", err)
+	}
+	return verifier, nil
+}
+func (db *DB) CreateSRPHandshake(handshake *models.SRPHandshake) error {
+	query := `INSERT INTO srp_handshakes (id, user_id, client_public, server_secret, expires_at, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6)`
+	handshake.CreatedAt = time.Now()
+	_, err := db.conn.Exec(query, handshake.ID, handshake.UserID, handshake.ClientPublic, handshake.ServerSecret, handshake.ExpiresAt, handshake.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create srp handshake: %w", err)
+	}
+	if _, err := db.conn.Exec(`DELETE FROM srp_handshakes WHERE expires_at < $1`, handshake.CreatedAt); err != nil {
+		return fmt.Errorf("failed to delete expired srp handshakes: %w", err)
+	}
+	return nil
+}
+// TakeSRPHandshake removes and returns an unexpired handshake, so each one
+// can be answered only once.
+func (db *DB) TakeSRPHandshake(id string) (*models.SRPHandshake, error) {
+	query := `DELETE FROM srp_handshakes WHERE id = $1 AND expires_at > $2
+			  RETURNING id, user_id, client_public, server_secret, expires_at, created_at`
+	handshake := &models.SRPHandshake{}
+	err := db.conn.QueryRow(query, id, time.Now()).Scan(
+		&handshake.ID, &handshake.UserID, &handshake.ClientPublic, &handshake.ServerSecret,
+		&handshake.ExpiresAt, &handshake.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrSRPHandshakeNotFound
+		}
+		return nil, fmt.Errorf("failed to get srp handshake: %w", err)
+	}
+	return handshake, nil
+}
+// HasLegacyAccounts reports whether any account still logs in with a
+// password hash and has no srp verifier yet.
+func (db *DB) HasLegacyAccounts() (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM users u WHERE u.password_hash <> ''
+			  AND NOT EXISTS (SELECT 1 FROM user_srp_verifiers v WHERE v.user_id = u.id))`
+	var exists bool
+	if err := db.conn.QueryRow(query).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check for legacy accounts: %w", err)
+	}
+	return exists, nil
+}
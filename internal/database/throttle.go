@@ -0,0 +1,57 @@
+package database
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+)
+// The methods below make *DB a login throttle store shared by all server
+// instances using the same database.
+func (db *DB) GetLoginThrottle(key string) (*models.LoginThrottle, error) {
+	query := `SELECT key, failures, last_failure_at, blocked_until, locked FROM login_throttle WHERE key = $1`
+	state, err := scanLoginThrottle(db.conn.QueryRow(query, key))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login throttle: %w", err)
+	}
+	return state, nil
+}
+// RecordLoginFailure counts a failure for key. The counter starts over when
+// the previous failure is older than window.
+func (db *DB) RecordLoginFailure(key string, now time.Time, window time.Duration) (*models.LoginThrottle, error) {
+	query := `INSERT INTO login_throttle (key, failures, last_failure_at) VALUES ($1, 1, $2)
+			  ON CONFLICT (key) DO UPDATE SET
+			  failures = CASE WHEN login_throttle.last_failure_at < $3 THEN 1 ELSE login_throttle.failures + 1 END,
+			  last_failure_at = $2
+			  RETURNING key, failures, last_failure_at, blocked_until, locked`
+	state, err := scanLoginThrottle(db.conn.QueryRow(query, key, now, now.Add(-window)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return state, nil
+}
+func (db *DB) BlockLogin(key string, until time.Time, locked bool) error {
+	if _, err := db.conn.Exec(`UPDATE login_throttle SET blocked_until = $2, locked = $3 WHERE key = $1`, key, until, locked); err != nil {
+		return fmt.Errorf("failed to block login: %w", err)
+	}
+	return nil
+}
+func (db *DB) ResetLoginThrottle(key string) error {
+	if _, err := db.conn.Exec(`DELETE FROM login_throttle WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("failed to reset login throttle: %w", err)
+	}
+	return nil
+}
+func scanLoginThrottle(row rowScanner) (*models.LoginThrottle, error) {
+	state := &models.LoginThrottle{}
+	var blockedUntil sql.NullTime
+	if err := row.Scan(&state.Key, &state.Failures, &state.LastFailureAt, &blockedUntil, &state.Locked); err != nil {
+		return nil, err
+	}
+	if blockedUntil.Valid {
+		state.BlockedUntil = &blockedUntil.Time
+	}
+	return state, nil
+}
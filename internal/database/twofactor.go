@@ -0,0 +1,182 @@
+package database
+import (
+	"database/sql"
+	"fmt"
+	"time"
+	"gophkeeper/internal/models"
+)
+// SaveTOTPSecret stores a pending secret, replacing any earlier unconfirmed
+// enrollment. An enabled secret is never overwritten.
+func (db *DB) SaveTOTPSecret(secret *models.TOTPSecret) error {
+	query := `INSERT INTO user_totp (user_id, secret, key_id, enabled, last_used_counter, created_at, updated_at)
+			  VALUES ($1, $2, $3, FALSE, 0, $4, $4)
+			  ON CONFLICT (user_id) DO UPDATE SET secret = $2, key_id = $3, last_used_counter = 0, updated_at = $4
+			  WHERE user_totp.enabled = FALSE`
+	now := time.Now()
+	result, err := db.conn.Exec(query, secret.UserID, secret.Secret, secret.KeyID, now)
+	if err != nil {
+		return fmt.Errorf("failed to save totp secret: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return models.ErrTOTPAlreadyEnabled
+	}
+	secret.CreatedAt = now
+	secret.UpdatedAt = now
+	return nil
+}
+func (db *DB) GetTOTPSecret(userID string) (*models.TOTPSecret, error) {
+	query := `SELECT user_id, secret, key_id, enabled, last_used_counter, created_at, updated_at
+			  FROM user_totp WHERE user_id = $1`
+	secret := &models.TOTPSecret{}
+	err := db.conn.QueryRow(query, userID).Scan(
+		&secret.UserID, &secret.Secret, &secret.KeyID, &secret.Enabled,
+		&secret.LastUsedCounter, &secret.CreatedAt, &secret.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrTOTPNotConfigured
+		}
+		return nil, fmt.Errorf("failed to get totp secret: %w", err)
+	}
+	return secret, nil
+}
+// UseTOTPCounter records counter as the last accepted step and reports false
+// when an equal or later step was already used, which makes replays fail.
+func (db *DB) UseTOTPCounter(userID string, counter int64) (bool, error) {
+	query := `UPDATE user_totp SET last_used_counter = $2, updated_at = $3
+			  WHERE user_id = $1 AND last_used_counter < $2`
+	result, err := db.conn.Exec(query, userID, counter, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp counter: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update totp counter: %w", err)
+	}
+	return rows == 1, nil
+}
+// EnableTOTP activates the pending secret and replaces the recovery codes in
+// one transaction.
+func (db *DB) EnableTOTP(userID string, codeHashes []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`UPDATE user_totp SET enabled = TRUE, updated_at = $2 WHERE user_id = $1 AND enabled = FALSE`, userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to enable totp: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return models.ErrTOTPAlreadyEnabled
+	}
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	if err := db.commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+func (db *DB) DisableTOTP(userID string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete totp secret: %w", err)
+	}
+	if err := db.commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+func (db *DB) ReplaceRecoveryCodes(userID string, codeHashes []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	if err := db.commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+// UseRecoveryCode burns the code with the given hash and reports whether an
+// unused one existed.
+func (db *DB) UseRecoveryCode(userID, codeHash string) (bool, error) {
+	query := `UPDATE recovery_codes SET used_at = $3 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	result, err := db.conn.Exec(query, userID, codeHash, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return rows == 1, nil
+}
+// CreateMFAChallenge replaces the user's login challenge. Attempts add up
+// across the challenges of one window, which starts with the first of them,
+// so a new password check does not buy more guesses at the second factor.
+func (db *DB) CreateMFAChallenge(challenge *models.MFAChallenge, window time.Duration) error {
+	query := `INSERT INTO mfa_challenges (id, user_id, token_hash, attempts, expires_at, created_at)
+			  VALUES ($1, $2, $3, 0, $4, $5)
+			  ON CONFLICT (user_id) DO UPDATE SET id = excluded.id, token_hash = excluded.token_hash, expires_at = excluded.expires_at,
+			  attempts = CASE WHEN mfa_challenges.created_at > $6 THEN mfa_challenges.attempts ELSE 0 END,
+			  created_at = CASE WHEN mfa_challenges.created_at > $6 THEN mfa_challenges.created_at ELSE excluded.created_at END`
+	now := time.Now()
+	_, err := db.conn.Exec(query, challenge.ID, challenge.UserID, challenge.TokenHash, challenge.ExpiresAt, now, now.Add(-window))
+	if err != nil {
+		return fmt.Errorf("failed to create mfa challenge: %w", err)
+	}
+	if _, err := db.conn.Exec(`DELETE FROM mfa_challenges WHERE expires_at < $1`, now); err != nil {
+		return fmt.Errorf("failed to delete expired mfa challenges: %w", err)
+	}
+	return nil
+}
+// GetMFAChallenge returns an unexpired challenge and counts the attempt
+// against it.
+func (db *DB) GetMFAChallenge(tokenHash string) (*models.MFAChallenge, error) {
+	query := `UPDATE mfa_challenges SET attempts = attempts + 1
+			  WHERE token_hash = $1 AND expires_at > $2
+			  RETURNING id, user_id, token_hash, attempts, expires_at, created_at`
+	challenge := &models.MFAChallenge{}
+	err := db.conn.QueryRow(query, tokenHash, time.Now()).Scan(
+		&challenge.ID, &challenge.UserID, &challenge.TokenHash,
+		&challenge.Attempts, &challenge.ExpiresAt, &challenge.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrMFAChallengeNotFound
+		}
+		return nil, fmt.Errorf("failed to get mfa challenge: %w", err)
+	}
+	return challenge, nil
+}
+func (db *DB) DeleteMFAChallenge(id string) error {
+	if _, err := db.conn.Exec(`DELETE FROM mfa_challenges WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete mfa challenge: %w", err)
+	}
+	return nil
+}
+func replaceRecoveryCodes(tx *sql.Tx, userID string, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	now := time.Now()
+	for _, hash := range codeHashes {
+		_, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, $3)`, userID, hash, now)
+		if err != nil {
+			return fmt.Errorf("failed to save recovery code: %w", err)
+		}
+	}
+	return nil
+}
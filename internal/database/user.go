@@ -6,16 +6,20 @@ import (
 	"gophkeeper/internal/models"
 )
 func (db *DB) CreateUser(user *models.User) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 	query := `INSERT INTO users (id, username, email, password_hash, created_at, updated_at) 
 			  VALUES ($1, $2, $3, $4, $5, $6)`
 	now := time.Now()
-	_, err := db.conn.Exec(query, user.ID, user.Username, user.Email, user.PasswordHash, now, now)
-	if err != nil {
+	if _, err := tx.Exec(query, user.ID, user.Username, user.Email, user.PasswordHash, now, now); err != nil {
 		return fmt.Errorf("failed to create user: %w", err)
 	}
 	user.CreatedAt = now
 	user.UpdatedAt = now
-	return nil
+	return db.commit(tx)
 }
 func (db *DB) GetUserByUsername(username string) (*models.User, error) {
 	query := `SELECT id, username, email, password_hash, created_at, updated_at 
@@ -66,20 +70,111 @@ func (db *DB) GetUserByID(id string) (*models.User, error) {
 	return user, nil
 }
 func (db *DB) UpdateUser(user *models.User) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 	query := `UPDATE users SET username = $2, email = $3, password_hash = $4, updated_at = $5 
 			  WHERE id = $1`
 	user.UpdatedAt = time.Now()
-	_, err := db.conn.Exec(query, user.ID, user.Username, user.Email, user.PasswordHash, user.UpdatedAt)
-	if err != nil {
+	if _, err := tx.Exec(query, user.ID, user.Username, user.Email, user.PasswordHash, user.UpdatedAt); err != nil {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
+	return db.commit(tx)
+}
+func (db *DB) UpdatePasswordHash(userID, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $2, updated_at = $3 WHERE id = $1`
+	_, err := db.conn.Exec(query, userID, passwordHash, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to update password hash: %w", err)
+	}
 	return nil
 }
-func (db *DB) DeleteUser(id string) error {
-	query := `DELETE FROM users WHERE id = $1`
-	_, err := db.conn.Exec(query, id)
+// ChangePassword swaps the SRP verifier, drops any legacy password hash so
+// the old password stops working everywhere, and revokes every session of
+// the user except keepFamilyID.
+func (db *DB) ChangePassword(userID string, verifier *models.SRPVerifier, keepFamilyID string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	if err := saveSRPVerifier(tx, userID, verifier); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET password_hash = '', updated_at = $2 WHERE id = $1`, userID, now); err != nil {
+		return fmt.Errorf("failed to clear password hash: %w", err)
+	}
+	query := `UPDATE sessions SET revoked_at = $3 WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL`
+	if _, err := tx.Exec(query, userID, keepFamilyID, now); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return db.commit(tx)
+}
+// DeleteUser erases the user with all stored data and history and returns
+// how many item and history rows were removed. Everything else keyed by the
+// user goes with it through ON DELETE CASCADE.
+func (db *DB) DeleteUser(id string) (int64, int64, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`DELETE FROM data_history WHERE user_id = $1`, id)
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return 0, 0, fmt.Errorf("failed to delete data history: %w", err)
+	}
+	history, _ := result.RowsAffected()
+	result, err = tx.Exec(`DELETE FROM stored_data WHERE user_id = $1`, id)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete stored data: %w", err)
+	}
+	items, _ := result.RowsAffected()
+	result, err = tx.Exec(`DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, 0, fmt.Errorf("user not found")
+	}
+	if err := db.commit(tx); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return items, history, nil
+}
+func (db *DB) SaveKDFParams(userID string, params *models.KDFParams) error {
+	query := `INSERT INTO user_kdf_params (user_id, algorithm, salt, time_cost, memory_cost, parallelism, key_length, key_check, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+			  ON CONFLICT (user_id) DO UPDATE SET algorithm = $2, salt = $3, time_cost = $4, memory_cost = $5,
+			  parallelism = $6, key_length = $7, key_check = $8, updated_at = $9`
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(query, userID, params.Algorithm, params.Salt, params.Time, params.Memory, params.Parallelism, params.KeyLength, params.KeyCheck, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save kdf params: %w", err)
+	}
+	if err := db.commit(tx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 	return nil
 }
+func (db *DB) GetKDFParams(userID string) (*models.KDFParams, error) {
+	query := `SELECT algorithm, salt, time_cost, memory_cost, parallelism, key_length, key_check
+			  FROM user_kdf_params WHERE user_id = $1`
+	params := &models.KDFParams{}
+	err := db.conn.QueryRow(query, userID).Scan(
+		&params.Algorithm, &params.Salt, &params.Time, &params.Memory, &params.Parallelism, &params.KeyLength, &params.KeyCheck,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, models.ErrKDFNotConfigured
+		}
+		return nil, fmt.Errorf("failed to get kdf params: %w", err)
+	}
+	return params, nil
+}
@@ -0,0 +1,42 @@
+package models
+import "errors"
+// PasswordProof re-proves the account password for sensitive changes. SRP
+// accounts answer a fresh /srp/init handshake; accounts without a verifier
+// send the password itself.
+type PasswordProof struct {
+	HandshakeID string `json:"handshake_id,omitempty"`
+	ClientProof []byte `json:"m1,omitempty"`
+	Password    string `json:"password,omitempty"`
+}
+// ChangePasswordRequest replaces the account password with a new SRP
+// verifier. Sessions other than the calling one are revoked.
+type ChangePasswordRequest struct {
+	Proof PasswordProof `json:"proof"`
+	SRP   *SRPVerifier  `json:"srp" validate:"required"`
+}
+type ChangeEmailRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+type DeleteAccountRequest struct {
+	Proof PasswordProof `json:"proof"`
+}
+// DeletionReceipt records what was erased when an account was deleted.
+type DeletionReceipt struct {
+	UserID         string `json:"user_id"`
+	Username       string `json:"username"`
+	DeletedItems   int64  `json:"deleted_items"`
+	DeletedHistory int64  `json:"deleted_history"`
+	DeletedAt      int64  `json:"deleted_at"`
+}
+// AccountDeletionResponse carries the receipt and the same receipt signed
+// by the server, which the user can keep as proof of the erasure.
+type AccountDeletionResponse struct {
+	Receipt   DeletionReceipt `json:"receipt"`
+	Signature string          `json:"signature"`
+}
+var (
+	ErrEmailInUse = errors.New("email already in use")
+	// ErrInvalidAccountRequest marks account changes refused because of
+	// what was sent rather than a failure on the server.
+	ErrInvalidAccountRequest = errors.New("invalid request")
+)
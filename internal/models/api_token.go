@@ -0,0 +1,91 @@
+package models
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+)
+// APIToken is a long-lived credential for automation such as CI jobs. An
+// empty ItemIDs allows every item, an empty AllowedIPs every address.
+type APIToken struct {
+	ID         string     `json:"id" db:"id"`
+	UserID     string     `json:"-" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	ReadOnly   bool       `json:"read_only" db:"read_only"`
+	ItemIDs    []string   `json:"item_ids,omitempty" db:"item_ids"`
+	AllowedIPs []string   `json:"allowed_ips,omitempty" db:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip,omitempty" db:"last_used_ip"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+// AllowsItem reports whether the token's scope covers the item.
+func (t *APIToken) AllowsItem(id string) bool {
+	if len(t.ItemIDs) == 0 {
+		return true
+	}
+	for _, allowed := range t.ItemIDs {
+		if allowed == id {
+			return true
+		}
+	}
+	return false
+}
+// AllowsIP reports whether ip matches one of the allowed addresses or CIDR
+// ranges.
+func (t *APIToken) AllowsIP(ip string) bool {
+	if len(t.AllowedIPs) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, allowed := range t.AllowedIPs {
+		if _, network, err := net.ParseCIDR(allowed); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if other := net.ParseIP(allowed); other != nil && other.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+// APITokenRequest asks for a new API token. Tokens are read-only unless
+// read_only is sent as false.
+type APITokenRequest struct {
+	Name       string     `json:"name" validate:"required"`
+	ReadOnly   bool       `json:"read_only"`
+	ItemIDs    []string   `json:"item_ids,omitempty"`
+	AllowedIPs []string   `json:"allowed_ips,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+func (r *APITokenRequest) UnmarshalJSON(data []byte) error {
+	type plain APITokenRequest
+	decoded := plain{ReadOnly: true}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*r = APITokenRequest(decoded)
+	return nil
+}
+// APITokenResponse carries the secret, which is shown only once.
+type APITokenResponse struct {
+	Token    string   `json:"token"`
+	APIToken APIToken `json:"api_token"`
+}
+// APITokenInfo describes the calling API token, with what a client needs
+// to open the vault.
+type APITokenInfo struct {
+	APIToken APIToken   `json:"api_token"`
+	UserID   string     `json:"user_id"`
+	Username string     `json:"username"`
+	KDF      *KDFParams `json:"kdf,omitempty"`
+}
+var (
+	ErrAPITokenNotFound = errors.New("api token not found")
+	ErrInvalidAPIToken  = errors.New("invalid api token")
+	ErrAPITokenScope    = errors.New("not permitted by the api token scope")
+)
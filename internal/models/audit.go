@@ -0,0 +1,133 @@
+package models
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+// Audit event types.
+const (
+	AuditAccountRegistered = "account.register"
+	AuditLoginSucceeded    = "login.success"
+	AuditLoginFailed       = "login.failure"
+	AuditLogout            = "logout"
+	AuditPasswordChanged   = "account.password"
+	AuditEmailChanged      = "account.email"
+	AuditAccountDeleted    = "account.delete"
+	AuditItemsRead         = "item.read"
+	AuditItemCreated       = "item.create"
+	AuditItemUpdated       = "item.update"
+	AuditItemDeleted       = "item.delete"
+	AuditSync              = "sync"
+	AuditAPITokenCreated   = "api_token.create"
+	AuditAPITokenRevoked   = "api_token.revoke"
+	AuditDeviceRegistered  = "device.register"
+	AuditDeviceRevoked     = "device.revoke"
+	AuditSessionRefreshed  = "session.refresh"
+	AuditKDFConfigured     = "account.kdf"
+	AuditRecoverySlotSaved = "account.recovery_slot"
+	AuditVaultRekeyed      = "vault.rekey"
+	AuditTOTPEnabled       = "2fa.enable"
+	AuditTOTPDisabled      = "2fa.disable"
+	AuditRecoveryCodesNew  = "2fa.recovery_codes"
+	AuditOIDCLinked        = "oidc.link"
+	AuditOIDCUnlinked      = "oidc.unlink"
+)
+var ErrAuditChainBroken = errors.New("audit chain is broken")
+// AuditActor is who makes a request: the account and, when known, the
+// device, the API token and the client address.
+type AuditActor struct {
+	UserID     string
+	DeviceID   string
+	APITokenID string
+	IP         string
+}
+// AuditEvent is a row of the append-only audit log. Each user's events form
+// a chain: Seq numbers them and Hash, keyed with a server secret, covers the
+// event and PrevHash, the hash of the user's event before it. Changing,
+// removing or reordering rows breaks the chain from that point on, and
+// without the key a forged row cannot be made to fit. KeyID names the key;
+// it is empty for events keyed before keys were named.
+type AuditEvent struct {
+	ID         int64     `json:"id" db:"id"`
+	Seq        int64     `json:"seq" db:"seq"`
+	UserID     string    `json:"user_id,omitempty" db:"user_id"`
+	Type       string    `json:"type" db:"event_type"`
+	ItemID     string    `json:"item_id,omitempty" db:"item_id"`
+	DeviceID   string    `json:"device_id,omitempty" db:"device_id"`
+	APITokenID string    `json:"api_token_id,omitempty" db:"api_token_id"`
+	IP         string    `json:"ip,omitempty" db:"ip"`
+	Detail     string    `json:"detail,omitempty" db:"detail"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	PrevHash   string    `json:"prev_hash" db:"prev_hash"`
+	Hash       string    `json:"hash" db:"hash"`
+	KeyID      string    `json:"key_id,omitempty" db:"key_id"`
+}
+// AuditQuery filters a user's audit events. Events are returned newest
+// first; Before pages through older ones by event ID.
+type AuditQuery struct {
+	Type   string
+	Since  *time.Time
+	Before int64
+	Limit  int
+}
+// AuditHead is the last event of a user's chain. UserID is empty for the
+// chain of events that belong to no account.
+type AuditHead struct {
+	UserID string `json:"user_id"`
+	Seq    int64  `json:"seq"`
+	Hash   string `json:"hash"`
+}
+// AuditVerification is the result of checking the chains. The chains alone
+// cannot show that events were cut off their end; the heads, kept outside
+// the database and passed to the next check, can.
+type AuditVerification struct {
+	Events int64       `json:"events"`
+	Heads  []AuditHead `json:"heads"`
+}
+// ChainHash computes the HMAC-SHA256 of the event's fields and PrevHash
+// under key. Fields are length-prefixed, so no two events encode the same
+// way. KeyID is covered when set, leaving unnamed events as they were.
+func (e *AuditEvent) ChainHash(key []byte) string {
+	h := hmac.New(sha256.New, key)
+	fields := []string{
+		e.PrevHash,
+		strconv.FormatInt(e.Seq, 10),
+		e.UserID,
+		e.Type,
+		e.ItemID,
+		e.DeviceID,
+		e.APITokenID,
+		e.IP,
+		e.Detail,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+	if e.KeyID != "" {
+		fields = append(fields, e.KeyID)
+	}
+	for _, field := range fields {
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(field)))
+		h.Write(size[:])
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+// Follows checks that the event directly succeeds the event with prevSeq
+// and prevHash in its user's chain and that its own hash is intact.
+func (e *AuditEvent) Follows(prevSeq int64, prevHash string, key []byte) error {
+	if e.Seq != prevSeq+1 {
+		return fmt.Errorf("%w: event %d of user %q follows event %d", ErrAuditChainBroken, e.Seq, e.UserID, prevSeq)
+	}
+	if e.PrevHash != prevHash {
+		return fmt.Errorf("%w: event %d of user %q does not link to event %d", ErrAuditChainBroken, e.Seq, e.UserID, prevSeq)
+	}
+	if !hmac.Equal([]byte(e.Hash), []byte(e.ChainHash(key))) {
+		return fmt.Errorf("%w: event %d of user %q was modified", ErrAuditChainBroken, e.Seq, e.UserID)
+	}
+	return nil
+}
@@ -1,5 +1,6 @@
 package models
 import (
+	"errors"
 	"time"
 )
 type DataType string
@@ -8,6 +9,9 @@ const (
 	DataTypeText          DataType = "text"
 	DataTypeBinary        DataType = "binary"
 	DataTypeBankCard      DataType = "bank_card"
+	// DataTypeSealed is what the server sees for items whose real type,
+	// title and metadata are encrypted inside the data blob.
+	DataTypeSealed DataType = "sealed"
 )
 type StoredData struct {
 	ID         string    `json:"id" db:"id"`
@@ -21,6 +25,16 @@ type StoredData struct {
 	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
 	LastSyncAt time.Time `json:"last_sync_at" db:"last_sync_at"`
 	IsDeleted  bool      `json:"is_deleted" db:"is_deleted"`
+	// KeyID names the server data key wrapping the client ciphertext: empty
+	// when stored as sent, LegacyKeyID or a user_data_keys id otherwise.
+	// It never leaves the server.
+	KeyID string `json:"-" db:"key_id"`
+	// ChangeSeq numbers the last write of the item in the owner's change
+	// feed. It never leaves the server; clients get an opaque sync cursor.
+	ChangeSeq int64 `json:"-" db:"change_seq"`
+	// BaseVersion is the version a client change was made on top of. It
+	// is only sent with changes and never stored.
+	BaseVersion int `json:"base_version,omitempty" db:"-"`
 }
 type DataHistory struct {
 	ID        string    `json:"id" db:"id"`
@@ -34,6 +48,7 @@ type DataHistory struct {
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 	IsDeleted bool      `json:"is_deleted" db:"is_deleted"`
+	KeyID     string    `json:"-" db:"key_id"`
 }
 type LoginPasswordData struct {
 	Login    string `json:"login"`
@@ -49,17 +64,34 @@ type BankCardData struct {
 	Bank       string `json:"bank,omitempty"`
 	Notes      string `json:"notes,omitempty"`
 }
+// DataSyncRequest carries the client's changes and the cursor returned by
+// its previous sync. An empty cursor asks for every item.
 type DataSyncRequest struct {
-	LastSyncAt time.Time    `json:"last_sync_at"`
-	Data       []StoredData `json:"data"`
+	Cursor string       `json:"cursor,omitempty"`
+	Data   []StoredData `json:"data"`
 }
+// DataSyncResponse carries every change made after the request cursor,
+// except the client's own, and the cursor to send next time.
 type DataSyncResponse struct {
-	Data       []StoredData `json:"data"`
-	LastSyncAt time.Time    `json:"last_sync_at"`
-	Conflicts  []Conflict   `json:"conflicts,omitempty"`
+	Data      []StoredData `json:"data"`
+	Cursor    string       `json:"cursor"`
+	Conflicts []Conflict   `json:"conflicts,omitempty"`
 }
 type Conflict struct {
 	LocalData  StoredData `json:"local_data"`
 	ServerData StoredData `json:"server_data"`
 	Reason     string     `json:"reason"`
 }
+// ErrDataNotFound is returned for items that do not exist and for items owned
+// by another user alike, so callers cannot probe for foreign IDs.
+var (
+	ErrDataNotFound      = errors.New("data not found")
+	ErrDataIDInUse       = errors.New("data id is already in use")
+	ErrInvalidSyncCursor = errors.New("invalid sync cursor")
+	// ErrVersionConflict is returned when an item no longer has the version
+	// a change was based on.
+	ErrVersionConflict = errors.New("item was changed by another client")
+	// ErrInvalidVersion is returned for a change based on version N that
+	// names another version than N+1.
+	ErrInvalidVersion = errors.New("version must be the base version plus one")
+)
@@ -0,0 +1,36 @@
+package models
+import (
+	"errors"
+	"time"
+)
+// Device is a client installation registered with the account. PublicKey
+// is its Ed25519 key; sessions bound to a device end when it is revoked.
+type Device struct {
+	ID         string     `json:"id" db:"id"`
+	UserID     string     `json:"-" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Platform   string     `json:"platform" db:"platform"`
+	PublicKey  []byte     `json:"public_key" db:"public_key"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty" db:"last_seen_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	Current    bool       `json:"current,omitempty" db:"-"`
+}
+// DeviceRegistrationRequest binds the calling session to a device. DeviceID
+// is empty for a new device; Signature is made with the device key over the
+// device and session IDs, see crypto.SignDeviceProof.
+type DeviceRegistrationRequest struct {
+	DeviceID  string `json:"device_id,omitempty"`
+	Name      string `json:"name" validate:"required"`
+	Platform  string `json:"platform"`
+	PublicKey []byte `json:"public_key" validate:"required"`
+	Signature []byte `json:"signature" validate:"required"`
+}
+var (
+	ErrDeviceNotFound     = errors.New("device not found")
+	ErrDeviceRevoked      = errors.New("device revoked")
+	ErrInvalidDeviceProof = errors.New("invalid device signature")
+	// ErrSessionBound refuses to move a session to another device once it
+	// is bound to one.
+	ErrSessionBound = errors.New("session is already bound to a device")
+)
@@ -0,0 +1,23 @@
+package models
+import (
+	"errors"
+	"time"
+)
+// IdempotencyRecord is the response to a request sent with an
+// Idempotency-Key, kept so that retries get the same response. StatusCode
+// is 0 while the first request is still being handled. Response is nil
+// when only the status was kept.
+type IdempotencyRecord struct {
+	UserID      string    `json:"user_id" db:"user_id"`
+	Key         string    `json:"key" db:"key"`
+	RequestHash string    `json:"request_hash" db:"request_hash"`
+	StatusCode  int       `json:"status_code" db:"status_code"`
+	Response    []byte    `json:"response" db:"response"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+var (
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was used with a different request")
+	ErrIdempotencyKeyInUse   = errors.New("a request with this idempotency key is still in progress")
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+	ErrIdempotencyNotKept    = errors.New("request with this idempotency key was already handled and its response is not kept, send it again with a new key")
+)
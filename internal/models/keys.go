@@ -0,0 +1,38 @@
+package models
+import (
+	"time"
+)
+// LegacyKeyID marks rows encrypted directly with the global ENCRYPTION_KEY
+// before per-user data keys existed.
+const LegacyKeyID = "legacy"
+type DataKey struct {
+	ID          string    `json:"id" db:"id"`
+	UserID      string    `json:"user_id" db:"user_id"`
+	MasterKeyID string    `json:"master_key_id" db:"master_key_id"`
+	WrappedKey  []byte    `json:"-" db:"wrapped_key"`
+	Active      bool      `json:"active" db:"active"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+// SigningKey is a stored JWT signing key. It signs tokens from ActivatesAt
+// until its successor activates and stays published until ExpiresAt.
+type SigningKey struct {
+	ID            string    `json:"id" db:"id"`
+	Algorithm     string    `json:"algorithm" db:"algorithm"`
+	WrappingKeyID string    `json:"-" db:"wrapping_key_id"`
+	PrivateKey    []byte    `json:"-" db:"private_key"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	ActivatesAt   time.Time `json:"activates_at" db:"activates_at"`
+	ExpiresAt     time.Time `json:"expires_at" db:"expires_at"`
+}
+// ReceiptKey is the stored key that signs deletion receipts. It never
+// expires, and its public JWK is kept apart from the wrapped private key so
+// it stays published even if the master key that wrapped it is retired.
+type ReceiptKey struct {
+	ID            string    `json:"id" db:"id"`
+	Algorithm     string    `json:"algorithm" db:"algorithm"`
+	WrappingKeyID string    `json:"-" db:"wrapping_key_id"`
+	PrivateKey    []byte    `json:"-" db:"private_key"`
+	PublicKey     []byte    `json:"-" db:"public_key"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,49 @@
+package models
+import (
+	"errors"
+	"time"
+)
+// OIDCProvider is an identity provider the server accepts ID tokens from.
+// ClientID is the public client the GophKeeper CLI is registered as.
+type OIDCProvider struct {
+	Issuer   string `json:"issuer"`
+	ClientID string `json:"client_id"`
+}
+// OIDCIdentity links an account to a subject at an identity provider.
+type OIDCIdentity struct {
+	Issuer      string     `json:"issuer" db:"issuer"`
+	Subject     string     `json:"subject" db:"subject"`
+	UserID      string     `json:"-" db:"user_id"`
+	Email       string     `json:"email,omitempty" db:"email"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+}
+// OIDCNonceResponse carries a single-use nonce the ID token must contain.
+type OIDCNonceResponse struct {
+	Nonce     string    `json:"nonce"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+// OIDCTokenRequest presents an ID token for login or for linking it to the
+// calling account.
+type OIDCTokenRequest struct {
+	Issuer  string `json:"issuer" validate:"required"`
+	IDToken string `json:"id_token" validate:"required"`
+}
+// OIDCLinkRequest links an identity to the calling account, which has to
+// prove its password first.
+type OIDCLinkRequest struct {
+	OIDCTokenRequest
+	Proof PasswordProof `json:"proof"`
+}
+type OIDCUnlinkRequest struct {
+	Issuer string        `json:"issuer" validate:"required"`
+	Proof  PasswordProof `json:"proof"`
+}
+var (
+	ErrOIDCUnknownIssuer    = errors.New("unknown identity provider")
+	ErrInvalidIDToken       = errors.New("invalid id token")
+	ErrOIDCNotLinked        = errors.New("no account is linked to this identity, link it with 'sso link' first")
+	ErrOIDCIdentityInUse    = errors.New("identity is already linked to an account")
+	ErrOIDCAlreadyLinked    = errors.New("account is already linked to another identity at this provider, unlink it first")
+	ErrOIDCIdentityNotFound = errors.New("identity not found")
+)
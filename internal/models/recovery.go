@@ -0,0 +1,13 @@
+package models
+import "errors"
+// RecoverySlot is the vault key wrapped with a recovery key that only
+// exists as Shamir shares held by the user. KeyCheck identifies the wrapped
+// key, so a slot left over from an older key is never used. SealedRecoveryKey
+// is the recovery key sealed under the vault key, which lets a master
+// password change re-wrap the new key without asking for the shares.
+type RecoverySlot struct {
+	WrappedKey        []byte `json:"wrapped_key" validate:"required"`
+	SealedRecoveryKey []byte `json:"sealed_recovery_key" validate:"required"`
+	KeyCheck          []byte `json:"key_check" validate:"required"`
+}
+var ErrRecoveryNotConfigured = errors.New("no recovery kit is set up for this account")
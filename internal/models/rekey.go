@@ -0,0 +1,23 @@
+package models
+import "errors"
+// RekeyRequest replaces every item and history entry of the account with a
+// copy sealed under a new vault key. PreviousKeyCheck names the key being replaced, so a
+// device holding a stale key cannot overwrite a newer re-key.
+type RekeyRequest struct {
+	PreviousKeyCheck []byte       `json:"previous_key_check"`
+	KDF              *KDFParams   `json:"kdf" validate:"required"`
+	Data             []StoredData `json:"data"`
+	// History holds every history entry of the account, matched by ID.
+	History []DataHistory `json:"history"`
+	// RecoverySlot wraps the new key with the existing recovery key. Without
+	// it the old slot is dropped, since it only opens the old key.
+	RecoverySlot *RecoverySlot `json:"recovery_slot,omitempty"`
+}
+var (
+	ErrRekeyConflict   = errors.New("vault key was changed by another device")
+	ErrRekeyIncomplete = errors.New("items changed since the re-key started, sync and try again")
+	// ErrStaleVaultKey refuses a write sealed under a vault key that was
+	// replaced since, e.g. after the master password changed on another
+	// device.
+	ErrStaleVaultKey = errors.New("vault key was changed on another device, log in again")
+)
@@ -0,0 +1,30 @@
+package models
+import (
+	"errors"
+	"time"
+)
+// Session is one refresh token. Every refresh replaces the token with a new
+// row in the same family; FamilyID is the session ID carried in access tokens.
+type Session struct {
+	ID        string     `json:"id" db:"id"`
+	FamilyID  string     `json:"family_id" db:"family_id"`
+	UserID    string     `json:"user_id" db:"user_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	DeviceID  *string    `json:"device_id,omitempty" db:"device_id"`
+}
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrSessionRevoked     = errors.New("session revoked")
+	ErrSessionExpired     = errors.New("session expired")
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected, session revoked")
+)
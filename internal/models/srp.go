@@ -0,0 +1,46 @@
+package models
+import (
+	"errors"
+	"time"
+)
+// SRPVerifier replaces the password for SRP logins. Time, Memory and
+// Parallelism are the Argon2id costs used to derive the private key.
+type SRPVerifier struct {
+	Salt        []byte `json:"salt"`
+	Verifier    []byte `json:"verifier"`
+	Time        uint32 `json:"time"`
+	Memory      uint32 `json:"memory"`
+	Parallelism uint8  `json:"parallelism"`
+}
+// SRPHandshake is the server state kept between the two SRP rounds.
+type SRPHandshake struct {
+	ID           string    `json:"id" db:"id"`
+	UserID       string    `json:"user_id" db:"user_id"`
+	ClientPublic []byte    `json:"-" db:"client_public"`
+	ServerSecret []byte    `json:"-" db:"server_secret"`
+	ExpiresAt    time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+type SRPInitRequest struct {
+	Username     string `json:"username" validate:"required"`
+	ClientPublic []byte `json:"a" validate:"required"`
+}
+// SRPInitResponse carries the salt and B. Legacy is set for accounts that
+// have no verifier yet; they log in once with the password, which stores one.
+type SRPInitResponse struct {
+	HandshakeID  string `json:"handshake_id,omitempty"`
+	Salt         []byte `json:"salt,omitempty"`
+	ServerPublic []byte `json:"b,omitempty"`
+	Time         uint32 `json:"time,omitempty"`
+	Memory       uint32 `json:"memory,omitempty"`
+	Parallelism  uint8  `json:"parallelism,omitempty"`
+	Legacy       bool   `json:"legacy,omitempty"`
+}
+type SRPVerifyRequest struct {
+	HandshakeID string `json:"handshake_id" validate:"required"`
+	ClientProof []byte `json:"m1" validate:"required"`
+}
+var (
+	ErrSRPNotConfigured     = errors.New("srp verifier not configured")
+	ErrSRPHandshakeNotFound = errors.New("srp handshake not found or expired")
+)
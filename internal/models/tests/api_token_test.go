@@ -0,0 +1,50 @@
+package tests
+import (
+	"encoding/json"
+	"gophkeeper/internal/models"
+	"testing"
+)
+func TestAPIToken_AllowsItem(t *testing.T) {
+	unscoped := &models.APIToken{}
+	if !unscoped.AllowsItem("any") {
+		t.Error("Token without items should allow every item")
+	}
+	scoped := &models.APIToken{ItemIDs: []string{"item-1", "item-2"}}
+	if !scoped.AllowsItem("item-2") {
+		t.Error("Token should allow an item in its scope")
+	}
+	if scoped.AllowsItem("item-3") || scoped.AllowsItem("") {
+		t.Error("Token should not allow items outside its scope")
+	}
+}
+func TestAPIToken_AllowsIP(t *testing.T) {
+	token := &models.APIToken{AllowedIPs: []string{"10.0.0.0/8", "192.168.1.5", "2001:db8::/32"}}
+	for _, ip := range []string{"10.1.2.3", "192.168.1.5", "2001:db8::1"} {
+		if !token.AllowsIP(ip) {
+			t.Errorf("Expected %s to be allowed", ip)
+		}
+	}
+	for _, ip := range []string{"11.0.0.1", "192.168.1.6", "not-an-ip", ""} {
+		if token.AllowsIP(ip) {
+			t.Errorf("Expected %s to be refused", ip)
+		}
+	}
+	if !(&models.APIToken{}).AllowsIP("203.0.113.9") {
+		t.Error("Token without addresses should allow any address")
+	}
+}
+func TestAPITokenRequest_ReadOnlyByDefault(t *testing.T) {
+	var req models.APITokenRequest
+	if err := json.Unmarshal([]byte(`{"name":"ci"}`), &req); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !req.ReadOnly {
+		t.Error("Token request without read_only should be read-only")
+	}
+	if err := json.Unmarshal([]byte(`{"name":"ci","read_only":false}`), &req); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if req.ReadOnly {
+		t.Error("Token request with read_only false should allow writes")
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+import (
+	"errors"
+	"gophkeeper/internal/models"
+	"testing"
+	"time"
+)
+var auditKey = []byte("audit-key")
+func auditChain(n int) []models.AuditEvent {
+	events := make([]models.AuditEvent, n)
+	prevHash := ""
+	for i := range events {
+		events[i] = models.AuditEvent{
+			ID:        int64(i + 10),
+			Seq:       int64(i + 1),
+			UserID:    "user-1",
+			Type:      models.AuditItemUpdated,
+			ItemID:    "item-1",
+			IP:        "10.0.0.1",
+			CreatedAt: time.Date(2024, 1, 1, 12, 0, i, 0, time.UTC),
+			PrevHash:  prevHash,
+		}
+		events[i].Hash = events[i].ChainHash(auditKey)
+		prevHash = events[i].Hash
+	}
+	return events
+}
+func verifyChain(events []models.AuditEvent) error {
+	var prevSeq int64
+	prevHash := ""
+	for i := range events {
+		if err := events[i].Follows(prevSeq, prevHash, auditKey); err != nil {
+			return err
+		}
+		prevSeq, prevHash = events[i].Seq, events[i].Hash
+	}
+	return nil
+}
+func TestAuditEvent_ChainHash(t *testing.T) {
+	event := auditChain(1)[0]
+	if len(event.Hash) != 64 {
+		t.Fatalf("Expected a hex HMAC-SHA256, got %q", event.Hash)
+	}
+	if event.ChainHash(auditKey) != event.Hash {
+		t.Error("Hash should be deterministic")
+	}
+	local := event
+	local.CreatedAt = event.CreatedAt.In(time.FixedZone("UTC+3", 3*60*60))
+	if local.ChainHash(auditKey) != event.Hash {
+		t.Error("Hash should not depend on the time zone of CreatedAt")
+	}
+	shifted := event
+	shifted.UserID, shifted.Type = event.UserID+event.Type[:4], event.Type[4:]
+	if shifted.ChainHash(auditKey) == event.Hash {
+		t.Error("Moving bytes between fields should change the hash")
+	}
+	if event.ChainHash([]byte("other-key")) == event.Hash {
+		t.Error("Hash should depend on the key")
+	}
+	named := event
+	named.KeyID = "0123456789abcdef"
+	if named.ChainHash(auditKey) == event.Hash {
+		t.Error("Hash should cover the key ID")
+	}
+}
+func TestAuditEvent_Follows(t *testing.T) {
+	if err := verifyChain(auditChain(5)); err != nil {
+		t.Fatalf("Intact chain should verify: %v", err)
+	}
+	tests := []struct {
+		name   string
+		tamper func([]models.AuditEvent) []models.AuditEvent
+	}{
+		{"modified field", func(e []models.AuditEvent) []models.AuditEvent {
+			e[2].ItemID = "item-2"
+			return e
+		}},
+		{"modified time", func(e []models.AuditEvent) []models.AuditEvent {
+			e[2].CreatedAt = e[2].CreatedAt.Add(time.Second)
+			return e
+		}},
+		{"rehashed event", func(e []models.AuditEvent) []models.AuditEvent {
+			e[2].Type = models.AuditItemDeleted
+			e[2].Hash = e[2].ChainHash(auditKey)
+			return e
+		}},
+		{"chain rebuilt without the key", func(e []models.AuditEvent) []models.AuditEvent {
+			e[2].Type = models.AuditItemDeleted
+			for i := 2; i < len(e); i++ {
+				e[i].PrevHash = e[i-1].Hash
+				e[i].Hash = e[i].ChainHash([]byte("guessed-key"))
+			}
+			return e
+		}},
+		{"removed event", func(e []models.AuditEvent) []models.AuditEvent {
+			return append(e[:2], e[3:]...)
+		}},
+		{"renumbered after removal", func(e []models.AuditEvent) []models.AuditEvent {
+			e = append(e[:2], e[3:]...)
+			e[2].Seq, e[3].Seq = 3, 4
+			return e
+		}},
+		{"swapped events", func(e []models.AuditEvent) []models.AuditEvent {
+			e[1], e[2] = e[2], e[1]
+			return e
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyChain(tt.tamper(auditChain(5)))
+			if !errors.Is(err, models.ErrAuditChainBroken) {
+				t.Errorf("Expected ErrAuditChainBroken, got %v", err)
+			}
+		})
+	}
+}
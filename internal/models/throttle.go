@@ -0,0 +1,37 @@
+package models
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+// LoginThrottle counts recent failed logins for a username or client IP.
+// BlockedUntil is set while further attempts are refused; Locked marks an
+// account lockout rather than a backoff delay.
+type LoginThrottle struct {
+	Key           string     `json:"key" db:"key"`
+	Failures      int        `json:"failures" db:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at" db:"last_failure_at"`
+	BlockedUntil  *time.Time `json:"blocked_until,omitempty" db:"blocked_until"`
+	Locked        bool       `json:"locked" db:"locked"`
+}
+// UnlockRequest clears login throttling for a username or a client IP.
+type UnlockRequest struct {
+	Username string `json:"username,omitempty"`
+	IP       string `json:"ip,omitempty"`
+}
+var ErrTooManyAttempts = errors.New("too many failed login attempts")
+// ThrottledError refuses a login attempt until RetryAfter has passed.
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+func (e *ThrottledError) Error() string {
+	wait := e.RetryAfter.Round(time.Second)
+	if e.Locked {
+		return fmt.Sprintf("account temporarily locked after too many failed login attempts, try again in %s", wait)
+	}
+	return fmt.Sprintf("%s, try again in %s", ErrTooManyAttempts, wait)
+}
+func (e *ThrottledError) Unwrap() error {
+	return ErrTooManyAttempts
+}
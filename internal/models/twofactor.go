@@ -0,0 +1,48 @@
+package models
+import (
+	"errors"
+	"time"
+)
+// TOTPSecret is a user's authenticator secret, encrypted with the user's
+// data key. It only guards logins once Enabled is set by a confirmed code.
+type TOTPSecret struct {
+	UserID          string    `json:"-" db:"user_id"`
+	Secret          []byte    `json:"-" db:"secret"`
+	KeyID           string    `json:"-" db:"key_id"`
+	Enabled         bool      `json:"enabled" db:"enabled"`
+	LastUsedCounter int64     `json:"-" db:"last_used_counter"`
+	CreatedAt       time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+}
+// MFAChallenge is issued by a password login for an account with 2FA and is
+// exchanged for a session by a valid second factor.
+type MFAChallenge struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	TokenHash string    `json:"-" db:"token_hash"`
+	Attempts  int       `json:"attempts" db:"attempts"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+type TOTPCodeRequest struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+type RecoveryCodesResponse struct {
+	Codes []string `json:"codes"`
+}
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+var (
+	ErrTOTPNotConfigured    = errors.New("two-factor authentication is not configured")
+	ErrTOTPAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrInvalidSecondFactor  = errors.New("invalid two-factor code")
+	ErrMFAChallengeNotFound = errors.New("two-factor challenge not found or expired")
+)
@@ -11,22 +11,47 @@ type User struct {
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
 }
+type KDFParams struct {
+	Algorithm   string `json:"algorithm"`
+	Salt        []byte `json:"salt"`
+	Time        uint32 `json:"time"`
+	Memory      uint32 `json:"memory"`
+	Parallelism uint8  `json:"parallelism"`
+	KeyLength   uint32 `json:"key_length"`
+	KeyCheck    []byte `json:"key_check,omitempty"`
+}
 type UserRegistrationRequest struct {
-	Username string `json:"username" validate:"required,min=3,max=50"`
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=8"`
+	Username string     `json:"username" validate:"required,min=3,max=50"`
+	Email    string     `json:"email" validate:"required,email"`
+	Password string     `json:"password,omitempty" validate:"omitempty,min=8"`
+	KDF      *KDFParams `json:"kdf,omitempty"`
+	// SRP registers the account for SRP logins; Password is then left empty
+	// and never reaches the server.
+	SRP *SRPVerifier `json:"srp,omitempty"`
 }
 type UserLoginRequest struct {
 	Username string `json:"username" validate:"required"`
 	Password string `json:"password" validate:"required"`
 }
 type AuthResponse struct {
-	Token     string `json:"token"`
-	User      User   `json:"user"`
-	ExpiresAt int64  `json:"expires_at"`
+	Token            string     `json:"token"`
+	RefreshToken     string     `json:"refresh_token,omitempty"`
+	User             User       `json:"user"`
+	ExpiresAt        int64      `json:"expires_at"`
+	RefreshExpiresAt int64      `json:"refresh_expires_at,omitempty"`
+	KDF              *KDFParams `json:"kdf,omitempty"`
+	// MFARequired is set instead of tokens when the password was correct but
+	// the account needs a second factor; MFAToken is passed to /login/2fa.
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+	// ServerProof is the SRP M2 value the client checks before trusting
+	// the tokens.
+	ServerProof []byte `json:"server_proof,omitempty"`
 }
 var (
 	ErrUserAlreadyExists  = errors.New("user already exists")
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrUnauthorized       = errors.New("unauthorized")
+	ErrKDFNotConfigured   = errors.New("kdf parameters not configured")
+	ErrDataKeyNotFound    = errors.New("data key not found")
 )
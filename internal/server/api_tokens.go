@@ -0,0 +1,130 @@
+package server
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/database"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+const maxAPITokenScopeEntries = 100
+// APITokenService issues and checks long-lived API tokens. Handlers enforce
+// the token scope on every item they serve or change.
+type APITokenService struct {
+	db    *database.DB
+	audit *AuditService
+}
+func NewAPITokenService(db *database.DB, audit *AuditService) *APITokenService {
+	return &APITokenService{db: db, audit: audit}
+}
+// Create issues a token for the actor's account and returns its secret,
+// which is not stored and cannot be shown again.
+func (t *APITokenService) Create(actor *models.AuditActor, req *models.APITokenRequest) (*models.APITokenResponse, error) {
+	if req.Name == "" || len(req.Name) > 255 {
+		return nil, fmt.Errorf("token name must be 1-255 characters")
+	}
+	if len(req.ItemIDs) > maxAPITokenScopeEntries || len(req.AllowedIPs) > maxAPITokenScopeEntries {
+		return nil, fmt.Errorf("at most %d items and %d addresses per token", maxAPITokenScopeEntries, maxAPITokenScopeEntries)
+	}
+	for _, allowed := range req.AllowedIPs {
+		if _, _, err := net.ParseCIDR(allowed); err != nil && net.ParseIP(allowed) == nil {
+			return nil, fmt.Errorf("invalid address or CIDR range: %s", allowed)
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("expiry must be in the future")
+	}
+	secret, err := crypto.NewAPIToken()
+	if err != nil {
+		return nil, err
+	}
+	token := &models.APIToken{
+		ID:         generateID(),
+		UserID:     actor.UserID,
+		Name:       req.Name,
+		ReadOnly:   req.ReadOnly,
+		ItemIDs:    req.ItemIDs,
+		AllowedIPs: req.AllowedIPs,
+		ExpiresAt:  req.ExpiresAt,
+	}
+	if err := t.audit.With(actor, models.AuditAPITokenCreated, "", token.ID+" "+token.Name).CreateAPIToken(token, crypto.HashToken(secret)); err != nil {
+		return nil, err
+	}
+	logger.Info("Created api token %s (%s) for user %s", token.ID, token.Name, actor.UserID)
+	return &models.APITokenResponse{Token: secret, APIToken: *token}, nil
+}
+// Authenticate resolves a presented token used from ip and records the use.
+func (t *APITokenService) Authenticate(secret, ip string) (*models.APIToken, error) {
+	token, err := t.db.GetAPITokenByHash(crypto.HashToken(secret))
+	if err != nil {
+		if errors.Is(err, models.ErrAPITokenNotFound) {
+			return nil, models.ErrInvalidAPIToken
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if token.RevokedAt != nil || (token.ExpiresAt != nil && !token.ExpiresAt.After(now)) {
+		return nil, models.ErrInvalidAPIToken
+	}
+	if !token.AllowsIP(ip) {
+		logger.Warn("Api token %s used from disallowed address %s", token.ID, ip)
+		return nil, models.ErrAPITokenScope
+	}
+	if err := t.db.TouchAPIToken(token.ID, ip, now); err != nil {
+		logger.Warn("Failed to record api token use: %v", err)
+	}
+	return token, nil
+}
+func (t *APITokenService) List(userID string) ([]models.APIToken, error) {
+	return t.db.ListAPITokens(userID)
+}
+func (t *APITokenService) Revoke(actor *models.AuditActor, id string) error {
+	if err := t.audit.With(actor, models.AuditAPITokenRevoked, "", id).RevokeAPIToken(actor.UserID, id); err != nil {
+		return err
+	}
+	logger.Info("Revoked api token %s of user %s", id, actor.UserID)
+	return nil
+}
+// Info describes the calling token together with the account's vault key
+// parameters.
+func (t *APITokenService) Info(token *models.APIToken) (*models.APITokenInfo, error) {
+	user, err := t.db.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	info := &models.APITokenInfo{APIToken: *token, UserID: user.ID, Username: user.Username}
+	params, err := t.db.GetKDFParams(user.ID)
+	switch {
+	case err == nil:
+		info.KDF = params
+	case !errors.Is(err, models.ErrKDFNotConfigured):
+		return nil, err
+	}
+	return info, nil
+}
+// checkItemScope fails with ErrAPITokenScope when scope does not cover a
+// read, or a write, of the item. A nil scope is a user session.
+func checkItemScope(scope *models.APIToken, id string, write bool) error {
+	if scope == nil {
+		return nil
+	}
+	if (write && scope.ReadOnly) || !scope.AllowsItem(id) {
+		return models.ErrAPITokenScope
+	}
+	return nil
+}
+// filterItemScope drops the items scope does not cover.
+func filterItemScope(scope *models.APIToken, items []models.StoredData) []models.StoredData {
+	if scope == nil {
+		return items
+	}
+	allowed := items[:0]
+	for _, item := range items {
+		if scope.AllowsItem(item.ID) {
+			allowed = append(allowed, item)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,166 @@
+package server
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gophkeeper/internal/database"
+	"gophkeeper/internal/models"
+)
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+	auditVerifyBatchSize = 1000
+)
+// MinAuditKeyLength is the shortest AUDIT_KEY the server starts with.
+const MinAuditKeyLength = 32
+// AuditOptions name the keys of the audit log.
+type AuditOptions struct {
+	// Key keys the hashes of new events. It is a secret of its own, so
+	// that knowing another server secret does not let anyone extend a chain.
+	Key string
+	// PreviousKeys check events written under keys since replaced.
+	PreviousKeys []string
+	// LegacySecret is the JWT secret, which keyed the events written
+	// before events named their key.
+	LegacySecret string
+}
+// AuditService writes the audit log, one hash chain per user, and checks
+// it. Hashes are keyed with the audit key, so only the server can extend a
+// chain; each event names its key, so the key can be replaced.
+type AuditService struct {
+	db    *database.DB
+	keyID string
+	keys  map[string][]byte
+}
+func NewAuditService(db *database.DB, opts AuditOptions) *AuditService {
+	a := &AuditService{db: db, keyID: auditKeyID(opts.Key), keys: make(map[string][]byte)}
+	for _, secret := range append([]string{opts.Key}, opts.PreviousKeys...) {
+		if secret != "" {
+			a.keys[auditKeyID(secret)] = auditKey(secret)
+		}
+	}
+	if opts.LegacySecret != "" {
+		a.keys[""] = auditKey(opts.LegacySecret)
+	}
+	return a
+}
+func auditKey(secret string) []byte {
+	key := sha256.Sum256([]byte("gophkeeper-audit-log:" + secret))
+	return key[:]
+}
+// auditKeyID names a key without giving it away.
+func auditKeyID(secret string) string {
+	id := sha256.Sum256([]byte("gophkeeper-audit-key-id:" + secret))
+	return hex.EncodeToString(id[:8])
+}
+// With returns a handle on the database whose next write also records the
+// event, in the same transaction: the change is not stored without it.
+func (a *AuditService) With(actor *models.AuditActor, eventType, itemID, detail string) *database.DB {
+	return a.db.Audited(a.newEvent(actor, eventType, itemID, detail), a.keys[a.keyID])
+}
+// Record appends an event that goes with no write, such as a read or a
+// failed login.
+func (a *AuditService) Record(actor *models.AuditActor, eventType, itemID, detail string) error {
+	event := a.newEvent(actor, eventType, itemID, detail)
+	if err := a.db.AppendAuditEvent(event, a.keys[a.keyID]); err != nil {
+		return fmt.Errorf("failed to record %s audit event: %w", eventType, err)
+	}
+	return nil
+}
+func (a *AuditService) newEvent(actor *models.AuditActor, eventType, itemID, detail string) *models.AuditEvent {
+	event := &models.AuditEvent{
+		Type:   eventType,
+		ItemID: itemID,
+		Detail: detail,
+		KeyID:  a.keyID,
+	}
+	if actor != nil {
+		event.UserID = actor.UserID
+		event.DeviceID = actor.DeviceID
+		event.APITokenID = actor.APITokenID
+		event.IP = actor.IP
+	}
+	return event
+}
+func (a *AuditService) List(userID string, query *models.AuditQuery) ([]models.AuditEvent, error) {
+	if query.Limit <= 0 {
+		query.Limit = defaultAuditPageSize
+	}
+	if query.Limit > maxAuditPageSize {
+		query.Limit = maxAuditPageSize
+	}
+	return a.db.ListAuditEvents(userID, query)
+}
+// Verify checks every chain and returns their heads. Each of anchors, the
+// heads of an earlier check, must still be in its chain; otherwise events
+// were cut off the end. It returns ErrAuditChainBroken naming the first
+// event that does not fit.
+func (a *AuditService) Verify(anchors []models.AuditHead) (*models.AuditVerification, error) {
+	users, err := a.db.ListAuditChains()
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[string]models.AuditHead, len(anchors))
+	for _, anchor := range anchors {
+		byUser[anchor.UserID] = anchor
+	}
+	result := &models.AuditVerification{Heads: []models.AuditHead{}}
+	for _, userID := range users {
+		anchor, anchored := byUser[userID]
+		delete(byUser, userID)
+		head, events, err := a.verifyChain(userID, anchor, anchored)
+		result.Events += events
+		if err != nil {
+			return result, err
+		}
+		result.Heads = append(result.Heads, *head)
+	}
+	// Anchors left over belong to chains that are gone as a whole.
+	for _, anchor := range anchors {
+		if _, gone := byUser[anchor.UserID]; gone {
+			return result, fmt.Errorf("%w: chain of user %q is gone", models.ErrAuditChainBroken, anchor.UserID)
+		}
+	}
+	return result, nil
+}
+// VerifyUser checks the chain of one user.
+func (a *AuditService) VerifyUser(userID string) (*models.AuditHead, error) {
+	head, _, err := a.verifyChain(userID, models.AuditHead{}, false)
+	return head, err
+}
+func (a *AuditService) verifyChain(userID string, anchor models.AuditHead, anchored bool) (*models.AuditHead, int64, error) {
+	head := &models.AuditHead{UserID: userID}
+	var events int64
+	// Once a chain has a named key, an unnamed event after it is forged
+	// with the legacy key rather than old.
+	named := false
+	for {
+		batch, err := a.db.ListAuditChain(userID, head.Seq, auditVerifyBatchSize)
+		if err != nil {
+			return nil, events, err
+		}
+		for i := range batch {
+			key, known := a.keys[batch[i].KeyID]
+			if !known || (named && batch[i].KeyID == "") {
+				return head, events, fmt.Errorf("%w: event %d of user %q was keyed with unknown key %q", models.ErrAuditChainBroken, batch[i].Seq, userID, batch[i].KeyID)
+			}
+			named = named || batch[i].KeyID != ""
+			if err := batch[i].Follows(head.Seq, head.Hash, key); err != nil {
+				return head, events, err
+			}
+			if anchored && batch[i].Seq == anchor.Seq && batch[i].Hash != anchor.Hash {
+				return head, events, fmt.Errorf("%w: event %d of user %q differs from the anchored head", models.ErrAuditChainBroken, anchor.Seq, userID)
+			}
+			events++
+			head.Seq = batch[i].Seq
+			head.Hash = batch[i].Hash
+		}
+		if len(batch) < auditVerifyBatchSize {
+			break
+		}
+	}
+	if anchored && head.Seq < anchor.Seq {
+		return head, events, fmt.Errorf("%w: events %d to %d of user %q are missing", models.ErrAuditChainBroken, head.Seq+1, anchor.Seq, userID)
+	}
+	return head, events, nil
+}
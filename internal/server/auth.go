@@ -1,23 +1,69 @@
 package server
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"net/mail"
 	"time"
 	"gophkeeper/internal/crypto"
 	"gophkeeper/internal/database"
+	"gophkeeper/internal/logger"
 	"gophkeeper/internal/models"
 	"github.com/google/uuid"
 )
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	srpHandshakeTTL        = 2 * time.Minute
+	srpMinSaltLength       = 16
+	srpMaxSaltLength       = 64
+)
+type AuthOptions struct {
+	PasswordHash    crypto.PasswordHashParams
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// SRPFakeSaltKey keys the salts returned for unknown usernames. It must
+	// be stable across restarts or the fake salts would give them away.
+	SRPFakeSaltKey []byte
+}
 type AuthService struct {
-	db         *database.DB
-	jwtManager *crypto.JWTManager
+	db              *database.DB
+	jwtManager      *crypto.JWTManager
+	twoFactor       *TwoFactorService
+	devices         *DeviceService
+	throttle        *Throttler
+	audit           *AuditService
+	receipts        *ReceiptKeyService
+	hashParams      crypto.PasswordHashParams
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	srpFakeSaltKey  []byte
 }
-func NewAuthService(db *database.DB, jwtManager *crypto.JWTManager) *AuthService {
+func NewAuthService(db *database.DB, jwtManager *crypto.JWTManager, twoFactor *TwoFactorService, devices *DeviceService, throttle *Throttler, audit *AuditService, receipts *ReceiptKeyService, opts AuthOptions) *AuthService {
+	if opts.PasswordHash == (crypto.PasswordHashParams{}) {
+		opts.PasswordHash = crypto.DefaultPasswordHashParams
+	}
+	if opts.AccessTokenTTL <= 0 {
+		opts.AccessTokenTTL = defaultAccessTokenTTL
+	}
+	if opts.RefreshTokenTTL <= 0 {
+		opts.RefreshTokenTTL = defaultRefreshTokenTTL
+	}
 	return &AuthService{
-		db:         db,
-		jwtManager: jwtManager,
+		db:              db,
+		jwtManager:      jwtManager,
+		twoFactor:       twoFactor,
+		devices:         devices,
+		throttle:        throttle,
+		audit:           audit,
+		receipts:        receipts,
+		hashParams:      opts.PasswordHash,
+		accessTokenTTL:  opts.AccessTokenTTL,
+		refreshTokenTTL: opts.RefreshTokenTTL,
+		srpFakeSaltKey:  opts.SRPFakeSaltKey,
 	}
 }
-func (a *AuthService) Register(req *models.UserRegistrationRequest) (*models.AuthResponse, error) {
+func (a *AuthService) Register(req *models.UserRegistrationRequest, ip string) (*models.AuthResponse, error) {
 	_, err := a.db.GetUserByUsername(req.Username)
 	if err == nil {
 		return nil, fmt.Errorf("username already exists")
@@ -26,9 +72,26 @@ func (a *AuthService) Register(req *models.UserRegistrationRequest) (*models.Aut
 	if err == nil {
 		return nil, fmt.Errorf("email already exists")
 	}
-	hashedPassword, err := crypto.HashPassword(req.Password)
-	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+	if req.KDF != nil {
+		if err := crypto.ValidateKDFParams(req.KDF); err != nil {
+			return nil, fmt.Errorf("invalid kdf parameters: %w", err)
+		}
+	}
+	// SRP accounts have no password hash; the verifier is their only
+	// credential.
+	hashedPassword := ""
+	if req.SRP != nil {
+		if err := validateSRPVerifier(req.SRP); err != nil {
+			return nil, err
+		}
+	} else {
+		if req.Password == "" {
+			return nil, fmt.Errorf("password is required")
+		}
+		hashedPassword, err = crypto.HashPasswordWithParams(req.Password, a.hashParams)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
 	}
 	user := &models.User{
 		ID:           generateID(),
@@ -36,23 +99,39 @@ func (a *AuthService) Register(req *models.UserRegistrationRequest) (*models.Aut
 		Email:        req.Email,
 		PasswordHash: hashedPassword,
 	}
-	if err := a.db.CreateUser(user); err != nil {
+	if err := a.audit.With(&models.AuditActor{UserID: user.ID, IP: ip}, models.AuditAccountRegistered, "", "").CreateUser(user); err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
-	token, err := a.jwtManager.GenerateToken(user.ID, user.Username, 24*time.Hour)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+	if req.KDF != nil {
+		if err := a.db.SaveKDFParams(user.ID, req.KDF); err != nil {
+			return nil, fmt.Errorf("failed to save kdf parameters: %w", err)
+		}
 	}
-	response := &models.AuthResponse{
-		Token:     token,
-		User:      *user,
-		ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+	if req.SRP != nil {
+		if err := a.db.SaveSRPVerifier(user.ID, req.SRP); err != nil {
+			return nil, fmt.Errorf("failed to save srp verifier: %w", err)
+		}
+	} else {
+		a.upgradeToSRP(user, req.Password)
+	}
+	response, err := a.startSession(a.db, user)
+	if err != nil {
+		return nil, err
 	}
+	response.KDF = req.KDF
 	return response, nil
 }
-func (a *AuthService) Login(req *models.UserLoginRequest) (*models.AuthResponse, error) {
+// Login checks a password. Failures from ip count towards throttling both
+// the username and the address; they are only cleared once the login is
+// complete, so with 2FA a wrong second factor keeps counting.
+func (a *AuthService) Login(req *models.UserLoginRequest, ip string) (*models.AuthResponse, error) {
+	if err := a.throttle.Check(req.Username, ip); err != nil {
+		return nil, err
+	}
 	user, err := a.db.GetUserByUsername(req.Username)
-	if err != nil {
+	if err != nil || user.PasswordHash == "" {
+		a.throttle.Failure(req.Username, ip)
+		a.recordLoginFailure(userOrEmpty(user), ip, "password")
 		return nil, fmt.Errorf("invalid credentials")
 	}
 	valid, err := crypto.VerifyPassword(req.Password, user.PasswordHash)
@@ -60,19 +139,528 @@ func (a *AuthService) Login(req *models.UserLoginRequest) (*models.AuthResponse,
 		return nil, fmt.Errorf("failed to verify password: %w", err)
 	}
 	if !valid {
+		a.throttle.Failure(req.Username, ip)
+		a.recordLoginFailure(userOrEmpty(user), ip, "password")
 		return nil, fmt.Errorf("invalid credentials")
 	}
-	token, err := a.jwtManager.GenerateToken(user.ID, user.Username, 24*time.Hour)
+	a.rehashPassword(user, req.Password)
+	a.upgradeToSRP(user, req.Password)
+	response, err := a.completeFirstFactor(user, ip, "password")
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
+		return nil, err
+	}
+	if !response.MFARequired {
+		a.throttle.Success(user.Username)
+	}
+	return response, nil
+}
+// SRPInit answers the first SRP round. Unknown usernames get a stable fake
+// salt and a random B, and fail only at SRPVerify like a wrong password.
+// While accounts without a verifier remain, some unknown usernames are
+// answered as legacy instead, so a legacy answer does not prove the account
+// exists.
+func (a *AuthService) SRPInit(req *models.SRPInitRequest, ip string) (*models.SRPInitResponse, error) {
+	if err := a.throttle.Check(req.Username, ip); err != nil {
+		return nil, err
+	}
+	user, err := a.db.GetUserByUsername(req.Username)
+	if err != nil {
+		return a.fakeSRPInit(req.Username)
+	}
+	verifier, err := a.db.GetSRPVerifier(user.ID)
+	if errors.Is(err, models.ErrSRPNotConfigured) && user.PasswordHash != "" {
+		return &models.SRPInitResponse{Legacy: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	server, err := crypto.NewSRPServer(verifier.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	handshake := &models.SRPHandshake{
+		ID:           generateID(),
+		UserID:       user.ID,
+		ClientPublic: req.ClientPublic,
+		ServerSecret: server.Secret(),
+		ExpiresAt:    time.Now().Add(srpHandshakeTTL),
+	}
+	if err := a.db.CreateSRPHandshake(handshake); err != nil {
+		return nil, err
+	}
+	return &models.SRPInitResponse{
+		HandshakeID:  handshake.ID,
+		Salt:         verifier.Salt,
+		ServerPublic: server.PublicKey(),
+		Time:         verifier.Time,
+		Memory:       verifier.Memory,
+		Parallelism:  verifier.Parallelism,
+	}, nil
+}
+// SRPVerify checks the client proof and logs the user in. The response
+// carries the server proof so the client can authenticate the server too.
+func (a *AuthService) SRPVerify(req *models.SRPVerifyRequest, ip string) (*models.AuthResponse, error) {
+	user, serverProof, err := a.checkSRPProof(req.HandshakeID, req.ClientProof)
+	username := ""
+	if user != nil {
+		username = user.Username
+	}
+	if blocked := a.throttle.Check(username, ip); blocked != nil {
+		return nil, blocked
+	}
+	if err != nil {
+		a.throttle.Failure(username, ip)
+		a.recordLoginFailure(userOrEmpty(user), ip, "srp")
+		return nil, err
+	}
+	response, err := a.completeFirstFactor(user, ip, "srp")
+	if err != nil {
+		return nil, err
+	}
+	if !response.MFARequired {
+		a.throttle.Success(username)
+	}
+	response.ServerProof = serverProof
+	return response, nil
+}
+// checkSRPProof returns the handshake's user even when the proof is wrong,
+// so the failure can be counted against it.
+func (a *AuthService) checkSRPProof(handshakeID string, clientProof []byte) (*models.User, []byte, error) {
+	handshake, err := a.db.TakeSRPHandshake(handshakeID)
+	if err != nil {
+		if errors.Is(err, models.ErrSRPHandshakeNotFound) {
+			return nil, nil, fmt.Errorf("invalid credentials")
+		}
+		return nil, nil, err
 	}
-	response := &models.AuthResponse{
-		Token:     token,
-		User:      *user,
-		ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+	user, err := a.db.GetUserByID(handshake.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid credentials")
+	}
+	verifier, err := a.db.GetSRPVerifier(user.ID)
+	if err != nil {
+		return user, nil, fmt.Errorf("invalid credentials")
 	}
+	server := crypto.RestoreSRPServer(verifier.Verifier, handshake.ServerSecret)
+	serverProof, _, err := server.VerifyClientProof(user.Username, verifier.Salt, handshake.ClientPublic, clientProof)
+	if err != nil {
+		return user, nil, fmt.Errorf("invalid credentials")
+	}
+	return user, serverProof, nil
+}
+func (a *AuthService) fakeSRPInit(username string) (*models.SRPInitResponse, error) {
+	if crypto.FakeSRPLegacy(a.srpFakeSaltKey, username) {
+		legacy, err := a.db.HasLegacyAccounts()
+		if err != nil {
+			return nil, err
+		}
+		if legacy {
+			return &models.SRPInitResponse{Legacy: true}, nil
+		}
+	}
+	serverPublic, err := crypto.FakeSRPPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	return &models.SRPInitResponse{
+		HandshakeID:  generateID(),
+		Salt:         crypto.FakeSRPSalt(a.srpFakeSaltKey, username),
+		ServerPublic: serverPublic,
+		Time:         a.hashParams.Time,
+		Memory:       a.hashParams.Memory,
+		Parallelism:  a.hashParams.Parallelism,
+	}, nil
+}
+// completeFirstFactor issues a session, or a two-factor challenge when the
+// account has 2FA enabled. method names the first factor in the audit log.
+func (a *AuthService) completeFirstFactor(user *models.User, ip, method string) (*models.AuthResponse, error) {
+	enabled, err := a.twoFactor.IsEnabled(user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check two-factor status: %w", err)
+	}
+	if enabled {
+		mfaToken, err := a.twoFactor.NewChallenge(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &models.AuthResponse{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+	return a.completeLogin(user, ip, method)
+}
+// LoginMFA finishes a login that Login answered with a two-factor challenge.
+// Wrong codes count towards the same throttling as wrong passwords, so
+// fetching new challenges with the password does not reset them.
+func (a *AuthService) LoginMFA(req *models.MFALoginRequest, ip string) (*models.AuthResponse, error) {
+	challenge, err := a.twoFactor.Challenge(req.MFAToken)
+	if err != nil {
+		if errors.Is(err, models.ErrMFAChallengeNotFound) {
+			a.throttle.Failure("", ip)
+			a.recordLoginFailure("", ip, "2fa")
+		}
+		return nil, err
+	}
+	user, err := a.db.GetUserByID(challenge.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	if err := a.throttle.Check(user.Username, ip); err != nil {
+		return nil, err
+	}
+	if err := a.twoFactor.CompleteChallenge(challenge, req); err != nil {
+		if errors.Is(err, models.ErrInvalidSecondFactor) || errors.Is(err, models.ErrMFAChallengeNotFound) {
+			a.throttle.Failure(user.Username, ip)
+		}
+		a.recordLoginFailure(user.ID, ip, "2fa")
+		return nil, err
+	}
+	response, err := a.completeLogin(user, ip, "2fa")
+	if err != nil {
+		return nil, err
+	}
+	a.throttle.Success(user.Username)
 	return response, nil
 }
+// recordLoginFailure audits a failed login. userID is empty when the attempt
+// cannot be tied to an account; the username is not recorded, since users
+// sometimes type their password into it. The login is refused either way,
+// so an event that cannot be written is only logged.
+func (a *AuthService) recordLoginFailure(userID, ip, method string) {
+	if err := a.audit.Record(&models.AuditActor{UserID: userID, IP: ip}, models.AuditLoginFailed, "", method); err != nil {
+		logger.Error("Failed to audit login failure: %v", err)
+	}
+}
+func userOrEmpty(user *models.User) string {
+	if user == nil {
+		return ""
+	}
+	return user.ID
+}
+func (a *AuthService) SetKDFParams(actor *models.AuditActor, params *models.KDFParams) error {
+	userID := actor.UserID
+	if err := crypto.ValidateKDFParams(params); err != nil {
+		return fmt.Errorf("invalid kdf parameters: %w", err)
+	}
+	_, err := a.db.GetKDFParams(userID)
+	if err == nil {
+		return fmt.Errorf("kdf parameters already configured")
+	}
+	if !errors.Is(err, models.ErrKDFNotConfigured) {
+		return fmt.Errorf("failed to load kdf parameters: %w", err)
+	}
+	return a.audit.With(actor, models.AuditKDFConfigured, "", "").SaveKDFParams(userID, params)
+}
+// SaveRecoverySlot stores a recovery slot, which must wrap the key the
+// account's current KDF parameters describe.
+func (a *AuthService) SaveRecoverySlot(actor *models.AuditActor, slot *models.RecoverySlot) error {
+	userID := actor.UserID
+	params, err := a.db.GetKDFParams(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load kdf parameters: %w", err)
+	}
+	if !bytes.Equal(params.KeyCheck, slot.KeyCheck) {
+		return models.ErrRekeyConflict
+	}
+	return a.audit.With(actor, models.AuditRecoverySlotSaved, "", "").SaveRecoverySlot(userID, slot)
+}
+func (a *AuthService) GetRecoverySlot(userID string) (*models.RecoverySlot, error) {
+	return a.db.GetRecoverySlot(userID)
+}
+// ChangePassword replaces the password of userID after checking the current
+// one. Every session except sessionID is revoked.
+func (a *AuthService) ChangePassword(actor *models.AuditActor, sessionID string, req *models.ChangePasswordRequest) error {
+	userID := actor.UserID
+	if req.SRP == nil {
+		return fmt.Errorf("%w: srp verifier is required", models.ErrInvalidAccountRequest)
+	}
+	if err := validateSRPVerifier(req.SRP); err != nil {
+		return fmt.Errorf("%w: %v", models.ErrInvalidAccountRequest, err)
+	}
+	if _, err := a.verifyPassword(userID, &req.Proof); err != nil {
+		return err
+	}
+	if err := a.audit.With(actor, models.AuditPasswordChanged, "", "").ChangePassword(userID, req.SRP, sessionID); err != nil {
+		return err
+	}
+	logger.Info("Password changed for user %s, other sessions revoked", userID)
+	return nil
+}
+func (a *AuthService) ChangeEmail(actor *models.AuditActor, email string) (*models.User, error) {
+	userID := actor.UserID
+	if _, err := mail.ParseAddress(email); err != nil {
+		return nil, fmt.Errorf("%w: invalid email format", models.ErrInvalidAccountRequest)
+	}
+	if other, err := a.db.GetUserByEmail(email); err == nil && other.ID != userID {
+		return nil, models.ErrEmailInUse
+	}
+	user, err := a.db.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	user.Email = email
+	if err := a.audit.With(actor, models.AuditEmailChanged, "", "").UpdateUser(user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+// DeleteAccount erases the account after checking the password and returns
+// a receipt signed with the receipt key.
+func (a *AuthService) DeleteAccount(actor *models.AuditActor, proof *models.PasswordProof) (*models.AccountDeletionResponse, error) {
+	userID := actor.UserID
+	user, err := a.verifyPassword(userID, proof)
+	if err != nil {
+		return nil, err
+	}
+	items, history, err := a.audit.With(actor, models.AuditAccountDeleted, "", "").DeleteUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	receipt := models.DeletionReceipt{
+		UserID:         user.ID,
+		Username:       user.Username,
+		DeletedItems:   items,
+		DeletedHistory: history,
+		DeletedAt:      time.Now().Unix(),
+	}
+	signature, err := a.receipts.Sign(receipt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign deletion receipt: %w", err)
+	}
+	logger.Info("Deleted user %s with %d items and %d history entries", userID, items, history)
+	return &models.AccountDeletionResponse{Receipt: receipt, Signature: signature}, nil
+}
+// verifyPassword checks a password proof made by userID, either an answer
+// to a fresh SRP handshake or, for accounts without a verifier, the password.
+// Failures are throttled like logins.
+func (a *AuthService) verifyPassword(userID string, proof *models.PasswordProof) (*models.User, error) {
+	user, err := a.db.GetUserByID(userID)
+	if err != nil {
+		return nil, models.ErrInvalidCredentials
+	}
+	if err := a.throttle.Check(user.Username, ""); err != nil {
+		return nil, err
+	}
+	valid, err := a.checkPasswordProof(user, proof)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		a.throttle.Failure(user.Username, "")
+		return nil, models.ErrInvalidCredentials
+	}
+	a.throttle.Success(user.Username)
+	return user, nil
+}
+func (a *AuthService) checkPasswordProof(user *models.User, proof *models.PasswordProof) (bool, error) {
+	if proof.HandshakeID != "" {
+		owner, _, err := a.checkSRPProof(proof.HandshakeID, proof.ClientProof)
+		return err == nil && owner.ID == user.ID, nil
+	}
+	if user.PasswordHash == "" || proof.Password == "" {
+		return false, nil
+	}
+	valid, err := crypto.VerifyPassword(proof.Password, user.PasswordHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to verify password: %w", err)
+	}
+	return valid, nil
+}
+// Refresh rotates a refresh token and issues a new access token for the same
+// session. Reusing an already rotated token revokes the session.
+func (a *AuthService) Refresh(refreshToken, ip string) (*models.AuthResponse, error) {
+	next, refresh, err := a.newSessionRow()
+	if err != nil {
+		return nil, err
+	}
+	tokenHash := crypto.HashToken(refreshToken)
+	// The token names the account; a token that names none fails in
+	// RotateSession below.
+	db := a.db
+	if current, err := a.db.GetSessionByTokenHash(tokenHash); err == nil {
+		actor := &models.AuditActor{UserID: current.UserID, IP: ip}
+		if current.DeviceID != nil {
+			actor.DeviceID = *current.DeviceID
+		}
+		db = a.audit.With(actor, models.AuditSessionRefreshed, "", "")
+	}
+	session, err := db.RotateSession(tokenHash, next)
+	if err != nil {
+		if errors.Is(err, models.ErrRefreshTokenReused) {
+			logger.Warn("Refresh token reuse detected, session revoked")
+		}
+		if errors.Is(err, models.ErrSessionRevoked) {
+			return nil, a.revokedSessionError(refreshToken, err)
+		}
+		return nil, err
+	}
+	if session.DeviceID != nil {
+		if err := a.db.TouchDevice(*session.DeviceID); err != nil {
+			logger.Warn("Failed to update device last seen time: %v", err)
+		}
+	}
+	user, err := a.db.GetUserByID(session.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	return a.issueTokens(user, session, refresh)
+}
+// Logout revokes the session the access token belongs to, or the session of
+// the given refresh token when the access token is no longer usable.
+func (a *AuthService) Logout(actor *models.AuditActor, sessionID, refreshToken string) error {
+	if sessionID == "" && refreshToken != "" {
+		session, err := a.db.GetSessionByTokenHash(crypto.HashToken(refreshToken))
+		if err != nil {
+			return err
+		}
+		sessionID = session.FamilyID
+		actor.UserID = session.UserID
+	}
+	if sessionID == "" {
+		return models.ErrSessionNotFound
+	}
+	return a.audit.With(actor, models.AuditLogout, "", "").RevokeSessionFamily(sessionID)
+}
+// Authenticate validates an access token and checks that its session has not
+// been revoked.
+func (a *AuthService) Authenticate(token string) (*crypto.JWTClaims, error) {
+	claims, err := a.jwtManager.ValidateToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID == "" {
+		return nil, fmt.Errorf("token is not bound to a session")
+	}
+	if claims.DeviceID != "" {
+		if err := a.devices.CheckActive(claims.UserID, claims.DeviceID); err != nil {
+			return nil, err
+		}
+	}
+	active, err := a.db.IsSessionActive(claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, models.ErrSessionRevoked
+	}
+	return claims, nil
+}
+// revokedSessionError tells a session ended by revoking its device apart
+// from one that was logged out, so the device knows to wipe itself.
+func (a *AuthService) revokedSessionError(refreshToken string, err error) error {
+	session, lookupErr := a.db.GetSessionByTokenHash(crypto.HashToken(refreshToken))
+	if lookupErr != nil || session.DeviceID == nil {
+		return err
+	}
+	if a.devices.CheckActive(session.UserID, *session.DeviceID) == models.ErrDeviceRevoked {
+		return models.ErrDeviceRevoked
+	}
+	return err
+}
+func (a *AuthService) completeLogin(user *models.User, ip, method string) (*models.AuthResponse, error) {
+	kdf, err := a.db.GetKDFParams(user.ID)
+	if err != nil && !errors.Is(err, models.ErrKDFNotConfigured) {
+		return nil, fmt.Errorf("failed to load kdf parameters: %w", err)
+	}
+	response, err := a.startSession(a.audit.With(&models.AuditActor{UserID: user.ID, IP: ip}, models.AuditLoginSucceeded, "", method), user)
+	if err != nil {
+		return nil, err
+	}
+	response.KDF = kdf
+	return response, nil
+}
+// startSession creates the session through db, which may be a handle that
+// audits it.
+func (a *AuthService) startSession(db *database.DB, user *models.User) (*models.AuthResponse, error) {
+	session, refresh, err := a.newSessionRow()
+	if err != nil {
+		return nil, err
+	}
+	session.FamilyID = session.ID
+	session.UserID = user.ID
+	if err := db.CreateSession(session); err != nil {
+		return nil, err
+	}
+	return a.issueTokens(user, session, refresh)
+}
+func (a *AuthService) newSessionRow() (*models.Session, string, error) {
+	refresh, err := crypto.NewRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	return &models.Session{
+		ID:        generateID(),
+		TokenHash: crypto.HashToken(refresh),
+		ExpiresAt: time.Now().Add(a.refreshTokenTTL),
+	}, refresh, nil
+}
+func (a *AuthService) issueTokens(user *models.User, session *models.Session, refresh string) (*models.AuthResponse, error) {
+	deviceID := ""
+	if session.DeviceID != nil {
+		deviceID = *session.DeviceID
+	}
+	token, err := a.jwtManager.GenerateDeviceToken(user.ID, user.Username, session.FamilyID, deviceID, a.accessTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	return &models.AuthResponse{
+		Token:            token,
+		RefreshToken:     refresh,
+		User:             *user,
+		ExpiresAt:        time.Now().Add(a.accessTokenTTL).Unix(),
+		RefreshExpiresAt: session.ExpiresAt.Unix(),
+	}, nil
+}
+// rehashPassword upgrades legacy or outdated hashes while the plaintext is at
+// hand. Failures are logged only; the login itself already succeeded.
+func (a *AuthService) rehashPassword(user *models.User, password string) {
+	if !crypto.NeedsRehash(user.PasswordHash, a.hashParams) {
+		return
+	}
+	hashedPassword, err := crypto.HashPasswordWithParams(password, a.hashParams)
+	if err != nil {
+		logger.Error("Failed to rehash password for user %s: %v", user.ID, err)
+		return
+	}
+	if err := a.db.UpdatePasswordHash(user.ID, hashedPassword); err != nil {
+		logger.Error("Failed to store rehashed password for user %s: %v", user.ID, err)
+		return
+	}
+	user.PasswordHash = hashedPassword
+	logger.Info("Upgraded password hash for user %s", user.ID)
+}
+// upgradeToSRP stores a verifier for accounts that predate SRP while the
+// password is at hand, so their next login can use the SRP handshake.
+func (a *AuthService) upgradeToSRP(user *models.User, password string) {
+	if _, err := a.db.GetSRPVerifier(user.ID); !errors.Is(err, models.ErrSRPNotConfigured) {
+		return
+	}
+	salt, verifier, err := crypto.NewSRPVerifier(user.Username, password, a.hashParams)
+	if err != nil {
+		logger.Error("Failed to compute srp verifier for user %s: %v", user.ID, err)
+		return
+	}
+	err = a.db.SaveSRPVerifier(user.ID, &models.SRPVerifier{
+		Salt:        salt,
+		Verifier:    verifier,
+		Time:        a.hashParams.Time,
+		Memory:      a.hashParams.Memory,
+		Parallelism: a.hashParams.Parallelism,
+	})
+	if err != nil {
+		logger.Error("Failed to store srp verifier for user %s: %v", user.ID, err)
+		return
+	}
+	logger.Info("Stored srp verifier for user %s", user.ID)
+}
+func validateSRPVerifier(verifier *models.SRPVerifier) error {
+	if len(verifier.Salt) < srpMinSaltLength || len(verifier.Salt) > srpMaxSaltLength {
+		return fmt.Errorf("srp salt must be %d to %d bytes", srpMinSaltLength, srpMaxSaltLength)
+	}
+	params := crypto.PasswordHashParams{Time: verifier.Time, Memory: verifier.Memory, Parallelism: verifier.Parallelism}
+	if err := crypto.ValidatePasswordHashParams(params); err != nil {
+		return fmt.Errorf("invalid srp parameters: %w", err)
+	}
+	return crypto.ValidateSRPVerifier(verifier.Verifier)
+}
 func generateID() string {
 	return uuid.New().String()
 }
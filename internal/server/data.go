@@ -1,23 +1,34 @@
 package server
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"time"
+	"strconv"
 	"gophkeeper/internal/crypto"
 	"gophkeeper/internal/database"
 	"gophkeeper/internal/models"
 )
+const unwrapBatchSize = 500
+// DataService stores item blobs wrapped with the owner's data key. In
+// zero-knowledge mode blobs are kept exactly as the client sent them and the
+// keys are only used to unwrap rows written before the mode was switched on.
+// Every read and change is recorded in the audit log.
 type DataService struct {
-	db        *database.DB
-	encryptor *crypto.Encryptor
+	db            *database.DB
+	keys          *KeyService
+	audit         *AuditService
+	zeroKnowledge bool
 }
-func NewDataService(db *database.DB, encryptor *crypto.Encryptor) *DataService {
+func NewDataService(db *database.DB, keys *KeyService, audit *AuditService, zeroKnowledge bool) *DataService {
 	return &DataService{
-		db:        db,
-		encryptor: encryptor,
+		db:            db,
+		keys:          keys,
+		audit:         audit,
+		zeroKnowledge: zeroKnowledge,
 	}
 }
-func (d *DataService) GetUserData(userID string) ([]models.StoredData, error) {
-	dataList, err := d.db.GetStoredDataByUserID(userID)
+func (d *DataService) GetUserData(actor *models.AuditActor) ([]models.StoredData, error) {
+	dataList, err := d.db.GetStoredDataByUserID(actor.UserID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user data: %w", err)
 	}
@@ -26,115 +37,448 @@ func (d *DataService) GetUserData(userID string) ([]models.StoredData, error) {
 			return nil, fmt.Errorf("failed to decrypt data: %w", err)
 		}
 	}
+	if err := d.audit.Record(actor, models.AuditItemsRead, "", strconv.Itoa(len(dataList))+" items"); err != nil {
+		return nil, err
+	}
 	return dataList, nil
 }
-func (d *DataService) CreateData(data *models.StoredData) error {
+func (d *DataService) CreateData(actor *models.AuditActor, data *models.StoredData) error {
+	data.UserID = actor.UserID
 	if err := d.encryptData(data); err != nil {
 		return fmt.Errorf("failed to encrypt data: %w", err)
 	}
 	if data.ID == "" {
 		data.ID = generateID()
 	}
-	data.Version = 1
-	if err := d.db.CreateStoredData(data); err != nil {
+	// Versions are part of the associated data the client's ciphertext is
+	// bound to, so new items keep the one they were sealed with. Changes
+	// based on a version get the next one from the update itself.
+	if data.Version == 0 {
+		data.Version = 1
+	}
+	if err := d.audit.With(actor, models.AuditItemCreated, data.ID, "").CreateStoredData(data); err != nil {
 		return fmt.Errorf("failed to create data: %w", err)
 	}
 	return nil
 }
-func (d *DataService) UpdateData(data *models.StoredData) error {
+// CheckVaultKey fails with ErrStaleVaultKey when keyCheck names another
+// vault key than the user's current one. Requests that do not say which key
+// they were sealed with, and accounts without KDF parameters, pass.
+func (d *DataService) CheckVaultKey(userID string, keyCheck []byte) error {
+	if len(keyCheck) == 0 {
+		return nil
+	}
+	params, err := d.db.GetKDFParams(userID)
+	if errors.Is(err, models.ErrKDFNotConfigured) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(params.KeyCheck, keyCheck) {
+		return models.ErrStaleVaultKey
+	}
+	return nil
+}
+func (d *DataService) UpdateData(actor *models.AuditActor, data *models.StoredData) error {
+	data.UserID = actor.UserID
 	if err := d.encryptData(data); err != nil {
 		return fmt.Errorf("failed to encrypt data: %w", err)
 	}
-	if err := d.db.UpdateStoredData(data); err != nil {
+	if err := d.audit.With(actor, itemChangeEvent(data), data.ID, "").UpdateStoredData(data); err != nil {
 		return fmt.Errorf("failed to update data: %w", err)
 	}
 	return nil
 }
-func (d *DataService) DeleteData(dataID, userID string) error {
-	data, err := d.db.GetStoredDataByID(dataID)
-	if err != nil {
-		return fmt.Errorf("data not found: %w", err)
+// UpdateDataFrom is UpdateData for a change based on baseVersion of the
+// item. It fails with ErrVersionConflict when the item has moved on. The
+// item becomes version baseVersion+1, which data may name but not change.
+func (d *DataService) UpdateDataFrom(actor *models.AuditActor, data *models.StoredData, baseVersion int) error {
+	if !followsBase(data, baseVersion) {
+		return models.ErrInvalidVersion
 	}
-	if data.UserID != userID {
-		return fmt.Errorf("access denied")
+	data.UserID = actor.UserID
+	if err := d.encryptData(data); err != nil {
+		return fmt.Errorf("failed to encrypt data: %w", err)
 	}
-	if err := d.db.DeleteStoredData(dataID); err != nil {
-		return fmt.Errorf("failed to delete data: %w", err)
+	if err := d.audit.With(actor, itemChangeEvent(data), data.ID, "").UpdateStoredDataFrom(data, baseVersion); err != nil {
+		if errors.Is(err, models.ErrVersionConflict) {
+			return err
+		}
+		return fmt.Errorf("failed to update data: %w", err)
 	}
 	return nil
 }
-func (d *DataService) SyncData(userID string, req *models.DataSyncRequest) (*models.DataSyncResponse, error) {
-	for i := range req.Data {
-		if err := d.encryptData(&req.Data[i]); err != nil {
-			return nil, fmt.Errorf("failed to encrypt client data: %w", err)
-		}
+func (d *DataService) DeleteData(actor *models.AuditActor, dataID string) error {
+	if err := d.audit.With(actor, models.AuditItemDeleted, dataID, "").DeleteStoredData(actor.UserID, dataID); err != nil {
+		return fmt.Errorf("failed to delete data: %w", err)
 	}
-	serverData, err := d.db.GetStoredDataByUserIDSince(userID, req.LastSyncAt)
+	return nil
+}
+// SyncData applies the client's changes and returns every change made after
+// the request cursor. The changes are read after the client's are stored,
+// so the returned cursor covers both and nothing written in between is
+// skipped. Each stored change is audited as an item event, the exchange
+// itself as a sync event.
+func (d *DataService) SyncData(actor *models.AuditActor, req *models.DataSyncRequest) (*models.DataSyncResponse, error) {
+	userID := actor.UserID
+	cursor, err := parseSyncCursor(req.Cursor)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get server data: %w", err)
+		return nil, err
 	}
+	// written maps the items stored from this request to their change, so
+	// they are not echoed back to the client.
+	written := make(map[string]int64)
 	var conflicts []models.Conflict
 	for _, clientData := range req.Data {
 		clientData.UserID = userID
-		serverDataItem, err := d.db.GetStoredDataByID(clientData.ID)
-		if err != nil && err.Error() != "stored data not found" {
+		serverDataItem, err := d.db.GetStoredDataByID(userID, clientData.ID)
+		if err != nil && !errors.Is(err, models.ErrDataNotFound) {
 			return nil, fmt.Errorf("failed to check existing data: %w", err)
 		}
+		stored := clientData
+		if err := d.encryptData(&stored); err != nil {
+			return nil, fmt.Errorf("failed to encrypt client data: %w", err)
+		}
 		if serverDataItem == nil {
-			if err := d.db.CreateStoredData(&clientData); err != nil {
+			err := d.audit.With(actor, models.AuditItemCreated, stored.ID, "sync").CreateStoredData(&stored)
+			if errors.Is(err, models.ErrDataIDInUse) {
+				// The ID belongs to another account. Nothing about that
+				// item is returned; the client has to pick a new ID.
+				conflicts = append(conflicts, models.Conflict{
+					LocalData: clientData,
+					Reason:    "Item ID is not available",
+				})
+				continue
+			}
+			if err != nil {
 				return nil, fmt.Errorf("failed to create new data: %w", err)
 			}
-		} else {
-			if clientData.UpdatedAt.After(serverDataItem.UpdatedAt) {
-				if err := d.db.UpdateStoredData(&clientData); err != nil {
-					return nil, fmt.Errorf("failed to update data: %w", err)
+			written[stored.ID] = stored.ChangeSeq
+			continue
+		}
+		if clientData.BaseVersion > 0 {
+			// The change was made on top of BaseVersion and only applies
+			// if nobody else changed the item since; the client merges
+			// otherwise.
+			if !followsBase(&clientData, clientData.BaseVersion) {
+				conflicts = append(conflicts, models.Conflict{
+					LocalData: clientData,
+					Reason:    models.ErrInvalidVersion.Error(),
+				})
+				continue
+			}
+			err := d.audit.With(actor, itemChangeEvent(&stored), stored.ID, "sync").UpdateStoredDataFrom(&stored, clientData.BaseVersion)
+			if errors.Is(err, models.ErrVersionConflict) {
+				current, err := d.currentData(userID, clientData.ID)
+				if err != nil {
+					return nil, err
 				}
-			} else if serverDataItem.UpdatedAt.After(clientData.UpdatedAt) {
 				conflicts = append(conflicts, models.Conflict{
 					LocalData:  clientData,
-					ServerData: *serverDataItem,
-					Reason:     "Server has newer version",
+					ServerData: *current,
+					Reason:     fmt.Sprintf("Server has version %d, change was based on %d", current.Version, clientData.BaseVersion),
 				})
-			} else {
-				if clientData.Version > serverDataItem.Version {
-					if err := d.db.UpdateStoredData(&clientData); err != nil {
-						return nil, fmt.Errorf("failed to update data: %w", err)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to update data: %w", err)
+			}
+			written[stored.ID] = stored.ChangeSeq
+			continue
+		}
+		// Clients that send no base version are reconciled by time, but the
+		// stored version only moves forward.
+		if clientData.UpdatedAt.After(serverDataItem.UpdatedAt) {
+			err := d.audit.With(actor, itemChangeEvent(&stored), stored.ID, "sync").UpdateStoredData(&stored)
+			if errors.Is(err, models.ErrVersionConflict) {
+				current, err := d.currentData(userID, clientData.ID)
+				if err != nil {
+					return nil, err
+				}
+				conflicts = append(conflicts, models.Conflict{
+					LocalData:  clientData,
+					ServerData: *current,
+					Reason:     "Server has higher version",
+				})
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to update data: %w", err)
+			}
+			written[stored.ID] = stored.ChangeSeq
+		} else if serverDataItem.UpdatedAt.After(clientData.UpdatedAt) {
+			if err := d.decryptData(serverDataItem); err != nil {
+				return nil, fmt.Errorf("failed to decrypt server data: %w", err)
+			}
+			conflicts = append(conflicts, models.Conflict{
+				LocalData:  clientData,
+				ServerData: *serverDataItem,
+				Reason:     "Server has newer version",
+			})
+		} else {
+			if clientData.Version > serverDataItem.Version {
+				err := d.audit.With(actor, itemChangeEvent(&stored), stored.ID, "sync").UpdateStoredData(&stored)
+				if errors.Is(err, models.ErrVersionConflict) {
+					// Another write got in since the item was read.
+					current, err := d.currentData(userID, clientData.ID)
+					if err != nil {
+						return nil, err
 					}
-				} else if serverDataItem.Version > clientData.Version {
 					conflicts = append(conflicts, models.Conflict{
 						LocalData:  clientData,
-						ServerData: *serverDataItem,
+						ServerData: *current,
 						Reason:     "Server has higher version",
 					})
+					continue
+				}
+				if err != nil {
+					return nil, fmt.Errorf("failed to update data: %w", err)
+				}
+				written[stored.ID] = stored.ChangeSeq
+			} else if serverDataItem.Version > clientData.Version {
+				if err := d.decryptData(serverDataItem); err != nil {
+					return nil, fmt.Errorf("failed to decrypt server data: %w", err)
 				}
+				conflicts = append(conflicts, models.Conflict{
+					LocalData:  clientData,
+					ServerData: *serverDataItem,
+					Reason:     "Server has higher version",
+				})
 			}
 		}
 	}
-	for i := range serverData {
-		if err := d.decryptData(&serverData[i]); err != nil {
+	changed, err := d.db.GetStoredDataChangedAfter(userID, cursor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get server data: %w", err)
+	}
+	serverData := make([]models.StoredData, 0, len(changed))
+	for i := range changed {
+		if changed[i].ChangeSeq > cursor {
+			cursor = changed[i].ChangeSeq
+		}
+		if seq, ok := written[changed[i].ID]; ok && seq == changed[i].ChangeSeq {
+			continue
+		}
+		if err := d.decryptData(&changed[i]); err != nil {
 			return nil, fmt.Errorf("failed to decrypt server data: %w", err)
 		}
+		serverData = append(serverData, changed[i])
 	}
 	response := &models.DataSyncResponse{
-		Data:       serverData,
-		LastSyncAt: time.Now(),
-		Conflicts:  conflicts,
+		Data:      serverData,
+		Cursor:    formatSyncCursor(cursor),
+		Conflicts: conflicts,
+	}
+	if err := d.audit.Record(actor, models.AuditSync, "", fmt.Sprintf("sent %d, received %d, conflicts %d", len(req.Data), len(serverData), len(conflicts))); err != nil {
+		return nil, err
 	}
 	return response, nil
 }
+// currentData reads the stored item again to report it in a conflict.
+func (d *DataService) currentData(userID, id string) (*models.StoredData, error) {
+	current, err := d.db.GetStoredDataByID(userID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current data: %w", err)
+	}
+	if err := d.decryptData(current); err != nil {
+		return nil, fmt.Errorf("failed to decrypt server data: %w", err)
+	}
+	return current, nil
+}
+// Sync cursors are the last change sequence number a client has seen. The
+// encoding is private to the server; clients only store and send it back.
+func formatSyncCursor(seq int64) string {
+	return strconv.FormatInt(seq, 10)
+}
+func parseSyncCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, models.ErrInvalidSyncCursor
+	}
+	return seq, nil
+}
+// followsBase reports whether data, a change based on baseVersion, leaves
+// the version to the server or names the one the server will assign. The
+// client binds its ciphertext to that version.
+func followsBase(data *models.StoredData, baseVersion int) bool {
+	return data.Version == 0 || data.Version == baseVersion+1
+}
+// itemChangeEvent tells deletions, which are updates that set is_deleted,
+// apart from edits.
+func itemChangeEvent(data *models.StoredData) string {
+	if data.IsDeleted {
+		return models.AuditItemDeleted
+	}
+	return models.AuditItemUpdated
+}
+// GetHistory returns every history entry of the user, so a client changing
+// the vault key can re-encrypt them.
+func (d *DataService) GetHistory(actor *models.AuditActor) ([]models.DataHistory, error) {
+	history, err := d.db.GetUserHistory(actor.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history: %w", err)
+	}
+	for i := range history {
+		if err := d.decryptHistory(&history[i]); err != nil {
+			return nil, fmt.Errorf("failed to decrypt history: %w", err)
+		}
+	}
+	if err := d.audit.Record(actor, models.AuditItemsRead, "", strconv.Itoa(len(history))+" history entries"); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+// Rekey stores the client's re-encrypted copy of every item and history
+// entry together with the parameters of the new vault key.
+func (d *DataService) Rekey(actor *models.AuditActor, req *models.RekeyRequest) error {
+	userID := actor.UserID
+	for i := range req.Data {
+		req.Data[i].UserID = userID
+		if err := d.encryptData(&req.Data[i]); err != nil {
+			return fmt.Errorf("failed to encrypt data: %w", err)
+		}
+	}
+	for i := range req.History {
+		req.History[i].UserID = userID
+		if err := d.encryptHistory(&req.History[i]); err != nil {
+			return fmt.Errorf("failed to encrypt history: %w", err)
+		}
+	}
+	detail := fmt.Sprintf("%d items, %d history entries", len(req.Data), len(req.History))
+	if err := d.audit.With(actor, models.AuditVaultRekeyed, "", detail).RekeyVault(userID, req.PreviousKeyCheck, req.KDF, req.RecoverySlot, req.Data, req.History); err != nil {
+		return fmt.Errorf("failed to rekey vault: %w", err)
+	}
+	return nil
+}
+// UnwrapLegacyData strips the server encryption layer from rows written
+// before zero-knowledge mode was enabled, leaving only client ciphertext.
+func (d *DataService) UnwrapLegacyData() (int, error) {
+	if !d.zeroKnowledge {
+		return 0, nil
+	}
+	total := 0
+	for {
+		n, err := d.rewrapBatch("", unwrapBatchSize, func(string) (string, *crypto.Encryptor, error) {
+			return "", nil, nil
+		})
+		total += n
+		if err != nil || n == 0 {
+			return total, err
+		}
+	}
+}
+// ReencryptLegacyData moves up to batchSize rows encrypted with the global
+// legacy key onto their owner's data key.
+func (d *DataService) ReencryptLegacyData(batchSize int) (int, error) {
+	if d.zeroKnowledge {
+		return 0, nil
+	}
+	return d.rewrapBatch(models.LegacyKeyID, batchSize, d.keys.EncryptorForUser)
+}
+// rewrapBatch replaces the server layer of up to batchSize rows under fromKeyID
+// (any key when empty). target picks the new key per user; a nil encryptor
+// stores the client ciphertext as-is. On failure it returns the rows
+// rewrapped before the error along with it.
+func (d *DataService) rewrapBatch(fromKeyID string, batchSize int, target func(userID string) (string, *crypto.Encryptor, error)) (int, error) {
+	dataList, err := d.db.GetServerEncryptedData(fromKeyID, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	history, err := d.db.GetServerEncryptedHistory(fromKeyID, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	rewrap := func(userID, keyID string, blob []byte) ([]byte, string, error) {
+		from, err := d.keys.Encryptor(keyID)
+		if err != nil {
+			return nil, "", err
+		}
+		plaintext, err := from.Decrypt(blob)
+		if err != nil {
+			return nil, "", err
+		}
+		newKeyID, to, err := target(userID)
+		if err != nil || to == nil {
+			return plaintext, newKeyID, err
+		}
+		ciphertext, err := to.Encrypt(plaintext)
+		return ciphertext, newKeyID, err
+	}
+	done := 0
+	for _, data := range dataList {
+		blob, keyID, err := rewrap(data.UserID, data.KeyID, data.Data)
+		if err != nil {
+			return done, fmt.Errorf("failed to rewrap data %s: %w", data.ID, err)
+		}
+		if err := d.db.ReplaceStoredDataBlob(data.ID, data.Version, data.KeyID, blob, keyID); err != nil {
+			return done, err
+		}
+		done++
+	}
+	for _, h := range history {
+		blob, keyID, err := rewrap(h.UserID, h.KeyID, h.Data)
+		if err != nil {
+			return done, fmt.Errorf("failed to rewrap history %s: %w", h.ID, err)
+		}
+		if err := d.db.ReplaceHistoryBlob(h.ID, h.KeyID, blob, keyID); err != nil {
+			return done, err
+		}
+		done++
+	}
+	return done, nil
+}
 func (d *DataService) encryptData(data *models.StoredData) error {
-	encrypted, err := d.encryptor.Encrypt(data.Data)
+	if d.zeroKnowledge {
+		data.KeyID = ""
+		return nil
+	}
+	keyID, encryptor, err := d.keys.EncryptorForUser(data.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to load data key: %w", err)
+	}
+	encrypted, err := encryptor.Encrypt(data.Data)
 	if err != nil {
 		return fmt.Errorf("failed to encrypt data: %w", err)
 	}
 	data.Data = encrypted
+	data.KeyID = keyID
 	return nil
 }
 func (d *DataService) decryptData(data *models.StoredData) error {
-	decrypted, err := d.encryptor.Decrypt(data.Data)
+	if data.KeyID == "" {
+		return nil
+	}
+	encryptor, err := d.keys.Encryptor(data.KeyID)
+	if err != nil {
+		return fmt.Errorf("failed to load data key: %w", err)
+	}
+	decrypted, err := encryptor.Decrypt(data.Data)
 	if err != nil {
 		return fmt.Errorf("failed to decrypt data: %w", err)
 	}
 	data.Data = decrypted
+	data.KeyID = ""
+	return nil
+}
+// encryptHistory and decryptHistory apply the server layer to a history
+// entry the same way as to an item.
+func (d *DataService) encryptHistory(h *models.DataHistory) error {
+	data := models.StoredData{UserID: h.UserID, Data: h.Data}
+	if err := d.encryptData(&data); err != nil {
+		return err
+	}
+	h.Data, h.KeyID = data.Data, data.KeyID
+	return nil
+}
+func (d *DataService) decryptHistory(h *models.DataHistory) error {
+	data := models.StoredData{Data: h.Data, KeyID: h.KeyID}
+	if err := d.decryptData(&data); err != nil {
+		return err
+	}
+	h.Data, h.KeyID = data.Data, data.KeyID
 	return nil
 }
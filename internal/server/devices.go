@@ -0,0 +1,100 @@
+package server
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/database"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+// DeviceService keeps the registry of client devices. A device proves
+// possession of its key when it binds a session, and revoking it ends all
+// of its sessions.
+type DeviceService struct {
+	db    *database.DB
+	audit *AuditService
+}
+func NewDeviceService(db *database.DB, audit *AuditService) *DeviceService {
+	return &DeviceService{db: db, audit: audit}
+}
+// Register binds the session in claims to the device in req, creating the
+// device when it is new or unknown to the server. ip is recorded with a
+// new device in the audit log.
+func (d *DeviceService) Register(claims *crypto.JWTClaims, req *models.DeviceRegistrationRequest, ip string) (*models.Device, error) {
+	if claims.SessionID == "" {
+		return nil, fmt.Errorf("token is not bound to a session")
+	}
+	if req.Name == "" || len(req.Name) > 255 || len(req.Platform) > 100 {
+		return nil, fmt.Errorf("invalid device name or platform")
+	}
+	if req.DeviceID != "" {
+		known, err := d.db.GetDevice(claims.UserID, req.DeviceID)
+		switch {
+		case err == nil:
+			return d.resume(claims, known, req)
+		case !errors.Is(err, models.ErrDeviceNotFound):
+			return nil, err
+		}
+	}
+	// A device the server no longer knows signed with its stale ID.
+	if !crypto.VerifyDeviceProof(req.PublicKey, req.DeviceID, claims.SessionID, req.Signature) {
+		return nil, models.ErrInvalidDeviceProof
+	}
+	device := &models.Device{
+		ID:        generateID(),
+		UserID:    claims.UserID,
+		Name:      req.Name,
+		Platform:  req.Platform,
+		PublicKey: req.PublicKey,
+	}
+	actor := &models.AuditActor{UserID: claims.UserID, DeviceID: device.ID, IP: ip}
+	if err := d.audit.With(actor, models.AuditDeviceRegistered, "", device.Name).RegisterDevice(device, claims.SessionID); err != nil {
+		return nil, err
+	}
+	logger.Info("Registered device %s (%s) for user %s", device.ID, device.Name, claims.UserID)
+	return device, nil
+}
+func (d *DeviceService) resume(claims *crypto.JWTClaims, device *models.Device, req *models.DeviceRegistrationRequest) (*models.Device, error) {
+	if device.RevokedAt != nil {
+		return nil, models.ErrDeviceRevoked
+	}
+	if !bytes.Equal(device.PublicKey, req.PublicKey) || !crypto.VerifyDeviceProof(device.PublicKey, device.ID, claims.SessionID, req.Signature) {
+		return nil, models.ErrInvalidDeviceProof
+	}
+	device.Name = req.Name
+	device.Platform = req.Platform
+	if err := d.db.ResumeDevice(device, claims.SessionID); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+// List returns the user's devices and marks the calling one.
+func (d *DeviceService) List(userID, currentID string) ([]models.Device, error) {
+	devices, err := d.db.ListDevices(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		devices[i].Current = devices[i].ID == currentID
+	}
+	return devices, nil
+}
+func (d *DeviceService) Revoke(actor *models.AuditActor, id string) error {
+	if err := d.audit.With(actor, models.AuditDeviceRevoked, "", id).RevokeDevice(actor.UserID, id); err != nil {
+		return err
+	}
+	logger.Info("Revoked device %s of user %s", id, actor.UserID)
+	return nil
+}
+// CheckActive fails with ErrDeviceRevoked for a revoked device.
+func (d *DeviceService) CheckActive(userID, id string) error {
+	device, err := d.db.GetDevice(userID, id)
+	if err != nil {
+		return err
+	}
+	if device.RevokedAt != nil {
+		return models.ErrDeviceRevoked
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package server
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+// IdempotencyKeyHeader names the header a client sets to make a retried
+// write safe; replayed responses carry IdempotentReplayedHeader.
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	DefaultIdempotencyTTL     = 24 * time.Hour
+	defaultIdempotencyTimeout = time.Minute
+	idempotencySweepInterval  = time.Hour
+)
+// IdempotencyStore keeps the responses to requests sent with an
+// Idempotency-Key; *database.DB implements it.
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(record *models.IdempotencyRecord, expiredBefore, abandonedBefore time.Time) (*models.IdempotencyRecord, error)
+	CompleteIdempotencyKey(record *models.IdempotencyRecord) error
+	ReleaseIdempotencyKey(userID, key string) error
+	DeleteExpiredIdempotencyKeys(before time.Time) (int64, error)
+}
+type IdempotencyOptions struct {
+	// Retention is how long a response is replayed to retries.
+	Retention time.Duration
+	// Timeout is how long a request may hold its key unanswered before the
+	// key is taken over, e.g. after the server stopped mid-request.
+	Timeout time.Duration
+}
+// IdempotencyService runs a write once per key of a user and answers
+// retries with the stored response.
+type IdempotencyService struct {
+	store IdempotencyStore
+	opts  IdempotencyOptions
+	now   func() time.Time
+}
+func NewIdempotencyService(store IdempotencyStore, opts IdempotencyOptions) *IdempotencyService {
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultIdempotencyTTL
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultIdempotencyTimeout
+	}
+	return &IdempotencyService{store: store, opts: opts, now: time.Now}
+}
+// Serve runs handler for the first request with key and stores its
+// response; a retry with the same method, URL and body gets that response
+// again. Without keepResponse only the status is stored, e.g. for a sync
+// that returns other items, and a retry fails with ErrIdempotencyNotKept
+// instead of running again; the client then pulls the applied changes with
+// a new key. A retry with another
+// payload fails with ErrIdempotencyKeyReused, one that arrives while the
+// first is running with ErrIdempotencyKeyInUse. Server errors are not
+// stored, so the request can be retried. Nothing is written to w when an
+// error is returned.
+func (i *IdempotencyService) Serve(w http.ResponseWriter, r *http.Request, userID, key string, keepResponse bool, handler http.HandlerFunc) error {
+	if len(key) > maxIdempotencyKeyLength {
+		return models.ErrInvalidIdempotencyKey
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	record := &models.IdempotencyRecord{
+		UserID:      userID,
+		Key:         key,
+		RequestHash: requestHash(r, body),
+		CreatedAt:   i.now(),
+	}
+	existing, err := i.store.ReserveIdempotencyKey(record, record.CreatedAt.Add(-i.opts.Retention), record.CreatedAt.Add(-i.opts.Timeout))
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		switch {
+		case existing.RequestHash != record.RequestHash:
+			return models.ErrIdempotencyKeyReused
+		case existing.StatusCode == 0:
+			return models.ErrIdempotencyKeyInUse
+		case existing.Response == nil:
+			return models.ErrIdempotencyNotKept
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		w.WriteHeader(existing.StatusCode)
+		w.Write(existing.Response)
+		return nil
+	}
+	recorder := &responseRecorder{ResponseWriter: w}
+	handler(recorder, r)
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	if recorder.status >= http.StatusInternalServerError {
+		if err := i.store.ReleaseIdempotencyKey(userID, key); err != nil {
+			logger.Error("Failed to release idempotency key: %v", err)
+		}
+		return nil
+	}
+	record.StatusCode = recorder.status
+	if keepResponse {
+		record.Response = append([]byte{}, recorder.body.Bytes()...)
+	}
+	if err := i.store.CompleteIdempotencyKey(record); err != nil {
+		// The write itself went through; a retry would be refused as in
+		// progress until the timeout, which is safer than applying it twice.
+		logger.Error("Failed to save idempotent response: %v", err)
+	}
+	return nil
+}
+// Run deletes the records of every user past the retention window until
+// ctx is done.
+func (i *IdempotencyService) Run(ctx context.Context) {
+	ticker := time.NewTicker(idempotencySweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := i.store.DeleteExpiredIdempotencyKeys(i.now().Add(-i.opts.Retention)); err != nil {
+				logger.Error("Failed to delete expired idempotency keys: %v", err)
+			}
+		}
+	}
+}
+// requestHash identifies the payload a key was first used with.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+// responseRecorder passes a response through and keeps a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
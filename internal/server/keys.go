@@ -0,0 +1,136 @@
+package server
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/database"
+	"gophkeeper/internal/models"
+)
+// KeyService manages per-user data keys. Each key is stored wrapped by the
+// master key provider and kept unwrapped in memory once used.
+type KeyService struct {
+	db       *database.DB
+	provider crypto.KeyProvider
+	legacy   *crypto.Encryptor
+	mu       sync.RWMutex
+	cache    map[string]*crypto.Encryptor
+}
+func NewKeyService(db *database.DB, provider crypto.KeyProvider, legacy *crypto.Encryptor) *KeyService {
+	return &KeyService{
+		db:       db,
+		provider: provider,
+		legacy:   legacy,
+		cache:    make(map[string]*crypto.Encryptor),
+	}
+}
+// EncryptorForUser returns the user's active data key, creating it on first use.
+func (k *KeyService) EncryptorForUser(userID string) (string, *crypto.Encryptor, error) {
+	if k.provider == nil {
+		return "", nil, fmt.Errorf("no master key configured")
+	}
+	key, err := k.db.GetActiveDataKey(userID)
+	if errors.Is(err, models.ErrDataKeyNotFound) {
+		key, err = k.createDataKey(userID)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	encryptor, err := k.open(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return key.ID, encryptor, nil
+}
+// Encryptor returns the encryptor for a key ID stored next to a ciphertext.
+func (k *KeyService) Encryptor(keyID string) (*crypto.Encryptor, error) {
+	if keyID == models.LegacyKeyID {
+		if k.legacy == nil {
+			return nil, fmt.Errorf("data is encrypted with the legacy key but ENCRYPTION_KEY is not set")
+		}
+		return k.legacy, nil
+	}
+	k.mu.RLock()
+	encryptor, ok := k.cache[keyID]
+	k.mu.RUnlock()
+	if ok {
+		return encryptor, nil
+	}
+	if k.provider == nil {
+		return nil, fmt.Errorf("no master key configured")
+	}
+	key, err := k.db.GetDataKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return k.open(key)
+}
+// RewrapDataKeys moves up to batchSize data keys onto the active master key.
+// Item ciphertexts are untouched, so this is cheap enough to run online.
+func (k *KeyService) RewrapDataKeys(batchSize int) (int, error) {
+	if k.provider == nil {
+		return 0, nil
+	}
+	keys, err := k.db.GetDataKeysNotWrappedBy(k.provider.ActiveKeyID(), batchSize)
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range keys {
+		raw, err := k.provider.Unwrap(key.MasterKeyID, key.WrappedKey)
+		if err != nil {
+			return i, fmt.Errorf("failed to unwrap data key %s: %w", key.ID, err)
+		}
+		masterKeyID, wrapped, err := k.provider.Wrap(raw)
+		if err != nil {
+			return i, err
+		}
+		if err := k.db.RewrapDataKey(key.ID, key.MasterKeyID, masterKeyID, wrapped); err != nil {
+			return i, err
+		}
+	}
+	return len(keys), nil
+}
+func (k *KeyService) createDataKey(userID string) (*models.DataKey, error) {
+	raw, err := crypto.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	masterKeyID, wrapped, err := k.provider.Wrap(raw)
+	if err != nil {
+		return nil, err
+	}
+	key := &models.DataKey{
+		ID:          generateID(),
+		UserID:      userID,
+		MasterKeyID: masterKeyID,
+		WrappedKey:  wrapped,
+	}
+	created, err := k.db.CreateDataKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return k.db.GetActiveDataKey(userID)
+	}
+	return key, nil
+}
+func (k *KeyService) open(key *models.DataKey) (*crypto.Encryptor, error) {
+	k.mu.RLock()
+	encryptor, ok := k.cache[key.ID]
+	k.mu.RUnlock()
+	if ok {
+		return encryptor, nil
+	}
+	raw, err := k.provider.Unwrap(key.MasterKeyID, key.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key %s: %w", key.ID, err)
+	}
+	encryptor, err = crypto.NewEncryptorFromKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	k.cache[key.ID] = encryptor
+	k.mu.Unlock()
+	return encryptor, nil
+}
@@ -0,0 +1,244 @@
+package server
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/database"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+const (
+	oidcNonceTTL   = 10 * time.Minute
+	jwksCacheTTL   = time.Hour
+	jwksMinRefresh = time.Minute
+)
+// OIDCVerifier validates ID tokens from the configured identity providers.
+// Provider key sets are fetched through discovery and cached; an unknown key
+// ID forces a refetch, at most once a minute, to follow key rotation.
+type OIDCVerifier struct {
+	list       []models.OIDCProvider
+	providers  map[string]models.OIDCProvider
+	httpClient *http.Client
+	mu         sync.Mutex
+	keys       map[string]*cachedJWKS
+}
+type cachedJWKS struct {
+	keys      *crypto.JWKS
+	fetchedAt time.Time
+	forcedAt  time.Time
+}
+func NewOIDCVerifier(providers []models.OIDCProvider, httpClient *http.Client) *OIDCVerifier {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	byIssuer := make(map[string]models.OIDCProvider, len(providers))
+	for _, provider := range providers {
+		byIssuer[provider.Issuer] = provider
+	}
+	return &OIDCVerifier{
+		list:       providers,
+		providers:  byIssuer,
+		httpClient: httpClient,
+		keys:       make(map[string]*cachedJWKS),
+	}
+}
+func (v *OIDCVerifier) Providers() []models.OIDCProvider {
+	return v.list
+}
+// Verify checks an ID token issued by issuer for the provider's client.
+func (v *OIDCVerifier) Verify(issuer, idToken string) (*crypto.IDTokenClaims, error) {
+	provider, ok := v.providers[issuer]
+	if !ok {
+		return nil, models.ErrOIDCUnknownIssuer
+	}
+	keys, err := v.providerKeys(issuer, false)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := crypto.VerifyIDToken(idToken, keys, provider.Issuer, provider.ClientID, time.Now())
+	if errors.Is(err, crypto.ErrUnknownKey) {
+		if keys, err = v.providerKeys(issuer, true); err != nil {
+			return nil, err
+		}
+		claims, err = crypto.VerifyIDToken(idToken, keys, provider.Issuer, provider.ClientID, time.Now())
+	}
+	if err != nil {
+		logger.Warn("Rejected id token from %s: %v", issuer, err)
+		return nil, fmt.Errorf("%w: %v", models.ErrInvalidIDToken, err)
+	}
+	return claims, nil
+}
+func (v *OIDCVerifier) providerKeys(issuer string, refresh bool) (*crypto.JWKS, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	cached := v.keys[issuer]
+	if cached != nil && time.Since(cached.fetchedAt) < jwksCacheTTL &&
+		(!refresh || time.Since(cached.forcedAt) < jwksMinRefresh) {
+		return cached.keys, nil
+	}
+	keys, err := v.fetchKeys(issuer)
+	if err != nil {
+		if cached != nil {
+			logger.Warn("Failed to refresh keys of %s, using cached keys: %v", issuer, err)
+			return cached.keys, nil
+		}
+		return nil, err
+	}
+	now := time.Now()
+	entry := &cachedJWKS{keys: keys, fetchedAt: now}
+	if refresh {
+		entry.forcedAt = now
+	} else if cached != nil {
+		entry.forcedAt = cached.forcedAt
+	}
+	v.keys[issuer] = entry
+	return keys, nil
+}
+func (v *OIDCVerifier) fetchKeys(issuer string) (*crypto.JWKS, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover %s: %w", issuer, err)
+	}
+	if discovery.Issuer != issuer || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %s does not match the issuer", issuer)
+	}
+	var keys crypto.JWKS
+	if err := v.getJSON(discovery.JWKSURI, &keys); err != nil {
+		return nil, fmt.Errorf("failed to fetch keys of %s: %w", issuer, err)
+	}
+	return &keys, nil
+}
+func (v *OIDCVerifier) getJSON(url string, result interface{}) error {
+	resp, err := v.httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(result)
+}
+// OIDCService logs users in with ID tokens from their identity provider.
+// An identity must be linked to an account first; SSO replaces the account
+// password only, the vault key stays derived from the master password.
+type OIDCService struct {
+	db       *database.DB
+	verifier *OIDCVerifier
+	auth     *AuthService
+}
+func NewOIDCService(db *database.DB, verifier *OIDCVerifier, auth *AuthService) *OIDCService {
+	return &OIDCService{db: db, verifier: verifier, auth: auth}
+}
+func (o *OIDCService) Providers() []models.OIDCProvider {
+	return o.verifier.Providers()
+}
+// Nonce hands out a single-use nonce for the next authorization request.
+// Requests are limited per IP since each one stores a row.
+func (o *OIDCService) Nonce(ip string) (*models.OIDCNonceResponse, error) {
+	if err := o.auth.throttle.Limit("nonce", ip); err != nil {
+		return nil, err
+	}
+	nonce, err := crypto.NewOIDCNonce()
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(oidcNonceTTL)
+	if err := o.db.CreateOIDCNonce(crypto.HashToken(nonce), expiresAt); err != nil {
+		return nil, err
+	}
+	return &models.OIDCNonceResponse{Nonce: nonce, ExpiresAt: expiresAt}, nil
+}
+// Run deletes expired nonces until ctx is done.
+func (o *OIDCService) Run(ctx context.Context) {
+	ticker := time.NewTicker(oidcNonceTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := o.db.DeleteExpiredOIDCNonces(time.Now()); err != nil {
+				logger.Error("Failed to delete expired oidc nonces: %v", err)
+			}
+		}
+	}
+}
+// Login issues a session, or a two-factor challenge, for the account linked
+// to the token's subject.
+func (o *OIDCService) Login(req *models.OIDCTokenRequest, ip string) (*models.AuthResponse, error) {
+	claims, err := o.verify(req)
+	if err != nil {
+		o.auth.recordLoginFailure("", ip, "sso "+req.Issuer)
+		return nil, err
+	}
+	user, err := o.db.GetUserByOIDCIdentity(claims.Issuer, claims.Subject)
+	if err != nil {
+		o.auth.recordLoginFailure("", ip, "sso "+claims.Issuer)
+		return nil, err
+	}
+	logger.Info("User %s logged in through %s", user.ID, claims.Issuer)
+	return o.auth.completeFirstFactor(user, ip, "sso "+claims.Issuer)
+}
+// Link attaches the token's subject to the account after checking the
+// password, since a linked identity logs in without it.
+func (o *OIDCService) Link(actor *models.AuditActor, req *models.OIDCLinkRequest) (*models.OIDCIdentity, error) {
+	userID := actor.UserID
+	if _, err := o.auth.verifyPassword(userID, &req.Proof); err != nil {
+		return nil, err
+	}
+	claims, err := o.verify(&req.OIDCTokenRequest)
+	if err != nil {
+		return nil, err
+	}
+	identity := &models.OIDCIdentity{
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		UserID:  userID,
+		Email:   claims.Email,
+	}
+	if err := o.auth.audit.With(actor, models.AuditOIDCLinked, "", claims.Issuer).LinkOIDCIdentity(identity); err != nil {
+		return nil, err
+	}
+	logger.Info("Linked %s identity %s to user %s", claims.Issuer, claims.Subject, userID)
+	return identity, nil
+}
+func (o *OIDCService) List(userID string) ([]models.OIDCIdentity, error) {
+	return o.db.ListOIDCIdentities(userID)
+}
+func (o *OIDCService) Unlink(actor *models.AuditActor, req *models.OIDCUnlinkRequest) error {
+	userID, issuer := actor.UserID, req.Issuer
+	if _, err := o.auth.verifyPassword(userID, &req.Proof); err != nil {
+		return err
+	}
+	if err := o.auth.audit.With(actor, models.AuditOIDCUnlinked, "", issuer).UnlinkOIDCIdentity(userID, issuer); err != nil {
+		return err
+	}
+	logger.Info("Unlinked %s identity from user %s", issuer, userID)
+	return nil
+}
+// verify checks the token and consumes the nonce it carries, which must have
+// been issued by Nonce.
+func (o *OIDCService) verify(req *models.OIDCTokenRequest) (*crypto.IDTokenClaims, error) {
+	claims, err := o.verifier.Verify(req.Issuer, req.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce == "" {
+		return nil, fmt.Errorf("%w: token has no nonce", models.ErrInvalidIDToken)
+	}
+	if err := o.db.TakeOIDCNonce(crypto.HashToken(claims.Nonce)); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
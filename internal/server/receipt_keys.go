@@ -0,0 +1,123 @@
+package server
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+// ReceiptKeyStore persists receipt signing keys. *database.DB implements it.
+type ReceiptKeyStore interface {
+	CreateReceiptKey(key *models.ReceiptKey) error
+	ListReceiptKeys() ([]models.ReceiptKey, error)
+}
+// ReceiptKeyService signs deletion receipts with a key of their own. Token
+// keys are withdrawn soon after they rotate, but a receipt has to verify
+// long after the account is gone, so receipt keys are never withdrawn.
+type ReceiptKeyService struct {
+	store   ReceiptKeyStore
+	wrapper crypto.KeyProvider
+	mu      sync.Mutex
+	signer  *crypto.SigningKey
+}
+func NewReceiptKeyService(store ReceiptKeyStore, wrapper crypto.KeyProvider) *ReceiptKeyService {
+	return &ReceiptKeyService{store: store, wrapper: wrapper}
+}
+// Load opens the receipt key ahead of the first deletion, so that a missing
+// master key shows at startup rather than after an account is erased.
+func (r *ReceiptKeyService) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	signer, err := r.load()
+	if err != nil {
+		return err
+	}
+	r.signer = signer
+	return nil
+}
+// Sign encodes receipt as a compact JWS signed with the oldest receipt key
+// the master key opens, creating one if there is none.
+func (r *ReceiptKeyService) Sign(receipt interface{}) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.signer == nil {
+		signer, err := r.load()
+		if err != nil {
+			return "", err
+		}
+		r.signer = signer
+	}
+	return r.signer.SignClaims(receipt)
+}
+// Keys returns the public half of every receipt key ever created.
+func (r *ReceiptKeyService) Keys() (*crypto.JWKS, error) {
+	stored, err := r.store.ListReceiptKeys()
+	if err != nil {
+		return nil, err
+	}
+	keys := &crypto.JWKS{Keys: []crypto.JWK{}}
+	for _, row := range stored {
+		var jwk crypto.JWK
+		if err := json.Unmarshal(row.PublicKey, &jwk); err != nil {
+			return nil, fmt.Errorf("failed to decode receipt key %s: %w", row.ID, err)
+		}
+		keys.Keys = append(keys.Keys, jwk)
+	}
+	return keys, nil
+}
+// load opens the oldest usable key. Instances starting together may each
+// create one; all of them are published, so either signature verifies.
+func (r *ReceiptKeyService) load() (*crypto.SigningKey, error) {
+	if r.wrapper == nil {
+		return nil, fmt.Errorf("receipt keys need a master key")
+	}
+	stored, err := r.store.ListReceiptKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range stored {
+		der, err := r.wrapper.Unwrap(row.WrappingKeyID, row.PrivateKey)
+		if err != nil {
+			logger.Warn("Skipping receipt key %s: %v", row.ID, err)
+			continue
+		}
+		key, err := crypto.ParseSigningKey(row.ID, row.Algorithm, der)
+		if err != nil {
+			logger.Warn("Skipping receipt key %s: %v", row.ID, err)
+			continue
+		}
+		return key, nil
+	}
+	return r.create()
+}
+func (r *ReceiptKeyService) create() (*crypto.SigningKey, error) {
+	key, err := crypto.GenerateSigningKey(crypto.JWTAlgEdDSA)
+	if err != nil {
+		return nil, err
+	}
+	der, err := key.MarshalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	wrappingKeyID, wrapped, err := r.wrapper.Wrap(der)
+	if err != nil {
+		return nil, err
+	}
+	public, err := json.Marshal(key.PublicJWK())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode receipt key: %w", err)
+	}
+	err = r.store.CreateReceiptKey(&models.ReceiptKey{
+		ID:            key.ID,
+		Algorithm:     key.Algorithm,
+		WrappingKeyID: wrappingKeyID,
+		PrivateKey:    wrapped,
+		PublicKey:     public,
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Created receipt signing key %s", key.ID)
+	return key, nil
+}
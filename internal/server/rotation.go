@@ -0,0 +1,63 @@
+package server
+import (
+	"context"
+	"time"
+	"gophkeeper/internal/logger"
+)
+// KeyRotator runs master-key rotation in small batches while the server keeps
+// serving: it rewraps data keys still under a retired master key and moves
+// rows encrypted with the legacy global key onto per-user data keys.
+type KeyRotator struct {
+	keys      *KeyService
+	data      *DataService
+	interval  time.Duration
+	batchSize int
+}
+func NewKeyRotator(keys *KeyService, data *DataService, interval time.Duration, batchSize int) *KeyRotator {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &KeyRotator{
+		keys:      keys,
+		data:      data,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+// Run processes batches until nothing is left, then waits for the next tick.
+func (r *KeyRotator) Run(ctx context.Context) {
+	if r.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := r.RunOnce()
+			if err != nil {
+				logger.Error("Key rotation batch failed: %v", err)
+				break
+			}
+			if n == 0 {
+				break
+			}
+			logger.Info("Key rotation: processed %d keys and rows", n)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+func (r *KeyRotator) RunOnce() (int, error) {
+	rewrapped, err := r.keys.RewrapDataKeys(r.batchSize)
+	if err != nil {
+		return rewrapped, err
+	}
+	reencrypted, err := r.data.ReencryptLegacyData(r.batchSize)
+	return rewrapped + reencrypted, err
+}
@@ -1,37 +1,112 @@
 package server
 import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+	"gophkeeper/internal/config"
 	"gophkeeper/internal/crypto"
 	"gophkeeper/internal/database"
+	"gophkeeper/internal/logger"
 	"gophkeeper/internal/models"
 )
+// VaultKeyCheckHeader carries the base64 key check of the vault key a
+// client sealed the items of a write with.
+const VaultKeyCheckHeader = "X-Vault-Key-Check"
 type Server struct {
 	db          *database.DB
 	jwtManager  *crypto.JWTManager
-	encryptor   *crypto.Encryptor
+	signingKeys *SigningKeyService
+	receipts    *ReceiptKeyService
+	keyService  *KeyService
+	twoFactor   *TwoFactorService
+	devices     *DeviceService
+	apiTokens   *APITokenService
 	authService *AuthService
+	oidc        *OIDCService
+	audit       *AuditService
 	dataService *DataService
+	throttle    *Throttler
+	idempotency *IdempotencyService
+	trustProxy  bool
+	adminToken  string
 }
-func NewServer(db *database.DB, jwtSecret, encryptionKey string) *Server {
-	jwtManager := crypto.NewJWTManager(jwtSecret)
-	encryptor := crypto.NewEncryptor(encryptionKey)
-	authService := NewAuthService(db, jwtManager)
-	dataService := NewDataService(db, encryptor)
+// NewServer wires the HTTP API. keyProvider wraps the data keys and the JWT
+// signing keys.
+func NewServer(db *database.DB, cfg config.ServerConfig, keyProvider crypto.KeyProvider) *Server {
+	signingKeys := NewSigningKeyService(db, SigningKeyOptions{
+		Algorithm:        cfg.JWTSigningAlg,
+		RotationInterval: cfg.JWTKeyRotation,
+		TokenTTL:         cfg.AccessTokenTTL,
+		Wrapper:          keyProvider,
+		LegacySecret:     cfg.JWTSecret,
+	})
+	jwtManager := crypto.NewJWTManager(signingKeys.KeyRing(), cfg.JWTIssuer, cfg.JWTAudience)
+	var legacy *crypto.Encryptor
+	if cfg.EncryptionKey != "" {
+		legacy = crypto.NewEncryptor(cfg.EncryptionKey)
+	}
+	keyService := NewKeyService(db, keyProvider, legacy)
+	audit := NewAuditService(db, AuditOptions{Key: cfg.AuditKey, PreviousKeys: cfg.AuditPreviousKeys, LegacySecret: cfg.JWTSecret})
+	twoFactor := NewTwoFactorService(db, keyService, audit)
+	devices := NewDeviceService(db, audit)
+	var throttleStore ThrottleStore = NewMemoryThrottleStore()
+	if cfg.LoginThrottleStore == "postgres" {
+		throttleStore = db
+	}
+	throttle := NewThrottler(throttleStore, ThrottleOptions{
+		MaxFailures:     cfg.LoginMaxFailures,
+		LockoutDuration: cfg.LoginLockoutDuration,
+		MaxDelay:        cfg.LoginBackoffMax,
+	})
+	receipts := NewReceiptKeyService(db, keyProvider)
+	authService := NewAuthService(db, jwtManager, twoFactor, devices, throttle, audit, receipts, AuthOptions{
+		PasswordHash: crypto.PasswordHashParams{
+			Time:        cfg.PasswordHashTime,
+			Memory:      cfg.PasswordHashMemory,
+			Parallelism: cfg.PasswordHashParallelism,
+		},
+		AccessTokenTTL:  cfg.AccessTokenTTL,
+		RefreshTokenTTL: cfg.RefreshTokenTTL,
+		SRPFakeSaltKey:  []byte(cfg.JWTSecret),
+	})
+	providers := make([]models.OIDCProvider, 0, len(cfg.OIDCProviders))
+	for _, provider := range cfg.OIDCProviders {
+		providers = append(providers, models.OIDCProvider{Issuer: provider.Issuer, ClientID: provider.ClientID})
+	}
+	oidc := NewOIDCService(db, NewOIDCVerifier(providers, nil), authService)
+	dataService := NewDataService(db, keyService, audit, cfg.ZeroKnowledge)
 	return &Server{
 		db:          db,
 		jwtManager:  jwtManager,
-		encryptor:   encryptor,
+		signingKeys: signingKeys,
+		receipts:    receipts,
+		keyService:  keyService,
+		twoFactor:   twoFactor,
+		devices:     devices,
+		apiTokens:   NewAPITokenService(db, audit),
 		authService: authService,
+		oidc:        oidc,
+		audit:       audit,
 		dataService: dataService,
+		throttle:    throttle,
+		idempotency: NewIdempotencyService(db, IdempotencyOptions{Retention: cfg.IdempotencyKeyTTL}),
+		adminToken:  cfg.AdminToken,
+		trustProxy:  cfg.TrustForwardedFor,
 	}
 }
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+IdempotencyKeyHeader+", "+VaultKeyCheckHeader)
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
@@ -39,31 +114,120 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
 	switch {
+	case path == "/.well-known/jwks.json" && r.Method == "GET":
+		s.handleJWKS(w, r)
+	case path == "/.well-known/receipt-keys.json" && r.Method == "GET":
+		s.handleReceiptKeys(w, r)
 	case path == "/register" && r.Method == "POST":
 		s.handleRegister(w, r)
 	case path == "/login" && r.Method == "POST":
 		s.handleLogin(w, r)
+	case path == "/srp/init" && r.Method == "POST":
+		s.handleSRPInit(w, r)
+	case path == "/srp/verify" && r.Method == "POST":
+		s.handleSRPVerify(w, r)
+	case path == "/login/2fa" && r.Method == "POST":
+		s.handleLoginMFA(w, r)
+	case path == "/oidc/providers" && r.Method == "GET":
+		s.handleOIDCProviders(w, r)
+	case path == "/oidc/nonce" && r.Method == "POST":
+		s.handleOIDCNonce(w, r)
+	case path == "/oidc/login" && r.Method == "POST":
+		s.handleOIDCLogin(w, r)
+	case path == "/oidc/identities" && r.Method == "GET":
+		s.handleListOIDCIdentities(w, r)
+	case path == "/oidc/identities" && r.Method == "POST":
+		s.handleLinkOIDCIdentity(w, r)
+	case path == "/oidc/identities" && r.Method == "DELETE":
+		s.handleUnlinkOIDCIdentity(w, r)
+	case path == "/token/refresh" && r.Method == "POST":
+		s.handleRefreshToken(w, r)
+	case path == "/logout" && r.Method == "POST":
+		s.handleLogout(w, r)
 	case path == "/data" && r.Method == "GET":
 		s.handleGetData(w, r)
 	case path == "/data" && r.Method == "POST":
-		s.handleCreateData(w, r)
+		s.idempotent(w, r, true, s.handleCreateData)
 	case path == "/data" && r.Method == "PUT":
-		s.handleUpdateData(w, r)
+		s.idempotent(w, r, true, s.handleUpdateData)
 	case path == "/data" && r.Method == "DELETE":
-		s.handleDeleteData(w, r)
+		s.idempotent(w, r, true, s.handleDeleteData)
 	case path == "/sync" && r.Method == "POST":
-		s.handleSyncData(w, r)
+		s.idempotent(w, r, false, s.handleSyncData)
+	case path == "/kdf" && r.Method == "PUT":
+		s.handleSetKDFParams(w, r)
+	case path == "/history" && r.Method == "GET":
+		s.handleGetHistory(w, r)
+	case path == "/rekey" && r.Method == "POST":
+		s.handleRekey(w, r)
+	case path == "/account/password" && r.Method == "POST":
+		s.handleChangePassword(w, r)
+	case path == "/account/email" && r.Method == "PUT":
+		s.handleChangeEmail(w, r)
+	case path == "/account" && r.Method == "DELETE":
+		s.handleDeleteAccount(w, r)
+	case path == "/audit" && r.Method == "GET":
+		s.handleListAuditEvents(w, r)
+	case path == "/devices" && r.Method == "GET":
+		s.handleListDevices(w, r)
+	case path == "/devices" && r.Method == "POST":
+		s.handleRegisterDevice(w, r)
+	case path == "/devices" && r.Method == "DELETE":
+		s.handleRevokeDevice(w, r)
+	case path == "/tokens" && r.Method == "GET":
+		s.handleListAPITokens(w, r)
+	case path == "/tokens" && r.Method == "POST":
+		s.handleCreateAPIToken(w, r)
+	case path == "/tokens" && r.Method == "DELETE":
+		s.handleRevokeAPIToken(w, r)
+	case path == "/tokens/self" && r.Method == "GET":
+		s.handleAPITokenSelf(w, r)
+	case path == "/recovery" && r.Method == "GET":
+		s.handleGetRecoverySlot(w, r)
+	case path == "/recovery" && r.Method == "PUT":
+		s.handleSaveRecoverySlot(w, r)
+	case path == "/admin/unlock" && r.Method == "POST":
+		s.handleAdminUnlock(w, r)
+	case path == "/2fa/enroll" && r.Method == "POST":
+		s.handleEnrollTOTP(w, r)
+	case path == "/2fa/confirm" && r.Method == "POST":
+		s.handleConfirmTOTP(w, r)
+	case path == "/2fa/disable" && r.Method == "POST":
+		s.handleDisableTOTP(w, r)
+	case path == "/2fa/recovery-codes" && r.Method == "POST":
+		s.handleRegenerateRecoveryCodes(w, r)
 	default:
 		s.writeErrorResponse(w, "Not found", http.StatusNotFound)
 	}
 }
+func (s *Server) DataService() *DataService {
+	return s.dataService
+}
+func (s *Server) KeyService() *KeyService {
+	return s.keyService
+}
+func (s *Server) SigningKeys() *SigningKeyService {
+	return s.signingKeys
+}
+func (s *Server) Receipts() *ReceiptKeyService {
+	return s.receipts
+}
+func (s *Server) OIDC() *OIDCService {
+	return s.oidc
+}
+func (s *Server) Idempotency() *IdempotencyService {
+	return s.idempotency
+}
+func (s *Server) AuditService() *AuditService {
+	return s.audit
+}
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	var req models.UserRegistrationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	response, err := s.authService.Register(&req)
+	response, err := s.authService.Register(&req, s.clientIP(r))
 	if err != nil {
 		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
 		return
@@ -76,30 +240,228 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	response, err := s.authService.Login(&req)
+	response, err := s.authService.Login(&req, s.clientIP(r))
 	if err != nil {
-		s.writeErrorResponse(w, err.Error(), http.StatusUnauthorized)
+		s.writeLoginError(w, err, http.StatusUnauthorized)
 		return
 	}
 	s.writeSuccessResponse(w, response)
 }
-func (s *Server) handleGetData(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleSRPInit(w http.ResponseWriter, r *http.Request) {
+	var req models.SRPInitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" || len(req.ClientPublic) == 0 {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	response, err := s.authService.SRPInit(&req, s.clientIP(r))
+	if err != nil {
+		s.writeLoginError(w, err, http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, response)
+}
+func (s *Server) handleSRPVerify(w http.ResponseWriter, r *http.Request) {
+	var req models.SRPVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.HandshakeID == "" || len(req.ClientProof) == 0 {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	response, err := s.authService.SRPVerify(&req, s.clientIP(r))
+	if err != nil {
+		s.writeLoginError(w, err, http.StatusUnauthorized)
+		return
+	}
+	s.writeSuccessResponse(w, response)
+}
+func (s *Server) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req models.MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	response, err := s.authService.LoginMFA(&req, s.clientIP(r))
+	if err != nil {
+		s.writeLoginError(w, err, http.StatusUnauthorized)
+		return
+	}
+	s.writeSuccessResponse(w, response)
+}
+// handleJWKS publishes the token verification keys as a plain JWK set, the
+// format other services expect at this path.
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	json.NewEncoder(w).Encode(s.jwtManager.KeyRing().JWKS())
+}
+// handleReceiptKeys publishes every key that ever signed a deletion receipt.
+func (s *Server) handleReceiptKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.receipts.Keys()
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=600")
+	json.NewEncoder(w).Encode(keys)
+}
+func (s *Server) handleOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	s.writeSuccessResponse(w, s.oidc.Providers())
+}
+func (s *Server) handleOIDCNonce(w http.ResponseWriter, r *http.Request) {
+	response, err := s.oidc.Nonce(s.clientIP(r))
+	if err != nil {
+		s.writeLoginError(w, err, http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, response)
+}
+func (s *Server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.OIDCTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Issuer == "" || req.IDToken == "" {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	response, err := s.oidc.Login(&req, s.clientIP(r))
+	if err != nil {
+		s.writeOIDCError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, response)
+}
+func (s *Server) handleListOIDCIdentities(w http.ResponseWriter, r *http.Request) {
 	userID, err := s.getUserIDFromToken(r)
 	if err != nil {
-		s.writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		s.writeAuthError(w, err)
 		return
 	}
-	data, err := s.dataService.GetUserData(userID)
+	identities, err := s.oidc.List(userID)
 	if err != nil {
 		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	s.writeSuccessResponse(w, data)
+	s.writeSuccessResponse(w, identities)
+}
+func (s *Server) handleLinkOIDCIdentity(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var req models.OIDCLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Issuer == "" || req.IDToken == "" {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	identity, err := s.oidc.Link(s.auditActor(r, claims), &req)
+	if err != nil {
+		s.writeOIDCError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, identity)
+}
+func (s *Server) handleUnlinkOIDCIdentity(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var req models.OIDCUnlinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Issuer == "" {
+		s.writeErrorResponse(w, "Issuer is required", http.StatusBadRequest)
+		return
+	}
+	if err := s.oidc.Unlink(s.auditActor(r, claims), &req); err != nil {
+		s.writeOIDCError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, map[string]string{"message": "Identity unlinked"})
+}
+func (s *Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	response, err := s.authService.Refresh(req.RefreshToken, s.clientIP(r))
+	if err != nil {
+		if errors.Is(err, models.ErrDeviceRevoked) {
+			s.writeAuthError(w, err)
+			return
+		}
+		logger.Warn("Refresh token rejected: %v", err)
+		s.writeErrorResponse(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+	s.writeSuccessResponse(w, response)
+}
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	var req models.LogoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	sessionID := ""
+	actor := &models.AuditActor{IP: s.clientIP(r)}
+	if claims, err := s.getClaimsFromToken(r); err == nil {
+		sessionID = claims.SessionID
+		actor = s.auditActor(r, claims)
+	} else if req.RefreshToken == "" {
+		s.writeAuthError(w, err)
+		return
+	}
+	if err := s.authService.Logout(actor, sessionID, req.RefreshToken); err != nil {
+		if errors.Is(err, models.ErrSessionNotFound) {
+			s.writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, map[string]string{"message": "Logged out successfully"})
+}
+// idempotent runs a data write through the caller's Idempotency-Key, if
+// the request has one. keepResponse keeps the response for replays.
+func (s *Server) idempotent(w http.ResponseWriter, r *http.Request, keepResponse bool, handler http.HandlerFunc) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		handler(w, r)
+		return
+	}
+	actor, _, err := s.getDataAccess(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	err = s.idempotency.Serve(w, r, actor.UserID, key, keepResponse, handler)
+	switch {
+	case err == nil:
+	case errors.Is(err, models.ErrIdempotencyKeyReused):
+		s.writeErrorResponse(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, models.ErrIdempotencyKeyInUse), errors.Is(err, models.ErrIdempotencyNotKept):
+		s.writeErrorResponse(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrInvalidIdempotencyKey):
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+	default:
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+func (s *Server) handleGetData(w http.ResponseWriter, r *http.Request) {
+	actor, scope, err := s.getDataAccess(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	data, err := s.dataService.GetUserData(actor)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, filterItemScope(scope, data))
 }
 func (s *Server) handleCreateData(w http.ResponseWriter, r *http.Request) {
-	userID, err := s.getUserIDFromToken(r)
+	actor, scope, err := s.getDataAccess(r)
 	if err != nil {
-		s.writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		s.writeAuthError(w, err)
 		return
 	}
 	var data models.StoredData
@@ -107,17 +469,24 @@ func (s *Server) handleCreateData(w http.ResponseWriter, r *http.Request) {
 		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	data.UserID = userID
-	if err := s.dataService.CreateData(&data); err != nil {
-		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	if err := checkItemScope(scope, data.ID, true); err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	if err := s.checkVaultKey(r, actor.UserID); err != nil {
+		s.writeDataError(w, err)
+		return
+	}
+	if err := s.dataService.CreateData(actor, &data); err != nil {
+		s.writeDataError(w, err)
 		return
 	}
 	s.writeSuccessResponse(w, data)
 }
 func (s *Server) handleUpdateData(w http.ResponseWriter, r *http.Request) {
-	userID, err := s.getUserIDFromToken(r)
+	actor, scope, err := s.getDataAccess(r)
 	if err != nil {
-		s.writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		s.writeAuthError(w, err)
 		return
 	}
 	var data models.StoredData
@@ -125,17 +494,35 @@ func (s *Server) handleUpdateData(w http.ResponseWriter, r *http.Request) {
 		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	data.UserID = userID
-	if err := s.dataService.UpdateData(&data); err != nil {
-		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	if err := checkItemScope(scope, data.ID, true); err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	if err := s.checkVaultKey(r, actor.UserID); err != nil {
+		s.writeDataError(w, err)
+		return
+	}
+	// With base_version the update only applies to that version of the item.
+	if base := r.URL.Query().Get("base_version"); base != "" {
+		baseVersion, convErr := strconv.Atoi(base)
+		if convErr != nil || baseVersion < 1 {
+			s.writeErrorResponse(w, "Invalid base_version", http.StatusBadRequest)
+			return
+		}
+		err = s.dataService.UpdateDataFrom(actor, &data, baseVersion)
+	} else {
+		err = s.dataService.UpdateData(actor, &data)
+	}
+	if err != nil {
+		s.writeDataError(w, err)
 		return
 	}
 	s.writeSuccessResponse(w, data)
 }
 func (s *Server) handleDeleteData(w http.ResponseWriter, r *http.Request) {
-	userID, err := s.getUserIDFromToken(r)
+	actor, scope, err := s.getDataAccess(r)
 	if err != nil {
-		s.writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		s.writeAuthError(w, err)
 		return
 	}
 	dataID := r.URL.Query().Get("id")
@@ -143,16 +530,20 @@ func (s *Server) handleDeleteData(w http.ResponseWriter, r *http.Request) {
 		s.writeErrorResponse(w, "Data ID is required", http.StatusBadRequest)
 		return
 	}
-	if err := s.dataService.DeleteData(dataID, userID); err != nil {
-		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	if err := checkItemScope(scope, dataID, true); err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	if err := s.dataService.DeleteData(actor, dataID); err != nil {
+		s.writeDataError(w, err)
 		return
 	}
 	s.writeSuccessResponse(w, map[string]string{"message": "Data deleted successfully"})
 }
 func (s *Server) handleSyncData(w http.ResponseWriter, r *http.Request) {
-	userID, err := s.getUserIDFromToken(r)
+	actor, scope, err := s.getDataAccess(r)
 	if err != nil {
-		s.writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		s.writeAuthError(w, err)
 		return
 	}
 	var req models.DataSyncRequest
@@ -160,14 +551,619 @@ func (s *Server) handleSyncData(w http.ResponseWriter, r *http.Request) {
 		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	response, err := s.dataService.SyncData(userID, &req)
+	for _, item := range req.Data {
+		if err := checkItemScope(scope, item.ID, true); err != nil {
+			s.writeAuthError(w, err)
+			return
+		}
+	}
+	if len(req.Data) > 0 {
+		if err := s.checkVaultKey(r, actor.UserID); err != nil {
+			s.writeDataError(w, err)
+			return
+		}
+	}
+	response, err := s.dataService.SyncData(actor, &req)
+	if errors.Is(err, models.ErrInvalidSyncCursor) {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response.Data = filterItemScope(scope, response.Data)
+	s.writeSuccessResponse(w, response)
+}
+func (s *Server) handleSetKDFParams(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var params models.KDFParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := crypto.ValidateKDFParams(&params); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.authService.SetKDFParams(s.auditActor(r, claims), &params); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.writeSuccessResponse(w, params)
+}
+func (s *Server) handleGetHistory(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	history, err := s.dataService.GetHistory(s.auditActor(r, claims))
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, history)
+}
+func (s *Server) handleRekey(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var req models.RekeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.KDF == nil || len(req.KDF.KeyCheck) == 0 {
+		s.writeErrorResponse(w, "KDF parameters with a key check are required", http.StatusBadRequest)
+		return
+	}
+	if err := crypto.ValidateKDFParams(req.KDF); err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.RecoverySlot != nil && !bytes.Equal(req.RecoverySlot.KeyCheck, req.KDF.KeyCheck) {
+		s.writeErrorResponse(w, "Recovery slot does not wrap the new key", http.StatusBadRequest)
+		return
+	}
+	if err := s.dataService.Rekey(s.auditActor(r, claims), &req); err != nil {
+		s.writeDataError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, req.KDF)
+}
+func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SRP == nil {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.authService.ChangePassword(s.auditActor(r, claims), claims.SessionID, &req); err != nil {
+		s.writeAccountError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, map[string]string{"message": "Password changed, other sessions were logged out"})
+}
+func (s *Server) handleChangeEmail(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var req models.ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	user, err := s.authService.ChangeEmail(s.auditActor(r, claims), req.Email)
+	if err != nil {
+		s.writeAccountError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, user)
+}
+func (s *Server) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var req models.DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	response, err := s.authService.DeleteAccount(s.auditActor(r, claims), &req.Proof)
+	if err != nil {
+		s.writeAccountError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, response)
+}
+func (s *Server) handleRegisterDevice(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var req models.DeviceRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.PublicKey) == 0 || len(req.Signature) == 0 {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	device, err := s.devices.Register(claims, &req, s.clientIP(r))
+	if err != nil {
+		s.writeDeviceError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, device)
+}
+func (s *Server) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	devices, err := s.devices.List(claims.UserID, claims.DeviceID)
+	if err != nil {
+		s.writeDeviceError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, devices)
+}
+func (s *Server) handleRevokeDevice(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		s.writeErrorResponse(w, "Device ID is required", http.StatusBadRequest)
+		return
+	}
+	if err := s.devices.Revoke(s.auditActor(r, claims), id); err != nil {
+		s.writeDeviceError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, map[string]string{"message": "Device revoked"})
+}
+// handleListAuditEvents returns the caller's own audit events, newest first.
+func (s *Server) handleListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	params := r.URL.Query()
+	query := &models.AuditQuery{Type: params.Get("type")}
+	if since := params.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			s.writeErrorResponse(w, "Invalid since, expected RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		query.Since = &t
+	}
+	if before := params.Get("before"); before != "" {
+		if query.Before, err = strconv.ParseInt(before, 10, 64); err != nil || query.Before <= 0 {
+			s.writeErrorResponse(w, "Invalid before, expected event ID", http.StatusBadRequest)
+			return
+		}
+	}
+	if limit := params.Get("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit <= 0 {
+			s.writeErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	events, err := s.audit.List(userID, query)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, events)
+}
+func (s *Server) handleCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var req models.APITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	response, err := s.apiTokens.Create(s.auditActor(r, claims), &req)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.writeSuccessResponse(w, response)
+}
+func (s *Server) handleListAPITokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	tokens, err := s.apiTokens.List(userID)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, tokens)
+}
+func (s *Server) handleRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		s.writeErrorResponse(w, "Token ID is required", http.StatusBadRequest)
+		return
+	}
+	if err := s.apiTokens.Revoke(s.auditActor(r, claims), id); err != nil {
+		if errors.Is(err, models.ErrAPITokenNotFound) {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, map[string]string{"message": "Token revoked"})
+}
+// handleAPITokenSelf lets a pipeline learn whose vault its token opens and
+// how to derive the key for it.
+func (s *Server) handleAPITokenSelf(w http.ResponseWriter, r *http.Request) {
+	_, scope, err := s.getDataAccess(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	if scope == nil {
+		s.writeErrorResponse(w, "An API token is required", http.StatusBadRequest)
+		return
+	}
+	info, err := s.apiTokens.Info(scope)
+	if err != nil {
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, info)
+}
+func (s *Server) handleGetRecoverySlot(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	slot, err := s.authService.GetRecoverySlot(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrRecoveryNotConfigured) {
+			s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, slot)
+}
+func (s *Server) handleSaveRecoverySlot(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var slot models.RecoverySlot
+	if err := json.NewDecoder(r.Body).Decode(&slot); err != nil || len(slot.WrappedKey) == 0 || len(slot.SealedRecoveryKey) == 0 {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.authService.SaveRecoverySlot(s.auditActor(r, claims), &slot); err != nil {
+		if errors.Is(err, models.ErrRekeyConflict) {
+			s.writeErrorResponse(w, err.Error(), http.StatusConflict)
+			return
+		}
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeSuccessResponse(w, nil)
+}
+// handleAdminUnlock lifts login throttling. It needs the ADMIN_TOKEN and is
+// not served at all without one.
+func (s *Server) handleAdminUnlock(w http.ResponseWriter, r *http.Request) {
+	if s.adminToken == "" {
+		s.writeErrorResponse(w, "Not found", http.StatusNotFound)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		s.writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req models.UnlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Username == "") == (req.IP == "") {
+		s.writeErrorResponse(w, "Exactly one of username or ip is required", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if req.Username != "" {
+		err = s.throttle.UnlockUser(req.Username)
+	} else {
+		err = s.throttle.UnlockIP(req.IP)
+	}
 	if err != nil {
 		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	s.writeSuccessResponse(w, map[string]string{"message": "Unlocked"})
+}
+func (s *Server) handleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := s.getUserIDFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	response, err := s.twoFactor.Enroll(userID)
+	if err != nil {
+		s.writeTwoFactorError(w, err)
+		return
+	}
 	s.writeSuccessResponse(w, response)
 }
+func (s *Server) handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var req models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	codes, err := s.twoFactor.Confirm(s.auditActor(r, claims), req.Code)
+	if err != nil {
+		s.writeTwoFactorError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, models.RecoveryCodesResponse{Codes: codes})
+}
+func (s *Server) handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var req models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.twoFactor.Disable(s.auditActor(r, claims), &req); err != nil {
+		s.writeTwoFactorError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, map[string]string{"message": "Two-factor authentication disabled"})
+}
+func (s *Server) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		s.writeAuthError(w, err)
+		return
+	}
+	var req models.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		s.writeErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	codes, err := s.twoFactor.RegenerateRecoveryCodes(s.auditActor(r, claims), &req)
+	if err != nil {
+		s.writeTwoFactorError(w, err)
+		return
+	}
+	s.writeSuccessResponse(w, models.RecoveryCodesResponse{Codes: codes})
+}
+// writeDataError answers 404 for items that are missing or owned by another
+// user, so the two cases are indistinguishable.
+func (s *Server) writeDataError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrDataNotFound):
+		s.writeErrorResponse(w, models.ErrDataNotFound.Error(), http.StatusNotFound)
+	case errors.Is(err, models.ErrDataIDInUse):
+		s.writeErrorResponse(w, models.ErrDataIDInUse.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrVersionConflict):
+		s.writeErrorResponse(w, models.ErrVersionConflict.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrInvalidVersion):
+		s.writeErrorResponse(w, models.ErrInvalidVersion.Error(), http.StatusBadRequest)
+	case errors.Is(err, models.ErrRekeyConflict):
+		s.writeErrorResponse(w, models.ErrRekeyConflict.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrRekeyIncomplete):
+		s.writeErrorResponse(w, models.ErrRekeyIncomplete.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrStaleVaultKey):
+		s.writeErrorResponse(w, models.ErrStaleVaultKey.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrKDFNotConfigured):
+		s.writeErrorResponse(w, models.ErrKDFNotConfigured.Error(), http.StatusBadRequest)
+	default:
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+// writeLoginError answers throttled attempts with 429 and Retry-After, and
+// other failures with status.
+func (s *Server) writeLoginError(w http.ResponseWriter, err error, status int) {
+	var throttled *models.ThrottledError
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		s.writeErrorResponse(w, throttled.Error(), http.StatusTooManyRequests)
+		return
+	}
+	s.writeErrorResponse(w, err.Error(), status)
+}
+// clientIP is the address login failures are counted against.
+func (s *Server) clientIP(r *http.Request) string {
+	if s.trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+func (s *Server) writeOIDCError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrOIDCUnknownIssuer):
+		s.writeErrorResponse(w, models.ErrOIDCUnknownIssuer.Error(), http.StatusBadRequest)
+	case errors.Is(err, models.ErrInvalidIDToken):
+		s.writeErrorResponse(w, models.ErrInvalidIDToken.Error(), http.StatusUnauthorized)
+	case errors.Is(err, models.ErrOIDCNotLinked):
+		s.writeErrorResponse(w, models.ErrOIDCNotLinked.Error(), http.StatusForbidden)
+	case errors.Is(err, models.ErrOIDCIdentityNotFound):
+		s.writeErrorResponse(w, models.ErrOIDCIdentityNotFound.Error(), http.StatusNotFound)
+	case errors.Is(err, models.ErrOIDCIdentityInUse), errors.Is(err, models.ErrOIDCAlreadyLinked):
+		s.writeErrorResponse(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrInvalidCredentials), isThrottled(err):
+		s.writeAccountError(w, err)
+	default:
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+// writeAuthError answers a request whose token was rejected. A revoked
+// device gets 403 so the client knows to wipe its local copy.
+func (s *Server) writeAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, models.ErrDeviceRevoked) {
+		s.writeErrorResponse(w, models.ErrDeviceRevoked.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, models.ErrAPITokenScope) {
+		s.writeErrorResponse(w, models.ErrAPITokenScope.Error(), http.StatusForbidden)
+		return
+	}
+	s.writeErrorResponse(w, "Unauthorized", http.StatusUnauthorized)
+}
+func (s *Server) writeDeviceError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrDeviceRevoked):
+		s.writeErrorResponse(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, models.ErrDeviceNotFound):
+		s.writeErrorResponse(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, models.ErrInvalidDeviceProof):
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, models.ErrSessionBound):
+		s.writeErrorResponse(w, err.Error(), http.StatusConflict)
+	default:
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+func (s *Server) writeAccountError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrInvalidCredentials):
+		s.writeErrorResponse(w, "Current password is incorrect", http.StatusForbidden)
+	case errors.Is(err, models.ErrEmailInUse):
+		s.writeErrorResponse(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrInvalidAccountRequest):
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+	case isThrottled(err):
+		s.writeLoginError(w, err, http.StatusTooManyRequests)
+	default:
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+func (s *Server) writeTwoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrInvalidSecondFactor):
+		s.writeErrorResponse(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, models.ErrTOTPAlreadyEnabled):
+		s.writeErrorResponse(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrTOTPNotConfigured):
+		s.writeErrorResponse(w, err.Error(), http.StatusBadRequest)
+	default:
+		s.writeErrorResponse(w, err.Error(), http.StatusInternalServerError)
+	}
+}
 func (s *Server) getUserIDFromToken(r *http.Request) (string, error) {
+	claims, err := s.getClaimsFromToken(r)
+	if err != nil {
+		return "", err
+	}
+	return claims.UserID, nil
+}
+// getDataAccess authenticates a data request made with either a user session
+// or an API token. The returned scope is nil for user sessions.
+func (s *Server) getDataAccess(r *http.Request) (*models.AuditActor, *models.APIToken, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !crypto.IsAPIToken(token) {
+		claims, err := s.getClaimsFromToken(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s.auditActor(r, claims), nil, nil
+	}
+	scope, err := s.apiTokens.Authenticate(token, s.clientIP(r))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return &models.AuditActor{UserID: scope.UserID, APITokenID: scope.ID, IP: s.clientIP(r)}, scope, nil
+}
+// auditActor describes the caller of a request made with a user session.
+func (s *Server) auditActor(r *http.Request, claims *crypto.JWTClaims) *models.AuditActor {
+	return &models.AuditActor{
+		UserID:   claims.UserID,
+		DeviceID: claims.DeviceID,
+		IP:       s.clientIP(r),
+	}
+}
+// checkVaultKey refuses a write whose VaultKeyCheckHeader names a replaced
+// vault key.
+func (s *Server) checkVaultKey(r *http.Request, userID string) error {
+	header := r.Header.Get(VaultKeyCheckHeader)
+	if header == "" {
+		return nil
+	}
+	keyCheck, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return models.ErrStaleVaultKey
+	}
+	return s.dataService.CheckVaultKey(userID, keyCheck)
+}
+// getClaimsFromToken accepts user sessions only; API tokens are refused
+// with ErrAPITokenScope.
+func (s *Server) getClaimsFromToken(r *http.Request) (*crypto.JWTClaims, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	if crypto.IsAPIToken(token) {
+		return nil, models.ErrAPITokenScope
+	}
+	claims, err := s.authService.Authenticate(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return claims, nil
+}
+func bearerToken(r *http.Request) (string, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
 		return "", fmt.Errorf("authorization header missing")
@@ -176,11 +1172,7 @@ func (s *Server) getUserIDFromToken(r *http.Request) (string, error) {
 	if token == authHeader {
 		return "", fmt.Errorf("invalid authorization header format")
 	}
-	claims, err := s.jwtManager.ValidateToken(token)
-	if err != nil {
-		return "", fmt.Errorf("invalid token: %w", err)
-	}
-	return claims.UserID, nil
+	return token, nil
 }
 func (s *Server) writeSuccessResponse(w http.ResponseWriter, data interface{}) {
 	response := models.NewSuccessResponse(data)
@@ -0,0 +1,216 @@
+package server
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+const (
+	defaultSigningKeyRotation = 30 * 24 * time.Hour
+	// signingKeyPublishAhead is how long a new key is published before it
+	// signs anything, so every instance and JWKS consumer knows it first.
+	signingKeyPublishAhead    = time.Hour
+	signingKeyRefreshInterval = time.Minute
+)
+// SigningKeyStore persists JWT signing keys. *database.DB implements it.
+type SigningKeyStore interface {
+	CreateSigningKey(key *models.SigningKey) (bool, error)
+	ListSigningKeys(now time.Time) ([]models.SigningKey, error)
+}
+type SigningKeyOptions struct {
+	Algorithm        string
+	RotationInterval time.Duration
+	// TokenTTL is the longest lifetime of a token signed with a key; a key
+	// stays published that long after its successor takes over.
+	TokenTTL time.Duration
+	// Wrapper is the master key provider that encrypts the private keys at
+	// rest.
+	Wrapper crypto.KeyProvider
+	// LegacySecret is the JWT secret, which wrapped the keys stored before
+	// the master key did. Such keys still verify tokens but sign no new ones.
+	LegacySecret string
+}
+// SigningKeyService keeps the JWT key ring in step with the stored keys.
+// Every instance reloads them once a minute, and whichever instance finds
+// the current key due schedules its successor an hour ahead.
+type SigningKeyService struct {
+	store     SigningKeyStore
+	ring      *crypto.KeyRing
+	wrapper   crypto.KeyProvider
+	legacy    crypto.KeyProvider
+	algorithm string
+	interval  time.Duration
+	tokenTTL  time.Duration
+	mu        sync.Mutex
+}
+type loadedSigningKey struct {
+	stored models.SigningKey
+	key    *crypto.SigningKey
+	legacy bool
+}
+func NewSigningKeyService(store SigningKeyStore, opts SigningKeyOptions) *SigningKeyService {
+	if opts.Algorithm == "" {
+		opts.Algorithm = crypto.JWTAlgEdDSA
+	}
+	if opts.RotationInterval <= 0 {
+		opts.RotationInterval = defaultSigningKeyRotation
+	}
+	if opts.TokenTTL <= 0 {
+		opts.TokenTTL = defaultAccessTokenTTL
+	}
+	s := &SigningKeyService{
+		store:     store,
+		ring:      crypto.NewKeyRing(nil),
+		wrapper:   opts.Wrapper,
+		algorithm: opts.Algorithm,
+		interval:  opts.RotationInterval,
+		tokenTTL:  opts.TokenTTL,
+	}
+	if opts.LegacySecret != "" {
+		s.legacy = crypto.NewStaticKeyProvider("jwt-signing-keys:" + opts.LegacySecret)
+	}
+	return s
+}
+func (s *SigningKeyService) KeyRing() *crypto.KeyRing {
+	return s.ring
+}
+// Run refreshes the key ring every minute until ctx is done.
+func (s *SigningKeyService) Run(ctx context.Context) {
+	ticker := time.NewTicker(signingKeyRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(); err != nil {
+				logger.Error("Failed to refresh signing keys: %v", err)
+			}
+		}
+	}
+}
+func (s *SigningKeyService) Refresh() error {
+	return s.RefreshAt(time.Now())
+}
+// RefreshAt loads the keys valid at now into the ring. It creates a key when
+// none can sign and schedules the successor once the current key is due.
+func (s *SigningKeyService) RefreshAt(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, signer, next, err := s.load(now)
+	if err != nil {
+		return err
+	}
+	activatesAt := time.Time{}
+	switch {
+	case signer == nil:
+		activatesAt = now
+	case next == nil && !now.Before(s.signUntil(signer.stored).Add(-signingKeyPublishAhead)):
+		activatesAt = s.signUntil(signer.stored)
+	}
+	if !activatesAt.IsZero() {
+		if err := s.create(activatesAt); err != nil {
+			return err
+		}
+		if keys, signer, _, err = s.load(now); err != nil {
+			return err
+		}
+		if signer == nil {
+			return fmt.Errorf("no usable signing key")
+		}
+	}
+	published := make([]*crypto.SigningKey, len(keys))
+	for i := range keys {
+		published[i] = keys[i].key
+	}
+	s.ring.Set(signer.key, published...)
+	return nil
+}
+// load returns the usable stored keys, the key that signs at now and the
+// key scheduled to take over from it, if any.
+func (s *SigningKeyService) load(now time.Time) ([]loadedSigningKey, *loadedSigningKey, *loadedSigningKey, error) {
+	stored, err := s.store.ListSigningKeys(now)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keys := make([]loadedSigningKey, 0, len(stored))
+	for _, row := range stored {
+		der, legacy, err := s.unwrap(row)
+		if err != nil {
+			logger.Warn("Skipping signing key %s: %v", row.ID, err)
+			continue
+		}
+		key, err := crypto.ParseSigningKey(row.ID, row.Algorithm, der)
+		if err != nil {
+			logger.Warn("Skipping signing key %s: %v", row.ID, err)
+			continue
+		}
+		keys = append(keys, loadedSigningKey{stored: row, key: key, legacy: legacy})
+	}
+	var signer, next *loadedSigningKey
+	for i := range keys {
+		key := &keys[i]
+		if key.legacy {
+			continue
+		}
+		if key.stored.ActivatesAt.After(now) {
+			next = key
+		} else if now.Before(s.signUntil(key.stored)) {
+			signer = key
+		}
+	}
+	return keys, signer, next, nil
+}
+// unwrap opens a stored private key and reports whether the JWT secret
+// rather than the master key wrapped it.
+func (s *SigningKeyService) unwrap(row models.SigningKey) ([]byte, bool, error) {
+	if s.legacy != nil && row.WrappingKeyID == s.legacy.ActiveKeyID() {
+		der, err := s.legacy.Unwrap(row.WrappingKeyID, row.PrivateKey)
+		return der, true, err
+	}
+	if s.wrapper == nil {
+		return nil, false, fmt.Errorf("no master key configured")
+	}
+	der, err := s.wrapper.Unwrap(row.WrappingKeyID, row.PrivateKey)
+	return der, false, err
+}
+// signUntil is when a key stops signing: late enough that every token it
+// signed expires before the key does.
+func (s *SigningKeyService) signUntil(key models.SigningKey) time.Time {
+	return key.ExpiresAt.Add(-s.tokenTTL - crypto.JWTLeeway)
+}
+func (s *SigningKeyService) create(activatesAt time.Time) error {
+	if s.wrapper == nil {
+		return fmt.Errorf("signing keys need a master key")
+	}
+	key, err := crypto.GenerateSigningKey(s.algorithm)
+	if err != nil {
+		return err
+	}
+	der, err := key.MarshalPrivateKey()
+	if err != nil {
+		return err
+	}
+	wrappingKeyID, wrapped, err := s.wrapper.Wrap(der)
+	if err != nil {
+		return err
+	}
+	created, err := s.store.CreateSigningKey(&models.SigningKey{
+		ID:            key.ID,
+		Algorithm:     key.Algorithm,
+		WrappingKeyID: wrappingKeyID,
+		PrivateKey:    wrapped,
+		ActivatesAt:   activatesAt,
+		ExpiresAt:     activatesAt.Add(s.interval + s.tokenTTL + crypto.JWTLeeway),
+	})
+	if err != nil {
+		return err
+	}
+	if created {
+		logger.Info("Created %s signing key %s, active from %s", key.Algorithm, key.ID, activatesAt.Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/models"
+	"gophkeeper/internal/server"
+)
+
+type memoryIdempotencyStore struct {
+	records map[string]models.IdempotencyRecord
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: make(map[string]models.IdempotencyRecord)}
+}
+func (m *memoryIdempotencyStore) ReserveIdempotencyKey(record *models.IdempotencyRecord, expiredBefore, abandonedBefore time.Time) (*models.IdempotencyRecord, error) {
+	id := record.UserID + "/" + record.Key
+	existing, ok := m.records[id]
+	if ok && !existing.CreatedAt.Before(expiredBefore) && (existing.StatusCode != 0 || !existing.CreatedAt.Before(abandonedBefore)) {
+		return &existing, nil
+	}
+	m.records[id] = *record
+	return nil, nil
+}
+func (m *memoryIdempotencyStore) CompleteIdempotencyKey(record *models.IdempotencyRecord) error {
+	m.records[record.UserID+"/"+record.Key] = *record
+	return nil
+}
+func (m *memoryIdempotencyStore) ReleaseIdempotencyKey(userID, key string) error {
+	delete(m.records, userID+"/"+key)
+	return nil
+}
+func (m *memoryIdempotencyStore) DeleteExpiredIdempotencyKeys(before time.Time) (int64, error) {
+	var deleted int64
+	for id, record := range m.records {
+		if record.CreatedAt.Before(before) {
+			delete(m.records, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// countingHandler answers with the number of times it ran.
+func countingHandler(calls *int, status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.WriteHeader(status)
+		w.Write([]byte(strings.Repeat("x", *calls)))
+	}
+}
+func serveKeyed(t *testing.T, service *server.IdempotencyService, userID, key, body string, handler http.HandlerFunc) (*httptest.ResponseRecorder, error) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/v1/data", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	return w, service.Serve(w, r, userID, key, true, handler)
+}
+func TestIdempotency_ReplaysResponse(t *testing.T) {
+	service := server.NewIdempotencyService(newMemoryIdempotencyStore(), server.IdempotencyOptions{})
+	calls := 0
+	handler := countingHandler(&calls, http.StatusOK)
+	first, err := serveKeyed(t, service, "user-1", "key-1", `{"id":"a"}`, handler)
+	if err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	retry, err := serveKeyed(t, service, "user-1", "key-1", `{"id":"a"}`, handler)
+	if err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("Expected the handler to run once, got %d", calls)
+	}
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() {
+		t.Errorf("Expected the first response again, got %d %q", retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get(server.IdempotentReplayedHeader) != "true" {
+		t.Error("Expected the replay to be marked")
+	}
+	if _, err := serveKeyed(t, service, "user-2", "key-1", `{"id":"a"}`, handler); err != nil || calls != 2 {
+		t.Errorf("Expected keys to be per user, got %d calls (%v)", calls, err)
+	}
+}
+func TestIdempotency_RejectsDifferentPayload(t *testing.T) {
+	service := server.NewIdempotencyService(newMemoryIdempotencyStore(), server.IdempotencyOptions{})
+	calls := 0
+	handler := countingHandler(&calls, http.StatusOK)
+	if _, err := serveKeyed(t, service, "user-1", "key-1", `{"id":"a"}`, handler); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	_, err := serveKeyed(t, service, "user-1", "key-1", `{"id":"b"}`, handler)
+	if !errors.Is(err, models.ErrIdempotencyKeyReused) {
+		t.Errorf("Expected ErrIdempotencyKeyReused, got %v", err)
+	}
+	if _, err := serveKeyed(t, service, "user-1", strings.Repeat("k", 256), `{}`, handler); !errors.Is(err, models.ErrInvalidIdempotencyKey) {
+		t.Errorf("Expected ErrInvalidIdempotencyKey for a long key, got %v", err)
+	}
+}
+func TestIdempotency_ServerErrorsAreRetried(t *testing.T) {
+	service := server.NewIdempotencyService(newMemoryIdempotencyStore(), server.IdempotencyOptions{})
+	calls := 0
+	if _, err := serveKeyed(t, service, "user-1", "key-1", `{}`, countingHandler(&calls, http.StatusInternalServerError)); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	retry, err := serveKeyed(t, service, "user-1", "key-1", `{}`, countingHandler(&calls, http.StatusOK))
+	if err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	if calls != 2 || retry.Code != http.StatusOK {
+		t.Errorf("Expected the retry to run, got %d calls and status %d", calls, retry.Code)
+	}
+}
+func TestIdempotency_InProgressAndTakeover(t *testing.T) {
+	store := newMemoryIdempotencyStore()
+	service := server.NewIdempotencyService(store, server.IdempotencyOptions{Timeout: time.Minute})
+	calls := 0
+	var concurrent error
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, concurrent = serveKeyed(t, service, "user-1", "key-1", `{}`, countingHandler(&calls, http.StatusOK))
+		w.WriteHeader(http.StatusOK)
+	}
+	if _, err := serveKeyed(t, service, "user-1", "key-1", `{}`, slow); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	if !errors.Is(concurrent, models.ErrIdempotencyKeyInUse) || calls != 1 {
+		t.Fatalf("Expected a concurrent retry to be refused, got %v after %d calls", concurrent, calls)
+	}
+	// The server stopped before answering: the key is held but has no
+	// response.
+	abandoned := store.records["user-1/key-1"]
+	abandoned.StatusCode, abandoned.Response = 0, nil
+	abandoned.CreatedAt = time.Now().Add(-2 * time.Minute)
+	store.records["user-1/key-1"] = abandoned
+	if _, err := serveKeyed(t, service, "user-1", "key-1", `{}`, countingHandler(&calls, http.StatusOK)); err != nil || calls != 2 {
+		t.Errorf("Expected an abandoned key to be taken over, got %d calls (%v)", calls, err)
+	}
+}
+func TestIdempotency_StatusOnlyResponse(t *testing.T) {
+	store := newMemoryIdempotencyStore()
+	service := server.NewIdempotencyService(store, server.IdempotencyOptions{})
+	calls := 0
+	serve := func() error {
+		r := httptest.NewRequest("POST", "/api/v1/sync", strings.NewReader(`{}`))
+		return service.Serve(httptest.NewRecorder(), r, "user-1", "key-1", false, countingHandler(&calls, http.StatusOK))
+	}
+	if err := serve(); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	kept := store.records["user-1/key-1"]
+	if kept.Response != nil || kept.StatusCode != http.StatusOK {
+		t.Fatalf("Expected only the status of the response to be kept, got %+v", kept)
+	}
+	if err := serve(); !errors.Is(err, models.ErrIdempotencyNotKept) || calls != 1 {
+		t.Errorf("Expected the retry to be refused without running, got %v after %d calls", err, calls)
+	}
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/models"
+	"gophkeeper/internal/server"
+	"gophkeeper/internal/testutil"
+)
+
+func newTestVerifier(t *testing.T) (*server.OIDCVerifier, *testutil.MockIdP) {
+	t.Helper()
+	idp := testutil.NewMockIdP("gophkeeper-cli")
+	t.Cleanup(idp.Close)
+	verifier := server.NewOIDCVerifier([]models.OIDCProvider{{Issuer: idp.Issuer(), ClientID: idp.ClientID}}, nil)
+	return verifier, idp
+}
+
+func TestOIDCVerifier_Verify(t *testing.T) {
+	verifier, idp := newTestVerifier(t)
+	claims, err := verifier.Verify(idp.Issuer(), idp.IDToken("nonce-1", nil))
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if claims.Subject != idp.Subject || claims.Nonce != "nonce-1" || claims.Email != idp.Email {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+}
+
+func TestOIDCVerifier_RejectsInvalidTokens(t *testing.T) {
+	verifier, idp := newTestVerifier(t)
+	cases := map[string]map[string]interface{}{
+		"wrong audience": {"aud": "other-client"},
+		"wrong issuer":   {"iss": "https://evil.example.com"},
+		"expired":        {"exp": time.Now().Add(-time.Hour).Unix()},
+		"no subject":     {"sub": ""},
+		"foreign azp":    {"aud": []string{idp.ClientID, "other-client"}, "azp": "other-client"},
+	}
+	for name, overrides := range cases {
+		if _, err := verifier.Verify(idp.Issuer(), idp.IDToken("nonce-1", overrides)); !errors.Is(err, models.ErrInvalidIDToken) {
+			t.Errorf("%s: expected ErrInvalidIDToken, got %v", name, err)
+		}
+	}
+	token := idp.IDToken("nonce-1", nil)
+	if _, err := verifier.Verify(idp.Issuer(), token[:len(token)-4]+"AAAA"); !errors.Is(err, models.ErrInvalidIDToken) {
+		t.Errorf("Expected a tampered signature to be rejected, got %v", err)
+	}
+	if _, err := verifier.Verify("https://unknown.example.com", token); !errors.Is(err, models.ErrOIDCUnknownIssuer) {
+		t.Errorf("Expected ErrOIDCUnknownIssuer, got %v", err)
+	}
+}
+
+func TestOIDCVerifier_FollowsKeyRotation(t *testing.T) {
+	verifier, idp := newTestVerifier(t)
+	if _, err := verifier.Verify(idp.Issuer(), idp.IDToken("nonce-1", nil)); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	idp.RotateKey()
+	if _, err := verifier.Verify(idp.Issuer(), idp.IDToken("nonce-2", nil)); err != nil {
+		t.Fatalf("Expected the rotated key to be fetched, got %v", err)
+	}
+	idp.RotateKey()
+	if _, err := verifier.Verify(idp.Issuer(), idp.IDToken("nonce-3", nil)); !errors.Is(err, models.ErrInvalidIDToken) {
+		t.Errorf("Expected forced refetches to be rate limited, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/models"
+	"gophkeeper/internal/server"
+)
+
+type memoryReceiptKeyStore struct {
+	mu   sync.Mutex
+	keys []models.ReceiptKey
+}
+
+func (m *memoryReceiptKeyStore) CreateReceiptKey(key *models.ReceiptKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = append(m.keys, *key)
+	return nil
+}
+
+func (m *memoryReceiptKeyStore) ListReceiptKeys() ([]models.ReceiptKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.ReceiptKey(nil), m.keys...), nil
+}
+
+func verifyReceipt(t *testing.T, receipts *server.ReceiptKeyService, signature string) models.DeletionReceipt {
+	t.Helper()
+	keys, err := receipts.Keys()
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	_, payload, err := crypto.VerifyJWS(signature, keys)
+	if err != nil {
+		t.Fatalf("Expected the receipt to verify against the published keys, got %v", err)
+	}
+	var receipt models.DeletionReceipt
+	if err := json.Unmarshal(payload, &receipt); err != nil {
+		t.Fatalf("Failed to decode receipt: %v", err)
+	}
+	return receipt
+}
+
+func TestReceiptKeyService_SignsWithOneLongLivedKey(t *testing.T) {
+	store := &memoryReceiptKeyStore{}
+	master := crypto.NewStaticKeyProvider("test-master-key")
+	first := server.NewReceiptKeyService(store, master)
+	signature, err := first.Sign(models.DeletionReceipt{UserID: "user-1", Username: "alice"})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if receipt := verifyReceipt(t, first, signature); receipt.Username != "alice" {
+		t.Errorf("Expected the signed receipt back, got %+v", receipt)
+	}
+
+	// Another instance, or a restart, signs with the stored key.
+	second := server.NewReceiptKeyService(store, master)
+	if err := second.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, err := second.Sign(models.DeletionReceipt{UserID: "user-2"}); err != nil || len(store.keys) != 1 {
+		t.Fatalf("Expected the stored key to be reused, got %d keys (%v)", len(store.keys), err)
+	}
+}
+
+func TestReceiptKeyService_KeepsUnreadableKeysPublished(t *testing.T) {
+	store := &memoryReceiptKeyStore{}
+	old := server.NewReceiptKeyService(store, crypto.NewStaticKeyProvider("retired-master-key"))
+	signature, err := old.Sign(models.DeletionReceipt{UserID: "user-1"})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	receipts := server.NewReceiptKeyService(store, crypto.NewStaticKeyProvider("test-master-key"))
+	if err := receipts.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(store.keys) != 2 {
+		t.Fatalf("Expected a new key when the stored one cannot be unwrapped, got %d keys", len(store.keys))
+	}
+	verifyReceipt(t, receipts, signature)
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/models"
+	"gophkeeper/internal/server"
+)
+
+type memorySigningKeyStore struct {
+	mu   sync.Mutex
+	keys []models.SigningKey
+}
+
+func (m *memorySigningKeyStore) CreateSigningKey(key *models.SigningKey) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.keys {
+		if existing.ActivatesAt.Equal(key.ActivatesAt) {
+			return false, nil
+		}
+	}
+	m.keys = append(m.keys, *key)
+	sort.Slice(m.keys, func(i, j int) bool { return m.keys[i].ActivatesAt.Before(m.keys[j].ActivatesAt) })
+	return true, nil
+}
+
+func (m *memorySigningKeyStore) ListSigningKeys(now time.Time) ([]models.SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []models.SigningKey
+	for _, key := range m.keys {
+		if key.ExpiresAt.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func newTestSigningKeys(store server.SigningKeyStore) *server.SigningKeyService {
+	return server.NewSigningKeyService(store, server.SigningKeyOptions{
+		Algorithm:        crypto.JWTAlgES256,
+		RotationInterval: 24 * time.Hour,
+		TokenTTL:         15 * time.Minute,
+		Wrapper:          crypto.NewStaticKeyProvider("test-master-key"),
+		LegacySecret:     "test-jwt-secret",
+	})
+}
+
+func publishedKeyIDs(ring *crypto.KeyRing) map[string]bool {
+	ids := make(map[string]bool)
+	for _, key := range ring.JWKS().Keys {
+		ids[key.Kid] = true
+	}
+	return ids
+}
+
+func TestSigningKeyService_Rotation(t *testing.T) {
+	store := &memorySigningKeyStore{}
+	keys := newTestSigningKeys(store)
+	start := time.Now()
+	if err := keys.RefreshAt(start); err != nil {
+		t.Fatalf("RefreshAt failed: %v", err)
+	}
+	first := keys.KeyRing().Signer()
+	if first == nil || first.Algorithm != crypto.JWTAlgES256 || len(store.keys) != 1 {
+		t.Fatalf("Expected a first key to be created, got %+v", first)
+	}
+
+	// An hour before the key is due its successor is published, but does
+	// not sign yet.
+	keys.RefreshAt(start.Add(23*time.Hour + time.Minute))
+	if len(store.keys) != 2 || keys.KeyRing().Signer().ID != first.ID {
+		t.Fatalf("Expected the successor to be scheduled, got %d keys", len(store.keys))
+	}
+	second := store.keys[1]
+	if !second.ActivatesAt.Equal(start.Add(24*time.Hour)) || !publishedKeyIDs(keys.KeyRing())[second.ID] {
+		t.Fatalf("Expected the successor to be published ahead, activating at %s", second.ActivatesAt)
+	}
+
+	// Once it activates, the previous key stays published for the token
+	// lifetime only.
+	keys.RefreshAt(start.Add(24*time.Hour + time.Minute))
+	if keys.KeyRing().Signer().ID != second.ID || !publishedKeyIDs(keys.KeyRing())[first.ID] {
+		t.Fatal("Expected the successor to sign while the previous key is still published")
+	}
+	keys.RefreshAt(start.Add(24*time.Hour + 20*time.Minute))
+	if publishedKeyIDs(keys.KeyRing())[first.ID] || len(store.keys) != 2 {
+		t.Fatal("Expected the previous key to be withdrawn once its tokens expired")
+	}
+}
+
+func TestSigningKeyService_SharedBetweenInstances(t *testing.T) {
+	store := &memorySigningKeyStore{}
+	first := newTestSigningKeys(store)
+	second := newTestSigningKeys(store)
+	start := time.Now()
+	first.RefreshAt(start)
+	second.RefreshAt(start)
+	if first.KeyRing().Signer().ID != second.KeyRing().Signer().ID || len(store.keys) != 1 {
+		t.Fatal("Expected instances to share the stored key")
+	}
+	due := start.Add(23*time.Hour + 30*time.Minute)
+	first.RefreshAt(due)
+	second.RefreshAt(due)
+	if len(store.keys) != 2 {
+		t.Fatalf("Expected a single successor, got %d keys", len(store.keys))
+	}
+
+	manager := crypto.NewJWTManager(first.KeyRing(), "gophkeeper", "gophkeeper-api")
+	token, err := manager.GenerateToken("user-1", "alice", 15*time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	if _, err := crypto.NewJWTManager(second.KeyRing(), "gophkeeper", "gophkeeper-api").ValidateToken(token); err != nil {
+		t.Errorf("Expected another instance to accept the token, got %v", err)
+	}
+}
+
+func TestSigningKeyService_SkipsUnreadableKeys(t *testing.T) {
+	store := &memorySigningKeyStore{}
+	start := time.Now()
+	newTestSigningKeys(store).RefreshAt(start)
+	other := server.NewSigningKeyService(store, server.SigningKeyOptions{Wrapper: crypto.NewStaticKeyProvider("another-master-key")})
+	if err := other.RefreshAt(start.Add(time.Minute)); err != nil {
+		t.Fatalf("RefreshAt failed: %v", err)
+	}
+	if signer := other.KeyRing().Signer(); signer == nil || signer.ID == store.keys[0].ID || signer.Algorithm != crypto.JWTAlgEdDSA {
+		t.Errorf("Expected a new EdDSA key when the stored one cannot be unwrapped, got %+v", signer)
+	}
+}
+
+func TestSigningKeyService_RetiresKeysWrappedWithJWTSecret(t *testing.T) {
+	store := &memorySigningKeyStore{}
+	start := time.Now()
+	// Keys used to be wrapped with a key derived from the JWT secret.
+	old := server.NewSigningKeyService(store, server.SigningKeyOptions{
+		Wrapper: crypto.NewStaticKeyProvider("jwt-signing-keys:test-jwt-secret"),
+	})
+	old.RefreshAt(start)
+	legacy := old.KeyRing().Signer()
+	token, err := crypto.NewJWTManager(old.KeyRing(), "gophkeeper", "gophkeeper-api").GenerateToken("user-1", "alice", 15*time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+	keys := newTestSigningKeys(store)
+	if err := keys.RefreshAt(start.Add(time.Minute)); err != nil {
+		t.Fatalf("RefreshAt failed: %v", err)
+	}
+	if signer := keys.KeyRing().Signer(); signer == nil || signer.ID == legacy.ID {
+		t.Fatalf("Expected a key wrapped with the master key to take over, got %+v", signer)
+	}
+	if store.keys[len(store.keys)-1].WrappingKeyID != crypto.NewStaticKeyProvider("test-master-key").ActiveKeyID() {
+		t.Error("Expected the new key to be wrapped with the master key")
+	}
+	if _, err := crypto.NewJWTManager(keys.KeyRing(), "gophkeeper", "gophkeeper-api").ValidateToken(token); err != nil {
+		t.Errorf("Expected tokens signed with the old key to stay valid, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gophkeeper/internal/models"
+	"gophkeeper/internal/server"
+)
+
+func newTestThrottler() *server.Throttler {
+	return server.NewThrottler(server.NewMemoryThrottleStore(), server.ThrottleOptions{
+		UserFreeAttempts: 2,
+		IPFreeAttempts:   4,
+		BaseDelay:        time.Minute,
+		MaxDelay:         10 * time.Minute,
+		MaxFailures:      6,
+		LockoutDuration:  time.Hour,
+	})
+}
+func retryAfter(t *testing.T, err error) *models.ThrottledError {
+	t.Helper()
+	var throttled *models.ThrottledError
+	if !errors.As(err, &throttled) {
+		t.Fatalf("Expected a throttled error, got %v", err)
+	}
+	return throttled
+}
+func TestThrottler_BackoffAndLockout(t *testing.T) {
+	throttle := newTestThrottler()
+	for i := 0; i < 2; i++ {
+		throttle.Failure("Alice", "203.0.113.7")
+	}
+	if err := throttle.Check("alice", "198.51.100.1"); err != nil {
+		t.Fatalf("Expected free attempts to pass, got %v", err)
+	}
+	throttle.Failure("alice", "198.51.100.1")
+	first := retryAfter(t, throttle.Check("ALICE", "198.51.100.2"))
+	if first.Locked || first.RetryAfter > time.Minute || first.RetryAfter < 59*time.Second {
+		t.Errorf("Expected a one minute backoff, got %+v", first)
+	}
+	throttle.Failure("alice", "198.51.100.1")
+	if second := retryAfter(t, throttle.Check("alice", "")); second.RetryAfter <= first.RetryAfter {
+		t.Errorf("Expected the backoff to grow, got %s after %s", second.RetryAfter, first.RetryAfter)
+	}
+	for i := 0; i < 2; i++ {
+		throttle.Failure("alice", "198.51.100.1")
+	}
+	if locked := retryAfter(t, throttle.Check("alice", "")); !locked.Locked || locked.RetryAfter < 59*time.Minute {
+		t.Errorf("Expected the account to be locked for an hour, got %+v", locked)
+	}
+	if err := throttle.UnlockUser("Alice"); err != nil {
+		t.Fatalf("UnlockUser failed: %v", err)
+	}
+	if err := throttle.Check("alice", ""); err != nil {
+		t.Errorf("Expected the account to be unlocked, got %v", err)
+	}
+}
+func TestThrottler_PerIP(t *testing.T) {
+	throttle := newTestThrottler()
+	for i := 0; i < 5; i++ {
+		throttle.Failure("user"+string(rune('a'+i)), "203.0.113.7")
+	}
+	if err := throttle.Check("someone-else", "203.0.113.7"); !errors.Is(err, models.ErrTooManyAttempts) {
+		t.Errorf("Expected the address to be throttled across usernames, got %v", err)
+	}
+	if err := throttle.Check("someone-else", "203.0.113.8"); err != nil {
+		t.Errorf("Expected other addresses to pass, got %v", err)
+	}
+	throttle.Success("usera")
+	if err := throttle.Check("", "203.0.113.7"); err == nil {
+		t.Error("Expected a successful login not to clear the address")
+	}
+	if err := throttle.UnlockIP("203.0.113.7"); err != nil {
+		t.Fatalf("UnlockIP failed: %v", err)
+	}
+	if err := throttle.Check("", "203.0.113.7"); err != nil {
+		t.Errorf("Expected the address to be unlocked, got %v", err)
+	}
+}
+func TestThrottler_Limit(t *testing.T) {
+	throttle := newTestThrottler()
+	for i := 0; i < 4; i++ {
+		if err := throttle.Limit("nonce", "203.0.113.7"); err != nil {
+			t.Fatalf("Expected free requests to pass, got %v", err)
+		}
+	}
+	if err := throttle.Limit("nonce", "203.0.113.7"); err != nil {
+		t.Fatalf("Expected the request starting the backoff to pass, got %v", err)
+	}
+	retryAfter(t, throttle.Limit("nonce", "203.0.113.7"))
+	if err := throttle.Check("", "203.0.113.7"); err != nil {
+		t.Errorf("Expected logins from the address to stay unaffected, got %v", err)
+	}
+}
+func TestMemoryThrottleStore_ForgetsAfterWindow(t *testing.T) {
+	store := server.NewMemoryThrottleStore()
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := store.RecordLoginFailure("user:bob", now, time.Hour); err != nil {
+			t.Fatalf("RecordLoginFailure failed: %v", err)
+		}
+	}
+	state, _ := store.RecordLoginFailure("user:bob", now.Add(2*time.Hour), time.Hour)
+	if state.Failures != 1 {
+		t.Errorf("Expected the counter to start over after a quiet window, got %d", state.Failures)
+	}
+}
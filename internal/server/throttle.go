@@ -0,0 +1,257 @@
+package server
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+// ThrottleStore keeps login failure counters. MemoryThrottleStore serves a
+// single instance; *database.DB shares the counters between instances.
+type ThrottleStore interface {
+	GetLoginThrottle(key string) (*models.LoginThrottle, error)
+	RecordLoginFailure(key string, now time.Time, window time.Duration) (*models.LoginThrottle, error)
+	BlockLogin(key string, until time.Time, locked bool) error
+	ResetLoginThrottle(key string) error
+}
+type ThrottleOptions struct {
+	// UserFreeAttempts and IPFreeAttempts are the failures allowed before
+	// the backoff starts; IPs get more since users may share one address.
+	UserFreeAttempts int
+	IPFreeAttempts   int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	// MaxFailures locks the account for LockoutDuration.
+	MaxFailures     int
+	LockoutDuration time.Duration
+	// Window is the quiet period after which failures are forgotten.
+	Window time.Duration
+}
+var DefaultThrottleOptions = ThrottleOptions{
+	UserFreeAttempts: 3,
+	IPFreeAttempts:   20,
+	BaseDelay:        time.Second,
+	MaxDelay:         5 * time.Minute,
+	MaxFailures:      10,
+	LockoutDuration:  15 * time.Minute,
+	Window:           time.Hour,
+}
+// Throttler slows down password guessing with an exponential backoff per
+// username and per client IP, and locks accounts after repeated failures.
+type Throttler struct {
+	store ThrottleStore
+	opts  ThrottleOptions
+	now   func() time.Time
+}
+func NewThrottler(store ThrottleStore, opts ThrottleOptions) *Throttler {
+	defaults := DefaultThrottleOptions
+	if opts.UserFreeAttempts <= 0 {
+		opts.UserFreeAttempts = defaults.UserFreeAttempts
+	}
+	if opts.IPFreeAttempts <= 0 {
+		opts.IPFreeAttempts = defaults.IPFreeAttempts
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = defaults.BaseDelay
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = defaults.MaxDelay
+	}
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = defaults.MaxFailures
+	}
+	if opts.LockoutDuration <= 0 {
+		opts.LockoutDuration = defaults.LockoutDuration
+	}
+	if opts.Window <= 0 {
+		opts.Window = defaults.Window
+	}
+	return &Throttler{store: store, opts: opts, now: time.Now}
+}
+// Check refuses an attempt with a *models.ThrottledError while the username
+// or the IP is blocked. Either may be empty.
+func (t *Throttler) Check(username, ip string) error {
+	now := t.now()
+	var refused *models.ThrottledError
+	for _, key := range throttleKeys(username, ip) {
+		state, err := t.store.GetLoginThrottle(key)
+		if err != nil {
+			return err
+		}
+		if state == nil || state.BlockedUntil == nil || !state.BlockedUntil.After(now) {
+			continue
+		}
+		wait := state.BlockedUntil.Sub(now)
+		if refused == nil || wait > refused.RetryAfter {
+			refused = &models.ThrottledError{RetryAfter: wait, Locked: state.Locked}
+		}
+	}
+	if refused != nil {
+		return refused
+	}
+	return nil
+}
+// Failure records a failed attempt and blocks the username and IP for the
+// backoff delay, or locks the account once MaxFailures is reached.
+func (t *Throttler) Failure(username, ip string) {
+	now := t.now()
+	for _, key := range throttleKeys(username, ip) {
+		state, err := t.store.RecordLoginFailure(key, now, t.opts.Window)
+		if err != nil {
+			logger.Error("Failed to record login failure: %v", err)
+			continue
+		}
+		isUser := strings.HasPrefix(key, userThrottlePrefix)
+		free := t.opts.IPFreeAttempts
+		if isUser {
+			free = t.opts.UserFreeAttempts
+		}
+		delay, locked := t.backoff(state.Failures, free), false
+		if isUser && state.Failures >= t.opts.MaxFailures {
+			delay, locked = t.opts.LockoutDuration, true
+			logger.Warn("Locking %s for %s after %d failed logins", key, delay, state.Failures)
+		}
+		if delay == 0 {
+			continue
+		}
+		if err := t.store.BlockLogin(key, now.Add(delay), locked); err != nil {
+			logger.Error("Failed to block login: %v", err)
+		}
+	}
+}
+// Success clears the username's failures. The IP keeps its count so one
+// valid account cannot be used to reset it.
+func (t *Throttler) Success(username string) {
+	if username == "" {
+		return
+	}
+	if err := t.store.ResetLoginThrottle(userThrottleKey(username)); err != nil {
+		logger.Error("Failed to reset login throttle: %v", err)
+	}
+}
+// Limit counts a request that is not a login attempt, such as asking for an
+// SSO nonce, against the IP under its own kind. Past the IP's free attempts
+// the requests back off like failed logins until the window passes quietly.
+func (t *Throttler) Limit(kind, ip string) error {
+	if ip == "" {
+		return nil
+	}
+	key := kind + ":" + ipThrottlePrefix + ip
+	now := t.now()
+	state, err := t.store.GetLoginThrottle(key)
+	if err != nil {
+		return err
+	}
+	if state != nil && state.BlockedUntil != nil && state.BlockedUntil.After(now) {
+		return &models.ThrottledError{RetryAfter: state.BlockedUntil.Sub(now)}
+	}
+	if state, err = t.store.RecordLoginFailure(key, now, t.opts.Window); err != nil {
+		return err
+	}
+	if delay := t.backoff(state.Failures, t.opts.IPFreeAttempts); delay > 0 {
+		return t.store.BlockLogin(key, now.Add(delay), false)
+	}
+	return nil
+}
+func (t *Throttler) UnlockUser(username string) error {
+	return t.store.ResetLoginThrottle(userThrottleKey(username))
+}
+func (t *Throttler) UnlockIP(ip string) error {
+	return t.store.ResetLoginThrottle(ipThrottlePrefix + ip)
+}
+func (t *Throttler) backoff(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	delay := t.opts.BaseDelay
+	for i := free + 1; i < failures && delay < t.opts.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.opts.MaxDelay {
+		delay = t.opts.MaxDelay
+	}
+	return delay
+}
+const (
+	userThrottlePrefix = "user:"
+	ipThrottlePrefix   = "ip:"
+)
+func userThrottleKey(username string) string {
+	return userThrottlePrefix + strings.ToLower(username)
+}
+func throttleKeys(username, ip string) []string {
+	var keys []string
+	if username != "" {
+		keys = append(keys, userThrottleKey(username))
+	}
+	if ip != "" {
+		keys = append(keys, ipThrottlePrefix+ip)
+	}
+	return keys
+}
+func isThrottled(err error) bool {
+	return errors.Is(err, models.ErrTooManyAttempts)
+}
+// MemoryThrottleStore keeps the counters in process memory.
+type MemoryThrottleStore struct {
+	mu      sync.Mutex
+	entries map[string]*models.LoginThrottle
+}
+func NewMemoryThrottleStore() *MemoryThrottleStore {
+	return &MemoryThrottleStore{entries: make(map[string]*models.LoginThrottle)}
+}
+func (m *MemoryThrottleStore) GetLoginThrottle(key string) (*models.LoginThrottle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	copied := *state
+	return &copied, nil
+}
+func (m *MemoryThrottleStore) RecordLoginFailure(key string, now time.Time, window time.Duration) (*models.LoginThrottle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.entries[key]
+	if !ok {
+		m.prune(now, window)
+		state = &models.LoginThrottle{Key: key}
+		m.entries[key] = state
+	} else if state.LastFailureAt.Before(now.Add(-window)) {
+		state.Failures = 0
+	}
+	state.Failures++
+	state.LastFailureAt = now
+	copied := *state
+	return &copied, nil
+}
+func (m *MemoryThrottleStore) BlockLogin(key string, until time.Time, locked bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state, ok := m.entries[key]; ok {
+		state.BlockedUntil = &until
+		state.Locked = locked
+	}
+	return nil
+}
+func (m *MemoryThrottleStore) ResetLoginThrottle(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+// prune drops entries that are neither recent nor blocked, so guessing
+// against many usernames cannot grow the map without bound.
+func (m *MemoryThrottleStore) prune(now time.Time, window time.Duration) {
+	if len(m.entries) < memoryThrottlePruneSize {
+		return
+	}
+	for key, state := range m.entries {
+		if state.LastFailureAt.Before(now.Add(-window)) && (state.BlockedUntil == nil || state.BlockedUntil.Before(now)) {
+			delete(m.entries, key)
+		}
+	}
+}
+const memoryThrottlePruneSize = 10000
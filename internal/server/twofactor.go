@@ -0,0 +1,219 @@
+package server
+import (
+	"errors"
+	"fmt"
+	"time"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/database"
+	"gophkeeper/internal/logger"
+	"gophkeeper/internal/models"
+)
+const (
+	totpIssuer        = "GophKeeper"
+	recoveryCodeCount = 10
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaMaxAttempts    = 5
+)
+// TwoFactorService manages TOTP enrollment, recovery codes and the login
+// challenges that bridge the password step and the second factor. Secrets are
+// encrypted with the user's data key, so 2FA needs a master key even in
+// zero-knowledge mode.
+type TwoFactorService struct {
+	db    *database.DB
+	keys  *KeyService
+	audit *AuditService
+}
+func NewTwoFactorService(db *database.DB, keys *KeyService, audit *AuditService) *TwoFactorService {
+	return &TwoFactorService{
+		db:    db,
+		keys:  keys,
+		audit: audit,
+	}
+}
+// Enroll creates a pending secret. It does not protect logins until Confirm
+// has seen a valid code from the authenticator.
+func (t *TwoFactorService) Enroll(userID string) (*models.TOTPEnrollResponse, error) {
+	user, err := t.db.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := crypto.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	keyID, encryptor, err := t.keys.EncryptorForUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+	ciphertext, err := encryptor.Encrypt([]byte(secret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt totp secret: %w", err)
+	}
+	err = t.db.SaveTOTPSecret(&models.TOTPSecret{
+		UserID: userID,
+		Secret: ciphertext,
+		KeyID:  keyID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.TOTPEnrollResponse{
+		Secret: secret,
+		URI:    crypto.TOTPURI(totpIssuer, user.Username, secret),
+	}, nil
+}
+// Confirm enables a pending enrollment and returns the first set of
+// recovery codes.
+func (t *TwoFactorService) Confirm(actor *models.AuditActor, code string) ([]string, error) {
+	userID := actor.UserID
+	secret, err := t.db.GetTOTPSecret(userID)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Enabled {
+		return nil, models.ErrTOTPAlreadyEnabled
+	}
+	if err := t.checkCode(secret, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.audit.With(actor, models.AuditTOTPEnabled, "", "").EnableTOTP(userID, hashes); err != nil {
+		return nil, err
+	}
+	logger.Info("Two-factor authentication enabled for user %s", userID)
+	return codes, nil
+}
+func (t *TwoFactorService) Disable(actor *models.AuditActor, req *models.TOTPCodeRequest) error {
+	userID := actor.UserID
+	if err := t.Verify(userID, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+	if err := t.audit.With(actor, models.AuditTOTPDisabled, "", "").DisableTOTP(userID); err != nil {
+		return err
+	}
+	logger.Info("Two-factor authentication disabled for user %s", userID)
+	return nil
+}
+// RegenerateRecoveryCodes invalidates all previous recovery codes.
+func (t *TwoFactorService) RegenerateRecoveryCodes(actor *models.AuditActor, req *models.TOTPCodeRequest) ([]string, error) {
+	userID := actor.UserID
+	if err := t.Verify(userID, req.Code, req.RecoveryCode); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.audit.With(actor, models.AuditRecoveryCodesNew, "", "").ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+func (t *TwoFactorService) IsEnabled(userID string) (bool, error) {
+	secret, err := t.db.GetTOTPSecret(userID)
+	if errors.Is(err, models.ErrTOTPNotConfigured) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return secret.Enabled, nil
+}
+// Verify checks a TOTP code or, when code is empty, a recovery code, which
+// is burned on success.
+func (t *TwoFactorService) Verify(userID, code, recoveryCode string) error {
+	secret, err := t.db.GetTOTPSecret(userID)
+	if err != nil {
+		return err
+	}
+	if !secret.Enabled {
+		return models.ErrTOTPNotConfigured
+	}
+	if code == "" && recoveryCode != "" {
+		used, err := t.db.UseRecoveryCode(userID, crypto.HashToken(crypto.NormalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return err
+		}
+		if !used {
+			return models.ErrInvalidSecondFactor
+		}
+		logger.Info("Recovery code used for user %s", userID)
+		return nil
+	}
+	return t.checkCode(secret, code)
+}
+// NewChallenge returns the opaque token a client presents with the second
+// factor after a successful password check. It replaces the user's earlier
+// challenge; at most mfaMaxAttempts codes are checked per user within
+// mfaChallengeTTL of the first challenge, however many are fetched.
+func (t *TwoFactorService) NewChallenge(userID string) (string, error) {
+	token, err := crypto.NewRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	challenge := &models.MFAChallenge{
+		ID:        generateID(),
+		UserID:    userID,
+		TokenHash: crypto.HashToken(token),
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	}
+	if err := t.db.CreateMFAChallenge(challenge, mfaChallengeTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+// Challenge returns the unexpired login challenge for token and counts the
+// attempt against it, so the caller knows the user before any code is
+// checked.
+func (t *TwoFactorService) Challenge(token string) (*models.MFAChallenge, error) {
+	return t.db.GetMFAChallenge(crypto.HashToken(token))
+}
+// CompleteChallenge verifies the second factor for a login challenge. A
+// challenge is single-use. After too many wrong codes it is refused but kept
+// until it expires, so that a new challenge inherits the attempts.
+func (t *TwoFactorService) CompleteChallenge(challenge *models.MFAChallenge, req *models.MFALoginRequest) error {
+	if challenge.Attempts > mfaMaxAttempts {
+		logger.Warn("Too many two-factor attempts for user %s", challenge.UserID)
+		return models.ErrMFAChallengeNotFound
+	}
+	if err := t.Verify(challenge.UserID, req.Code, req.RecoveryCode); err != nil {
+		return err
+	}
+	return t.db.DeleteMFAChallenge(challenge.ID)
+}
+func (t *TwoFactorService) checkCode(secret *models.TOTPSecret, code string) error {
+	encryptor, err := t.keys.Encryptor(secret.KeyID)
+	if err != nil {
+		return err
+	}
+	plaintext, err := encryptor.Decrypt(secret.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt totp secret: %w", err)
+	}
+	counter, ok := crypto.ValidateTOTP(string(plaintext), code, time.Now())
+	if !ok {
+		return models.ErrInvalidSecondFactor
+	}
+	fresh, err := t.db.UseTOTPCounter(secret.UserID, counter)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return models.ErrInvalidSecondFactor
+	}
+	return nil
+}
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := crypto.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = crypto.HashToken(crypto.NormalizeRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
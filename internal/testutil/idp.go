@@ -0,0 +1,171 @@
+// Package testutil holds test helpers shared by the client and server tests.
+package testutil
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+	gkcrypto "gophkeeper/internal/crypto"
+)
+// MockIdP is an in-process OpenID Connect provider. Its authorization
+// endpoint approves every request for Subject at once, and its token endpoint
+// enforces the redirect URI and PKCE like a real provider.
+type MockIdP struct {
+	Server   *httptest.Server
+	ClientID string
+	Subject  string
+	Email    string
+	mu       sync.Mutex
+	key      *rsa.PrivateKey
+	kid      int
+	codes    map[string]mockAuthorization
+}
+type mockAuthorization struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+func NewMockIdP(clientID string) *MockIdP {
+	idp := &MockIdP{
+		ClientID: clientID,
+		Subject:  "subject-123",
+		Email:    "user@example.com",
+		codes:    make(map[string]mockAuthorization),
+	}
+	idp.RotateKey()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.handleDiscovery)
+	mux.HandleFunc("/authorize", idp.handleAuthorize)
+	mux.HandleFunc("/token", idp.handleToken)
+	mux.HandleFunc("/jwks", idp.handleJWKS)
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+func (m *MockIdP) Issuer() string {
+	return m.Server.URL
+}
+func (m *MockIdP) Close() {
+	m.Server.Close()
+}
+// RotateKey replaces the signing key with one under a new key ID.
+func (m *MockIdP) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.key = key
+	m.kid++
+}
+// Browser stands in for the user's browser: it opens the authorization URL
+// and follows the redirect back to the client.
+func (m *MockIdP) Browser(authURL string) error {
+	resp, err := http.Get(authURL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+// IDToken signs an ID token for Subject with the given nonce. Entries in
+// overrides replace the default claims.
+func (m *MockIdP) IDToken(nonce string, overrides map[string]interface{}) string {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            m.Issuer(),
+		"sub":            m.Subject,
+		"aud":            m.ClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          m.Email,
+		"email_verified": true,
+	}
+	for name, value := range overrides {
+		claims[name] = value
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": fmt.Sprintf("key-%d", m.kid)})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+func (m *MockIdP) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 m.Issuer(),
+		"authorization_endpoint": m.Issuer() + "/authorize",
+		"token_endpoint":         m.Issuer() + "/token",
+		"jwks_uri":               m.Issuer() + "/jwks",
+	})
+}
+func (m *MockIdP) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != m.ClientID || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Hostname() != "127.0.0.1" {
+		http.Error(w, "redirect uri must be a loopback address", http.StatusBadRequest)
+		return
+	}
+	code, _ := gkcrypto.NewOIDCNonce()
+	m.mu.Lock()
+	m.codes[code] = mockAuthorization{
+		redirectURI: redirect.String(),
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+	}
+	m.mu.Unlock()
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+func (m *MockIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	r.ParseForm()
+	m.mu.Lock()
+	auth, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("client_id") != m.ClientID ||
+		r.PostForm.Get("redirect_uri") != auth.redirectURI ||
+		gkcrypto.PKCEChallenge(r.PostForm.Get("code_verifier")) != auth.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "idp-access-token",
+		"token_type":   "Bearer",
+		"id_token":     m.IDToken(auth.nonce, nil),
+	})
+}
+func (m *MockIdP) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	json.NewEncoder(w).Encode(gkcrypto.JWKS{Keys: []gkcrypto.JWK{{
+		Kty: "RSA",
+		Kid: fmt.Sprintf("key-%d", m.kid),
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+	}}})
+}
@@ -0,0 +1,173 @@
+package tests
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/database"
+	"gophkeeper/internal/models"
+	"gophkeeper/internal/server"
+	"github.com/google/uuid"
+)
+// auditOptions match the AUDIT_KEY and JWT_SECRET of the server in
+// docker-compose-test.yml, so events written here verify like the server's own.
+var auditOptions = server.AuditOptions{
+	Key:          "audit-key-for-local-runs-only-0123456789",
+	LegacySecret: "supersecretkey",
+}
+func TestAuditLogPerUser(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	alice, bob := registerAndGetToken(t, "auditalice"), registerAndGetToken(t, "auditbob")
+	aliceItem := models.StoredData{ID: uuid.New().String(), Type: models.DataTypeText, Data: []byte("alice"), Version: 1}
+	expectStatus(t, "POST", "/api/v1/data", aliceItem, alice, http.StatusOK)
+	bobItem := models.StoredData{ID: uuid.New().String(), Type: models.DataTypeText, Data: []byte("bob"), Version: 1}
+	expectStatus(t, "POST", "/api/v1/data", bobItem, bob, http.StatusOK)
+	list := func(token string) []models.AuditEvent {
+		var events []models.AuditEvent
+		decodeData(t, expectStatus(t, "GET", "/api/v1/audit", nil, token, http.StatusOK), &events)
+		return events
+	}
+	for _, tt := range []struct {
+		name       string
+		token      string
+		own, other string
+	}{
+		{"alice", alice, aliceItem.ID, bobItem.ID},
+		{"bob", bob, bobItem.ID, aliceItem.ID},
+	} {
+		events := list(tt.token)
+		if len(events) < 2 {
+			t.Fatalf("Expected %s to see the registration and the item, got %+v", tt.name, events)
+		}
+		created := false
+		for i, event := range events {
+			if event.UserID != events[0].UserID {
+				t.Errorf("Expected only %s's events, got one of user %s", tt.name, event.UserID)
+			}
+			if event.ItemID == tt.other {
+				t.Errorf("Expected %s not to see the other user's item", tt.name)
+			}
+			created = created || (event.ItemID == tt.own && event.Type == models.AuditItemCreated)
+			// Newest first, each event links to the one listed after it.
+			if i+1 < len(events) && (event.Seq != events[i+1].Seq+1 || event.PrevHash != events[i+1].Hash) {
+				t.Errorf("Expected event %d of %s to follow event %d", event.Seq, tt.name, events[i+1].Seq)
+			}
+		}
+		if !created {
+			t.Errorf("Expected %s to see the creation of their item", tt.name)
+		}
+	}
+}
+// TestAuditSecurityChanges expects enabling 2FA and refreshing the session
+// to show up in the log.
+func TestAuditSecurityChanges(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	suffix := time.Now().UnixNano()
+	var registered models.AuthResponse
+	decodeData(t, expectStatus(t, "POST", "/api/v1/register", models.UserRegistrationRequest{
+		Username: fmt.Sprintf("auditsec_%d", suffix),
+		Email:    fmt.Sprintf("auditsec_%d@example.com", suffix),
+		Password: testPass,
+	}, "", http.StatusOK), &registered)
+	var enrolled models.TOTPEnrollResponse
+	decodeData(t, expectStatus(t, "POST", "/api/v1/2fa/enroll", nil, registered.Token, http.StatusOK), &enrolled)
+	code, err := crypto.TOTPCode(enrolled.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("TOTPCode failed: %v", err)
+	}
+	expectStatus(t, "POST", "/api/v1/2fa/confirm", models.TOTPCodeRequest{Code: code}, registered.Token, http.StatusOK)
+	var refreshed models.AuthResponse
+	decodeData(t, expectStatus(t, "POST", "/api/v1/token/refresh", models.RefreshTokenRequest{RefreshToken: registered.RefreshToken}, "", http.StatusOK), &refreshed)
+	var events []models.AuditEvent
+	decodeData(t, expectStatus(t, "GET", "/api/v1/audit", nil, refreshed.Token, http.StatusOK), &events)
+	seen := make(map[string]bool)
+	for _, event := range events {
+		seen[event.Type] = true
+	}
+	for _, eventType := range []string{models.AuditTOTPEnabled, models.AuditSessionRefreshed} {
+		if !seen[eventType] {
+			t.Errorf("Expected a %s event, got %+v", eventType, events)
+		}
+	}
+}
+func TestAuditChainVerification(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	db, err := database.NewDB("host=localhost port=5432 user=gophkeeper password=password dbname=gophkeeper sslmode=disable")
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+	audit := server.NewAuditService(db, auditOptions)
+	userID, otherID := uuid.New().String(), uuid.New().String()
+	for i, id := range []string{userID, otherID, userID, userID} {
+		actor := &models.AuditActor{UserID: id, IP: "203.0.113.7"}
+		if err := audit.Record(actor, models.AuditItemsRead, "", string(rune('a'+i))); err != nil {
+			t.Fatalf("Record failed: %v", err)
+		}
+	}
+	chain, err := db.ListAuditChain(userID, 0, 10)
+	if err != nil {
+		t.Fatalf("ListAuditChain failed: %v", err)
+	}
+	if len(chain) != 3 {
+		t.Fatalf("Expected the user's three events in their chain, got %d", len(chain))
+	}
+	for i, event := range chain {
+		prevHash := ""
+		if i > 0 {
+			prevHash = chain[i-1].Hash
+		}
+		if event.Seq != int64(i+1) || event.PrevHash != prevHash {
+			t.Errorf("Expected event %d to link to the one before, got seq %d", i+1, event.Seq)
+		}
+	}
+	head, err := audit.VerifyUser(userID)
+	if err != nil || head.Seq != 3 || head.Hash != chain[2].Hash {
+		t.Fatalf("Expected the chain to verify up to its third event, got %+v, %v", head, err)
+	}
+	// A head recorded earlier that is no longer in the chain means events
+	// were cut off.
+	_, err = audit.Verify([]models.AuditHead{{UserID: userID, Seq: 4, Hash: chain[2].Hash}})
+	if !errors.Is(err, models.ErrAuditChainBroken) || !strings.Contains(err.Error(), userID) {
+		t.Errorf("Expected a missing anchored head to break the chain, got %v", err)
+	}
+	// The trigger stays off only inside the transaction that changes the row.
+	tamper := func(detail string) {
+		t.Helper()
+		tx, err := db.Conn().Begin()
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+		_, err = tx.Exec(`ALTER TABLE audit_events DISABLE TRIGGER audit_events_no_update`)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE audit_events SET detail = $2 WHERE user_id = $1 AND seq = 2`, userID, detail)
+		}
+		if err == nil {
+			_, err = tx.Exec(`ALTER TABLE audit_events ENABLE TRIGGER audit_events_no_update`)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatalf("Failed to tamper with the audit log: %v", err)
+		}
+	}
+	tamper("tampered")
+	defer tamper(chain[1].Detail)
+	if _, err := audit.VerifyUser(userID); !errors.Is(err, models.ErrAuditChainBroken) {
+		t.Errorf("Expected a modified event to break the chain, got %v", err)
+	}
+	if _, err := audit.VerifyUser(otherID); err != nil {
+		t.Errorf("Expected other chains to stay intact, got %v", err)
+	}
+}
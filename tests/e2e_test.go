@@ -16,9 +16,11 @@ func TestEndToEnd(t *testing.T) {
 	}
 	cfg := config.LoadClientConfig()
 	cfg.ServerURL = "http://localhost:8080"
+	cfg.MasterPassword = "e2e-master-password"
 	testDir := filepath.Join(os.TempDir(), "gophkeeper-test")
 	os.RemoveAll(testDir) // Clean up any previous test data
-	cli, err := client.NewClient(cfg.ServerURL, testDir, cfg.EncryptionKey)
+	cfg.ConfigDir = testDir
+	cli, err := client.NewClient(cfg)
 	if err != nil {
 		t.Fatalf("Failed to create client: %v", err)
 	}
@@ -105,7 +107,8 @@ func testCLIWorkflow(t *testing.T, testDir string) {
 	cmd := exec.Command("../test-client", "register", testUser, testEmail, "clipass123")
 	cmd.Env = append(os.Environ(),
 		"SERVER_URL=http://localhost:8080",
-		"CLIENT_CONFIG_DIR="+testDir)
+		"CLIENT_CONFIG_DIR="+testDir,
+		"GOPHKEEPER_MASTER_PASSWORD=cli-master-password")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		t.Fatalf("CLI registration failed: %v\nOutput: %s", err, string(output))
@@ -117,7 +120,8 @@ func testCLIWorkflow(t *testing.T, testDir string) {
 	cmd = exec.Command("../test-client", "login", testUser, "clipass123")
 	cmd.Env = append(os.Environ(),
 		"SERVER_URL=http://localhost:8080",
-		"CLIENT_CONFIG_DIR="+testDir)
+		"CLIENT_CONFIG_DIR="+testDir,
+		"GOPHKEEPER_MASTER_PASSWORD=cli-master-password")
 	output, err = cmd.CombinedOutput()
 	if err != nil {
 		t.Fatalf("CLI login failed: %v\nOutput: %s", err, string(output))
@@ -137,7 +141,8 @@ func testCLIWorkflow(t *testing.T, testDir string) {
 	cmd = exec.Command("../test-client", "add", "login_password", "CLI Test Site", "cliuser", "clipass", "https://cli.example.com", "CLI test notes")
 	cmd.Env = append(os.Environ(),
 		"SERVER_URL=http://localhost:8080",
-		"CLIENT_CONFIG_DIR="+testDir)
+		"CLIENT_CONFIG_DIR="+testDir,
+		"GOPHKEEPER_MASTER_PASSWORD=cli-master-password")
 	output, err = cmd.CombinedOutput()
 	if err != nil {
 		t.Fatalf("CLI add data failed: %v\nOutput: %s", err, string(output))
@@ -148,7 +153,8 @@ func testCLIWorkflow(t *testing.T, testDir string) {
 	cmd = exec.Command("../test-client", "list")
 	cmd.Env = append(os.Environ(),
 		"SERVER_URL=http://localhost:8080",
-		"CLIENT_CONFIG_DIR="+testDir)
+		"CLIENT_CONFIG_DIR="+testDir,
+		"GOPHKEEPER_MASTER_PASSWORD=cli-master-password")
 	output, err = cmd.CombinedOutput()
 	if err != nil {
 		t.Fatalf("CLI list data failed: %v\nOutput: %s", err, string(output))
@@ -159,7 +165,8 @@ func testCLIWorkflow(t *testing.T, testDir string) {
 	cmd = exec.Command("../test-client", "sync")
 	cmd.Env = append(os.Environ(),
 		"SERVER_URL=http://localhost:8080",
-		"CLIENT_CONFIG_DIR="+testDir)
+		"CLIENT_CONFIG_DIR="+testDir,
+		"GOPHKEEPER_MASTER_PASSWORD=cli-master-password")
 	output, err = cmd.CombinedOutput()
 	if err != nil {
 		t.Fatalf("CLI sync failed: %v\nOutput: %s", err, string(output))
@@ -180,7 +187,9 @@ func TestDataPersistence(t *testing.T) {
 	testEmail := fmt.Sprintf("persist_%d@example.com", time.Now().UnixNano())
 	cfg := config.LoadClientConfig()
 	cfg.ServerURL = "http://localhost:8080"
-	cli1, err := client.NewClient(cfg.ServerURL, testDir, cfg.EncryptionKey)
+	cfg.MasterPassword = "e2e-master-password"
+	cfg.ConfigDir = testDir
+	cli1, err := client.NewClient(cfg)
 	if err != nil {
 		t.Fatalf("Failed to create first client: %v", err)
 	}
@@ -192,7 +201,7 @@ func TestDataPersistence(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to add data: %v", err)
 	}
-	cli2, err := client.NewClient(cfg.ServerURL, testDir, cfg.EncryptionKey)
+	cli2, err := client.NewClient(cfg)
 	if err != nil {
 		t.Fatalf("Failed to create second client: %v", err)
 	}
@@ -15,10 +15,12 @@ func TestFullE2EWithDatabase(t *testing.T) {
 	}
 	cfg := config.LoadClientConfig()
 	cfg.ServerURL = "http://localhost:8080"
+	cfg.MasterPassword = "e2e-master-password"
 	testDir := fmt.Sprintf("/tmp/gophkeeper-full-test-%d", time.Now().UnixNano())
 	os.RemoveAll(testDir) // Clean up any previous test data
 	defer os.RemoveAll(testDir)
-	cli, err := client.NewClient(cfg.ServerURL, testDir, cfg.EncryptionKey)
+	cfg.ConfigDir = testDir
+	cli, err := client.NewClient(cfg)
 	if err != nil {
 		t.Fatalf("Failed to create client: %v", err)
 	}
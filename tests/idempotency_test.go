@@ -0,0 +1,75 @@
+package tests
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+	"gophkeeper/internal/models"
+	"github.com/google/uuid"
+)
+// expectKeyedStatus is expectStatus with an Idempotency-Key header.
+func expectKeyedStatus(t *testing.T, method, path string, body interface{}, token, key string, status int) (*http.Response, []byte) {
+	t.Helper()
+	var reqBody io.Reader
+	if body != nil {
+		jsonData, _ := json.Marshal(body)
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+	req, err := http.NewRequest(method, serverURL+path, reqBody)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Idempotency-Key", key)
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, status, resp.StatusCode, string(respBody))
+	}
+	return resp, respBody
+}
+func TestIdempotencyKeys(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	token := registerAndGetToken(t, "idem")
+	item := models.StoredData{ID: uuid.New().String(), Type: models.DataTypeText, Data: []byte("one"), Version: 1}
+	key := uuid.New().String()
+	_, first := expectKeyedStatus(t, "POST", "/api/v1/data", item, token, key, http.StatusOK)
+	// The retry would be a 409 for the taken ID without the key.
+	resp, retry := expectKeyedStatus(t, "POST", "/api/v1/data", item, token, key, http.StatusOK)
+	if resp.Header.Get("Idempotent-Replayed") != "true" || !bytes.Equal(first, retry) {
+		t.Errorf("Expected the first response replayed, got %s", string(retry))
+	}
+	other := item
+	other.ID = uuid.New().String()
+	expectKeyedStatus(t, "POST", "/api/v1/data", other, token, key, http.StatusUnprocessableEntity)
+	// Keys belong to their user.
+	expectKeyedStatus(t, "POST", "/api/v1/data", other, registerAndGetToken(t, "idem2"), key, http.StatusOK)
+	deleteKey := uuid.New().String()
+	expectKeyedStatus(t, "DELETE", "/api/v1/data?id="+item.ID, nil, token, deleteKey, http.StatusOK)
+	expectKeyedStatus(t, "DELETE", "/api/v1/data?id="+item.ID, nil, token, deleteKey, http.StatusOK)
+	expectKeyedStatus(t, "DELETE", "/api/v1/data?id="+other.ID, nil, token, deleteKey, http.StatusUnprocessableEntity)
+	updated := models.StoredData{ID: uuid.New().String(), Type: models.DataTypeText, Data: []byte("one"), Version: 1}
+	expectStatus(t, "POST", "/api/v1/data", updated, token, http.StatusOK)
+	updated.Data, updated.Version = []byte("two"), 2
+	updateKey := uuid.New().String()
+	_, first = expectKeyedStatus(t, "PUT", "/api/v1/data?base_version=1", updated, token, updateKey, http.StatusOK)
+	// The retry would be a 409 for the moved base version without the key.
+	_, retry = expectKeyedStatus(t, "PUT", "/api/v1/data?base_version=1", updated, token, updateKey, http.StatusOK)
+	if !bytes.Equal(first, retry) {
+		t.Errorf("Expected the update replayed, got %s", string(retry))
+	}
+	syncKey := uuid.New().String()
+	expectKeyedStatus(t, "POST", "/api/v1/sync", models.DataSyncRequest{}, token, syncKey, http.StatusOK)
+	// Sync responses are not kept, so a retry is refused rather than replayed.
+	expectKeyedStatus(t, "POST", "/api/v1/sync", models.DataSyncRequest{}, token, syncKey, http.StatusConflict)
+	expectKeyedStatus(t, "POST", "/api/v1/sync", models.DataSyncRequest{Cursor: "other"}, token, syncKey, http.StatusUnprocessableEntity)
+}
@@ -166,8 +166,7 @@ func testDataOperations(t *testing.T) {
 func testSynchronization(t *testing.T) {
 	token := loginAndGetToken(t)
 	syncReq := models.DataSyncRequest{
-		LastSyncAt: time.Now().Add(-1 * time.Hour),
-		Data:       []models.StoredData{},
+		Data: []models.StoredData{},
 	}
 	resp, err := makeRequestWithAuth("POST", "/api/v1/sync", syncReq, token)
 	if err != nil {
@@ -190,8 +189,8 @@ func testSynchronization(t *testing.T) {
 	if err := json.Unmarshal(dataBytes, &syncResp); err != nil {
 		t.Fatalf("Failed to unmarshal sync response: %v", err)
 	}
-	if syncResp.LastSyncAt.IsZero() {
-		t.Fatal("LastSyncAt is zero")
+	if syncResp.Cursor == "" {
+		t.Fatal("Sync returned no cursor")
 	}
 }
 func loginAndGetToken(t *testing.T) string {
@@ -0,0 +1,126 @@
+package tests
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+	"gophkeeper/internal/models"
+	"github.com/google/uuid"
+)
+func TestCrossTenantAccess(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	owner := registerAndGetToken(t, "owner")
+	intruder := registerAndGetToken(t, "intruder")
+	item := models.StoredData{
+		ID:      uuid.New().String(),
+		Type:    models.DataTypeText,
+		Title:   "Owner secret",
+		Data:    []byte("owner only"),
+		Version: 1,
+	}
+	expectStatus(t, "POST", "/api/v1/data", item, owner, http.StatusOK)
+	t.Run("UpdateIsNotFound", func(t *testing.T) {
+		forged := item
+		forged.Data = []byte("overwritten")
+		expectStatus(t, "PUT", "/api/v1/data", forged, intruder, http.StatusNotFound)
+	})
+	t.Run("DeleteIsNotFound", func(t *testing.T) {
+		expectStatus(t, "DELETE", "/api/v1/data?id="+item.ID, nil, intruder, http.StatusNotFound)
+	})
+	t.Run("DeleteOfMissingItemIsNotFound", func(t *testing.T) {
+		expectStatus(t, "DELETE", "/api/v1/data?id="+uuid.New().String(), nil, intruder, http.StatusNotFound)
+	})
+	t.Run("CreateWithForeignIDFails", func(t *testing.T) {
+		forged := item
+		forged.Data = []byte("overwritten")
+		expectStatus(t, "POST", "/api/v1/data", forged, intruder, http.StatusConflict)
+	})
+	t.Run("SyncDoesNotReadOrWriteForeignItems", func(t *testing.T) {
+		forged := item
+		forged.Data = []byte("overwritten")
+		forged.Version = 100
+		forged.UpdatedAt = time.Now().Add(-24 * time.Hour)
+		var syncResp models.DataSyncResponse
+		body := expectStatus(t, "POST", "/api/v1/sync", models.DataSyncRequest{
+			Data: []models.StoredData{forged},
+		}, intruder, http.StatusOK)
+		decodeData(t, body, &syncResp)
+		for _, data := range syncResp.Data {
+			if data.ID == item.ID {
+				t.Fatal("Sync returned an item owned by another user")
+			}
+		}
+		for _, conflict := range syncResp.Conflicts {
+			if conflict.ServerData.ID != "" || len(conflict.ServerData.Data) != 0 {
+				t.Fatalf("Sync conflict leaked another user's item: %+v", conflict.ServerData)
+			}
+		}
+	})
+	t.Run("ListOnlyShowsOwnItems", func(t *testing.T) {
+		var dataList []models.StoredData
+		decodeData(t, expectStatus(t, "GET", "/api/v1/data", nil, intruder, http.StatusOK), &dataList)
+		for _, data := range dataList {
+			if data.ID == item.ID {
+				t.Fatal("Item listed for a user who does not own it")
+			}
+		}
+	})
+	t.Run("OwnerItemIsUnchanged", func(t *testing.T) {
+		var dataList []models.StoredData
+		decodeData(t, expectStatus(t, "GET", "/api/v1/data", nil, owner, http.StatusOK), &dataList)
+		for _, data := range dataList {
+			if data.ID == item.ID {
+				if string(data.Data) != "owner only" || data.Version != 1 {
+					t.Fatalf("Owner item was modified: version %d, data %q", data.Version, data.Data)
+				}
+				return
+			}
+		}
+		t.Fatal("Owner item disappeared")
+	})
+}
+func registerAndGetToken(t *testing.T, prefix string) string {
+	suffix := time.Now().UnixNano()
+	req := models.UserRegistrationRequest{
+		Username: fmt.Sprintf("%s_%d", prefix, suffix),
+		Email:    fmt.Sprintf("%s_%d@example.com", prefix, suffix),
+		Password: testPass,
+	}
+	var authResp models.AuthResponse
+	decodeData(t, expectStatus(t, "POST", "/api/v1/register", req, "", http.StatusOK), &authResp)
+	return authResp.Token
+}
+func expectStatus(t *testing.T, method, path string, body interface{}, token string, status int) []byte {
+	t.Helper()
+	var resp *http.Response
+	var err error
+	if token == "" {
+		resp, err = makeRequest(method, path, body)
+	} else {
+		resp, err = makeRequestWithAuth(method, path, body, token)
+	}
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, status, resp.StatusCode, string(respBody))
+	}
+	return respBody
+}
+func decodeData(t *testing.T, body []byte, result interface{}) {
+	t.Helper()
+	var apiResp models.APIResponse
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	dataBytes, _ := json.Marshal(apiResp.Data)
+	if err := json.Unmarshal(dataBytes, result); err != nil {
+		t.Fatalf("Failed to unmarshal response data: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/models"
+	"github.com/google/uuid"
+)
+func TestRekeyReplacesHistory(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	token := registerAndGetToken(t, "rekeyhist")
+	old, err := crypto.NewKDFParams(1, 8*1024, 1)
+	if err != nil {
+		t.Fatalf("NewKDFParams failed: %v", err)
+	}
+	old.KeyCheck = []byte("old-key-check")
+	expectStatus(t, "PUT", "/api/v1/kdf", old, token, http.StatusOK)
+	item := models.StoredData{ID: uuid.New().String(), Type: models.DataTypeSealed, Data: []byte("old-1"), Version: 1}
+	expectStatus(t, "POST", "/api/v1/data", item, token, http.StatusOK)
+	item.Data, item.Version = []byte("old-2"), 2
+	expectStatus(t, "PUT", "/api/v1/data?base_version=1", item, token, http.StatusOK)
+	var history []models.DataHistory
+	decodeData(t, expectStatus(t, "GET", "/api/v1/history", nil, token, http.StatusOK), &history)
+	if len(history) != 2 {
+		t.Fatalf("Expected both versions in history, got %d", len(history))
+	}
+	next, err := crypto.NewKDFParams(1, 8*1024, 1)
+	if err != nil {
+		t.Fatalf("NewKDFParams failed: %v", err)
+	}
+	next.KeyCheck = []byte("new-key-check")
+	item.Data = []byte("new-2")
+	req := models.RekeyRequest{PreviousKeyCheck: old.KeyCheck, KDF: next, Data: []models.StoredData{item}}
+	// History left under the old key would make the change incomplete.
+	expectStatus(t, "POST", "/api/v1/rekey", req, token, http.StatusConflict)
+	for i := range history {
+		history[i].Data = []byte("new-history")
+	}
+	req.History = history
+	expectStatus(t, "POST", "/api/v1/rekey", req, token, http.StatusOK)
+	var rekeyed []models.DataHistory
+	decodeData(t, expectStatus(t, "GET", "/api/v1/history", nil, token, http.StatusOK), &rekeyed)
+	if len(rekeyed) != 2 {
+		t.Fatalf("Expected history to keep both versions, got %d", len(rekeyed))
+	}
+	for _, h := range rekeyed {
+		if string(h.Data) != "new-history" {
+			t.Errorf("Expected version %d of history to be replaced, got %q", h.Version, h.Data)
+		}
+	}
+	// A device still holding the old key cannot write over the new one.
+	item.Data, item.Version = []byte("old-3"), 3
+	expectKeyCheckStatus(t, "PUT", "/api/v1/data?base_version=2", item, token, old.KeyCheck, http.StatusConflict)
+	item.Data = []byte("new-3")
+	expectKeyCheckStatus(t, "PUT", "/api/v1/data?base_version=2", item, token, next.KeyCheck, http.StatusOK)
+}
+// expectKeyCheckStatus is expectStatus for a write naming the vault key it
+// was sealed with.
+func expectKeyCheckStatus(t *testing.T, method, path string, body interface{}, token string, keyCheck []byte, status int) {
+	t.Helper()
+	jsonData, _ := json.Marshal(body)
+	req, err := http.NewRequest(method, serverURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Vault-Key-Check", base64.StdEncoding.EncodeToString(keyCheck))
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, path, status, resp.StatusCode, string(respBody))
+	}
+}
@@ -0,0 +1,150 @@
+package tests
+import (
+	"database/sql"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+	"gophkeeper/internal/models"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+func TestSyncChangeFeed(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	token := registerAndGetToken(t, "feed")
+	sync := func(req models.DataSyncRequest) models.DataSyncResponse {
+		var resp models.DataSyncResponse
+		decodeData(t, expectStatus(t, "POST", "/api/v1/sync", req, token, http.StatusOK), &resp)
+		return resp
+	}
+	first := sync(models.DataSyncRequest{})
+	item := models.StoredData{ID: uuid.New().String(), Type: models.DataTypeText, Data: []byte("one"), Version: 1}
+	expectStatus(t, "POST", "/api/v1/data", item, token, http.StatusOK)
+	// The other device's clock is a day behind; only the order of writes
+	// counts.
+	pushed := models.StoredData{ID: uuid.New().String(), Type: models.DataTypeText, Data: []byte("two"), Version: 1, UpdatedAt: time.Now().Add(-24 * time.Hour)}
+	second := sync(models.DataSyncRequest{Cursor: first.Cursor, Data: []models.StoredData{pushed}})
+	if len(second.Data) != 1 || second.Data[0].ID != item.ID {
+		t.Fatalf("Expected only the item written by another client, got %+v", second.Data)
+	}
+	third := sync(models.DataSyncRequest{Cursor: second.Cursor})
+	if len(third.Data) != 0 {
+		t.Fatalf("Expected no changes after the cursor, got %d", len(third.Data))
+	}
+	if third.Cursor != second.Cursor {
+		t.Errorf("Expected the cursor to stay at %s, got %s", second.Cursor, third.Cursor)
+	}
+	expectStatus(t, "DELETE", "/api/v1/data?id="+pushed.ID, nil, token, http.StatusOK)
+	fourth := sync(models.DataSyncRequest{Cursor: third.Cursor})
+	if len(fourth.Data) != 1 || fourth.Data[0].ID != pushed.ID || !fourth.Data[0].IsDeleted {
+		t.Fatalf("Expected the deletion in the feed, got %+v", fourth.Data)
+	}
+	all := sync(models.DataSyncRequest{})
+	if len(all.Data) != 2 {
+		t.Errorf("Expected an empty cursor to return every item, got %d", len(all.Data))
+	}
+	expectStatus(t, "POST", "/api/v1/sync", models.DataSyncRequest{Cursor: "not-a-cursor"}, token, http.StatusBadRequest)
+}
+func TestUpdateDataBaseVersion(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	token := registerAndGetToken(t, "basever")
+	item := models.StoredData{ID: uuid.New().String(), Type: models.DataTypeText, Data: []byte("one"), Version: 1}
+	expectStatus(t, "POST", "/api/v1/data", item, token, http.StatusOK)
+	item.Data, item.Version = []byte("two"), 2
+	expectStatus(t, "PUT", "/api/v1/data?base_version=1", item, token, http.StatusOK)
+	// A second client still based on version 1 must not overwrite version 2.
+	item.Data, item.Version = []byte("stale"), 2
+	expectStatus(t, "PUT", "/api/v1/data?base_version=1", item, token, http.StatusConflict)
+	expectStatus(t, "PUT", "/api/v1/data?base_version=x", item, token, http.StatusBadRequest)
+	missing := models.StoredData{ID: uuid.New().String(), Type: models.DataTypeText, Data: []byte("x"), Version: 2}
+	expectStatus(t, "PUT", "/api/v1/data?base_version=1", missing, token, http.StatusNotFound)
+	// The server assigns the version after the base.
+	item.Data, item.Version = []byte("three"), 0
+	var updated models.StoredData
+	decodeData(t, expectStatus(t, "PUT", "/api/v1/data?base_version=2", item, token, http.StatusOK), &updated)
+	if updated.Version != 3 {
+		t.Errorf("Expected version 3 after base 2, got %d", updated.Version)
+	}
+	item.Data, item.Version = []byte("skipped"), 7
+	expectStatus(t, "PUT", "/api/v1/data?base_version=3", item, token, http.StatusBadRequest)
+}
+func TestSyncBaseVersion(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	token := registerAndGetToken(t, "syncbase")
+	item := models.StoredData{ID: uuid.New().String(), Type: models.DataTypeText, Data: []byte("one"), Version: 1}
+	expectStatus(t, "POST", "/api/v1/data", item, token, http.StatusOK)
+	sync := func(data models.StoredData) models.DataSyncResponse {
+		var resp models.DataSyncResponse
+		req := models.DataSyncRequest{Data: []models.StoredData{data}}
+		decodeData(t, expectStatus(t, "POST", "/api/v1/sync", req, token, http.StatusOK), &resp)
+		return resp
+	}
+	// A change based on the current version is taken even with an old clock.
+	edited := models.StoredData{ID: item.ID, Type: models.DataTypeText, Data: []byte("two"), Version: 2, BaseVersion: 1, UpdatedAt: time.Now().Add(-time.Hour)}
+	if resp := sync(edited); len(resp.Conflicts) != 0 {
+		t.Fatalf("Expected the change to be accepted, got %+v", resp.Conflicts)
+	}
+	// A change still based on version 1 is refused even with a newer clock.
+	stale := models.StoredData{ID: item.ID, Type: models.DataTypeText, Data: []byte("stale"), Version: 2, BaseVersion: 1, UpdatedAt: time.Now().Add(time.Hour)}
+	resp := sync(stale)
+	if len(resp.Conflicts) != 1 || resp.Conflicts[0].ServerData.Version != 2 || string(resp.Conflicts[0].ServerData.Data) != "two" {
+		t.Fatalf("Expected a conflict against version 2, got %+v", resp.Conflicts)
+	}
+}
+func TestUpdateDataRejectsOldVersion(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	token := registerAndGetToken(t, "oldver")
+	db := openTestDB(t)
+	item := models.StoredData{ID: uuid.New().String(), Type: models.DataTypeText, Data: []byte("one"), Version: 1}
+	expectStatus(t, "POST", "/api/v1/data", item, token, http.StatusOK)
+	item.Data, item.Version = []byte("two"), 2
+	expectStatus(t, "PUT", "/api/v1/data", item, token, http.StatusOK)
+	history := func() map[int]string {
+		rows, err := db.Query("SELECT version, data FROM data_history WHERE data_id = $1", item.ID)
+		if err != nil {
+			t.Fatalf("Failed to query history: %v", err)
+		}
+		defer rows.Close()
+		versions := make(map[int]string)
+		for rows.Next() {
+			var version int
+			var data []byte
+			if err := rows.Scan(&version, &data); err != nil {
+				t.Fatalf("Failed to scan history: %v", err)
+			}
+			versions[version] = string(data)
+		}
+		return versions
+	}
+	before := history()
+	// A replayed PUT must neither roll the item back nor rewrite history.
+	item.Data, item.Version = []byte("replayed"), 1
+	expectStatus(t, "PUT", "/api/v1/data", item, token, http.StatusConflict)
+	item.Version = 2
+	expectStatus(t, "PUT", "/api/v1/data", item, token, http.StatusConflict)
+	if after := history(); !reflect.DeepEqual(before, after) {
+		t.Errorf("Expected history to stay unchanged, got %d versions instead of %d", len(after), len(before))
+	}
+	var dataList []models.StoredData
+	decodeData(t, expectStatus(t, "GET", "/api/v1/data", nil, token, http.StatusOK), &dataList)
+	if len(dataList) != 1 || dataList[0].Version != 2 || string(dataList[0].Data) != "two" {
+		t.Fatalf("Expected version 2 to stay current, got %+v", dataList)
+	}
+}
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("postgres", "host=localhost port=5432 user=gophkeeper password=password dbname=gophkeeper sslmode=disable")
+	if err != nil {
+		t.Fatalf("Failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -0,0 +1,149 @@
+package tests
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"gophkeeper/internal/crypto"
+	"gophkeeper/internal/models"
+)
+// TestTwoFactorFailuresLockAccount fetches a new challenge with the right
+// password before every wrong code: the password must not clear the
+// failures of the second factor, so the account still locks.
+func TestTwoFactorFailuresLockAccount(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	username, secret := registerWithTOTP(t, "mfalock")
+	wrong := wrongTOTPCode(t, secret)
+	for cycle := 0; cycle < 30; cycle++ {
+		token, locked := loginChallenge(t, username)
+		if locked {
+			return
+		}
+		resp, body := post(t, "/api/v1/login/2fa", models.MFALoginRequest{MFAToken: token, Code: wrong})
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+		case http.StatusTooManyRequests:
+			if strings.Contains(string(body), "locked") {
+				return
+			}
+			waitRetryAfter(t, resp)
+		default:
+			t.Fatalf("Login 2FA: expected a refusal, got %d: %s", resp.StatusCode, body)
+		}
+	}
+	t.Fatal("Expected wrong second factors to lock the account")
+}
+// TestTwoFactorAttemptsCarryOver uses up one challenge and expects the next
+// one, fetched right away, to refuse even the right code.
+func TestTwoFactorAttemptsCarryOver(t *testing.T) {
+	if !waitForServer(30 * time.Second) {
+		t.Fatal("Server not ready after 30 seconds")
+	}
+	username, secret := registerWithTOTP(t, "mfacarry")
+	wrong := wrongTOTPCode(t, secret)
+	token, _ := loginChallenge(t, username)
+	// Every request counts against the challenge, throttled ones too.
+	for attempt := 0; attempt < 6; attempt++ {
+		resp, _ := post(t, "/api/v1/login/2fa", models.MFALoginRequest{MFAToken: token, Code: wrong})
+		if resp.StatusCode == http.StatusTooManyRequests {
+			waitRetryAfter(t, resp)
+		}
+	}
+	token, locked := loginChallenge(t, username)
+	if locked {
+		t.Fatal("Expected the account not to be locked yet")
+	}
+	for {
+		code, err := crypto.TOTPCode(secret, time.Now())
+		if err != nil {
+			t.Fatalf("TOTPCode failed: %v", err)
+		}
+		resp, body := post(t, "/api/v1/login/2fa", models.MFALoginRequest{MFAToken: token, Code: code})
+		if resp.StatusCode == http.StatusTooManyRequests {
+			waitRetryAfter(t, resp)
+			continue
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected the new challenge to inherit the attempts, got %d: %s", resp.StatusCode, body)
+		}
+		return
+	}
+}
+// registerWithTOTP creates an account with 2FA enabled and returns its
+// username and TOTP secret.
+func registerWithTOTP(t *testing.T, prefix string) (string, string) {
+	t.Helper()
+	suffix := time.Now().UnixNano()
+	username := fmt.Sprintf("%s_%d", prefix, suffix)
+	var registered models.AuthResponse
+	decodeData(t, expectStatus(t, "POST", "/api/v1/register", models.UserRegistrationRequest{
+		Username: username,
+		Email:    fmt.Sprintf("%s_%d@example.com", prefix, suffix),
+		Password: testPass,
+	}, "", http.StatusOK), &registered)
+	var enrolled models.TOTPEnrollResponse
+	decodeData(t, expectStatus(t, "POST", "/api/v1/2fa/enroll", nil, registered.Token, http.StatusOK), &enrolled)
+	code, err := crypto.TOTPCode(enrolled.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("TOTPCode failed: %v", err)
+	}
+	expectStatus(t, "POST", "/api/v1/2fa/confirm", models.TOTPCodeRequest{Code: code}, registered.Token, http.StatusOK)
+	return username, enrolled.Secret
+}
+// wrongTOTPCode shifts every digit of the current code, so it differs from
+// it.
+func wrongTOTPCode(t *testing.T, secret string) string {
+	t.Helper()
+	code, err := crypto.TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatalf("TOTPCode failed: %v", err)
+	}
+	return strings.Map(func(r rune) rune { return '0' + (r-'0'+5)%10 }, code)
+}
+// loginChallenge logs in with the password, waiting out any backoff, and
+// returns the two-factor token, or locked once the account is locked.
+func loginChallenge(t *testing.T, username string) (string, bool) {
+	t.Helper()
+	for {
+		resp, body := post(t, "/api/v1/login", models.UserLoginRequest{Username: username, Password: testPass})
+		if resp.StatusCode == http.StatusTooManyRequests {
+			if strings.Contains(string(body), "locked") {
+				return "", true
+			}
+			waitRetryAfter(t, resp)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Login: expected status 200, got %d: %s", resp.StatusCode, body)
+		}
+		var challenge models.AuthResponse
+		decodeData(t, body, &challenge)
+		if !challenge.MFARequired {
+			t.Fatal("Expected the login to ask for the second factor")
+		}
+		return challenge.MFAToken, false
+	}
+}
+func post(t *testing.T, path string, body interface{}) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := makeRequest("POST", path, body)
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	return resp, respBody
+}
+func waitRetryAfter(t *testing.T, resp *http.Response) {
+	t.Helper()
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil {
+		t.Fatalf("Expected a Retry-After header, got %q", resp.Header.Get("Retry-After"))
+	}
+	time.Sleep(time.Duration(seconds) * time.Second)
+}
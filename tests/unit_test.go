@@ -60,7 +60,11 @@ func testEncryption(t *testing.T) {
 	}
 }
 func testJWT(t *testing.T) {
-	jwtManager := crypto.NewJWTManager("test-secret-key")
+	key, err := crypto.GenerateSigningKey(crypto.JWTAlgEdDSA)
+	if err != nil {
+		t.Fatalf("GenerateSigningKey failed: %v", err)
+	}
+	jwtManager := crypto.NewJWTManager(crypto.NewKeyRing(key), "gophkeeper", "gophkeeper-api")
 	userID := uuid.New().String()
 	username := "testuser"
 	duration := 1 * time.Hour